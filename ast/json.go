@@ -0,0 +1,952 @@
+package ast
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/madlambda/nash/token"
+)
+
+// JSONVersion is the version of the JSON schema of the trees.
+const JSONVersion = 1
+
+// The JSON encoding of a tree is an object with the schema version, the
+// tree name and the root block:
+//
+//	{"version": 1, "name": "script.sh", "root": {"type": "block", ...}}
+//
+// Each node is an object with its "type", its position "pos" as
+// [line, column, endLine, endColumn], the attached "comments" (if any)
+// and the fields of the node type:
+//
+//	block           nodes
+//	import          path
+//	setenv          name, assign
+//	name            name, index
+//	assign          names, values
+//	execAssign      names, command
+//	varAssignDecl   assign
+//	varExecAssignDecl assign
+//	command         name, args, redirects, multi, lparen
+//	pipe            commands, multi
+//	redirect        left, right, location
+//	string          value, quoted
+//	int             value
+//	list            elements, variadic
+//	concat          elements
+//	var             name, variadic
+//	index           var, index, variadic
+//	rfork           flags, options, body
+//	if              lvalue, op, rvalue, body, else, elseIf
+//	comment         value
+//	fnArg           name, variadic
+//	fnDecl          name, args, body
+//	fnInv           name, args
+//	return          values
+//	bindfn          name, cmdname
+//	for             name, in, body
+//
+// Missing fields have their zero value.
+type (
+	jsonTree struct {
+		Version int       `json:"version"`
+		Name    string    `json:"name"`
+		Root    *jsonNode `json:"root"`
+	}
+
+	jsonComments struct {
+		Leading  []*jsonNode `json:"leading,omitempty"`
+		Trailing *jsonNode   `json:"trailing,omitempty"`
+		Opening  *jsonNode   `json:"opening,omitempty"`
+	}
+
+	jsonNode struct {
+		Type     string        `json:"type"`
+		Pos      [4]int        `json:"pos"`
+		Comments *jsonComments `json:"comments,omitempty"`
+
+		Name    string          `json:"name,omitempty"`
+		Value   json.RawMessage `json:"value,omitempty"`
+		Op      string          `json:"op,omitempty"`
+		CmdName string          `json:"cmdname,omitempty"`
+
+		Quoted   bool `json:"quoted,omitempty"`
+		Multi    bool `json:"multi,omitempty"`
+		Variadic bool `json:"variadic,omitempty"`
+		ElseIf   bool `json:"elseIf,omitempty"`
+
+		Left   *int    `json:"left,omitempty"`
+		Right  *int    `json:"right,omitempty"`
+		LParen *[2]int `json:"lparen,omitempty"`
+
+		Path     *jsonNode `json:"path,omitempty"`
+		Assign   *jsonNode `json:"assign,omitempty"`
+		Var      *jsonNode `json:"var,omitempty"`
+		Index    *jsonNode `json:"index,omitempty"`
+		Command  *jsonNode `json:"command,omitempty"`
+		Location *jsonNode `json:"location,omitempty"`
+		Flags    *jsonNode `json:"flags,omitempty"`
+		Options  *jsonNode `json:"options,omitempty"`
+		Lvalue   *jsonNode `json:"lvalue,omitempty"`
+		Rvalue   *jsonNode `json:"rvalue,omitempty"`
+		In       *jsonNode `json:"in,omitempty"`
+		Body     *jsonNode `json:"body,omitempty"`
+		Else     *jsonNode `json:"else,omitempty"`
+
+		Nodes     []*jsonNode `json:"nodes,omitempty"`
+		Names     []*jsonNode `json:"names,omitempty"`
+		Values    []*jsonNode `json:"values,omitempty"`
+		Args      []*jsonNode `json:"args,omitempty"`
+		Redirects []*jsonNode `json:"redirects,omitempty"`
+		Commands  []*jsonNode `json:"commands,omitempty"`
+		Elements  []*jsonNode `json:"elements,omitempty"`
+	}
+)
+
+var jsonTypes = map[NodeType]string{
+	NodeBlock:             "block",
+	NodeImport:            "import",
+	NodeSetenv:            "setenv",
+	NodeName:              "name",
+	NodeAssign:            "assign",
+	NodeExecAssign:        "execAssign",
+	NodeVarAssignDecl:     "varAssignDecl",
+	NodeVarExecAssignDecl: "varExecAssignDecl",
+	NodeCommand:           "command",
+	NodePipe:              "pipe",
+	NodeRedirect:          "redirect",
+	NodeStringExpr:        "string",
+	NodeIntExpr:           "int",
+	NodeListExpr:          "list",
+	NodeConcatExpr:        "concat",
+	NodeVarExpr:           "var",
+	NodeIndexExpr:         "index",
+	NodeRfork:             "rfork",
+	NodeIf:                "if",
+	NodeComment:           "comment",
+	NodeFnArg:             "fnArg",
+	NodeFnDecl:            "fnDecl",
+	NodeFnInv:             "fnInv",
+	NodeReturn:            "return",
+	NodeBindFn:            "bindfn",
+	NodeFor:               "for",
+}
+
+// MarshalJSON encodes the tree as JSON.
+func (tree *Tree) MarshalJSON() ([]byte, error) {
+	jt := jsonTree{
+		Version: JSONVersion,
+		Name:    tree.Name,
+	}
+
+	if tree.Root != nil {
+		jt.Root = toJSON(tree.Root)
+	}
+
+	var buf bytes.Buffer
+
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+
+	err := enc.Encode(jt)
+	if err != nil {
+		return nil, err
+	}
+
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
+
+// UnmarshalJSON decodes a tree encoded by MarshalJSON.
+func (tree *Tree) UnmarshalJSON(data []byte) error {
+	var jt jsonTree
+
+	err := json.Unmarshal(data, &jt)
+	if err != nil {
+		return err
+	}
+
+	if jt.Version != JSONVersion {
+		return fmt.Errorf("unsupported AST JSON version %d", jt.Version)
+	}
+
+	tree.Name = jt.Name
+	tree.Root = nil
+
+	if jt.Root == nil {
+		return nil
+	}
+
+	root, err := fromJSON(jt.Root)
+	if err != nil {
+		return err
+	}
+
+	block, ok := root.(*BlockNode)
+	if !ok {
+		return fmt.Errorf("root of the tree must be a block, found %s", jt.Root.Type)
+	}
+
+	tree.Root = block
+	return nil
+}
+
+func toJSON(node Node) *jsonNode {
+	if node == nil {
+		return nil
+	}
+
+	jn := &jsonNode{
+		Type: jsonTypes[node.Type()],
+		Pos: [4]int{
+			node.Line(), node.Column(),
+			node.EndLine(), node.EndColumn(),
+		},
+	}
+
+	if c := node.Comments(); c.HasComments() {
+		jn.Comments = &jsonComments{}
+
+		for _, l := range c.Leading {
+			jn.Comments.Leading = append(jn.Comments.Leading, toJSON(l))
+		}
+
+		if c.Trailing != nil {
+			jn.Comments.Trailing = toJSON(c.Trailing)
+		}
+
+		if c.Opening != nil {
+			jn.Comments.Opening = toJSON(c.Opening)
+		}
+	}
+
+	switch n := node.(type) {
+	case *BlockNode:
+		for _, stmt := range n.Nodes {
+			jn.Nodes = append(jn.Nodes, toJSON(stmt))
+		}
+	case *ImportNode:
+		if n.Path != nil {
+			jn.Path = toJSON(n.Path)
+		}
+	case *SetenvNode:
+		jn.Name = n.Name
+		jn.Assign = toJSON(n.assign)
+	case *NameNode:
+		jn.Name = n.Ident
+		jn.Index = toJSON(n.Index)
+	case *AssignNode:
+		jn.Names = namesJSON(n.Names)
+		jn.Values = exprsJSON(n.Values)
+	case *ExecAssignNode:
+		jn.Names = namesJSON(n.Names)
+		jn.Command = toJSON(n.cmd)
+	case *VarAssignDeclNode:
+		if n.Assign != nil {
+			jn.Assign = toJSON(n.Assign)
+		}
+	case *VarExecAssignDeclNode:
+		if n.ExecAssign != nil {
+			jn.Assign = toJSON(n.ExecAssign)
+		}
+	case *CommandNode:
+		jn.Name = n.name
+		jn.Multi = n.multi
+		jn.Args = exprsJSON(n.args)
+
+		if n.lparen.Line() > 0 {
+			jn.LParen = &[2]int{n.lparen.Line(), n.lparen.Column()}
+		}
+
+		for _, redir := range n.redirs {
+			jn.Redirects = append(jn.Redirects, toJSON(redir))
+		}
+	case *PipeNode:
+		jn.Multi = n.multi
+
+		for _, cmd := range n.cmds {
+			jn.Commands = append(jn.Commands, toJSON(cmd))
+		}
+	case *RedirectNode:
+		left, right := n.rmap.lfd, n.rmap.rfd
+		jn.Left, jn.Right = &left, &right
+		jn.Location = toJSON(n.location)
+	case *StringExpr:
+		jn.Value = jsonString(n.str)
+		jn.Quoted = n.quoted
+	case *IntExpr:
+		jn.Value = json.RawMessage(strconv.Itoa(n.val))
+	case *ListExpr:
+		jn.Elements = exprsJSON(n.List)
+		jn.Variadic = n.IsVariadic
+	case *ConcatExpr:
+		jn.Elements = exprsJSON(n.concat)
+	case *VarExpr:
+		jn.Name = n.Name
+		jn.Variadic = n.IsVariadic
+	case *IndexExpr:
+		if n.Var != nil {
+			jn.Var = toJSON(n.Var)
+		}
+		jn.Index = toJSON(n.Index)
+		jn.Variadic = n.IsVariadic
+	case *RforkNode:
+		if n.arg != nil {
+			jn.Flags = toJSON(n.arg)
+		}
+		if n.opts != nil {
+			jn.Options = toJSON(n.opts)
+		}
+		jn.Body = treeJSON(n.tree)
+	case *IfNode:
+		jn.Lvalue = toJSON(n.lvalue)
+		jn.Rvalue = toJSON(n.rvalue)
+		jn.Op = n.op
+		jn.ElseIf = n.elseIf
+		jn.Body = treeJSON(n.ifTree)
+		jn.Else = treeJSON(n.elseTree)
+	case *CommentNode:
+		jn.Value = jsonString(n.val)
+	case *FnArgNode:
+		jn.Name = n.Name
+		jn.Variadic = n.IsVariadic
+	case *FnDeclNode:
+		jn.Name = n.name
+
+		for _, arg := range n.args {
+			jn.Args = append(jn.Args, toJSON(arg))
+		}
+
+		jn.Body = treeJSON(n.tree)
+	case *FnInvNode:
+		jn.Name = n.name
+		jn.Args = exprsJSON(n.args)
+	case *ReturnNode:
+		jn.Values = exprsJSON(n.Returns)
+	case *BindFnNode:
+		jn.Name = n.name
+		jn.CmdName = n.cmdname
+	case *ForNode:
+		jn.Name = n.identifier
+		jn.In = toJSON(n.inExpr)
+		jn.Body = treeJSON(n.tree)
+	}
+
+	return jn
+}
+
+func namesJSON(names []*NameNode) []*jsonNode {
+	var nodes []*jsonNode
+	for _, name := range names {
+		nodes = append(nodes, toJSON(name))
+	}
+	return nodes
+}
+
+func exprsJSON(exprs []Expr) []*jsonNode {
+	var nodes []*jsonNode
+	for _, expr := range exprs {
+		nodes = append(nodes, toJSON(expr))
+	}
+	return nodes
+}
+
+func treeJSON(tree *Tree) *jsonNode {
+	if tree == nil || tree.Root == nil {
+		return nil
+	}
+	return toJSON(tree.Root)
+}
+
+func jsonString(s string) json.RawMessage {
+	data, _ := json.Marshal(s)
+	return data
+}
+
+func fromJSON(jn *jsonNode) (Node, error) {
+	if jn == nil {
+		return nil, nil
+	}
+
+	info := token.NewFileInfo(jn.Pos[0], jn.Pos[1])
+	info.SetEnd(jn.Pos[2], jn.Pos[3])
+
+	var (
+		node Node
+		err  error
+	)
+
+	err = checkJSON(jn)
+	if err != nil {
+		return nil, err
+	}
+
+	switch jn.Type {
+	case "block":
+		n := NewBlockNode(info)
+		for _, stmt := range jn.Nodes {
+			child, err := fromJSON(stmt)
+			if err != nil {
+				return nil, err
+			}
+			n.Push(child)
+		}
+		node = n
+	case "import":
+		path, err := stringFromJSON(jn.Path)
+		if err != nil {
+			return nil, err
+		}
+		node = NewImportNode(info, path)
+	case "setenv":
+		assign, err := fromJSON(jn.Assign)
+		if err != nil {
+			return nil, err
+		}
+
+		node, err = NewSetenvNode(info, jn.Name, assign)
+		if err != nil {
+			return nil, err
+		}
+	case "name":
+		index, err := exprFromJSON(jn.Index)
+		if err != nil {
+			return nil, err
+		}
+		node = NewNameNode(info, jn.Name, index)
+	case "assign":
+		names, err := namesFromJSON(jn.Names)
+		if err != nil {
+			return nil, err
+		}
+
+		values, err := exprsFromJSON(jn.Values)
+		if err != nil {
+			return nil, err
+		}
+		node = NewAssignNode(info, names, values)
+	case "execAssign":
+		node, err = execAssignFromJSON(info, jn)
+	case "varAssignDecl":
+		assign, err := fromJSON(jn.Assign)
+		if err != nil {
+			return nil, err
+		}
+
+		n, ok := assign.(*AssignNode)
+		if !ok {
+			return nil, fmt.Errorf("%s: expected assign node", jn.Type)
+		}
+		node = NewVarAssignDecl(info, n)
+	case "varExecAssignDecl":
+		assign, err := fromJSON(jn.Assign)
+		if err != nil {
+			return nil, err
+		}
+
+		n, ok := assign.(*ExecAssignNode)
+		if !ok {
+			return nil, fmt.Errorf("%s: expected execAssign node", jn.Type)
+		}
+		node = NewVarExecAssignDecl(info, n)
+	case "command":
+		node, err = commandFromJSON(info, jn)
+	case "pipe":
+		n := NewPipeNode(info, jn.Multi)
+		for _, jc := range jn.Commands {
+			cmd, err := fromJSON(jc)
+			if err != nil {
+				return nil, err
+			}
+
+			c, ok := cmd.(*CommandNode)
+			if !ok {
+				return nil, fmt.Errorf("pipe: expected command node, found %s", jc.Type)
+			}
+			n.AddCmd(c)
+		}
+		node = n
+	case "redirect":
+		n := NewRedirectNode(info)
+		if jn.Left != nil && jn.Right != nil {
+			n.SetMap(*jn.Left, *jn.Right)
+		}
+
+		location, err := exprFromJSON(jn.Location)
+		if err != nil {
+			return nil, err
+		}
+
+		if location != nil {
+			n.SetLocation(location)
+		}
+		node = n
+	case "string":
+		var value string
+		if err := unmarshalValue(jn, &value); err != nil {
+			return nil, err
+		}
+		node = NewStringExpr(info, value, jn.Quoted)
+	case "int":
+		var value int
+		if err := unmarshalValue(jn, &value); err != nil {
+			return nil, err
+		}
+		node = NewIntExpr(info, value)
+	case "list":
+		elems, err := exprsFromJSON(jn.Elements)
+		if err != nil {
+			return nil, err
+		}
+		node = NewListVariadicExpr(info, elems, jn.Variadic)
+	case "concat":
+		parts, err := exprsFromJSON(jn.Elements)
+		if err != nil {
+			return nil, err
+		}
+		node = NewConcatExpr(info, parts)
+	case "var":
+		node = NewVarVariadicExpr(info, jn.Name, jn.Variadic)
+	case "index":
+		v, err := fromJSON(jn.Var)
+		if err != nil {
+			return nil, err
+		}
+
+		va, ok := v.(*VarExpr)
+		if !ok {
+			return nil, fmt.Errorf("index: expected var node")
+		}
+
+		index, err := exprFromJSON(jn.Index)
+		if err != nil {
+			return nil, err
+		}
+		node = NewIndexVariadicExpr(info, va, index, jn.Variadic)
+	case "rfork":
+		n := NewRforkNode(info)
+
+		if jn.Flags != nil {
+			flags, err := stringFromJSON(jn.Flags)
+			if err != nil {
+				return nil, err
+			}
+			n.SetFlags(flags)
+		}
+
+		if jn.Options != nil {
+			opts, err := exprFromJSON(jn.Options)
+			if err != nil {
+				return nil, err
+			}
+			n.SetOptions(opts)
+		}
+
+		tree, err := treeFromJSON(jn.Body)
+		if err != nil {
+			return nil, err
+		}
+
+		n.SetTree(tree)
+		node = n
+	case "if":
+		node, err = ifFromJSON(info, jn)
+	case "comment":
+		var value string
+		if err := unmarshalValue(jn, &value); err != nil {
+			return nil, err
+		}
+		node = NewCommentNode(info, value)
+	case "fnArg":
+		node = NewFnArgNode(info, jn.Name, jn.Variadic)
+	case "fnDecl":
+		n := NewFnDeclNode(info, jn.Name)
+
+		for _, ja := range jn.Args {
+			arg, err := fromJSON(ja)
+			if err != nil {
+				return nil, err
+			}
+
+			a, ok := arg.(*FnArgNode)
+			if !ok {
+				return nil, fmt.Errorf("fnDecl: expected fnArg node, found %s", ja.Type)
+			}
+			n.AddArg(a)
+		}
+
+		tree, err := treeFromJSON(jn.Body)
+		if err != nil {
+			return nil, err
+		}
+
+		n.SetTree(tree)
+		node = n
+	case "fnInv":
+		n := NewFnInvNode(info, jn.Name)
+
+		args, err := exprsFromJSON(jn.Args)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, arg := range args {
+			n.AddArg(arg)
+		}
+		node = n
+	case "return":
+		n := NewReturnNode(info)
+
+		n.Returns, err = exprsFromJSON(jn.Values)
+		if err != nil {
+			return nil, err
+		}
+		node = n
+	case "bindfn":
+		node = NewBindFnNode(info, jn.Name, jn.CmdName)
+	case "for":
+		n := NewForNode(info)
+		n.SetIdentifier(jn.Name)
+
+		in, err := exprFromJSON(jn.In)
+		if err != nil {
+			return nil, err
+		}
+
+		if in != nil {
+			n.SetInExpr(in)
+		}
+
+		tree, err := treeFromJSON(jn.Body)
+		if err != nil {
+			return nil, err
+		}
+
+		n.SetTree(tree)
+		node = n
+	default:
+		return nil, fmt.Errorf("unknown node type %q", jn.Type)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if jn.Comments != nil {
+		err = commentsFromJSON(node.Comments(), jn.Comments)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return node, nil
+}
+
+// jsonRequired lists the fields, with the names of the JSON encoding,
+// required on each node type.
+var jsonRequired = map[string][]string{
+	"import":            {"path"},
+	"setenv":            {"name"},
+	"name":              {"name"},
+	"assign":            {"names", "values"},
+	"execAssign":        {"names", "command"},
+	"varAssignDecl":     {"assign"},
+	"varExecAssignDecl": {"assign"},
+	"command":           {"name"},
+	"pipe":              {"commands"},
+	"var":               {"name"},
+	"index":             {"var", "index"},
+	"rfork":             {"flags"},
+	"if":                {"lvalue", "rvalue", "op"},
+	"fnArg":             {"name"},
+	"fnDecl":            {"name"},
+	"fnInv":             {"name"},
+	"bindfn":            {"name", "cmdname"},
+}
+
+// checkJSON returns an error if the node lacks a required field or
+// has a null node in its lists.
+func checkJSON(jn *jsonNode) error {
+	present := map[string]bool{
+		"name":     jn.Name != "",
+		"op":       jn.Op != "",
+		"cmdname":  jn.CmdName != "",
+		"path":     jn.Path != nil,
+		"assign":   jn.Assign != nil,
+		"var":      jn.Var != nil,
+		"index":    jn.Index != nil,
+		"command":  jn.Command != nil,
+		"flags":    jn.Flags != nil,
+		"lvalue":   jn.Lvalue != nil,
+		"rvalue":   jn.Rvalue != nil,
+		"names":    len(jn.Names) > 0,
+		"values":   len(jn.Values) > 0,
+		"commands": len(jn.Commands) > 0,
+	}
+
+	for _, field := range jsonRequired[jn.Type] {
+		if !present[field] {
+			return fmt.Errorf("%s: missing %s", jn.Type, field)
+		}
+	}
+
+	// the identifier and the list of a for loop go together
+	if jn.Type == "for" && (jn.Name == "") != (jn.In == nil) {
+		return fmt.Errorf("for: missing name or in")
+	}
+
+	for field, nodes := range map[string][]*jsonNode{
+		"nodes":     jn.Nodes,
+		"names":     jn.Names,
+		"values":    jn.Values,
+		"args":      jn.Args,
+		"redirects": jn.Redirects,
+		"commands":  jn.Commands,
+		"elements":  jn.Elements,
+	} {
+		for _, n := range nodes {
+			if n == nil {
+				return fmt.Errorf("%s: null node in %s", jn.Type, field)
+			}
+		}
+	}
+
+	if jn.Comments != nil {
+		for _, n := range jn.Comments.Leading {
+			if n == nil {
+				return fmt.Errorf("%s: null leading comment", jn.Type)
+			}
+		}
+	}
+
+	return nil
+}
+
+func unmarshalValue(jn *jsonNode, value interface{}) error {
+	if len(jn.Value) == 0 {
+		return nil
+	}
+
+	err := json.Unmarshal(jn.Value, value)
+	if err != nil {
+		return fmt.Errorf("%s: invalid value: %s", jn.Type, err)
+	}
+
+	return nil
+}
+
+func exprFromJSON(jn *jsonNode) (Expr, error) {
+	node, err := fromJSON(jn)
+	if err != nil || node == nil {
+		return nil, err
+	}
+
+	// function invocations are allowed as arguments
+	if !node.Type().IsExpr() && node.Type() != NodeFnInv {
+		return nil, fmt.Errorf("expected expression, found %s", jn.Type)
+	}
+
+	return node, nil
+}
+
+func exprsFromJSON(nodes []*jsonNode) ([]Expr, error) {
+	var exprs []Expr
+
+	for _, jn := range nodes {
+		expr, err := exprFromJSON(jn)
+		if err != nil {
+			return nil, err
+		}
+
+		if expr == nil {
+			return nil, fmt.Errorf("unexpected null expression")
+		}
+
+		exprs = append(exprs, expr)
+	}
+
+	return exprs, nil
+}
+
+func stringFromJSON(jn *jsonNode) (*StringExpr, error) {
+	node, err := fromJSON(jn)
+	if err != nil {
+		return nil, err
+	}
+
+	str, ok := node.(*StringExpr)
+	if !ok {
+		return nil, fmt.Errorf("expected string node")
+	}
+
+	return str, nil
+}
+
+func namesFromJSON(nodes []*jsonNode) ([]*NameNode, error) {
+	var names []*NameNode
+
+	for _, jn := range nodes {
+		node, err := fromJSON(jn)
+		if err != nil {
+			return nil, err
+		}
+
+		name, ok := node.(*NameNode)
+		if !ok {
+			return nil, fmt.Errorf("expected name node")
+		}
+
+		names = append(names, name)
+	}
+
+	return names, nil
+}
+
+func execAssignFromJSON(info token.FileInfo, jn *jsonNode) (*ExecAssignNode, error) {
+	names, err := namesFromJSON(jn.Names)
+	if err != nil {
+		return nil, err
+	}
+
+	cmd, err := fromJSON(jn.Command)
+	if err != nil {
+		return nil, err
+	}
+
+	if cmd == nil {
+		return nil, fmt.Errorf("%s: missing command", jn.Type)
+	}
+
+	return NewExecAssignNode(info, names, cmd)
+}
+
+func commandFromJSON(info token.FileInfo, jn *jsonNode) (*CommandNode, error) {
+	n := NewCommandNode(info, jn.Name, jn.Multi)
+
+	if jn.LParen != nil {
+		n.SetLParen(token.NewFileInfo(jn.LParen[0], jn.LParen[1]))
+	}
+
+	args, err := exprsFromJSON(jn.Args)
+	if err != nil {
+		return nil, err
+	}
+
+	n.SetArgs(args)
+
+	for _, jr := range jn.Redirects {
+		redir, err := fromJSON(jr)
+		if err != nil {
+			return nil, err
+		}
+
+		r, ok := redir.(*RedirectNode)
+		if !ok {
+			return nil, fmt.Errorf("command: expected redirect node, found %s", jr.Type)
+		}
+		n.AddRedirect(r)
+	}
+
+	return n, nil
+}
+
+func ifFromJSON(info token.FileInfo, jn *jsonNode) (*IfNode, error) {
+	n := NewIfNode(info)
+
+	lvalue, err := exprFromJSON(jn.Lvalue)
+	if err != nil {
+		return nil, err
+	}
+
+	rvalue, err := exprFromJSON(jn.Rvalue)
+	if err != nil {
+		return nil, err
+	}
+
+	n.SetLvalue(lvalue)
+	n.SetRvalue(rvalue)
+	n.SetOp(jn.Op)
+	n.SetElseif(jn.ElseIf)
+
+	ifTree, err := treeFromJSON(jn.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	n.SetIfTree(ifTree)
+
+	if jn.Else != nil {
+		elseTree, err := treeFromJSON(jn.Else)
+		if err != nil {
+			return nil, err
+		}
+
+		n.SetElseTree(elseTree)
+	}
+
+	return n, nil
+}
+
+func treeFromJSON(jn *jsonNode) (*Tree, error) {
+	tree := NewTree("")
+
+	if jn == nil {
+		return tree, nil
+	}
+
+	node, err := fromJSON(jn)
+	if err != nil {
+		return nil, err
+	}
+
+	block, ok := node.(*BlockNode)
+	if !ok {
+		return nil, fmt.Errorf("expected block node, found %s", jn.Type)
+	}
+
+	tree.Root = block
+	return tree, nil
+}
+
+func commentsFromJSON(comments *Comments, jc *jsonComments) error {
+	for _, jn := range jc.Leading {
+		c, err := commentFromJSON(jn)
+		if err != nil {
+			return err
+		}
+		comments.Leading = append(comments.Leading, c)
+	}
+
+	if jc.Trailing != nil {
+		c, err := commentFromJSON(jc.Trailing)
+		if err != nil {
+			return err
+		}
+		comments.Trailing = c
+	}
+
+	if jc.Opening != nil {
+		c, err := commentFromJSON(jc.Opening)
+		if err != nil {
+			return err
+		}
+		comments.Opening = c
+	}
+
+	return nil
+}
+
+func commentFromJSON(jn *jsonNode) (*CommentNode, error) {
+	node, err := fromJSON(jn)
+	if err != nil {
+		return nil, err
+	}
+
+	c, ok := node.(*CommentNode)
+	if !ok {
+		return nil, fmt.Errorf("expected comment node")
+	}
+
+	return c, nil
+}
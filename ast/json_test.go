@@ -0,0 +1,171 @@
+package ast_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/madlambda/nash/ast"
+	"github.com/madlambda/nash/parser"
+)
+
+func TestJSONRoundTrip(t *testing.T) {
+	files := map[string]string{
+		"walk": walkScript,
+		"rfork": `rfork um ("propagation=" + $type) {
+	ls
+}
+rfork u $opts {
+	ls
+}`,
+	}
+
+	for _, pattern := range []string{
+		"../testfiles/*.sh",
+		"../examples/*.sh",
+		"../stdlib/*.sh",
+	} {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, fname := range matches {
+			content, err := ioutil.ReadFile(fname)
+			if err != nil {
+				t.Fatal(err)
+			}
+			files[fname] = string(content)
+		}
+	}
+
+	for fname, content := range files {
+		tree, err := parser.NewParser(fname, content).Parse()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		data, err := json.Marshal(tree)
+		if err != nil {
+			t.Fatalf("%s: %s", fname, err)
+		}
+
+		var got ast.Tree
+		err = json.Unmarshal(data, &got)
+		if err != nil {
+			t.Fatalf("%s: %s", fname, err)
+		}
+
+		if got.Name != fname {
+			t.Fatalf("expected tree name %s but got %s", fname, got.Name)
+		}
+
+		if !tree.IsEqual(&got) {
+			t.Fatalf("%s: decoded tree differs", fname)
+		}
+
+		if tree.String() != got.String() {
+			t.Fatalf("%s: decoded tree formats differently:\n%s\n!=\n%s",
+				fname, tree.String(), got.String())
+		}
+
+		again, err := json.Marshal(&got)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.Equal(data, again) {
+			t.Fatalf("%s: encoding is not stable:\n%s\n!=\n%s", fname, data, again)
+		}
+	}
+}
+
+func TestJSONSchema(t *testing.T) {
+	tree, err := parser.NewParser("schema", `echo "a" $b[0] # comment`).Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := json.Marshal(tree)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `{"version":1,"name":"schema","root":{"type":"block","pos":[1,0,1,14],` +
+		`"nodes":[{"type":"command","pos":[1,0,1,14],` +
+		`"comments":{"trailing":{"type":"comment","pos":[1,15,1,24],"value":"# comment"}},` +
+		`"name":"echo","args":[` +
+		`{"type":"string","pos":[1,6,1,8],"value":"a","quoted":true},` +
+		`{"type":"index","pos":[1,9,1,14],"var":{"type":"var","pos":[1,9,1,11],"name":"$b"},` +
+		`"index":{"type":"int","pos":[1,12,1,13],"value":0}}]}]}}`
+
+	if string(data) != expected {
+		t.Fatalf("JSON differs:\n%s\n!=\n%s", data, expected)
+	}
+}
+
+func TestJSONErrors(t *testing.T) {
+	for _, tc := range []string{
+		`{"version":2,"name":"a"}`,
+		`{"version":1,"name":"a","root":{"type":"command"}}`,
+		`{"version":1,"name":"a","root":{"type":"block","nodes":[{"type":"unknown"}]}}`,
+		`{"version":1,"name":"a","root":{"type":"block","nodes":[{"type":"pipe","commands":[{"type":"string"}]}]}}`,
+	} {
+		var tree ast.Tree
+		if err := json.NewDecoder(strings.NewReader(tc)).Decode(&tree); err == nil {
+			t.Errorf("expected error decoding %s", tc)
+		}
+	}
+}
+
+func TestJSONMalformedNodes(t *testing.T) {
+	const (
+		echo = `{"type":"command","name":"echo"}`
+		a    = `{"type":"var","name":"$a"}`
+	)
+
+	for _, tc := range []struct {
+		name string
+		node string
+		err  string
+	}{
+		{"NullNode", `null`, "block: null node in nodes"},
+		{"IfWithoutValues", `{"type":"if"}`, "if: missing lvalue"},
+		{"IfWithoutOp", `{"type":"if","lvalue":` + a + `,"rvalue":` + a + `}`, "if: missing op"},
+		{"RforkWithoutFlags", `{"type":"rfork"}`, "rfork: missing flags"},
+		{"CommandWithoutName", `{"type":"command"}`, "command: missing name"},
+		{"NullRedirect", `{"type":"command","name":"echo","redirects":[null]}`, "command: null node in redirects"},
+		{"NullArg", `{"type":"command","name":"echo","args":[null]}`, "command: null node in args"},
+		{"PipeWithoutCommands", `{"type":"pipe"}`, "pipe: missing commands"},
+		{"NullPipeCommand", `{"type":"pipe","commands":[` + echo + `,null]}`, "pipe: null node in commands"},
+		{"FnDeclWithoutName", `{"type":"fnDecl"}`, "fnDecl: missing name"},
+		{"NullFnArg", `{"type":"fnDecl","name":"f","args":[null]}`, "fnDecl: null node in args"},
+		{"FnArgWithoutName", `{"type":"fnDecl","name":"f","args":[{"type":"fnArg"}]}`, "fnArg: missing name"},
+		{"FnInvWithoutName", `{"type":"fnInv"}`, "fnInv: missing name"},
+		{"ForWithoutName", `{"type":"for","in":` + a + `}`, "for: missing name or in"},
+		{"ForWithoutIn", `{"type":"for","name":"x"}`, "for: missing name or in"},
+		{"AssignWithoutValues", `{"type":"assign","names":[{"type":"name","name":"a"}]}`, "assign: missing values"},
+		{"ExecAssignWithoutNames", `{"type":"execAssign","command":` + echo + `}`, "execAssign: missing names"},
+		{"VarDeclWithoutAssign", `{"type":"varAssignDecl"}`, "varAssignDecl: missing assign"},
+		{"SetenvWithoutName", `{"type":"setenv"}`, "setenv: missing name"},
+		{"ImportWithoutPath", `{"type":"import"}`, "import: missing path"},
+		{"VarWithoutName", `{"type":"command","name":"echo","args":[{"type":"var"}]}`, "var: missing name"},
+		{"IndexWithoutIndex", `{"type":"command","name":"echo","args":[{"type":"index","var":` + a + `}]}`, "index: missing index"},
+		{"NullListElement", `{"type":"command","name":"echo","args":[{"type":"list","elements":[null]}]}`, "list: null node in elements"},
+		{"BindfnWithoutCmdName", `{"type":"bindfn","name":"f"}`, "bindfn: missing cmdname"},
+		{"NullComment", `{"type":"command","name":"echo","comments":{"leading":[null]}}`, "command: null leading comment"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			data := `{"version":1,"name":"a","root":{"type":"block","nodes":[` + tc.node + `]}}`
+
+			var tree ast.Tree
+			err := json.Unmarshal([]byte(data), &tree)
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Fatalf("expected error %q but got: %v", tc.err, err)
+			}
+		})
+	}
+}
@@ -13,7 +13,7 @@ const (
 	// RedirMapSupress indicates the rhs of map was suppressed
 	RedirMapSupress = -2
 
-	RforkFlags = "umnips"
+	RforkFlags = "cumnipsgt"
 )
 
 type (
@@ -26,6 +26,13 @@ type (
 		Line() int
 		// Column of the node in the file
 		Column() int
+		// EndLine is the line of the end of the node
+		EndLine() int
+		// EndColumn is the column right after the end of the node
+		EndColumn() int
+
+		// Comments attached to the node
+		Comments() *Comments
 
 		// String representation of the node.
 		// Note that it could not match the correspondent node in
@@ -33,14 +40,28 @@ type (
 		String() string
 	}
 
-	assignable interface {
-		names() []*NameNode
-		setEqSpace(int)
-		getEqSpace() int
-		string() (string, bool)
+	egalitarian struct{}
+
+	// Comments are the comments attached to a node. Comment lines
+	// between statements are CommentNode statements of the block,
+	// instead.
+	Comments struct {
+		// Leading are the comment lines preceding the node.
+		Leading []*CommentNode
+
+		// Trailing is the comment at the end of the last line of
+		// the node or, for blocks, at the end of the line opening
+		// them.
+		Trailing *CommentNode
+
+		// Opening is the comment at the end of the line opening a
+		// list that spans multiple lines.
+		Opening *CommentNode
 	}
 
-	egalitarian struct{}
+	commented struct {
+		comments Comments
+	}
 
 	// Expr is the interface of expression nodes.
 	Expr Node
@@ -53,6 +74,7 @@ type (
 		NodeType
 		token.FileInfo
 		egalitarian
+		commented
 
 		Nodes []Node
 	}
@@ -62,6 +84,7 @@ type (
 		NodeType
 		token.FileInfo
 		egalitarian
+		commented
 
 		Path *StringExpr // Import path
 	}
@@ -71,6 +94,7 @@ type (
 		NodeType
 		token.FileInfo
 		egalitarian
+		commented
 
 		Name   string
 		assign Node
@@ -80,6 +104,7 @@ type (
 		NodeType
 		token.FileInfo
 		egalitarian
+		commented
 
 		Ident string
 		Index Expr
@@ -90,10 +115,10 @@ type (
 		NodeType
 		token.FileInfo
 		egalitarian
+		commented
 
-		Names   []*NameNode
-		Values  []Expr
-		eqSpace int
+		Names  []*NameNode
+		Values []Expr
 	}
 
 	// ExecAssignNode represents the node for execution assignment.
@@ -101,10 +126,10 @@ type (
 		NodeType
 		token.FileInfo
 		egalitarian
+		commented
 
-		Names   []*NameNode
-		cmd     Node
-		eqSpace int
+		Names []*NameNode
+		cmd   Node
 	}
 
 	// A CommandNode is a node for commands
@@ -112,12 +137,14 @@ type (
 		NodeType
 		token.FileInfo
 		egalitarian
+		commented
 
 		name   string
 		args   []Expr
 		redirs []*RedirectNode
 
-		multi bool
+		multi  bool
+		lparen token.FileInfo
 	}
 
 	// PipeNode represents the node for a command pipeline.
@@ -125,6 +152,7 @@ type (
 		NodeType
 		token.FileInfo
 		egalitarian
+		commented
 
 		cmds  []*CommandNode
 		multi bool
@@ -135,6 +163,7 @@ type (
 		NodeType
 		token.FileInfo
 		egalitarian
+		commented
 
 		str    string
 		quoted bool
@@ -145,6 +174,7 @@ type (
 		NodeType
 		token.FileInfo
 		egalitarian
+		commented
 
 		val int
 	}
@@ -154,6 +184,7 @@ type (
 		NodeType
 		token.FileInfo
 		egalitarian
+		commented
 
 		List       []Expr
 		IsVariadic bool
@@ -164,6 +195,7 @@ type (
 		NodeType
 		token.FileInfo
 		egalitarian
+		commented
 
 		concat []Expr
 	}
@@ -173,6 +205,7 @@ type (
 		NodeType
 		token.FileInfo
 		egalitarian
+		commented
 
 		Name       string
 		IsVariadic bool
@@ -183,6 +216,7 @@ type (
 		NodeType
 		token.FileInfo
 		egalitarian
+		commented
 
 		Var        *VarExpr
 		Index      Expr
@@ -194,6 +228,7 @@ type (
 		NodeType
 		token.FileInfo
 		egalitarian
+		commented
 
 		rmap     RedirMap
 		location Expr
@@ -204,8 +239,10 @@ type (
 		NodeType
 		token.FileInfo
 		egalitarian
+		commented
 
 		arg  *StringExpr
+		opts Expr
 		tree *Tree
 	}
 
@@ -214,6 +251,7 @@ type (
 		NodeType
 		token.FileInfo
 		egalitarian
+		commented
 
 		val string
 	}
@@ -229,6 +267,7 @@ type (
 		NodeType
 		token.FileInfo
 		egalitarian
+		commented
 
 		lvalue Expr
 		rvalue Expr
@@ -244,6 +283,7 @@ type (
 		NodeType
 		token.FileInfo
 		egalitarian
+		commented
 
 		Assign *AssignNode
 	}
@@ -253,6 +293,7 @@ type (
 		NodeType
 		token.FileInfo
 		egalitarian
+		commented
 
 		ExecAssign *ExecAssignNode
 	}
@@ -262,6 +303,7 @@ type (
 		NodeType
 		token.FileInfo
 		egalitarian
+		commented
 
 		Name       string
 		IsVariadic bool
@@ -272,6 +314,7 @@ type (
 		NodeType
 		token.FileInfo
 		egalitarian
+		commented
 
 		name string
 		args []*FnArgNode
@@ -283,6 +326,7 @@ type (
 		NodeType
 		token.FileInfo
 		egalitarian
+		commented
 
 		name string
 		args []Expr
@@ -293,6 +337,7 @@ type (
 		NodeType
 		token.FileInfo
 		egalitarian
+		commented
 
 		Returns []Expr
 	}
@@ -302,6 +347,7 @@ type (
 		NodeType
 		token.FileInfo
 		egalitarian
+		commented
 
 		name    string
 		cmdname string
@@ -312,6 +358,7 @@ type (
 		NodeType
 		token.FileInfo
 		egalitarian
+		commented
 
 		identifier string
 		inExpr     Expr
@@ -439,6 +486,14 @@ func (t NodeType) IsExecutable() bool {
 	return t > execBegin && t < execEnd
 }
 
+// Comments returns the comments attached to the node.
+func (c *commented) Comments() *Comments { return &c.comments }
+
+// HasComments tells if there are comments attached.
+func (c *Comments) HasComments() bool {
+	return len(c.Leading) > 0 || c.Trailing != nil || c.Opening != nil
+}
+
 func (e egalitarian) equal(node, other Node) bool {
 	if node == other {
 		return true
@@ -612,7 +667,6 @@ func NewAssignNode(info token.FileInfo, names []*NameNode, values []Expr) *Assig
 	return &AssignNode{
 		NodeType: NodeAssign,
 		FileInfo: info,
-		eqSpace:  -1,
 
 		Names:  names,
 		Values: values,
@@ -620,18 +674,15 @@ func NewAssignNode(info token.FileInfo, names []*NameNode, values []Expr) *Assig
 }
 
 // NewSingleAssignNode creates an assignment of a single variable. Eg.:
-//   name = "hello"
+//
+//	name = "hello"
+//
 // To make an assignment of multiple variables in the same statement
 // use `NewAssignNode`.
 func NewSingleAssignNode(info token.FileInfo, name *NameNode, value Expr) *AssignNode {
 	return NewAssignNode(info, []*NameNode{name}, []Expr{value})
 }
 
-// TODO(i4k): fix that
-func (n *AssignNode) names() []*NameNode   { return n.Names }
-func (n *AssignNode) getEqSpace() int      { return n.eqSpace }
-func (n *AssignNode) setEqSpace(value int) { n.eqSpace = value }
-
 // IsEqual returns if it is equal to the other node.
 func (n *AssignNode) IsEqual(other Node) bool {
 	if !n.equal(n, other) {
@@ -678,25 +729,21 @@ func (n *AssignNode) IsEqual(other Node) bool {
 // TODO(i4k): Change the API to specific node types. Eg.: NewExecAssignCmdNode and
 // so on.
 func NewExecAssignNode(info token.FileInfo, names []*NameNode, n Node) (*ExecAssignNode, error) {
-	if !n.Type().IsExecutable() {
-		return nil, errors.New("NewExecAssignNode expects a CommandNode, PipeNode or FninvNode")
+	if !n.Type().IsExecutable() && n.Type() != NodeRfork {
+		return nil, errors.New("NewExecAssignNode expects a CommandNode, PipeNode, FninvNode or RforkNode")
 	}
 
 	return &ExecAssignNode{
 		NodeType: NodeExecAssign,
 		FileInfo: info,
 
-		Names:   names,
-		cmd:     n,
-		eqSpace: -1,
+		Names: names,
+		cmd:   n,
 	}, nil
 }
 
-func (n *ExecAssignNode) names() []*NameNode   { return n.Names }
-func (n *ExecAssignNode) getEqSpace() int      { return n.eqSpace }
-func (n *ExecAssignNode) setEqSpace(value int) { n.eqSpace = value }
-
-// Command returns the command (or r-value). Command could be a CommandNode or FnNode
+// Command returns the command (or r-value). Command could be a CommandNode,
+// PipeNode, FnInvNode or RforkNode
 func (n *ExecAssignNode) Command() Node {
 	return n.cmd
 }
@@ -754,6 +801,13 @@ func NewCommandNode(info token.FileInfo, name string, multiline bool) *CommandNo
 func (n *CommandNode) IsMulti() bool   { return n.multi }
 func (n *CommandNode) SetMulti(b bool) { n.multi = b }
 
+// LParen returns the position of the opening parenthesis of a
+// multiline command.
+func (n *CommandNode) LParen() token.FileInfo { return n.lparen }
+
+// SetLParen sets the position of the opening parenthesis.
+func (n *CommandNode) SetLParen(info token.FileInfo) { n.lparen = info }
+
 // AddArg adds a new argument to the command
 func (n *CommandNode) AddArg(a Expr) {
 	n.args = append(n.args, a)
@@ -958,6 +1012,17 @@ func (n *RforkNode) SetFlags(a *StringExpr) {
 	n.arg = a
 }
 
+// Options returns the expression of the rfork options, a list or a
+// variable, or nil if there are none.
+func (n *RforkNode) Options() Expr {
+	return n.opts
+}
+
+// SetOptions sets the rfork options
+func (n *RforkNode) SetOptions(opts Expr) {
+	n.opts = opts
+}
+
 // Tree returns the child tree of node
 func (n *RforkNode) Tree() *Tree {
 	return n.tree
@@ -979,6 +1044,12 @@ func (n *RforkNode) IsEqual(other Node) bool {
 		return false
 	}
 
+	if n.opts != o.opts {
+		if n.opts == nil || o.opts == nil || !n.opts.IsEqual(o.opts) {
+			return false
+		}
+	}
+
 	if n.arg == o.arg {
 		return true
 	}
@@ -1158,6 +1229,7 @@ func (a *FnArgNode) IsEqual(other Node) bool {
 func NewVarAssignDecl(info token.FileInfo, assignNode *AssignNode) *VarAssignDeclNode {
 	return &VarAssignDeclNode{
 		NodeType: NodeVarAssignDecl,
+		FileInfo: info,
 		Assign:   assignNode,
 	}
 }
@@ -1178,6 +1250,7 @@ func (n *VarAssignDeclNode) IsEqual(other Node) bool {
 func NewVarExecAssignDecl(info token.FileInfo, assignNode *ExecAssignNode) *VarExecAssignDeclNode {
 	return &VarExecAssignDeclNode{
 		NodeType:   NodeVarExecAssignDecl,
+		FileInfo:   info,
 		ExecAssign: assignNode,
 	}
 }
@@ -6,6 +6,87 @@ import (
 	"strings"
 )
 
+type (
+	// Style is the layout used to format nash code.
+	Style struct {
+		// Indent is the number of spaces used to indent blocks.
+		// Zero indents with tabs.
+		Indent int
+
+		// LineWidth is the maximum width of the lines. Commands
+		// exceeding it are broken in multiple lines using the
+		// ( ... ) form. Zero disables the wrapping.
+		LineWidth int
+
+		// AlignVars aligns the '=' and '<=' of consecutive
+		// assignments.
+		AlignVars bool
+	}
+
+	// formatter formats nodes following a style. The depth is the
+	// nesting level of the block being formatted, used to compute
+	// the width of its lines.
+	formatter struct {
+		style Style
+		depth int
+	}
+)
+
+// DefaultStyle is the canonical style of nash code, used by the
+// String methods of the nodes.
+var DefaultStyle = Style{
+	AlignVars: true,
+}
+
+// tabWidth is the width of a tab when computing line widths.
+const tabWidth = 8
+
+// multiWidth is the width above which the ( ... ) forms of commands and
+// lists are broken in multiple lines.
+const multiWidth = 50
+
+func (f formatter) indent() string {
+	if f.style.Indent > 0 {
+		return strings.Repeat(" ", f.style.Indent)
+	}
+	return "\t"
+}
+
+func (f formatter) indentWidth() int {
+	if f.style.Indent > 0 {
+		return f.style.Indent
+	}
+	return tabWidth
+}
+
+func (f formatter) nested() formatter {
+	f.depth++
+	return f
+}
+
+// indentLines indents the non empty lines of text.
+func (f formatter) indentLines(text string) string {
+	lines := strings.Split(text, "\n")
+
+	for i := 0; i < len(lines); i++ {
+		if lines[i] != "" {
+			lines[i] = f.indent() + lines[i]
+		}
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// exceeds tells if the line, starting at column col of the current
+// block, exceeds the maximum line width.
+func (f formatter) exceeds(col int, line string) bool {
+	if f.style.LineWidth <= 0 {
+		return false
+	}
+
+	return f.depth*f.indentWidth()+col+len(line) > f.style.LineWidth
+}
+
 func (s *StringExpr) String() string {
 	if s.quoted {
 		return `"` + stringify(s.str) + `"`
@@ -18,30 +99,55 @@ func (i *IntExpr) String() string {
 	return strconv.Itoa(i.val)
 }
 
-func (l *ListExpr) string() (string, bool) {
+func (f formatter) expr(e Expr) string {
+	if l, ok := e.(*ListExpr); ok {
+		str, _ := f.list(l)
+		return str
+	}
+
+	return e.String()
+}
+
+func (f formatter) list(l *ListExpr) (string, bool) {
 	elems := make([]string, len(l.List))
 	columnCount := 0
-	forceMulti := false
+	forceMulti := l.Comments().Opening != nil
 
 	for i := 0; i < len(l.List); i++ {
-		if l.List[i].Type() == NodeListExpr {
+		if l.List[i].Type() == NodeListExpr ||
+			l.List[i].Comments().HasComments() {
 			forceMulti = true
 		}
 
-		elems[i] = l.List[i].String()
+		elems[i] = f.expr(l.List[i])
 		columnCount += len(elems[i])
 	}
 
-	if columnCount+len(elems) > 50 || forceMulti {
-		forceMulti = true
-		return "(\n\t" + strings.Join(elems, "\n\t") + "\n)", forceMulti
+	if columnCount+len(elems) > multiWidth || forceMulti {
+		var lines []string
+
+		for i, elem := range elems {
+			lines = append(lines, leading(l.List[i])...)
+			lines = append(lines, elem+trailing(l.List[i]))
+		}
+
+		open := "("
+		if c := l.Comments().Opening; c != nil {
+			open += " " + c.String()
+		}
+
+		if len(lines) == 0 {
+			return open + "\n)", true
+		}
+
+		return open + "\n" + f.indentLines(strings.Join(lines, "\n")) + "\n)", true
 	}
 
 	return "(" + strings.Join(elems, " ") + ")", false
 }
 
 func (l *ListExpr) String() string {
-	str, _ := l.string()
+	str, _ := formatter{style: DefaultStyle}.list(l)
 	return str
 }
 
@@ -74,39 +180,58 @@ func (i *IndexExpr) String() string {
 	return ret
 }
 
-func (l *BlockNode) adjustGroupAssign(node assignable, nodes []Node) {
-	var (
-		eqSpace int = node.getEqSpace()
-		i       int
-	)
+// lhsOf returns the left hand side of assignment nodes, including var
+// declarations.
+func lhsOf(node Node) (string, bool) {
+	switch n := node.(type) {
+	case *AssignNode:
+		return getlhs(n.Names), true
+	case *ExecAssignNode:
+		return getlhs(n.Names), true
+	case *VarAssignDeclNode:
+		return getlhs(n.Assign.Names), true
+	case *VarExecAssignDeclNode:
+		return getlhs(n.ExecAssign.Names), true
+	}
 
-	lhs := getlhs(node)
+	return "", false
+}
 
-	eqSpace = len(lhs) + 1
+// eqSpaces returns, for each node, the column of the '=' or '<=' of
+// assignments, aligning consecutive assignments of the same kind.
+func (f formatter) eqSpaces(nodes []Node) []int {
+	spaces := make([]int, len(nodes))
 
-	for i = 0; i < len(nodes); i++ {
-		assign, ok := nodes[i].(assignable)
+	if !f.style.AlignVars {
+		return spaces
+	}
 
-		if !ok {
-			break
+	for i := 0; i < len(nodes); {
+		if _, ok := lhsOf(nodes[i]); !ok {
+			i++
+			continue
 		}
 
-		if len(getlhs(assign))+1 > eqSpace {
-			eqSpace = len(getlhs(assign)) + 1
+		j, eqSpace := i, 0
+		for ; j < len(nodes) && nodes[j].Type() == nodes[i].Type(); j++ {
+			lhs, _ := lhsOf(nodes[j])
+			if len(lhs)+1 > eqSpace {
+				eqSpace = len(lhs) + 1
+			}
 		}
-	}
 
-	for j := 0; j < i; j++ {
-		knode := nodes[j].(assignable)
-		knode.setEqSpace(eqSpace)
+		for ; i < j; i++ {
+			spaces[i] = eqSpace
+		}
 	}
 
-	node.setEqSpace(eqSpace)
+	return spaces
 }
 
-func (l *BlockNode) String() string {
+func (f formatter) block(l *BlockNode) string {
 	nodes := l.Nodes
-	content := make([]string, 0, 8192)
+	content := make([]string, 0, len(nodes))
+	eqSpaces := f.eqSpaces(nodes)
 
 	last := (len(nodes) - 1)
 
@@ -114,7 +239,8 @@ func (l *BlockNode) String() string {
 		addEOL := false
 		node := nodes[i]
 
-		nodebytes := node.String()
+		nodebytes, multi := f.stmt(node, eqSpaces[i])
+		nodebytes += trailing(node)
 
 		if i == 0 && node.Type() == NodeComment &&
 			strings.HasPrefix(node.String(), "#!") {
@@ -122,25 +248,21 @@ func (l *BlockNode) String() string {
 		} else if (node.Type() == NodeComment) && i < last {
 			nextNode := nodes[i+1]
 
-			if nextNode.Line() > node.Line()+1 {
+			if startLine(nextNode) > node.Line()+1 {
 				addEOL = true
 			}
 		} else if i < last {
 			nextNode := nodes[i+1]
 
-			if node.Type() != nextNode.Type() {
+			if nextNode.Type() == NodeComment && node.EndLine() > 0 {
+				// keeps the comment close to the statement
+				addEOL = nextNode.Line() > node.EndLine()+1
+			} else if node.Type() != nextNode.Type() {
 				addEOL = true
 			} else if node.Type() == NodeFnDecl {
 				addEOL = true
-			} else if node.Type() == NodeAssign || node.Type() == NodeExecAssign {
-				nodeAssign := node.(assignable)
-
-				if nodeAssign.getEqSpace() == -1 {
-					// lookahead to decide about best '=' distance
-					l.adjustGroupAssign(nodeAssign, nodes[i+1:])
-				}
-
-				nodebytes, addEOL = nodeAssign.string()
+			} else if _, ok := lhsOf(node); ok {
+				addEOL = multi
 			}
 		}
 
@@ -154,6 +276,100 @@ func (l *BlockNode) String() string {
 	return strings.Join(content, "\n")
 }
 
+func (l *BlockNode) String() string {
+	return formatter{style: DefaultStyle}.block(l)
+}
+
+// startLine returns the line where the statement starts in the source,
+// which is the line of the opening parenthesis of multiline commands.
+func startLine(node Node) int {
+	cmd, ok := node.(*CommandNode)
+	if pipe, isPipe := node.(*PipeNode); isPipe && len(pipe.cmds) > 0 {
+		cmd, ok = pipe.cmds[0], true
+	}
+
+	if ok && cmd.lparen.Line() > 0 {
+		return cmd.lparen.Line()
+	}
+
+	return node.Line()
+}
+
+// trailing returns the trailing comment of the node, to be appended
+// to its last line.
+func trailing(node Node) string {
+	if c := node.Comments().Trailing; c != nil {
+		return " " + c.String()
+	}
+
+	return ""
+}
+
+// leading returns the leading comment lines of the node.
+func leading(node Node) []string {
+	var lines []string
+
+	for _, c := range node.Comments().Leading {
+		lines = append(lines, c.String())
+	}
+
+	return lines
+}
+
+// openBlock returns the opening of the block of tree, with its
+// trailing comment.
+func openBlock(tree *Tree) string {
+	if tree == nil || tree.Root == nil {
+		return "{\n"
+	}
+
+	return "{" + trailing(tree.Root) + "\n"
+}
+
+// tree formats the statements of a tree.
+func (f formatter) tree(tree *Tree) string {
+	if tree == nil || tree.Root == nil {
+		return ""
+	}
+
+	return f.block(tree.Root)
+}
+
+// stmt formats a statement and tells if it spans multiple lines.
+// eqSpace is the column of the '=' or '<=' of assignments.
+func (f formatter) stmt(node Node, eqSpace int) (string, bool) {
+	switch n := node.(type) {
+	case *AssignNode:
+		return f.assign(n, eqSpace)
+	case *ExecAssignNode:
+		return f.execAssign(n, eqSpace, 0)
+	case *VarAssignDeclNode:
+		str, multi := f.assign(n.Assign, eqSpace)
+		return "var " + str, multi
+	case *VarExecAssignDeclNode:
+		str, multi := f.execAssign(n.ExecAssign, eqSpace, len("var "))
+		return "var " + str, multi
+	case *CommandNode:
+		return f.command(n, 0)
+	case *PipeNode:
+		return f.pipe(n, 0)
+	case *FnInvNode:
+		return f.fnInv(n), false
+	case *IfNode:
+		return f.ifStmt(n), true
+	case *ForNode:
+		return f.forStmt(n), true
+	case *FnDeclNode:
+		return f.fnDecl(n), true
+	case *RforkNode:
+		return f.rfork(n), n.Tree() != nil
+	case *ReturnNode:
+		return f.returnStmt(n), false
+	}
+
+	return node.String(), false
+}
+
 // String returns the string representation of the import
 func (n *ImportNode) String() string {
 	return `import ` + n.Path.String()
@@ -176,13 +392,13 @@ func (n *NameNode) String() string {
 	return n.Ident
 }
 
-func (n *AssignNode) string() (string, bool) {
+func (f formatter) assign(n *AssignNode, eqSpace int) (string, bool) {
 	var (
 		multi bool
 	)
 
 	objs := n.Values
-	lhs := getlhs(n)
+	lhs := getlhs(n.Names)
 
 	ret := ""
 
@@ -196,16 +412,15 @@ func (n *AssignNode) string() (string, bool) {
 
 		if obj.Type().IsExpr() {
 			if obj.Type() == NodeListExpr {
-				lobj := obj.(*ListExpr)
-				objStr, objmulti = lobj.string()
+				objStr, objmulti = f.list(obj.(*ListExpr))
 			} else {
 				objStr = obj.String()
 			}
 		}
 
 		if i == 0 {
-			if n.eqSpace > len(lhs) && !multi {
-				ret = lhs + strings.Repeat(" ", n.eqSpace-len(lhs)) + "= " + objStr
+			if eqSpace > len(lhs) {
+				ret = lhs + strings.Repeat(" ", eqSpace-len(lhs)) + "= " + objStr
 			} else {
 				ret = lhs + " = " + objStr
 			}
@@ -225,40 +440,45 @@ func (n *AssignNode) string() (string, bool) {
 
 // String returns the string representation of assignment statement
 func (n *AssignNode) String() string {
-	str, _ := n.string()
+	str, _ := formatter{style: DefaultStyle}.assign(n, 0)
 	return str
 }
 
-func (n *ExecAssignNode) string() (string, bool) {
+// execAssign formats the command assignment, that starts at column col.
+func (f formatter) execAssign(n *ExecAssignNode, eqSpace, col int) (string, bool) {
 	var (
 		cmdStr string
 		multi  bool
 	)
 
-	lhs := getlhs(n)
+	lhs := getlhs(n.Names)
 
-	if n.cmd.Type() == NodeCommand {
-		cmd := n.cmd.(*CommandNode)
-		cmdStr, multi = cmd.string()
-	} else if n.cmd.Type() == NodePipe {
-		cmd := n.cmd.(*PipeNode)
-		cmdStr, multi = cmd.string()
+	if eqSpace > len(lhs) {
+		lhs += strings.Repeat(" ", eqSpace-len(lhs))
 	} else {
-		cmd := n.cmd.(*FnInvNode)
-		cmdStr, multi = cmd.string()
+		lhs += " "
 	}
 
-	if n.eqSpace > len(lhs) {
-		ret := lhs + strings.Repeat(" ", n.eqSpace-len(lhs)) + "<= " + cmdStr
-		return ret, multi
+	lhs += "<= "
+	col += len(lhs)
+
+	switch cmd := n.cmd.(type) {
+	case *CommandNode:
+		cmdStr, multi = f.command(cmd, col)
+	case *PipeNode:
+		cmdStr, multi = f.pipe(cmd, col)
+	case *FnInvNode:
+		cmdStr = f.fnInv(cmd)
+	case *RforkNode:
+		cmdStr = f.rfork(cmd)
 	}
 
-	return lhs + " <= " + cmdStr, multi
+	return lhs + cmdStr, multi
 }
 
 // String returns the string representation of command assignment statement
 func (n *ExecAssignNode) String() string {
-	str, _ := n.string()
+	str, _ := formatter{style: DefaultStyle}.execAssign(n, 0, 0)
 	return str
 }
 
@@ -270,19 +490,36 @@ func (n *CommandNode) toStringParts() ([]string, int) {
 		totalLen = 0
 	)
 
-	for i := 0; i < len(n.args); i += 2 {
+	for i := 0; i < len(n.args); {
 		var next string
 
 		arg := n.args[i].String()
+		comment := trailing(n.args[i])
+		step := 1
 
-		if i < last {
-			next = n.args[i+1].String()
-		}
+		if lines := leading(n.args[i]); len(lines) > 0 {
+			if i == 0 {
+				line = n.name
+			}
 
-		if i == 0 {
+			if line != "" {
+				content = append(content, line)
+				line = ""
+			}
+
+			content = append(content, lines...)
+		} else if i == 0 {
 			arg = n.name + " " + arg
 		}
 
+		// arguments are paired, unless comments separate them
+		if i < last && comment == "" &&
+			len(n.args[i+1].Comments().Leading) == 0 {
+			next = n.args[i+1].String()
+			comment = trailing(n.args[i+1])
+			step = 2
+		}
+
 		if arg[0] == '-' {
 			if line != "" {
 				content = append(content, line)
@@ -296,7 +533,10 @@ func (n *CommandNode) toStringParts() ([]string, int) {
 					line += " " + arg + " " + next
 				}
 			} else {
-				content = append(content, arg, next)
+				content = append(content, arg)
+				if next != "" {
+					content = append(content, next)
+				}
 			}
 		} else if next != "" {
 			if line == "" {
@@ -312,8 +552,17 @@ func (n *CommandNode) toStringParts() ([]string, int) {
 			}
 		}
 
-		totalLen += len(arg) + len(next) + 1
+		if comment != "" {
+			if line != "" {
+				content = append(content, line+comment)
+				line = ""
+			} else {
+				content[len(content)-1] += comment
+			}
+		}
 
+		totalLen += len(arg) + len(next) + 1
+		i += step
 	}
 
 	if line != "" {
@@ -327,35 +576,80 @@ func (n *CommandNode) toStringParts() ([]string, int) {
 	for i := 0; i < len(n.redirs); i++ {
 		rstr := n.redirs[i].String()
 		totalLen += len(rstr) + 1
-		content = append(content, rstr)
+		content = append(content, leading(n.redirs[i])...)
+		content = append(content, rstr+trailing(n.redirs[i]))
 	}
 
 	return content, totalLen
 }
 
-func (n *CommandNode) multiString() string {
+// cmdLines indents the lines of a command in the multi-line form. The
+// continuation lines are aligned after the first one or, when indenting
+// with spaces, with the first argument.
+func (f formatter) cmdLines(content []string) string {
+	first := f.indent() + content[0]
+
+	var cont string
+	if f.style.Indent > 0 {
+		argcol := strings.IndexByte(content[0], ' ') + 1
+		if argcol == 0 {
+			argcol = len(content[0]) + 1
+		}
+		cont = f.indent() + strings.Repeat(" ", argcol)
+	} else {
+		cont = strings.Repeat("\t", (len(first)+7)/8)
+	}
+
+	lines := []string{first}
+	for i := 1; i < len(content); i++ {
+		lines = append(lines, cont+content[i])
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// multiCommand formats the command in the ( ... ) form, that starts at
+// column col. Short commands are kept in a single line.
+func (f formatter) multiCommand(n *CommandNode, col int) string {
 	content, totalLen := n.toStringParts()
 
-	if totalLen < 50 {
-		return "(" + strings.Join(content, " ") + ")"
+	collapsed := "(" + strings.Join(content, " ") + ")"
+	if totalLen < multiWidth && !f.exceeds(col, collapsed) &&
+		!n.hasComments() && len(n.Comments().Leading) == 0 {
+		return collapsed
 	}
 
-	content[0] = "\t" + content[0]
+	result := "(\n"
+	for _, line := range leading(n) {
+		result += f.indent() + line + "\n"
+	}
 
-	gentab := func(n int) string { return strings.Repeat("\t", n) }
-	tabLen := (len(content[0]) + 7) / 8
+	return result + f.cmdLines(content) + "\n)"
+}
 
-	for i := 1; i < len(content); i++ {
-		content[i] = gentab(tabLen) + content[i]
+// hasComments tells if there are comments attached to the arguments
+// or redirections of the command.
+func (n *CommandNode) hasComments() bool {
+	for _, arg := range n.args {
+		if arg.Comments().HasComments() {
+			return true
+		}
+	}
+
+	for _, redir := range n.redirs {
+		if redir.Comments().HasComments() {
+			return true
+		}
 	}
 
-	return "(\n" + strings.Join(content, "\n") + "\n)"
+	return false
 }
 
-// String returns the string representation of command statement
-func (n *CommandNode) string() (string, bool) {
+// command formats the command, that starts at column col, wrapping it
+// if it is too long.
+func (f formatter) command(n *CommandNode, col int) (string, bool) {
 	if n.multi {
-		return n.multiString(), true
+		return f.multiCommand(n, col), true
 	}
 
 	var content []string
@@ -370,15 +664,23 @@ func (n *CommandNode) string() (string, bool) {
 		content = append(content, n.redirs[i].String())
 	}
 
-	return strings.Join(content, " "), false
+	line := strings.Join(content, " ")
+
+	if len(content) > 1 && f.exceeds(col, line) {
+		parts, _ := n.toStringParts()
+		return "(\n" + f.cmdLines(parts) + "\n)", true
+	}
+
+	return line, false
 }
 
+// String returns the string representation of command statement
 func (n *CommandNode) String() string {
-	str, _ := n.string()
+	str, _ := formatter{style: DefaultStyle}.command(n, 0)
 	return str
 }
 
-func (n *PipeNode) multiString() string {
+func (f formatter) multiPipe(n *PipeNode, col int, force bool) string {
 	totalLen := 0
 
 	type cmdData struct {
@@ -397,9 +699,13 @@ func (n *PipeNode) multiString() string {
 		}
 
 		totalLen += cmdLen
+
+		if n.cmds[i].hasComments() || n.cmds[i].Comments().HasComments() {
+			force = true
+		}
 	}
 
-	if totalLen+3 < 50 {
+	if !force && totalLen+3 < multiWidth {
 		result := "("
 
 		for i := 0; i < len(content); i++ {
@@ -410,54 +716,68 @@ func (n *PipeNode) multiString() string {
 			}
 		}
 
-		return result + ")"
-	}
+		result += ")"
 
-	gentab := func(n int) string { return strings.Repeat("\t", n) }
+		if !f.exceeds(col, result) {
+			return result
+		}
+	}
 
 	result := "(\n"
 
 	for i := 0; i < len(content); i++ {
-		cmdContent := content[i].content
+		for _, line := range leading(n.cmds[i]) {
+			result += f.indent() + line + "\n"
+		}
 
-		cmdContent[0] = "\t" + cmdContent[0]
-		tabLen := (len(cmdContent[0]) + 7) / 8
+		result += f.cmdLines(content[i].content)
 
-		for j := 1; j < len(cmdContent); j++ {
-			cmdContent[j] = gentab(tabLen) + cmdContent[j]
+		if i < len(content)-1 {
+			result += " |"
 		}
 
-		result += strings.Join(cmdContent, "\n")
+		result += trailing(n.cmds[i])
 
 		if i < len(content)-1 {
-			result += " |\n"
+			result += "\n"
 		}
 	}
 
 	return result + "\n)"
 }
 
-// String returns the string representation of pipeline statement
-func (n *PipeNode) string() (string, bool) {
+// pipe formats the pipeline, that starts at column col, wrapping it if
+// it is too long.
+func (f formatter) pipe(n *PipeNode, col int) (string, bool) {
 	if n.multi {
-		return n.multiString(), true
+		return f.multiPipe(n, col, false), true
 	}
 
+	// the commands are wrapped together, in the pipe form
+	noWrap := f
+	noWrap.style.LineWidth = 0
+
 	ret := ""
 
 	for i := 0; i < len(n.cmds); i++ {
-		ret += n.cmds[i].String()
+		cmd, _ := noWrap.command(n.cmds[i], 0)
+		ret += cmd
 
 		if i < (len(n.cmds) - 1) {
 			ret += " | "
 		}
 	}
 
+	if f.exceeds(col, ret) {
+		return f.multiPipe(n, col, true), true
+	}
+
 	return ret, false
 }
 
+// String returns the string representation of pipeline statement
 func (n *PipeNode) String() string {
-	str, _ := n.string()
+	str, _ := formatter{style: DefaultStyle}.pipe(n, 0)
 	return str
 }
 
@@ -488,116 +808,126 @@ func (r *RedirectNode) String() string {
 	return result
 }
 
-// String returns the string representation of rfork statement
-func (n *RforkNode) String() string {
+func (f formatter) rfork(n *RforkNode) string {
 	rforkstr := "rfork " + n.arg.String()
 	tree := n.Tree()
 
-	if tree != nil {
-		rforkstr += " {\n"
-		block := tree.String()
-		stmts := strings.Split(block, "\n")
-
-		for i := 0; i < len(stmts); i++ {
-			stmts[i] = "\t" + stmts[i]
-		}
+	if n.opts != nil {
+		rforkstr += " " + n.opts.String()
+	}
 
-		rforkstr += strings.Join(stmts, "\n") + "\n}"
+	if tree != nil {
+		rforkstr += " " + openBlock(tree) + f.indentLines(f.nested().tree(tree)) + "\n}"
 	}
 
 	return rforkstr
 }
 
+// String returns the string representation of rfork statement
+func (n *RforkNode) String() string {
+	return formatter{style: DefaultStyle}.rfork(n)
+}
+
 // String returns the string representation of comment
 func (n *CommentNode) String() string {
 	return n.val
 }
 
-// String returns the string representation of if statement
-func (n *IfNode) String() string {
+func (f formatter) ifStmt(n *IfNode) string {
 	var lstr, rstr string
 
 	lstr = n.lvalue.String()
 	rstr = n.rvalue.String()
 
-	ifStr := "if " + lstr + " " + n.op + " " + rstr + " {\n"
-
-	ifTree := n.IfTree()
-
-	block := ifTree.String()
-	stmts := strings.Split(block, "\n")
-
-	if strings.TrimSpace(block) != "" {
-		for i := 0; i < len(stmts); i++ {
-			stmts[i] = "\t" + stmts[i]
-		}
-	}
-
-	ifStr += strings.Join(stmts, "\n") + "\n}"
+	ifStr := "if " + lstr + " " + n.op + " " + rstr + " " + openBlock(n.IfTree())
+	ifStr += f.indentLines(f.nested().tree(n.IfTree())) + "\n}"
 
 	elseTree := n.ElseTree()
 
 	if elseTree != nil {
 		ifStr += " else "
 
-		elseBlock := elseTree.String()
-		elsestmts := strings.Split(elseBlock, "\n")
-
-		for i := 0; i < len(elsestmts); i++ {
-			if !n.IsElseIf() {
-				elsestmts[i] = "\t" + elsestmts[i]
-			}
-		}
-
-		if !n.IsElseIf() {
-			ifStr += "{\n"
-		}
-
-		ifStr += strings.Join(elsestmts, "\n")
-
-		if !n.IsElseIf() {
-			ifStr += "\n}"
+		if n.IsElseIf() {
+			ifStr += f.tree(elseTree)
+		} else {
+			ifStr += openBlock(elseTree) + f.indentLines(f.nested().tree(elseTree)) + "\n}"
 		}
 	}
 
 	return ifStr
 }
 
+// String returns the string representation of if statement
+func (n *IfNode) String() string {
+	return formatter{style: DefaultStyle}.ifStmt(n)
+}
+
 func (n *VarAssignDeclNode) String() string     { return "var " + n.Assign.String() }
 func (n *VarExecAssignDeclNode) String() string { return "var " + n.ExecAssign.String() }
 
-// String returns the string representation of function declaration
-func (n *FnDeclNode) String() string {
+func (f formatter) fnDecl(n *FnDeclNode) string {
 	fnStr := "fn"
 
 	if n.name != "" {
 		fnStr += " " + n.name + "("
 	}
 
-	for i := 0; i < len(n.args); i++ {
-		fnStr += n.args[i].String()
-		if i < (len(n.args) - 1) {
-			fnStr += ", "
-		}
-	}
+	fnStr += f.fnArgs(n) + ") " + openBlock(n.Tree())
+	fnStr += f.indentLines(f.nested().tree(n.Tree())) + "\n}"
 
-	fnStr += ") {\n"
+	return fnStr
+}
 
-	tree := n.Tree()
+// fnArgs formats the arguments of the function declaration. The
+// comments attached to them are kept in the lines of the arguments,
+// breaking the arguments in multiple lines.
+func (f formatter) fnArgs(n *FnDeclNode) string {
+	var (
+		lines []string
+		cur   string
+	)
 
-	stmts := strings.Split(tree.String(), "\n")
+	for i, arg := range n.args {
+		for _, c := range arg.Comments().Leading {
+			if i == 0 && len(lines) == 0 && cur == "" && c.Line() == n.Line() {
+				// comment after the opening parenthesis
+				lines = append(lines, " "+c.String())
+				continue
+			}
 
-	for i := 0; i < len(stmts); i++ {
-		if len(stmts[i]) > 0 {
-			fnStr += "\t" + stmts[i] + "\n"
-		} else {
-			fnStr += "\n"
+			if cur != "" || len(lines) == 0 {
+				lines = append(lines, strings.TrimSuffix(cur, " "))
+				cur = ""
+			}
+			lines = append(lines, c.String())
+		}
+
+		cur += arg.String()
+		if i < (len(n.args) - 1) {
+			cur += ", "
+		}
+
+		if c := arg.Comments().Trailing; c != nil {
+			lines = append(lines, strings.TrimSuffix(cur, " ")+" "+c.String())
+			cur = ""
 		}
 	}
 
-	fnStr += "}"
+	if len(lines) == 0 {
+		return cur
+	}
 
-	return fnStr
+	if cur == "" {
+		// the closing parenthesis goes in a line of its own
+		return strings.Join(lines, "\n"+f.indent()) + "\n"
+	}
+
+	return strings.Join(append(lines, cur), "\n"+f.indent())
+}
+
+// String returns the string representation of function declaration
+func (n *FnDeclNode) String() string {
+	return formatter{style: DefaultStyle}.fnDecl(n)
 }
 
 func (arg *FnArgNode) String() string {
@@ -608,12 +938,11 @@ func (arg *FnArgNode) String() string {
 	return ret
 }
 
-// String returns the string representation of function invocation
-func (n *FnInvNode) string() (string, bool) {
+func (f formatter) fnInv(n *FnInvNode) string {
 	fnInvStr := n.name + "("
 
 	for i := 0; i < len(n.args); i++ {
-		fnInvStr += n.args[i].String()
+		fnInvStr += f.expr(n.args[i])
 
 		if i < (len(n.args) - 1) {
 			fnInvStr += ", "
@@ -622,12 +951,12 @@ func (n *FnInvNode) string() (string, bool) {
 
 	fnInvStr += ")"
 
-	return fnInvStr, false
+	return fnInvStr
 }
 
+// String returns the string representation of function invocation
 func (n *FnInvNode) String() string {
-	str, _ := n.string()
-	return str
+	return formatter{style: DefaultStyle}.fnInv(n)
 }
 
 // String returns the string representation of bindfn
@@ -635,8 +964,7 @@ func (n *BindFnNode) String() string {
 	return "bindfn " + n.name + " " + n.cmdname
 }
 
-// String returns the string representation of return statement
-func (n *ReturnNode) String() string {
+func (f formatter) returnStmt(n *ReturnNode) string {
 	var returns []string
 
 	ret := "return"
@@ -644,7 +972,7 @@ func (n *ReturnNode) String() string {
 	returnExprs := n.Returns
 
 	for i := 0; i < len(returnExprs); i++ {
-		returns = append(returns, returnExprs[i].String())
+		returns = append(returns, f.expr(returnExprs[i]))
 	}
 
 	if len(returns) > 0 {
@@ -654,33 +982,29 @@ func (n *ReturnNode) String() string {
 	return ret
 }
 
-// String returns the string representation of for statement
-func (n *ForNode) String() string {
+// String returns the string representation of return statement
+func (n *ReturnNode) String() string {
+	return formatter{style: DefaultStyle}.returnStmt(n)
+}
+
+func (f formatter) forStmt(n *ForNode) string {
 	ret := "for"
 
 	if n.identifier != "" {
-		ret += " " + n.identifier + " in " + n.inExpr.String()
+		ret += " " + n.identifier + " in " + f.expr(n.inExpr)
 	}
 
-	ret += " {\n"
-
-	tree := n.Tree()
-
-	stmts := strings.Split(tree.String(), "\n")
-
-	for i := 0; i < len(stmts); i++ {
-		if len(stmts[i]) > 0 {
-			ret += "\t" + stmts[i] + "\n"
-		} else {
-			ret += "\n"
-		}
-	}
-
-	ret += "}"
+	ret += " " + openBlock(n.Tree())
+	ret += f.indentLines(f.nested().tree(n.Tree())) + "\n}"
 
 	return ret
 }
 
+// String returns the string representation of for statement
+func (n *ForNode) String() string {
+	return formatter{style: DefaultStyle}.forStmt(n)
+}
+
 func stringify(s string) string {
 	buf := make([]byte, 0, len(s))
 
@@ -704,13 +1028,11 @@ func stringify(s string) string {
 	return string(buf)
 }
 
-func getlhs(node assignable) string {
+func getlhs(names []*NameNode) string {
 	var nameStrs []string
 
-	nodeNames := node.names()
-
-	for i := 0; i < len(nodeNames); i++ {
-		nameStrs = append(nameStrs, nodeNames[i].String())
+	for i := 0; i < len(names); i++ {
+		nameStrs = append(nameStrs, names[i].String())
 	}
 
 	return strings.Join(nameStrs, ", ")
@@ -24,6 +24,12 @@ func (t *Tree) IsEqual(other *Tree) bool {
 }
 
 func (tree *Tree) String() string {
+	return tree.Format(DefaultStyle)
+}
+
+// Format returns the source code of the tree formatted with the given
+// style.
+func (tree *Tree) Format(style Style) string {
 	if tree.Root == nil {
 		return ""
 	}
@@ -32,5 +38,5 @@ func (tree *Tree) String() string {
 		return ""
 	}
 
-	return tree.Root.String()
+	return formatter{style: style}.block(tree.Root)
 }
@@ -0,0 +1,195 @@
+package ast
+
+import "fmt"
+
+// A Visitor's Visit method is invoked for each node encountered by Walk.
+// If the result visitor w is not nil, Walk visits each of the children
+// of node with the visitor w, followed by a call of w.Visit(nil).
+type Visitor interface {
+	Visit(node Node) (w Visitor)
+}
+
+// Walk traverses an AST in depth-first order: It starts by calling
+// v.Visit(node); node must not be nil. If the visitor w returned by
+// v.Visit(node) is not nil, Walk is invoked recursively with visitor
+// w for each of the non-nil children of node, followed by a call of
+// w.Visit(nil).
+//
+// The comments attached to the nodes are not visited, only the
+// CommentNode statements of the blocks.
+func Walk(v Visitor, node Node) {
+	if v = v.Visit(node); v == nil {
+		return
+	}
+
+	switch n := node.(type) {
+	case *BlockNode:
+		for _, stmt := range n.Nodes {
+			Walk(v, stmt)
+		}
+	case *ImportNode:
+		if n.Path != nil {
+			Walk(v, n.Path)
+		}
+	case *SetenvNode:
+		if n.assign != nil {
+			Walk(v, n.assign)
+		}
+	case *NameNode:
+		if n.Index != nil {
+			Walk(v, n.Index)
+		}
+	case *AssignNode:
+		walkNames(v, n.Names)
+		walkExprs(v, n.Values)
+	case *ExecAssignNode:
+		walkNames(v, n.Names)
+
+		if n.cmd != nil {
+			Walk(v, n.cmd)
+		}
+	case *VarAssignDeclNode:
+		if n.Assign != nil {
+			Walk(v, n.Assign)
+		}
+	case *VarExecAssignDeclNode:
+		if n.ExecAssign != nil {
+			Walk(v, n.ExecAssign)
+		}
+	case *CommandNode:
+		walkExprs(v, n.args)
+
+		for _, redir := range n.redirs {
+			Walk(v, redir)
+		}
+	case *PipeNode:
+		for _, cmd := range n.cmds {
+			Walk(v, cmd)
+		}
+	case *RedirectNode:
+		if n.location != nil {
+			Walk(v, n.location)
+		}
+	case *ListExpr:
+		walkExprs(v, n.List)
+	case *ConcatExpr:
+		walkExprs(v, n.concat)
+	case *IndexExpr:
+		if n.Var != nil {
+			Walk(v, n.Var)
+		}
+
+		if n.Index != nil {
+			Walk(v, n.Index)
+		}
+	case *RforkNode:
+		if n.arg != nil {
+			Walk(v, n.arg)
+		}
+
+		if n.opts != nil {
+			Walk(v, n.opts)
+		}
+
+		walkTree(v, n.tree)
+	case *IfNode:
+		if n.lvalue != nil {
+			Walk(v, n.lvalue)
+		}
+
+		if n.rvalue != nil {
+			Walk(v, n.rvalue)
+		}
+
+		walkTree(v, n.ifTree)
+		walkTree(v, n.elseTree)
+	case *FnDeclNode:
+		for _, arg := range n.args {
+			Walk(v, arg)
+		}
+
+		walkTree(v, n.tree)
+	case *FnInvNode:
+		walkExprs(v, n.args)
+	case *ReturnNode:
+		walkExprs(v, n.Returns)
+	case *ForNode:
+		if n.inExpr != nil {
+			Walk(v, n.inExpr)
+		}
+
+		walkTree(v, n.tree)
+	case *StringExpr, *IntExpr, *VarExpr, *CommentNode, *FnArgNode,
+		*BindFnNode:
+		// nothing to do
+	default:
+		panic(fmt.Sprintf("ast.Walk: unexpected node type %T", n))
+	}
+
+	v.Visit(nil)
+}
+
+func walkExprs(v Visitor, list []Expr) {
+	for _, expr := range list {
+		Walk(v, expr)
+	}
+}
+
+func walkNames(v Visitor, names []*NameNode) {
+	for _, name := range names {
+		Walk(v, name)
+	}
+}
+
+func walkTree(v Visitor, tree *Tree) {
+	if tree != nil && tree.Root != nil {
+		Walk(v, tree.Root)
+	}
+}
+
+type inspector func(Node) bool
+
+func (f inspector) Visit(node Node) Visitor {
+	if f(node) {
+		return f
+	}
+	return nil
+}
+
+// Inspect traverses an AST in depth-first order: It starts by calling
+// f(node); node must not be nil. If f returns true, Inspect invokes f
+// recursively for each of the non-nil children of node, followed by a
+// call of f(nil).
+func Inspect(node Node, f func(Node) bool) {
+	Walk(inspector(f), node)
+}
+
+// Rewrite replaces the statements of every block found traversing node,
+// including the blocks of functions, loops, if/else and rfork trees.
+// Each statement is replaced by the result of f, or removed if f returns
+// nil. The nested blocks of a statement are rewritten before it is
+// passed to f.
+func Rewrite(node Node, f func(Node) Node) {
+	var blocks []*BlockNode
+
+	Inspect(node, func(n Node) bool {
+		if block, ok := n.(*BlockNode); ok {
+			blocks = append(blocks, block)
+		}
+		return true
+	})
+
+	// inner blocks come later in depth-first order
+	for i := len(blocks) - 1; i >= 0; i-- {
+		block := blocks[i]
+		nodes := block.Nodes[:0]
+
+		for _, stmt := range block.Nodes {
+			if stmt = f(stmt); stmt != nil {
+				nodes = append(nodes, stmt)
+			}
+		}
+
+		block.Nodes = nodes
+	}
+}
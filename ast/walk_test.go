@@ -0,0 +1,155 @@
+package ast_test
+
+import (
+	"testing"
+
+	"github.com/madlambda/nash/ast"
+	"github.com/madlambda/nash/parser"
+)
+
+const walkScript = `# every node type
+import io
+setenv PATH = "/bin"
+var a, b = "a", ("b" "c")
+var out <= echo $a+$b
+out <= f($a, $b...)
+a[0] = $b[1]
+echo hello | grep hello >[2=1] >[1] /dev/null
+fn f(x, y...) {
+	return $x
+}
+bindfn f cmd
+if $a == "a" {
+	echo a
+} else if $a == "b" {
+	echo b
+} else {
+	echo c
+}
+for x in $b {
+	echo $x
+}
+rfork u {
+	echo rfork
+}
+`
+
+func TestInspect(t *testing.T) {
+	tree, err := parser.NewParser("walk", walkScript).Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	visited := make(map[ast.NodeType]int)
+	nils := 0
+
+	ast.Inspect(tree.Root, func(node ast.Node) bool {
+		if node == nil {
+			nils++
+			return false
+		}
+
+		visited[node.Type()]++
+		return true
+	})
+
+	for typ := ast.NodeSetenv; typ <= ast.NodeFor; typ++ {
+		name := typ.String()
+		if typ == ast.NodeString || typ == ast.NodeRforkFlags ||
+			name[0] != 'N' {
+			// unused or internal markers
+			continue
+		}
+
+		if visited[typ] == 0 {
+			t.Errorf("node type %s not visited", typ)
+		}
+	}
+
+	total := 0
+	for _, count := range visited {
+		total += count
+	}
+
+	if nils != total {
+		t.Fatalf("expected %d nil visits but got %d", total, nils)
+	}
+
+	// the else-if tree and the rfork tree have commands
+	if visited[ast.NodeCommand] != 8 {
+		t.Fatalf("expected 8 commands but got %d", visited[ast.NodeCommand])
+	}
+}
+
+func TestInspectPrune(t *testing.T) {
+	tree, err := parser.NewParser("walk", walkScript).Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var fns []string
+
+	ast.Inspect(tree.Root, func(node ast.Node) bool {
+		switch n := node.(type) {
+		case *ast.FnDeclNode:
+			fns = append(fns, n.Name())
+		case *ast.FnInvNode:
+			fns = append(fns, n.Name())
+		case *ast.IfNode, *ast.ForNode:
+			return false
+		}
+		return true
+	})
+
+	if len(fns) != 2 || fns[0] != "f" || fns[1] != "f" {
+		t.Fatalf("unexpected functions: %v", fns)
+	}
+}
+
+func TestRewrite(t *testing.T) {
+	tree, err := parser.NewParser("rewrite", `echo a
+fn f() {
+	echo b
+	ls
+}
+if $x == "" {
+	echo c
+} else {
+	rfork u {
+		echo d
+	}
+}
+`).Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// removes echo commands and the emptied rfork blocks
+	ast.Rewrite(tree.Root, func(node ast.Node) ast.Node {
+		switch n := node.(type) {
+		case *ast.CommandNode:
+			if n.Name() == "echo" {
+				return nil
+			}
+		case *ast.RforkNode:
+			if len(n.Tree().Root.Nodes) == 0 {
+				return nil
+			}
+		}
+		return node
+	})
+
+	expected := `fn f() {
+	ls
+}
+
+if $x == "" {
+
+} else {
+
+}`
+
+	if tree.String() != expected {
+		t.Fatalf("rewrite differs:\n%s\n!=\n%s", tree.String(), expected)
+	}
+}
@@ -0,0 +1,560 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+type (
+	tokenType int
+
+	// token is a lexical item of a sh script.
+	token struct {
+		typ  tokenType
+		text string // operator, redirection or comment text
+		word *word
+		fd   int // file descriptor of redirections, -1 if not given
+
+		line, endLine int
+	}
+
+	partKind int
+
+	// wordPart is a piece of a word: literal text, a parameter
+	// expansion or a substitution.
+	wordPart struct {
+		kind   partKind
+		text   string
+		quoted bool
+		glob   bool
+	}
+
+	// word is a sh word, made of parts that are concatenated.
+	word struct {
+		parts []wordPart
+		line  int
+	}
+
+	lexer struct {
+		input string
+		pos   int
+		line  int
+
+		heredocs []string
+		tokens   []token
+	}
+)
+
+const (
+	tEOF tokenType = iota
+	tWord
+	tNewline
+	tSemi
+	tDoubleSemi
+	tPipe
+	tAndAnd
+	tOrOr
+	tAmp
+	tLParen
+	tRParen
+	tRedir
+	tComment
+)
+
+const (
+	partLit     partKind = iota
+	partParam            // $name, ${name} and special parameters
+	partComplex          // ${name...} with operators
+	partCmdSubst
+	partArith
+)
+
+// lex splits the sh script into tokens.
+func lex(input string) ([]token, error) {
+	l := &lexer{
+		input: input,
+		line:  1,
+	}
+
+	for {
+		tok, err := l.next()
+		if err != nil {
+			return nil, err
+		}
+
+		l.tokens = append(l.tokens, tok)
+		if tok.typ == tEOF {
+			return l.tokens, nil
+		}
+	}
+}
+
+func (l *lexer) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("line %d: %s", l.line, fmt.Sprintf(format, args...))
+}
+
+func (l *lexer) peekByte(i int) byte {
+	if l.pos+i < len(l.input) {
+		return l.input[l.pos+i]
+	}
+	return 0
+}
+
+func (l *lexer) next() (token, error) {
+	// blanks and line continuations
+	for l.pos < len(l.input) {
+		c := l.input[l.pos]
+		if c == ' ' || c == '\t' || c == '\r' {
+			l.pos++
+		} else if c == '\\' && l.peekByte(1) == '\n' {
+			l.pos += 2
+			l.line++
+		} else {
+			break
+		}
+	}
+
+	tok := token{line: l.line, fd: -1}
+
+	if l.pos >= len(l.input) {
+		tok.typ = tEOF
+		tok.endLine = l.line
+		return tok, nil
+	}
+
+	op := func(typ tokenType, text string) (token, error) {
+		l.pos += len(text)
+		tok.typ, tok.text, tok.endLine = typ, text, l.line
+		return tok, nil
+	}
+
+	switch c := l.input[l.pos]; {
+	case c == '\n':
+		l.pos++
+		l.line++
+		tok.typ, tok.endLine = tNewline, tok.line
+
+		if err := l.readHeredocs(); err != nil {
+			return tok, err
+		}
+		return tok, nil
+	case c == '#':
+		end := strings.IndexByte(l.input[l.pos:], '\n')
+		if end < 0 {
+			end = len(l.input) - l.pos
+		}
+
+		tok.typ, tok.text, tok.endLine = tComment, l.input[l.pos:l.pos+end], l.line
+		l.pos += end
+		return tok, nil
+	case strings.HasPrefix(l.input[l.pos:], ";;"):
+		return op(tDoubleSemi, ";;")
+	case c == ';':
+		return op(tSemi, ";")
+	case strings.HasPrefix(l.input[l.pos:], "||"):
+		return op(tOrOr, "||")
+	case c == '|':
+		return op(tPipe, "|")
+	case strings.HasPrefix(l.input[l.pos:], "&&"):
+		return op(tAndAnd, "&&")
+	case strings.HasPrefix(l.input[l.pos:], "&>"):
+		return l.redirect(tok)
+	case c == '&':
+		return op(tAmp, "&")
+	case c == '(':
+		return op(tLParen, "(")
+	case c == ')':
+		return op(tRParen, ")")
+	case c == '>' || c == '<':
+		return l.redirect(tok)
+	}
+
+	// file descriptor of redirections, like 2>
+	i := l.pos
+	for i < len(l.input) && l.input[i] >= '0' && l.input[i] <= '9' {
+		i++
+	}
+
+	if i > l.pos && i < len(l.input) && (l.input[i] == '>' || l.input[i] == '<') {
+		fmt.Sscanf(l.input[l.pos:i], "%d", &tok.fd)
+		l.pos = i
+		return l.redirect(tok)
+	}
+
+	w, err := l.word()
+	if err != nil {
+		return tok, err
+	}
+
+	tok.typ, tok.word, tok.endLine = tWord, w, l.line
+	return tok, nil
+}
+
+var redirOps = []string{"&>>", "&>", ">>", ">&", ">|", "<<-", "<<", "<&", "<>", ">", "<"}
+
+func (l *lexer) redirect(tok token) (token, error) {
+	for _, op := range redirOps {
+		if strings.HasPrefix(l.input[l.pos:], op) {
+			tok.typ, tok.text = tRedir, op
+			l.pos += len(op)
+			break
+		}
+	}
+
+	for l.pos < len(l.input) && (l.input[l.pos] == ' ' || l.input[l.pos] == '\t') {
+		l.pos++
+	}
+
+	if l.pos >= len(l.input) || strings.IndexByte("\n;|&()<>", l.input[l.pos]) >= 0 {
+		return tok, l.errorf("missing target of redirection %s", tok.text)
+	}
+
+	w, err := l.word()
+	if err != nil {
+		return tok, err
+	}
+
+	tok.word, tok.endLine = w, l.line
+
+	if tok.text == "<<" || tok.text == "<<-" {
+		l.heredocs = append(l.heredocs, w.literal())
+	}
+
+	return tok, nil
+}
+
+// readHeredocs skips the bodies of the pending here documents.
+func (l *lexer) readHeredocs() error {
+	for len(l.heredocs) > 0 {
+		delim := strings.Trim(l.heredocs[0], "\"'")
+		l.heredocs = l.heredocs[1:]
+
+		for {
+			if l.pos >= len(l.input) {
+				return l.errorf("here document delimited by %s not finished", delim)
+			}
+
+			end := strings.IndexByte(l.input[l.pos:], '\n')
+			if end < 0 {
+				end = len(l.input) - l.pos
+			}
+
+			line := l.input[l.pos : l.pos+end]
+			l.pos += end
+			if l.pos < len(l.input) {
+				l.pos++
+				l.line++
+			}
+
+			if strings.TrimLeft(line, "\t") == delim {
+				break
+			}
+		}
+	}
+
+	return nil
+}
+
+func isWordEnd(c byte) bool {
+	return strings.IndexByte(" \t\r\n;|&()<>", c) >= 0
+}
+
+func (l *lexer) word() (*word, error) {
+	w := &word{line: l.line}
+
+	var lit strings.Builder
+
+	flush := func() {
+		if lit.Len() > 0 {
+			text := lit.String()
+			w.parts = append(w.parts, wordPart{
+				kind: partLit,
+				text: text,
+				glob: isGlob(text),
+			})
+			lit.Reset()
+		}
+	}
+
+	for l.pos < len(l.input) && !isWordEnd(l.input[l.pos]) {
+		switch c := l.input[l.pos]; c {
+		case '\\':
+			l.pos++
+			if l.pos < len(l.input) {
+				flush()
+				w.parts = append(w.parts, wordPart{
+					kind:   partLit,
+					text:   string(l.input[l.pos]),
+					quoted: true,
+				})
+				l.pos++
+			}
+		case '\'':
+			end := strings.IndexByte(l.input[l.pos+1:], '\'')
+			if end < 0 {
+				return nil, l.errorf("unterminated single quoted string")
+			}
+
+			flush()
+			text := l.input[l.pos+1 : l.pos+1+end]
+			w.parts = append(w.parts, wordPart{kind: partLit, text: text, quoted: true})
+			l.line += strings.Count(text, "\n")
+			l.pos += end + 2
+		case '"':
+			flush()
+			if err := l.doubleQuoted(w); err != nil {
+				return nil, err
+			}
+		case '$':
+			flush()
+			part, err := l.dollar(false)
+			if err != nil {
+				return nil, err
+			}
+			w.parts = append(w.parts, part)
+		case '`':
+			flush()
+			part, err := l.backquote(false)
+			if err != nil {
+				return nil, err
+			}
+			w.parts = append(w.parts, part)
+		default:
+			lit.WriteByte(c)
+			l.pos++
+		}
+	}
+
+	flush()
+	return w, nil
+}
+
+func (l *lexer) doubleQuoted(w *word) error {
+	l.pos++ // "
+
+	var lit strings.Builder
+
+	flush := func() {
+		if lit.Len() > 0 {
+			w.parts = append(w.parts, wordPart{kind: partLit, text: lit.String(), quoted: true})
+			lit.Reset()
+		}
+	}
+
+	// "" is an empty word
+	if l.peekByte(0) == '"' {
+		w.parts = append(w.parts, wordPart{kind: partLit, quoted: true})
+	}
+
+	for {
+		if l.pos >= len(l.input) {
+			return l.errorf("unterminated double quoted string")
+		}
+
+		switch c := l.input[l.pos]; c {
+		case '"':
+			l.pos++
+			flush()
+			return nil
+		case '\\':
+			next := l.peekByte(1)
+			if next == '$' || next == '`' || next == '"' || next == '\\' {
+				lit.WriteByte(next)
+				l.pos += 2
+			} else if next == '\n' {
+				l.pos += 2
+				l.line++
+			} else {
+				lit.WriteByte(c)
+				l.pos++
+			}
+		case '$':
+			if !isNameStart(l.peekByte(1)) && strings.IndexByte("{(@*#?$!-0123456789", l.peekByte(1)) < 0 {
+				lit.WriteByte(c)
+				l.pos++
+				continue
+			}
+
+			flush()
+			part, err := l.dollar(true)
+			if err != nil {
+				return err
+			}
+			w.parts = append(w.parts, part)
+		case '`':
+			flush()
+			part, err := l.backquote(true)
+			if err != nil {
+				return err
+			}
+			w.parts = append(w.parts, part)
+		default:
+			if c == '\n' {
+				l.line++
+			}
+			lit.WriteByte(c)
+			l.pos++
+		}
+	}
+}
+
+func (l *lexer) dollar(quoted bool) (wordPart, error) {
+	l.pos++ // $
+
+	switch c := l.peekByte(0); {
+	case strings.HasPrefix(l.input[l.pos:], "(("):
+		text, err := l.balanced('(', ')')
+		if err != nil {
+			return wordPart{}, err
+		}
+		return wordPart{kind: partArith, text: "$" + text, quoted: quoted}, nil
+	case c == '(':
+		text, err := l.balanced('(', ')')
+		if err != nil {
+			return wordPart{}, err
+		}
+		return wordPart{kind: partCmdSubst, text: text[1 : len(text)-1], quoted: quoted}, nil
+	case c == '{':
+		text, err := l.balanced('{', '}')
+		if err != nil {
+			return wordPart{}, err
+		}
+
+		name := text[1 : len(text)-1]
+		if isName(name) || isSpecialParam(name) {
+			return wordPart{kind: partParam, text: name, quoted: quoted}, nil
+		}
+		return wordPart{kind: partComplex, text: "$" + text, quoted: quoted}, nil
+	case isNameStart(c):
+		start := l.pos
+		for l.pos < len(l.input) && isNameChar(l.input[l.pos]) {
+			l.pos++
+		}
+		return wordPart{kind: partParam, text: l.input[start:l.pos], quoted: quoted}, nil
+	case c != 0 && strings.IndexByte("@*#?$!-0123456789", c) >= 0:
+		l.pos++
+		return wordPart{kind: partParam, text: string(c), quoted: quoted}, nil
+	}
+
+	return wordPart{kind: partLit, text: "$", quoted: quoted}, nil
+}
+
+func (l *lexer) backquote(quoted bool) (wordPart, error) {
+	end := strings.IndexByte(l.input[l.pos+1:], '`')
+	if end < 0 {
+		return wordPart{}, l.errorf("unterminated command substitution")
+	}
+
+	text := l.input[l.pos+1 : l.pos+1+end]
+	l.line += strings.Count(text, "\n")
+	l.pos += end + 2
+
+	return wordPart{kind: partCmdSubst, text: text, quoted: quoted}, nil
+}
+
+// balanced returns the text from the open character at the current
+// position to its matching close character, skipping quoted strings.
+func (l *lexer) balanced(open, close byte) (string, error) {
+	start := l.pos
+	depth := 0
+
+	for l.pos < len(l.input) {
+		c := l.input[l.pos]
+
+		switch c {
+		case open:
+			depth++
+		case close:
+			depth--
+		case '\\':
+			l.pos++
+		case '\n':
+			l.line++
+		case '\'', '"':
+			end := strings.IndexByte(l.input[l.pos+1:], c)
+			if end < 0 {
+				return "", l.errorf("unterminated quoted string")
+			}
+			l.line += strings.Count(l.input[l.pos+1:l.pos+1+end], "\n")
+			l.pos += end + 1
+		}
+
+		l.pos++
+
+		if depth == 0 {
+			return l.input[start:l.pos], nil
+		}
+	}
+
+	return "", l.errorf("missing %c", close)
+}
+
+func isNameStart(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isNameChar(c byte) bool {
+	return isNameStart(c) || (c >= '0' && c <= '9')
+}
+
+func isName(s string) bool {
+	if s == "" || !isNameStart(s[0]) {
+		return false
+	}
+
+	for i := 1; i < len(s); i++ {
+		if !isNameChar(s[i]) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func isSpecialParam(s string) bool {
+	if len(s) == 1 && strings.IndexByte("@*#?$!-", s[0]) >= 0 {
+		return true
+	}
+
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+
+	return s != ""
+}
+
+// isGlob reports whether the unquoted text has pattern characters.
+func isGlob(text string) bool {
+	if strings.ContainsAny(text, "*?") {
+		return true
+	}
+
+	i := strings.IndexByte(text, '[')
+	return i >= 0 && strings.IndexByte(text[i+1:], ']') > 0
+}
+
+// literal returns the text of the word if it has only literal parts.
+func (w *word) literal() string {
+	var buf strings.Builder
+
+	for _, part := range w.parts {
+		if part.kind != partLit {
+			return ""
+		}
+		buf.WriteString(part.text)
+	}
+
+	return buf.String()
+}
+
+// keyword returns the word if it is an unquoted literal, which may be a
+// reserved word depending on its position.
+func (w *word) keyword() string {
+	if len(w.parts) != 1 || w.parts[0].kind != partLit || w.parts[0].quoted {
+		return ""
+	}
+
+	return w.parts[0].text
+}
@@ -0,0 +1,90 @@
+// Command bash2nash translates sh scripts to nash.
+//
+// Usage:
+//
+//	bash2nash [file.sh]
+//
+// The script is read from the file, or from the standard input, and the
+// nash translation is printed to the standard output.
+//
+// Only a restricted subset of POSIX sh is translated: simple commands,
+// pipes, the redirections >, 2> and 2>&1, variables, functions, for
+// loops and if statements comparing strings with [ or checking the
+// status of commands. The sh constructs without nash equivalent, like
+// globbing, eval and command substitutions inside strings, are not
+// translated: they are kept as comments starting with
+// "TODO(bash2nash)", and the number of them is reported to the standard
+// error.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+var (
+	version bool
+	// version is set at build time
+	VersionString = "No version provided"
+)
+
+func init() {
+	flag.BoolVar(&version, "version", false, "Show version")
+}
+
+func main() {
+	flag.Parse()
+
+	if version {
+		fmt.Printf("build tag: %s\n", VersionString)
+		return
+	}
+
+	if len(flag.Args()) > 1 {
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+
+	todos, err := run(os.Stdout, flag.Args())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %s\n", err.Error())
+		os.Exit(1)
+	}
+
+	if todos > 0 {
+		fmt.Fprintf(os.Stderr, "warning: %d statements not translated, see the TODO(bash2nash) comments\n", todos)
+	}
+}
+
+// run translates the script named by args, or the standard input, and
+// returns the number of statements not translated.
+func run(out io.Writer, args []string) (int, error) {
+	var (
+		name    = "<stdin>"
+		content []byte
+		err     error
+	)
+
+	if len(args) == 1 {
+		name = filepath.Base(args[0])
+		content, err = ioutil.ReadFile(args[0])
+	} else {
+		content, err = ioutil.ReadAll(os.Stdin)
+	}
+
+	if err != nil {
+		return 0, err
+	}
+
+	tree, todos, err := translate(name, string(content))
+	if err != nil {
+		return 0, fmt.Errorf("%s: %s", name, err.Error())
+	}
+
+	_, err = io.WriteString(out, tree.String()+"\n")
+	return todos, err
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	nashparser "github.com/madlambda/nash/parser"
+)
+
+func TestTranslate(t *testing.T) {
+	for _, test := range []struct {
+		name  string
+		sh    string
+		nash  string
+		todos int
+	}{
+		{
+			name: "Commands",
+			sh:   "#!/bin/sh\nls -l /tmp\necho 'hello world' \"a b\"\n",
+			nash: "#!/usr/bin/env nash\n\nls -l /tmp\necho \"hello world\" \"a b\"",
+		},
+		{
+			name: "Pipes",
+			sh:   "ls | grep a | wc -l",
+			nash: "ls | grep a | wc -l",
+		},
+		{
+			name: "Redirections",
+			sh:   "cmd > out.txt 2>&1\ncmd 2> err.txt\ncmd >&2",
+			nash: "cmd > out.txt >[2=1]\ncmd >[2] err.txt\ncmd >[1=2]",
+		},
+		{
+			name: "Variables",
+			sh:   "a=1\nb=\"$a\"/bin\na=2\necho $a$b ~/x",
+			nash: "var a = \"1\"\nvar b = $a+\"/bin\"\n\na = \"2\"\n\necho $a+$b $HOME+\"/x\"",
+		},
+		{
+			name: "CommandSubstitution",
+			sh:   "out=$(ls | wc -l)\nd=`date`",
+			nash: "var out <= ls | wc -l\nvar d   <= date",
+		},
+		{
+			name: "Export",
+			sh:   "export PATH=/bin:$PATH\nexport HOME",
+			nash: "setenv PATH = \"/bin:\"+$PATH\nsetenv HOME",
+		},
+		{
+			name: "Builtins",
+			sh:   "cd /tmp\ncd\nexit 2\n. ./lib.sh",
+			nash: "chdir(\"/tmp\")\nchdir($HOME)\nexit(\"2\")\n\nimport ./lib.sh",
+		},
+		{
+			name: "If",
+			sh:   "if [ \"$a\" = yes ]; then\n\techo y\nelif [ -z \"$b\" ]; then\n\techo z\nelse\n\techo n\nfi",
+			nash: "if $a == \"yes\" {\n\techo y\n} else if $b == \"\" {\n\techo z\n} else {\n\techo n\n}",
+		},
+		{
+			name: "IfStatus",
+			sh:   "if grep -q a f; then\n\techo found\nfi",
+			nash: "var _, status <= grep -q a f\n\nif $status == \"0\" {\n\techo found\n}",
+		},
+		{
+			name: "IfTest",
+			sh:   "if [ -f /etc/passwd ]; then echo file; fi",
+			nash: "var _, status <= test -f /etc/passwd\n\nif $status == \"0\" {\n\techo file\n}",
+		},
+		{
+			name: "For",
+			sh:   "for i in a \"b c\"; do\n\techo $i\ndone\nfor i in $list; do echo $i; done",
+			nash: "for i in (\"a\" \"b c\") {\n\techo $i\n}\nfor i in split($list, \" \") {\n\techo $i\n}",
+		},
+		{
+			name: "Functions",
+			sh:   "greet() {\n\tlocal who=$1\n\techo hello $who \"$@\"\n}\n\ngreet world",
+			nash: "fn greet(args...) {\n\tvar argv <= append($args, \"\")\n\n\tvar who = $argv[0]\n\n\techo hello $who $args...\n}\n\ngreet(\"world\")",
+		},
+		{
+			name: "FunctionMissingArguments",
+			sh:   "pair() {\n\techo $1 $2\n}\npair a",
+			nash: "fn pair(args...) {\n\tvar argv <= append($args, \"\", \"\")\n\n\techo $argv[0] $argv[1]\n}\n\npair(\"a\")",
+		},
+		{
+			name: "FunctionGlobals",
+			sh:   "f() { A=3; B=$(date); }\nA=1\nf\necho $A $B",
+			nash: "var A = \"\"\nvar B = \"\"\n\nfn f() {\n\tA = \"3\"\n\n\tB <= date\n}\n\nA = \"1\"\n\nf()\n\necho $A $B",
+		},
+		{
+			name: "FunctionLoop",
+			sh:   "function each {\n\tfor a; do echo $a; done\n}",
+			nash: "fn each(args...) {\n\tfor a in $args {\n\t\techo $a\n\t}\n}",
+		},
+		{
+			name: "ScriptArguments",
+			sh:   "#!/bin/sh\necho $0 $1",
+			nash: "#!/usr/bin/env nash\n\nvar argv <= append($ARGS, \"\")\n\necho $ARGS[0] $argv[1]",
+		},
+		{
+			name: "Comments",
+			sh:   "# list\nls # all files",
+			nash: "# list\nls # all files",
+		},
+		{
+			name:  "Glob",
+			sh:    "ls *.go",
+			nash:  "# TODO(bash2nash): translate globbing:\n#\tls *.go",
+			todos: 1,
+		},
+		{
+			name:  "Eval",
+			sh:    "eval \"$cmd\"",
+			nash:  "# TODO(bash2nash): translate eval builtin:\n#\teval \"$cmd\"",
+			todos: 1,
+		},
+		{
+			name:  "SubstitutionInString",
+			sh:    "echo \"today is $(date)\"\necho ok",
+			nash:  "# TODO(bash2nash): translate command substitution inside words:\n#\techo \"today is $(date)\"\necho ok",
+			todos: 1,
+		},
+		{
+			name:  "Unsupported",
+			sh:    "if true; then\n\twhile read l; do\n\t\techo $l\n\tdone < f\nfi\na && b\ncat >> log",
+			todos: 3,
+			nash: "var _, status <= true\n\nif $status == \"0\" {\n" +
+				"\t# TODO(bash2nash): translate redirection of compound commands:\n" +
+				"\t#\twhile read l; do\n\t#\t\techo $l\n\t#\tdone < f\n}\n" +
+				"# TODO(bash2nash): translate && and || lists:\n#\ta && b\n" +
+				"# TODO(bash2nash): translate >> redirections:\n#\tcat >> log",
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			tree, todos, err := translate(test.name, test.sh)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := tree.String()
+			if got != test.nash {
+				t.Fatalf("expected:\n%s\n\ngot:\n%s", test.nash, got)
+			}
+
+			if todos != test.todos {
+				t.Fatalf("expected %d TODOs but got %d", test.todos, todos)
+			}
+
+			_, err = nashparser.NewParser(test.name, got).Parse()
+			if err != nil {
+				t.Fatalf("translation is not valid nash: %s", err)
+			}
+		})
+	}
+}
+
+func TestTranslateErrors(t *testing.T) {
+	for _, sh := range []string{
+		"echo 'unterminated",
+		"if true; then echo",
+		"for 1 in a; do echo; done",
+		"echo )",
+	} {
+		_, _, err := translate("error", sh)
+		if err == nil {
+			t.Errorf("expected error translating %q", sh)
+			continue
+		}
+
+		if !strings.HasPrefix(err.Error(), "line ") {
+			t.Errorf("expected error with line but got %q", err)
+		}
+	}
+}
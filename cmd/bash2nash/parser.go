@@ -0,0 +1,588 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+type (
+	// stmt is a sh statement.
+	stmt interface {
+		base() *pos
+	}
+
+	// pos is the position of a statement and the comment at the end
+	// of its last line.
+	pos struct {
+		line, endLine int
+		comment       *comment
+	}
+
+	redir struct {
+		op     string
+		fd     int
+		target *word
+	}
+
+	assignment struct {
+		name  string
+		value *word
+	}
+
+	simpleCmd struct {
+		pos
+		assigns []assignment
+		words   []*word
+		redirs  []redir
+	}
+
+	pipeline struct {
+		pos
+		cmds []*simpleCmd
+	}
+
+	ifClause struct {
+		cond []stmt
+		body []stmt
+	}
+
+	ifStmt struct {
+		pos
+		clauses []ifClause
+		els     []stmt
+		hasElse bool
+	}
+
+	forStmt struct {
+		pos
+		name  string
+		items []*word
+		hasIn bool
+		body  []stmt
+	}
+
+	funcDecl struct {
+		pos
+		name string
+		body []stmt
+	}
+
+	comment struct {
+		pos
+		text string
+	}
+
+	// unsupported is a statement that can not be translated.
+	unsupported struct {
+		pos
+		reason string
+	}
+
+	parser struct {
+		tokens []token
+		pos    int
+		last   int
+	}
+)
+
+func (p *pos) base() *pos { return p }
+
+// parse parses the sh script.
+func parse(input string) ([]stmt, error) {
+	tokens, err := lex(input)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &parser{tokens: tokens}
+	return p.list()
+}
+
+func (p *parser) peek() token { return p.tokens[p.pos] }
+
+func (p *parser) next() token {
+	tok := p.tokens[p.pos]
+	if tok.typ != tEOF {
+		p.pos++
+	}
+
+	if tok.endLine > p.last {
+		p.last = tok.endLine
+	}
+	return tok
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("line %d: %s", p.peek().line, fmt.Sprintf(format, args...))
+}
+
+// peekKeyword returns the reserved word at the current position.
+func (p *parser) peekKeyword() string {
+	tok := p.peek()
+	if tok.typ != tWord {
+		return ""
+	}
+	return tok.word.keyword()
+}
+
+func (p *parser) expect(keyword string) error {
+	if p.peekKeyword() != keyword {
+		return p.errorf("expected %q", keyword)
+	}
+
+	p.next()
+	return nil
+}
+
+func (p *parser) skipNewlines() {
+	for p.peek().typ == tNewline {
+		p.next()
+	}
+}
+
+// list parses statements until one of the reserved words terminators,
+// a ')' when inside a subshell, or the end of the script.
+func (p *parser) list(terminators ...string) ([]stmt, error) {
+	var stmts []stmt
+
+	for {
+		tok := p.peek()
+
+		switch tok.typ {
+		case tNewline, tSemi:
+			p.next()
+			continue
+		case tEOF:
+			if len(terminators) > 0 {
+				return nil, p.errorf("expected %s", strings.Join(terminators, " or "))
+			}
+			return stmts, nil
+		case tRParen:
+			if len(terminators) > 0 && terminators[0] == ")" {
+				return stmts, nil
+			}
+			return nil, p.errorf("unexpected ')'")
+		case tComment:
+			p.next()
+			stmts = append(stmts, &comment{
+				pos:  pos{line: tok.line, endLine: tok.line},
+				text: tok.text,
+			})
+			continue
+		}
+
+		kw := p.peekKeyword()
+		for _, term := range terminators {
+			if kw == term {
+				return stmts, nil
+			}
+		}
+
+		s, err := p.andOr()
+		if err != nil {
+			return nil, err
+		}
+
+		stmts = append(stmts, s)
+
+		switch tok := p.peek(); tok.typ {
+		case tComment:
+			p.next()
+			s.base().comment = &comment{
+				pos:  pos{line: tok.line, endLine: tok.line},
+				text: tok.text,
+			}
+		case tNewline, tSemi, tEOF, tRParen:
+		default:
+			return nil, p.errorf("unexpected %s", describe(tok))
+		}
+	}
+}
+
+func describe(tok token) string {
+	if tok.typ == tWord {
+		return fmt.Sprintf("word %q", tok.word.literal())
+	}
+	return fmt.Sprintf("%q", tok.text)
+}
+
+func (p *parser) andOr() (stmt, error) {
+	start := p.peek().line
+
+	s, err := p.pipeline()
+	if err != nil {
+		return nil, err
+	}
+
+	reason := ""
+
+	for p.peek().typ == tAndAnd || p.peek().typ == tOrOr {
+		p.next()
+		p.skipNewlines()
+
+		if _, err := p.pipeline(); err != nil {
+			return nil, err
+		}
+		reason = "&& and || lists"
+	}
+
+	if p.peek().typ == tAmp {
+		p.next()
+		reason = "background commands"
+	}
+
+	if reason != "" {
+		return &unsupported{pos{line: start, endLine: p.last}, reason}, nil
+	}
+
+	return s, nil
+}
+
+func (p *parser) pipeline() (stmt, error) {
+	start := p.peek().line
+
+	if p.peekKeyword() == "!" {
+		p.next()
+
+		if _, err := p.pipeline(); err != nil {
+			return nil, err
+		}
+		return &unsupported{pos{line: start, endLine: p.last}, "negated pipelines"}, nil
+	}
+
+	s, err := p.command()
+	if err != nil {
+		return nil, err
+	}
+
+	if p.peek().typ != tPipe {
+		return s, nil
+	}
+
+	pipe := &pipeline{}
+	supported := true
+
+	for {
+		if cmd, ok := s.(*simpleCmd); ok {
+			pipe.cmds = append(pipe.cmds, cmd)
+		} else {
+			supported = false
+		}
+
+		if p.peek().typ != tPipe {
+			break
+		}
+
+		p.next()
+		p.skipNewlines()
+
+		s, err = p.command()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	pipe.pos = pos{line: start, endLine: p.last}
+
+	if !supported {
+		return &unsupported{pipe.pos, "pipes of compound commands"}, nil
+	}
+
+	return pipe, nil
+}
+
+func (p *parser) command() (stmt, error) {
+	tok := p.peek()
+	start := tok.line
+
+	if tok.typ == tLParen {
+		p.next()
+
+		if _, err := p.list(")"); err != nil {
+			return nil, err
+		}
+
+		p.next()
+		return p.compoundEnd(&unsupported{pos{line: start, endLine: p.last}, "subshells"})
+	}
+
+	switch p.peekKeyword() {
+	case "if":
+		return p.ifStmt()
+	case "for":
+		return p.forStmt()
+	case "while", "until":
+		kw := p.peekKeyword()
+		p.next()
+
+		if _, err := p.list("do"); err != nil {
+			return nil, err
+		}
+
+		if err := p.body("do", "done"); err != nil {
+			return nil, err
+		}
+		return p.compoundEnd(&unsupported{pos{line: start, endLine: p.last}, kw + " loops"})
+	case "case":
+		return p.caseStmt()
+	case "{":
+		p.next()
+
+		if _, err := p.list("}"); err != nil {
+			return nil, err
+		}
+
+		p.next()
+		return p.compoundEnd(&unsupported{pos{line: start, endLine: p.last}, "command groups"})
+	case "function":
+		p.next()
+
+		name := p.next()
+		if name.typ != tWord || !isName(name.word.literal()) {
+			return nil, p.errorf("expected function name")
+		}
+
+		if p.peek().typ == tLParen {
+			p.next()
+			if p.next().typ != tRParen {
+				return nil, p.errorf("expected ')'")
+			}
+		}
+
+		return p.funcBody(start, name.word.literal())
+	}
+
+	if tok.typ == tWord && p.tokens[p.pos+1].typ == tLParen && isName(tok.word.literal()) {
+		p.next()
+		p.next()
+
+		if p.next().typ != tRParen {
+			return nil, p.errorf("expected ')'")
+		}
+
+		return p.funcBody(start, tok.word.literal())
+	}
+
+	return p.simpleCmd()
+}
+
+// compoundEnd parses the redirections of compound commands, which are
+// not supported.
+func (p *parser) compoundEnd(s stmt) (stmt, error) {
+	if p.peek().typ != tRedir {
+		return s, nil
+	}
+
+	for p.peek().typ == tRedir {
+		p.next()
+	}
+
+	line := s.base().line
+	return &unsupported{pos{line: line, endLine: p.last}, "redirection of compound commands"}, nil
+}
+
+func (p *parser) body(open, close string) error {
+	if err := p.expect(open); err != nil {
+		return err
+	}
+
+	if _, err := p.list(close); err != nil {
+		return err
+	}
+
+	return p.expect(close)
+}
+
+func (p *parser) funcBody(start int, name string) (stmt, error) {
+	p.skipNewlines()
+
+	if p.peekKeyword() != "{" {
+		body, err := p.command()
+		if err != nil {
+			return nil, err
+		}
+
+		line := body.base().line
+		return &unsupported{pos{line: line, endLine: p.last}, "function bodies other than { ... }"}, nil
+	}
+
+	p.next()
+
+	body, err := p.list("}")
+	if err != nil {
+		return nil, err
+	}
+
+	p.next()
+	return p.compoundEnd(&funcDecl{pos{line: start, endLine: p.last}, name, body})
+}
+
+func (p *parser) ifStmt() (stmt, error) {
+	s := &ifStmt{}
+	s.line = p.next().line
+
+	for {
+		cond, err := p.list("then")
+		if err != nil {
+			return nil, err
+		}
+
+		if err := p.expect("then"); err != nil {
+			return nil, err
+		}
+
+		body, err := p.list("elif", "else", "fi")
+		if err != nil {
+			return nil, err
+		}
+
+		s.clauses = append(s.clauses, ifClause{cond, body})
+
+		if p.peekKeyword() != "elif" {
+			break
+		}
+		p.next()
+	}
+
+	if p.peekKeyword() == "else" {
+		p.next()
+
+		els, err := p.list("fi")
+		if err != nil {
+			return nil, err
+		}
+
+		s.els, s.hasElse = els, true
+	}
+
+	if err := p.expect("fi"); err != nil {
+		return nil, err
+	}
+
+	s.endLine = p.last
+	return p.compoundEnd(s)
+}
+
+func (p *parser) forStmt() (stmt, error) {
+	s := &forStmt{}
+	s.line = p.next().line
+
+	name := p.next()
+	if name.typ != tWord || !isName(name.word.literal()) {
+		return nil, p.errorf("expected variable name in for loop")
+	}
+
+	s.name = name.word.literal()
+	p.skipNewlines()
+
+	if p.peekKeyword() == "in" {
+		p.next()
+		s.hasIn = true
+
+		for p.peek().typ == tWord {
+			s.items = append(s.items, p.next().word)
+		}
+	}
+
+	if t := p.peek().typ; t == tSemi || t == tNewline {
+		p.next()
+	}
+	p.skipNewlines()
+
+	if err := p.expect("do"); err != nil {
+		return nil, err
+	}
+
+	body, err := p.list("done")
+	if err != nil {
+		return nil, err
+	}
+
+	if err := p.expect("done"); err != nil {
+		return nil, err
+	}
+
+	s.body = body
+	s.endLine = p.last
+	return p.compoundEnd(s)
+}
+
+func (p *parser) caseStmt() (stmt, error) {
+	start := p.next().line
+	depth := 1
+
+	for depth > 0 {
+		tok := p.next()
+
+		switch {
+		case tok.typ == tEOF:
+			return nil, p.errorf("expected esac")
+		case tok.typ != tWord:
+		case tok.word.keyword() == "case":
+			depth++
+		case tok.word.keyword() == "esac":
+			depth--
+		}
+	}
+
+	return p.compoundEnd(&unsupported{pos{line: start, endLine: p.last}, "case statements"})
+}
+
+func (p *parser) simpleCmd() (stmt, error) {
+	cmd := &simpleCmd{}
+	cmd.line = p.peek().line
+
+	for {
+		tok := p.peek()
+
+		if tok.typ == tRedir {
+			p.next()
+			cmd.redirs = append(cmd.redirs, redir{op: tok.text, fd: tok.fd, target: tok.word})
+			continue
+		}
+
+		if tok.typ != tWord {
+			break
+		}
+
+		p.next()
+
+		if len(cmd.words) == 0 {
+			if name, value, ok := splitAssignment(tok.word); ok {
+				cmd.assigns = append(cmd.assigns, assignment{name, value})
+				continue
+			}
+		}
+
+		cmd.words = append(cmd.words, tok.word)
+	}
+
+	if len(cmd.words) == 0 && len(cmd.assigns) == 0 && len(cmd.redirs) == 0 {
+		return nil, p.errorf("unexpected %s", describe(p.peek()))
+	}
+
+	cmd.endLine = p.last
+	return cmd, nil
+}
+
+// splitAssignment splits words like name=value.
+func splitAssignment(w *word) (string, *word, bool) {
+	if len(w.parts) == 0 || w.parts[0].kind != partLit || w.parts[0].quoted {
+		return "", nil, false
+	}
+
+	first := w.parts[0].text
+
+	i := strings.IndexByte(first, '=')
+	if i <= 0 || !isName(first[:i]) {
+		return "", nil, false
+	}
+
+	value := &word{line: w.line}
+	if rest := first[i+1:]; rest != "" {
+		value.parts = append(value.parts, wordPart{kind: partLit, text: rest, glob: isGlob(rest)})
+	}
+
+	value.parts = append(value.parts, w.parts[1:]...)
+	return first[:i], value, true
+}
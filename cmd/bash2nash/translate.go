@@ -0,0 +1,941 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/madlambda/nash/ast"
+	nashtoken "github.com/madlambda/nash/token"
+)
+
+type (
+	// untranslatable is the error of sh constructs without a nash
+	// equivalent, explaining what is not supported.
+	untranslatable string
+
+	translator struct {
+		lines []string
+		funcs map[string]bool
+
+		scopes   []map[string]bool
+		inFunc   bool
+		usesArgs bool
+
+		// params is the greatest positional parameter used by the
+		// script or the current function
+		params int
+
+		// globals are the variables first assigned inside functions
+		globals []string
+
+		// todos is the number of statements not translated
+		todos int
+	}
+)
+
+func (e untranslatable) Error() string { return string(e) }
+
+// unsupportedBuiltins are the sh builtins without nash equivalent.
+var unsupportedBuiltins = map[string]bool{
+	"eval": true, "exec": true, "set": true, "shift": true, "trap": true,
+	"read": true, "wait": true, "unset": true, "alias": true, "getopts": true,
+	"let": true, "declare": true, "typeset": true, "readonly": true,
+	"[[": true, "((": true, ":": true, "ulimit": true, "umask": true,
+}
+
+// translate translates the sh script into a nash tree.
+func translate(name, input string) (*ast.Tree, int, error) {
+	stmts, err := parse(input)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	t := &translator{
+		lines:  strings.Split(input, "\n"),
+		funcs:  make(map[string]bool),
+		scopes: []map[string]bool{{}},
+	}
+
+	t.findFuncs(stmts)
+
+	tree := ast.NewTree(name)
+	tree.Root = t.block(stmts, 1)
+
+	var decls []ast.Node
+
+	// sh variables assigned inside functions are global, but nash
+	// ones are local to the function declaring them. The declarations
+	// have no position in the sh script.
+	fi := info(0, 0)
+	for _, name := range t.globals {
+		decls = append(decls, ast.NewVarAssignDecl(fi, ast.NewAssignNode(fi,
+			[]*ast.NameNode{ast.NewNameNode(fi, name, nil)},
+			[]ast.Expr{ast.NewStringExpr(fi, "", true)})))
+	}
+
+	if t.params > 0 {
+		decls = append(decls, t.argv(fi, "$ARGS"))
+	}
+
+	// the declarations go at the top, after the #! line
+	nodes := tree.Root.Nodes
+	i := 0
+	if len(nodes) > 0 && strings.HasPrefix(nodes[0].String(), "#!") {
+		i = 1
+	}
+
+	tree.Root.Nodes = append(append(append([]ast.Node{}, nodes[:i]...), decls...), nodes[i:]...)
+	return tree, t.todos, nil
+}
+
+// argv returns the declaration of argv as the arguments in the list
+// named args, followed by empty strings, because the sh positional
+// parameters not given are empty and nash fails indexing past the end
+// of the list.
+func (t *translator) argv(fi nashtoken.FileInfo, args string) ast.Node {
+	inv := ast.NewFnInvNode(fi, "append")
+	inv.AddArg(ast.NewVarExpr(fi, args))
+	for i := 0; i < t.params; i++ {
+		inv.AddArg(ast.NewStringExpr(fi, "", true))
+	}
+
+	exec, _ := ast.NewExecAssignNode(fi, []*ast.NameNode{ast.NewNameNode(fi, "argv", nil)}, inv)
+	return ast.NewVarExecAssignDecl(fi, exec)
+}
+
+// findFuncs records the functions declared in the script, because sh
+// functions are called like commands but nash functions are not.
+func (t *translator) findFuncs(stmts []stmt) {
+	for _, s := range stmts {
+		switch s := s.(type) {
+		case *funcDecl:
+			t.funcs[s.name] = true
+			t.findFuncs(s.body)
+		case *ifStmt:
+			for _, clause := range s.clauses {
+				t.findFuncs(clause.body)
+			}
+			t.findFuncs(s.els)
+		case *forStmt:
+			t.findFuncs(s.body)
+		}
+	}
+}
+
+func info(line, endLine int) nashtoken.FileInfo {
+	fi := nashtoken.NewFileInfo(line, 0)
+	fi.SetEnd(endLine, 0)
+	return fi
+}
+
+func (p *pos) info() nashtoken.FileInfo {
+	return info(p.line, p.endLine)
+}
+
+func (t *translator) block(stmts []stmt, line int) *ast.BlockNode {
+	block := ast.NewBlockNode(info(line, line))
+
+	for _, s := range stmts {
+		for _, node := range t.stmt(s) {
+			block.Push(node)
+		}
+	}
+
+	return block
+}
+
+func (t *translator) tree(stmts []stmt, line int) *ast.Tree {
+	tree := ast.NewTree("")
+	tree.Root = t.block(stmts, line)
+	return tree
+}
+
+func (t *translator) declare(name string) {
+	t.scopes[len(t.scopes)-1][name] = true
+}
+
+func (t *translator) declared(name string) bool {
+	for _, scope := range t.scopes {
+		if scope[name] {
+			return true
+		}
+	}
+	return false
+}
+
+// stmt translates the statement, or comments it with a TODO if it can
+// not be translated.
+func (t *translator) stmt(s stmt) []ast.Node {
+	var (
+		nodes []ast.Node
+		err   error
+	)
+
+	switch s := s.(type) {
+	case *comment:
+		text := s.text
+		if s.line == 1 && strings.HasPrefix(text, "#!") {
+			text = "#!/usr/bin/env nash"
+		}
+		return []ast.Node{ast.NewCommentNode(s.info(), text)}
+	case *unsupported:
+		err = untranslatable(s.reason)
+	case *simpleCmd:
+		nodes, err = t.simpleCmd(s)
+	case *pipeline:
+		var node ast.Node
+		node, err = t.pipeline(s)
+		nodes = []ast.Node{node}
+	case *ifStmt:
+		nodes, err = t.ifStmt(s)
+	case *forStmt:
+		nodes, err = t.forStmt(s)
+	case *funcDecl:
+		nodes = []ast.Node{t.funcDecl(s)}
+	}
+
+	if reason, ok := err.(untranslatable); ok {
+		return t.todo(s.base(), string(reason))
+	}
+
+	if c := s.base().comment; c != nil && len(nodes) > 0 {
+		last := nodes[len(nodes)-1]
+		last.Comments().Trailing = ast.NewCommentNode(c.info(), c.text)
+	}
+
+	return nodes
+}
+
+// todo returns comments with the reason and the original source of a
+// statement that could not be translated.
+func (t *translator) todo(p *pos, reason string) []ast.Node {
+	t.todos++
+
+	nodes := []ast.Node{
+		ast.NewCommentNode(info(p.line, p.line),
+			"# TODO(bash2nash): translate "+reason+":"),
+	}
+
+	indent := ""
+	if p.line-1 < len(t.lines) {
+		first := t.lines[p.line-1]
+		indent = first[:len(first)-len(strings.TrimLeft(first, " \t"))]
+	}
+
+	for line := p.line; line <= p.endLine && line-1 < len(t.lines); line++ {
+		text := strings.TrimRight(strings.TrimPrefix(t.lines[line-1], indent), " \t\r")
+		nodes = append(nodes, ast.NewCommentNode(info(line, line), "#\t"+text))
+	}
+
+	return nodes
+}
+
+func (t *translator) simpleCmd(cmd *simpleCmd) ([]ast.Node, error) {
+	if len(cmd.words) == 0 {
+		if len(cmd.redirs) > 0 {
+			return nil, untranslatable("redirections without command")
+		}
+
+		var nodes []ast.Node
+		for _, assign := range cmd.assigns {
+			node, err := t.assign(&cmd.pos, assign, false, false)
+			if err != nil {
+				return nil, err
+			}
+			nodes = append(nodes, node)
+		}
+		return nodes, nil
+	}
+
+	if len(cmd.assigns) > 0 {
+		return nil, untranslatable("variable assignments for a single command")
+	}
+
+	name := cmd.words[0].keyword()
+	if name == "" {
+		return nil, untranslatable("commands with computed names")
+	}
+
+	fi := cmd.info()
+	args := cmd.words[1:]
+
+	if unsupportedBuiltins[name] {
+		return nil, untranslatable(name + " builtin")
+	}
+
+	switch name {
+	case "export", "local":
+		return t.declaration(cmd, name == "export")
+	case "cd", "exit":
+		if len(args) > 1 || len(cmd.redirs) > 0 {
+			return nil, untranslatable(name + " with redirections or many arguments")
+		}
+
+		fnname, value := "chdir", ast.Expr(ast.NewVarExpr(fi, "$HOME"))
+		if name == "exit" {
+			fnname, value = "exit", ast.NewStringExpr(fi, "0", true)
+		}
+
+		if len(args) == 1 {
+			var err error
+			if value, err = t.word(args[0], true); err != nil {
+				return nil, err
+			}
+		}
+
+		inv := ast.NewFnInvNode(fi, fnname)
+		inv.AddArg(value)
+		return []ast.Node{inv}, nil
+	case "return":
+		if !t.inFunc || len(args) > 0 || len(cmd.redirs) > 0 {
+			return nil, untranslatable("return with status")
+		}
+		return []ast.Node{ast.NewReturnNode(fi)}, nil
+	case "source", ".":
+		if len(args) != 1 || args[0].literal() == "" || len(cmd.redirs) > 0 {
+			return nil, untranslatable("source of computed files")
+		}
+
+		path := args[0].literal()
+		return []ast.Node{ast.NewImportNode(fi, ast.NewStringExpr(fi, path, !safeArg(path)))}, nil
+	}
+
+	node, err := t.exec(cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	return []ast.Node{node}, nil
+}
+
+// exec translates a command to a node executable by nash: a command or
+// a function invocation.
+func (t *translator) exec(cmd *simpleCmd) (ast.Node, error) {
+	if len(cmd.assigns) > 0 {
+		return nil, untranslatable("variable assignments for a single command")
+	}
+
+	name := cmd.words[0].keyword()
+	if name == "" {
+		return nil, untranslatable("commands with computed names")
+	}
+
+	fi := cmd.info()
+	args := cmd.words[1:]
+
+	if t.funcs[name] {
+		if len(cmd.redirs) > 0 {
+			return nil, untranslatable("redirection of function calls")
+		}
+
+		inv := ast.NewFnInvNode(fi, name)
+		for _, arg := range args {
+			expr, err := t.arg(arg, true)
+			if err != nil {
+				return nil, err
+			}
+			inv.AddArg(expr)
+		}
+		return inv, nil
+	}
+
+	if name == "[" {
+		if len(args) == 0 || args[len(args)-1].keyword() != "]" {
+			return nil, untranslatable("[ without ]")
+		}
+
+		name, args = "test", args[:len(args)-1]
+	}
+
+	n := ast.NewCommandNode(fi, name, false)
+
+	for _, arg := range args {
+		expr, err := t.arg(arg, false)
+		if err != nil {
+			return nil, err
+		}
+		n.AddArg(expr)
+	}
+
+	for _, r := range cmd.redirs {
+		redir, err := t.redirect(fi, r)
+		if err != nil {
+			return nil, err
+		}
+		n.AddRedirect(redir)
+	}
+
+	return n, nil
+}
+
+func (t *translator) redirect(fi nashtoken.FileInfo, r redir) (*ast.RedirectNode, error) {
+	redir := ast.NewRedirectNode(fi)
+
+	switch r.op {
+	case ">", ">|":
+		if r.fd >= 0 && r.fd != 1 {
+			redir.SetMap(r.fd, ast.RedirMapNoValue)
+		}
+
+		location, err := t.word(r.target, false)
+		if err != nil {
+			return nil, err
+		}
+		redir.SetLocation(location)
+	case ">&":
+		lfd := 1
+		if r.fd >= 0 {
+			lfd = r.fd
+		}
+
+		rfd, err := strconv.Atoi(r.target.literal())
+		if err != nil {
+			return nil, untranslatable(">& redirections to files")
+		}
+		redir.SetMap(lfd, rfd)
+	default:
+		return nil, untranslatable(r.op + " redirections")
+	}
+
+	return redir, nil
+}
+
+// declaration translates export and local.
+func (t *translator) declaration(cmd *simpleCmd, export bool) ([]ast.Node, error) {
+	if len(cmd.redirs) > 0 {
+		return nil, untranslatable("redirections of declarations")
+	}
+
+	if !export && !t.inFunc {
+		return nil, untranslatable("local outside functions")
+	}
+
+	var nodes []ast.Node
+
+	for _, w := range cmd.words[1:] {
+		name, value, ok := splitAssignment(w)
+		if !ok {
+			name = w.keyword()
+			if !isName(name) {
+				return nil, untranslatable("declarations of computed names")
+			}
+		}
+
+		if export {
+			var assign ast.Node
+
+			if ok {
+				n, err := t.assign(&cmd.pos, assignment{name, value}, true, false)
+				if err != nil {
+					return nil, err
+				}
+				assign = n
+			}
+
+			setenv, err := ast.NewSetenvNode(cmd.info(), name, assign)
+			if err != nil {
+				return nil, err
+			}
+
+			nodes = append(nodes, setenv)
+			t.declare(name)
+			continue
+		}
+
+		if !ok {
+			value = &word{parts: []wordPart{{kind: partLit, quoted: true}}}
+		}
+
+		n, err := t.assign(&cmd.pos, assignment{name, value}, false, true)
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, n)
+	}
+
+	return nodes, nil
+}
+
+// assign translates name=value. The first assignment of a name is a
+// var declaration. Command substitutions are translated to <=.
+func (t *translator) assign(p *pos, a assignment, setenv, local bool) (ast.Node, error) {
+	fi := p.info()
+	names := []*ast.NameNode{ast.NewNameNode(fi, a.name, nil)}
+
+	var assign ast.Node
+
+	if len(a.value.parts) == 1 && a.value.parts[0].kind == partCmdSubst {
+		stmts, err := parse(a.value.parts[0].text)
+		if err != nil || len(stmts) != 1 {
+			return nil, untranslatable("complex command substitutions")
+		}
+
+		var cmd ast.Node
+
+		switch s := stmts[0].(type) {
+		case *simpleCmd:
+			if len(s.words) > 0 && t.funcs[s.words[0].keyword()] {
+				return nil, untranslatable("command substitution of functions")
+			}
+			cmd, err = t.exec(s)
+		case *pipeline:
+			cmd, err = t.pipeline(s)
+		default:
+			err = untranslatable("complex command substitutions")
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		exec, err := ast.NewExecAssignNode(fi, names, cmd)
+		if err != nil {
+			return nil, err
+		}
+		assign = exec
+	} else {
+		value, err := t.word(a.value, true)
+		if err != nil {
+			return nil, err
+		}
+		assign = ast.NewAssignNode(fi, names, []ast.Expr{value})
+	}
+
+	if setenv {
+		return assign, nil
+	}
+
+	// local always declares the variable in the function scope
+	if t.scopes[len(t.scopes)-1][a.name] || !local && t.declared(a.name) {
+		return assign, nil
+	}
+
+	if t.inFunc && !local {
+		// declared at the top of the script by translate
+		t.scopes[0][a.name] = true
+		t.globals = append(t.globals, a.name)
+		return assign, nil
+	}
+
+	t.declare(a.name)
+
+	if exec, ok := assign.(*ast.ExecAssignNode); ok {
+		return ast.NewVarExecAssignDecl(fi, exec), nil
+	}
+
+	return ast.NewVarAssignDecl(fi, assign.(*ast.AssignNode)), nil
+}
+
+func (t *translator) pipeline(pipe *pipeline) (ast.Node, error) {
+	n := ast.NewPipeNode(pipe.info(), false)
+
+	for _, cmd := range pipe.cmds {
+		node, err := t.exec(cmd)
+		if err != nil {
+			return nil, err
+		}
+
+		c, ok := node.(*ast.CommandNode)
+		if !ok {
+			return nil, untranslatable("pipes with functions")
+		}
+		n.AddCmd(c)
+	}
+
+	return n, nil
+}
+
+// execNode translates statements used as conditions.
+func (t *translator) execNode(s stmt) (ast.Node, error) {
+	switch s := s.(type) {
+	case *simpleCmd:
+		if len(s.words) == 0 {
+			return nil, untranslatable("assignments as conditions")
+		}
+		return t.exec(s)
+	case *pipeline:
+		return t.pipeline(s)
+	case *unsupported:
+		return nil, untranslatable(s.reason)
+	}
+
+	return nil, untranslatable("compound commands as conditions")
+}
+
+func (t *translator) ifStmt(s *ifStmt) ([]ast.Node, error) {
+	var (
+		nodes []ast.Node
+		prev  *ast.IfNode
+	)
+
+	for i, clause := range s.clauses {
+		prelude, n, err := t.condition(clause.cond, &s.pos)
+		if err != nil {
+			return nil, err
+		}
+
+		n.SetIfTree(t.tree(clause.body, s.line))
+
+		if i == 0 {
+			nodes = append(prelude, n)
+		} else {
+			elseTree := ast.NewTree("")
+			elseTree.Root = ast.NewBlockNode(s.info())
+
+			for _, node := range prelude {
+				elseTree.Root.Push(node)
+			}
+
+			elseTree.Root.Push(n)
+			prev.SetElseTree(elseTree)
+			prev.SetElseif(len(prelude) == 0)
+		}
+
+		prev = n
+	}
+
+	if s.hasElse {
+		prev.SetElseTree(t.tree(s.els, s.line))
+	}
+
+	return nodes, nil
+}
+
+// condition translates the condition of an if. The comparisons of
+// strings with [ or test are translated to nash comparisons, other
+// commands have their status checked.
+func (t *translator) condition(cond []stmt, p *pos) ([]ast.Node, *ast.IfNode, error) {
+	if len(cond) != 1 {
+		return nil, nil, untranslatable("conditions with many commands")
+	}
+
+	fi := p.info()
+	n := ast.NewIfNode(fi)
+
+	if cmd, ok := cond[0].(*simpleCmd); ok && len(cmd.assigns) == 0 &&
+		len(cmd.redirs) == 0 && len(cmd.words) > 0 {
+		words := cmd.words[1:]
+
+		switch cmd.words[0].keyword() {
+		case "[":
+			if len(words) > 0 && words[len(words)-1].keyword() == "]" {
+				words = words[:len(words)-1]
+			} else {
+				words = nil
+			}
+		case "test":
+		default:
+			words = nil
+		}
+
+		if lvalue, op, rvalue, ok := t.comparison(fi, words); ok {
+			n.SetLvalue(lvalue)
+			n.SetOp(op)
+			n.SetRvalue(rvalue)
+			return nil, n, nil
+		}
+	}
+
+	cmd, err := t.execNode(cond[0])
+	if err != nil {
+		return nil, nil, err
+	}
+
+	names := []*ast.NameNode{
+		ast.NewNameNode(fi, "_", nil),
+		ast.NewNameNode(fi, "status", nil),
+	}
+
+	exec, err := ast.NewExecAssignNode(fi, names, cmd)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	n.SetLvalue(ast.NewVarExpr(fi, "$status"))
+	n.SetOp("==")
+	n.SetRvalue(ast.NewStringExpr(fi, "0", true))
+
+	return []ast.Node{ast.NewVarExecAssignDecl(fi, exec)}, n, nil
+}
+
+// comparison translates the string comparisons of test.
+func (t *translator) comparison(fi nashtoken.FileInfo, words []*word) (ast.Expr, string, ast.Expr, bool) {
+	empty := ast.NewStringExpr(fi, "", true)
+
+	switch len(words) {
+	case 1:
+		value, err := t.word(words[0], true)
+		if err != nil || strings.HasPrefix(words[0].literal(), "-") {
+			return nil, "", nil, false
+		}
+		return value, "!=", empty, true
+	case 2:
+		op := words[0].keyword()
+		if op != "-z" && op != "-n" {
+			return nil, "", nil, false
+		}
+
+		value, err := t.word(words[1], true)
+		if err != nil {
+			return nil, "", nil, false
+		}
+
+		if op == "-z" {
+			return value, "==", empty, true
+		}
+		return value, "!=", empty, true
+	case 3:
+		op := words[1].keyword()
+		if op == "=" {
+			op = "=="
+		}
+
+		if op != "==" && op != "!=" {
+			return nil, "", nil, false
+		}
+
+		lvalue, err := t.word(words[0], true)
+		if err != nil {
+			return nil, "", nil, false
+		}
+
+		rvalue, err := t.word(words[2], true)
+		if err != nil {
+			return nil, "", nil, false
+		}
+		return lvalue, op, rvalue, true
+	}
+
+	return nil, "", nil, false
+}
+
+func (t *translator) forStmt(s *forStmt) ([]ast.Node, error) {
+	fi := s.info()
+	n := ast.NewForNode(fi)
+	n.SetIdentifier(s.name)
+
+	var in ast.Expr
+
+	switch {
+	case !s.hasIn:
+		args, err := t.allArgs(fi)
+		if err != nil {
+			return nil, err
+		}
+		in = args
+	case len(s.items) == 1 && len(s.items[0].parts) == 1 &&
+		s.items[0].parts[0].kind == partParam:
+		part := s.items[0].parts[0]
+
+		if part.text == "@" || part.text == "*" {
+			args, err := t.allArgs(fi)
+			if err != nil {
+				return nil, err
+			}
+			in = args
+			break
+		}
+
+		value, err := t.word(s.items[0], true)
+		if err != nil {
+			return nil, err
+		}
+
+		if part.quoted {
+			in = ast.NewListExpr(fi, []ast.Expr{value})
+			break
+		}
+
+		// unquoted variables are split in words
+		split := ast.NewFnInvNode(fi, "split")
+		split.AddArg(value)
+		split.AddArg(ast.NewStringExpr(fi, " ", true))
+		in = split
+	default:
+		var items []ast.Expr
+		for _, item := range s.items {
+			value, err := t.word(item, true)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, value)
+		}
+		in = ast.NewListExpr(fi, items)
+	}
+
+	t.declare(s.name)
+
+	n.SetInExpr(in)
+	n.SetTree(t.tree(s.body, s.line))
+	return []ast.Node{n}, nil
+}
+
+// allArgs returns the arguments of the function.
+func (t *translator) allArgs(fi nashtoken.FileInfo) (ast.Expr, error) {
+	if !t.inFunc {
+		return nil, untranslatable("loops over the script arguments")
+	}
+
+	t.usesArgs = true
+	return ast.NewVarExpr(fi, "$args"), nil
+}
+
+func (t *translator) funcDecl(s *funcDecl) ast.Node {
+	n := ast.NewFnDeclNode(s.info(), s.name)
+
+	inFunc, usesArgs, params := t.inFunc, t.usesArgs, t.params
+	t.inFunc, t.usesArgs, t.params = true, false, 0
+	t.scopes = append(t.scopes, map[string]bool{})
+
+	tree := t.tree(s.body, s.line)
+	if t.params > 0 {
+		fi := info(0, 0)
+		tree.Root.Nodes = append([]ast.Node{t.argv(fi, "$args")}, tree.Root.Nodes...)
+	}
+	n.SetTree(tree)
+
+	// sh functions receive any number of arguments
+	if t.usesArgs {
+		n.AddArg(ast.NewFnArgNode(s.info(), "args", true))
+	}
+
+	t.scopes = t.scopes[:len(t.scopes)-1]
+	t.inFunc, t.usesArgs, t.params = inFunc, usesArgs, params
+	return n
+}
+
+// arg translates a word used as argument of commands and functions,
+// where "$@" expands to all the arguments of the function.
+func (t *translator) arg(w *word, quote bool) (ast.Expr, error) {
+	if len(w.parts) == 1 && w.parts[0].kind == partParam &&
+		(w.parts[0].text == "@" || w.parts[0].text == "*") {
+		if !t.inFunc {
+			return nil, untranslatable("$@ outside functions")
+		}
+
+		t.usesArgs = true
+		return ast.NewVarVariadicExpr(nashtoken.NewFileInfo(w.line, 0), "$args", true), nil
+	}
+
+	return t.word(w, quote)
+}
+
+// safeArg reports whether the string can be an unquoted nash argument.
+func safeArg(s string) bool {
+	if s == "" {
+		return false
+	}
+
+	for _, r := range s {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' ||
+			r >= '0' && r <= '9' || strings.ContainsRune("_./:@%-", r)) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// word translates a sh word to an expression. The strings are quoted
+// if quote is true or if they have characters special to nash.
+func (t *translator) word(w *word, quote bool) (ast.Expr, error) {
+	fi := nashtoken.NewFileInfo(w.line, 0)
+
+	var (
+		exprs  []ast.Expr
+		lit    strings.Builder
+		inLit  bool
+		quoted bool
+	)
+
+	flush := func() {
+		if inLit {
+			text := lit.String()
+			exprs = append(exprs, ast.NewStringExpr(fi, text, quote || quoted || !safeArg(text)))
+			lit.Reset()
+			inLit, quoted = false, false
+		}
+	}
+
+	for i, part := range w.parts {
+		switch part.kind {
+		case partLit:
+			text := part.text
+
+			if !part.quoted && part.glob {
+				return nil, untranslatable("globbing")
+			}
+
+			if i == 0 && !part.quoted && (text == "~" || strings.HasPrefix(text, "~/")) {
+				exprs = append(exprs, ast.NewVarExpr(fi, "$HOME"))
+				text = text[1:]
+				if text == "" {
+					continue
+				}
+			}
+
+			lit.WriteString(text)
+			inLit, quoted = true, quoted || part.quoted
+		case partParam:
+			flush()
+
+			expr, err := t.param(fi, part.text)
+			if err != nil {
+				return nil, err
+			}
+			exprs = append(exprs, expr)
+		case partComplex:
+			return nil, untranslatable("parameter expansion " + part.text)
+		case partCmdSubst:
+			return nil, untranslatable("command substitution inside words")
+		case partArith:
+			return nil, untranslatable("arithmetic expansion")
+		}
+	}
+
+	flush()
+
+	switch len(exprs) {
+	case 0:
+		return ast.NewStringExpr(fi, "", true), nil
+	case 1:
+		return exprs[0], nil
+	}
+
+	// concatenated strings are always quoted
+	for i, expr := range exprs {
+		if str, ok := expr.(*ast.StringExpr); ok {
+			exprs[i] = ast.NewStringExpr(fi, str.Value(), true)
+		}
+	}
+
+	return ast.NewConcatExpr(fi, exprs), nil
+}
+
+// param translates the parameter expansion of name.
+func (t *translator) param(fi nashtoken.FileInfo, name string) (ast.Expr, error) {
+	if isName(name) {
+		return ast.NewVarExpr(fi, "$"+name), nil
+	}
+
+	n, err := strconv.Atoi(name)
+	if err != nil {
+		return nil, untranslatable("special parameter $" + name)
+	}
+
+	if n == 0 {
+		return ast.NewIndexExpr(fi, ast.NewVarExpr(fi, "$ARGS"), ast.NewIntExpr(fi, 0)), nil
+	}
+
+	if n > t.params {
+		t.params = n
+	}
+
+	// $argv holds the arguments padded by empty strings, see argv
+	if !t.inFunc {
+		return ast.NewIndexExpr(fi, ast.NewVarExpr(fi, "$argv"), ast.NewIntExpr(fi, n)), nil
+	}
+
+	t.usesArgs = true
+	return ast.NewIndexExpr(fi, ast.NewVarExpr(fi, "$argv"), ast.NewIntExpr(fi, n-1)), nil
+}
@@ -0,0 +1,393 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/madlambda/nash/ast"
+)
+
+const buildUsage = `usage: nash build [flags] main.sh
+
+Builds a standalone executable that runs main.sh. The executable is the
+nash interpreter, with the standard library embedded, plus main.sh and
+the scripts it imports, so it does not need $NASHROOT or $NASHPATH.
+
+The scripts imported relative to main.sh keep their path relative to
+it, and the ones found in $NASHPATH/lib are embedded as a library
+directory. Imports of the standard library use the one embedded in the
+interpreter. When the executable runs, the imports are searched on disk
+first and then in the embedded scripts.
+
+The arguments of the executable are passed to the script, the first one
+($ARGS[0]) being the executable name.
+
+Flags:
+`
+
+// payloadMagic ends the executables built by nash build, after the
+// zip archive with the scripts and its size.
+const payloadMagic = "NASHBUILD1"
+
+// runBuild implements the "nash build" subcommand and returns the
+// process exit status.
+func runBuild(args []string) int {
+	flags := flag.NewFlagSet("build", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprint(flags.Output(), buildUsage)
+		flags.PrintDefaults()
+	}
+
+	output := flags.String("o", "", "name of the executable (default is the script name without .sh)")
+
+	scripts := parseInterspersed(flags, args)
+	if len(scripts) != 1 {
+		flags.Usage()
+		return 2
+	}
+
+	if *output == "" {
+		*output = strings.TrimSuffix(filepath.Base(scripts[0]), ".sh")
+	}
+
+	nashpath, err := NashPath()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		return 1
+	}
+
+	nashroot, err := NashRoot()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		return 1
+	}
+
+	interpreter, err := os.Executable()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "unable to find the nash executable: %s\n", err)
+		return 1
+	}
+
+	unresolved, err := Build(*output, interpreter, scripts[0], nashpath, nashroot)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		return 1
+	}
+
+	for _, imp := range unresolved {
+		fmt.Fprintf(os.Stderr, "warning: %s\n", imp)
+	}
+	return 0
+}
+
+// parseInterspersed parses the flags of args, that can also follow the
+// positional arguments, like in nash bundle main.sh -o out.sh, and
+// returns the positional arguments.
+func parseInterspersed(flags *flag.FlagSet, args []string) []string {
+	var positional []string
+
+	for {
+		flags.Parse(args)
+		if flags.NArg() == 0 {
+			return positional
+		}
+
+		positional = append(positional, flags.Arg(0))
+		args = flags.Args()[1:]
+	}
+}
+
+// Build writes to output an executable made of the interpreter and the
+// script fname, with the scripts it imports. The imports that can only
+// be resolved at runtime, and were not found, are returned as a list
+// of descriptions.
+func Build(output, interpreter, fname, nashpath, nashroot string) ([]string, error) {
+	files, unresolved, err := embeddedFiles(fname, nashpath, nashroot)
+	if err != nil {
+		return nil, err
+	}
+
+	exe, err := os.Open(interpreter)
+	if err != nil {
+		return nil, err
+	}
+	defer exe.Close()
+
+	// the interpreter can be itself a built executable
+	size, err := interpreterSize(exe)
+	if err != nil {
+		return nil, err
+	}
+
+	out, err := os.OpenFile(output, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0755)
+	if err != nil {
+		return nil, err
+	}
+	defer out.Close()
+
+	if _, err := io.Copy(out, io.NewSectionReader(exe, 0, size)); err != nil {
+		return nil, err
+	}
+
+	counter := &countWriter{w: out}
+	archive := zip.NewWriter(counter)
+
+	for _, f := range files {
+		content, err := f.script.read()
+		if err != nil {
+			return nil, err
+		}
+
+		w, err := archive.Create(f.name)
+		if err != nil {
+			return nil, err
+		}
+
+		if _, err := w.Write(content); err != nil {
+			return nil, err
+		}
+	}
+
+	// the comment of the archive is the script to run
+	if err := archive.SetComment(files[0].name); err != nil {
+		return nil, err
+	}
+
+	if err := archive.Close(); err != nil {
+		return nil, err
+	}
+
+	trailer := make([]byte, 8, 8+len(payloadMagic))
+	binary.LittleEndian.PutUint64(trailer, uint64(counter.n))
+	trailer = append(trailer, payloadMagic...)
+
+	if _, err := out.Write(trailer); err != nil {
+		return nil, err
+	}
+
+	return unresolved, out.Close()
+}
+
+type (
+	// embeddedFile is a script embedded in a built executable, with
+	// the name it has inside the executable.
+	embeddedFile struct {
+		script script
+		name   string
+	}
+
+	countWriter struct {
+		w io.Writer
+		n int64
+	}
+)
+
+func (c *countWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// embeddedFiles returns the script fname, first, and the scripts
+// imported by it, recursively, that are not part of the standard
+// library.
+func embeddedFiles(fname, nashpath, nashroot string) ([]embeddedFile, []string, error) {
+	var (
+		files []embeddedFile
+		names = make(map[string]script)
+	)
+
+	dir, err := filepath.Abs(filepath.Dir(fname))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	libdir := filepath.Join(nashpath, "lib")
+	stdlibdir := filepath.Join(nashroot, "stdlib")
+
+	b := newBundler(nashpath, nashroot)
+	queue := []script{{path: fname}}
+
+	for len(queue) > 0 {
+		s := queue[0]
+		queue = queue[1:]
+
+		tree, err := b.parse(s)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		// already embedded or part of the embedded stdlib
+		if tree == nil || s.fsys != nil || within(stdlibdir, s.path) {
+			continue
+		}
+
+		name, err := embeddedName(s.path, dir, libdir)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if other, ok := names[name]; ok {
+			return nil, nil, fmt.Errorf("%s and %s would be embedded as %s", other, s, name)
+		}
+
+		names[name] = s
+		files = append(files, embeddedFile{s, name})
+
+		toplevel := make(map[ast.Node]bool)
+		for _, node := range tree.Root.Nodes {
+			toplevel[node] = true
+		}
+
+		var errResolve error
+
+		ast.Inspect(tree.Root, func(node ast.Node) bool {
+			imp, ok := node.(*ast.ImportNode)
+			if !ok || errResolve != nil {
+				return errResolve == nil
+			}
+
+			module, err := b.resolve(s, imp)
+			switch {
+			case err == nil:
+				queue = append(queue, module)
+			case toplevel[imp]:
+				errResolve = err
+			default:
+				// the imports of blocks could be found at runtime
+				b.unresolved = append(b.unresolved, err.Error())
+			}
+			return true
+		})
+
+		if errResolve != nil {
+			return nil, nil, errResolve
+		}
+	}
+
+	return files, b.unresolved, nil
+}
+
+// embeddedName returns the name of the script fname inside the built
+// executable: its path relative to the directory of the main script or
+// to the library directory.
+func embeddedName(fname, dir, libdir string) (string, error) {
+	fname, err := filepath.Abs(fname)
+	if err != nil {
+		return "", err
+	}
+
+	if within(dir, fname) {
+		rel, err := filepath.Rel(dir, fname)
+		return filepath.ToSlash(rel), err
+	}
+
+	if within(libdir, fname) {
+		rel, err := filepath.Rel(libdir, fname)
+		return "lib/" + filepath.ToSlash(rel), err
+	}
+
+	return "", fmt.Errorf("%s can not be embedded: it is not inside %s or %s", fname, dir, libdir)
+}
+
+// within reports whether fname is inside the directory dir.
+func within(dir, fname string) bool {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return false
+	}
+
+	fname, err = filepath.Abs(fname)
+	if err != nil {
+		return false
+	}
+
+	rel, err := filepath.Rel(dir, fname)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// interpreterSize returns the size of the executable exe without the
+// scripts embedded by nash build.
+func interpreterSize(exe *os.File) (int64, error) {
+	info, err := exe.Stat()
+	if err != nil {
+		return 0, err
+	}
+
+	_, offset, err := readPayload(exe, info.Size())
+	if err != nil {
+		return 0, err
+	}
+
+	if offset < 0 {
+		return info.Size(), nil
+	}
+	return offset, nil
+}
+
+// readPayload returns the archive of scripts embedded at the end of the
+// executable r, of the given size, and its offset. The offset is -1 if
+// there is no archive.
+func readPayload(r io.ReaderAt, size int64) (*zip.Reader, int64, error) {
+	trailerSize := int64(8 + len(payloadMagic))
+	if size < trailerSize {
+		return nil, -1, nil
+	}
+
+	// without a readable trailer there is no archive to be found
+	trailer := make([]byte, trailerSize)
+	if _, err := r.ReadAt(trailer, size-trailerSize); err != nil {
+		return nil, -1, nil
+	}
+
+	if string(trailer[8:]) != payloadMagic {
+		return nil, -1, nil
+	}
+
+	archiveSize := int64(binary.LittleEndian.Uint64(trailer))
+	offset := size - trailerSize - archiveSize
+
+	if archiveSize <= 0 || offset < 0 {
+		return nil, -1, fmt.Errorf("invalid size of embedded scripts: %d", archiveSize)
+	}
+
+	archive, err := zip.NewReader(io.NewSectionReader(r, offset, archiveSize), archiveSize)
+	if err != nil {
+		return nil, -1, fmt.Errorf("invalid embedded scripts: %s", err)
+	}
+
+	return archive, offset, nil
+}
+
+// OpenEmbedded returns the scripts embedded in the executable exe by
+// nash build and the name of the script to run. The filesystem is nil
+// if exe has no embedded scripts or can not be read, as an execute
+// only interpreter, and the error is only about invalid scripts.
+func OpenEmbedded(exe string) (fs.FS, string, error) {
+	f, err := os.Open(exe)
+	if err != nil {
+		return nil, "", nil
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, "", nil
+	}
+
+	// the file stays open to read the scripts while they run
+	archive, _, err := readPayload(f, info.Size())
+	if archive == nil || err != nil {
+		f.Close()
+		return nil, "", err
+	}
+
+	return archive, archive.Comment, nil
+}
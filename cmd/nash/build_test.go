@@ -0,0 +1,148 @@
+package main_test
+
+import (
+	"io/fs"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	main "github.com/madlambda/nash/cmd/nash"
+	"github.com/madlambda/nash/internal/testing/fixture"
+)
+
+func TestBuild(t *testing.T) {
+	dir, cleanup := fixture.Tmpdir(t)
+	defer cleanup()
+
+	nashpath := filepath.Join(dir, "nashpath")
+	nashroot := filepath.Join(dir, "nashroot")
+
+	scripts := map[string]string{
+		"app/tool.sh":             "import lib/greet\nimport io\n\nfn main() {\n\timport later\n}\n",
+		"app/lib/greet.sh":        "import fmt\nimport util\n",
+		"app/later.sh":            "echo later\n",
+		"nashpath/lib/fmt.sh":     "echo fmt\n",
+		"nashroot/stdlib/util.sh": "echo util\n",
+	}
+
+	for name, content := range scripts {
+		path := filepath.Join(dir, name)
+		fixture.MkdirAll(t, filepath.Dir(path))
+		err := ioutil.WriteFile(path, []byte(content), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	interpreter := filepath.Join(dir, "nash")
+	err := ioutil.WriteFile(interpreter, []byte("interpreter"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tool := filepath.Join(dir, "tool")
+	checkBuild := func(interpreter string) {
+		unresolved, err := main.Build(tool, interpreter,
+			filepath.Join(dir, "app", "tool.sh"), nashpath, nashroot)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(unresolved) != 0 {
+			t.Fatalf("unexpected unresolved imports: %v", unresolved)
+		}
+
+		content, err := ioutil.ReadFile(tool)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !strings.HasPrefix(string(content), "interpreter") ||
+			strings.HasPrefix(string(content), "interpreterinterpreter") {
+			t.Fatalf("the executable does not start with the interpreter")
+		}
+
+		fsys, entry, err := main.OpenEmbedded(tool)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if entry != "tool.sh" {
+			t.Fatalf("expected entry tool.sh but got %s", entry)
+		}
+
+		var files []string
+		err = fs.WalkDir(fsys, ".", func(path string, d fs.DirEntry, err error) error {
+			if err == nil && !d.IsDir() {
+				files = append(files, path)
+			}
+			return err
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		sort.Strings(files)
+
+		// io and util are part of the stdlib
+		want := []string{"later.sh", "lib/fmt.sh", "lib/greet.sh", "tool.sh"}
+		if strings.Join(files, " ") != strings.Join(want, " ") {
+			t.Fatalf("expected embedded files %v but got %v", want, files)
+		}
+
+		later, err := fs.ReadFile(fsys, "later.sh")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if string(later) != scripts["app/later.sh"] {
+			t.Fatalf("unexpected content of later.sh: %q", later)
+		}
+	}
+
+	checkBuild(interpreter)
+
+	// building with a built executable does not embed its scripts
+	built := filepath.Join(dir, "built")
+	if err := copyFile(tool, built); err != nil {
+		t.Fatal(err)
+	}
+
+	checkBuild(built)
+}
+
+func TestBuildNoScripts(t *testing.T) {
+	dir, cleanup := fixture.Tmpdir(t)
+	defer cleanup()
+
+	exe := filepath.Join(dir, "nash")
+	err := ioutil.WriteFile(exe, []byte("interpreter"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fsys, _, err := main.OpenEmbedded(exe)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if fsys != nil {
+		t.Fatal("expected no embedded scripts")
+	}
+
+	// an interpreter that can not be read runs as usual
+	fsys, _, err = main.OpenEmbedded(filepath.Join(dir, "unreadable"))
+	if err != nil || fsys != nil {
+		t.Fatalf("expected no embedded scripts and no error but got: %v", err)
+	}
+}
+
+func copyFile(src, dst string) error {
+	content, err := ioutil.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(dst, content, 0755)
+}
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/madlambda/nash/ast"
+	"github.com/madlambda/nash/parser"
+	"github.com/madlambda/nash/stdlib"
+	"github.com/madlambda/nash/token"
+)
+
+const bundleUsage = `usage: nash bundle [flags] main.sh
+
+Writes main.sh and the modules it imports as a single script, that runs
+without the libraries being installed. The imports are searched like
+the import statement does: relative to the importing script, then in
+$NASHPATH/lib and $NASHROOT/stdlib, and last in the standard library
+embedded in nash. Every module is included once, in place of its first
+import.
+
+Only the imports at the top level of the scripts are bundled. The
+imports inside functions and blocks are resolved at runtime, so they
+are reported and kept in the bundle.
+
+Flags:
+`
+
+type (
+	// bundler inlines the imports of scripts.
+	bundler struct {
+		nashpath string
+		nashroot string
+
+		seen       map[script]bool
+		unresolved []string
+	}
+
+	// script is a file on disk or, if fsys is not nil, of the
+	// standard library embedded in nash.
+	script struct {
+		fsys fs.FS
+		path string
+	}
+)
+
+// runBundle implements the "nash bundle" subcommand and returns the
+// process exit status.
+func runBundle(args []string) int {
+	flags := flag.NewFlagSet("bundle", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprint(flags.Output(), bundleUsage)
+		flags.PrintDefaults()
+	}
+
+	output := flags.String("o", "", "write the bundle to file instead of the standard output")
+
+	scripts := parseInterspersed(flags, args)
+	if len(scripts) != 1 {
+		flags.Usage()
+		return 2
+	}
+
+	nashpath, err := NashPath()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		return 1
+	}
+
+	nashroot, err := NashRoot()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		return 1
+	}
+
+	tree, unresolved, err := Bundle(scripts[0], nashpath, nashroot)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		return 1
+	}
+
+	for _, imp := range unresolved {
+		fmt.Fprintf(os.Stderr, "warning: %s\n", imp)
+	}
+
+	content := tree.String() + "\n"
+
+	if *output == "" {
+		_, err = io.WriteString(os.Stdout, content)
+	} else {
+		err = ioutil.WriteFile(*output, []byte(content), 0755)
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		return 1
+	}
+	return 0
+}
+
+// Bundle returns the script fname with its imports, and the imports
+// of the imported modules, replaced by the content of the modules.
+// The imports that can only be resolved at runtime are not bundled and
+// are returned as a list of descriptions.
+func Bundle(fname, nashpath, nashroot string) (*ast.Tree, []string, error) {
+	b := newBundler(nashpath, nashroot)
+
+	nodes, err := b.inline(script{path: fname}, true)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	tree := ast.NewTree(fname)
+	tree.Root = ast.NewBlockNode(token.NewFileInfo(1, 0))
+	tree.Root.Nodes = nodes
+	return tree, b.unresolved, nil
+}
+
+func newBundler(nashpath, nashroot string) *bundler {
+	return &bundler{
+		nashpath: nashpath,
+		nashroot: nashroot,
+		seen:     make(map[script]bool),
+	}
+}
+
+// parse returns the syntax tree of s, or nil if it was already parsed.
+func (b *bundler) parse(s script) (*ast.Tree, error) {
+	if s.fsys == nil {
+		abspath, err := filepath.Abs(s.path)
+		if err != nil {
+			return nil, err
+		}
+		s.path = abspath
+	}
+
+	if b.seen[s] {
+		return nil, nil
+	}
+
+	b.seen[s] = true
+
+	content, err := s.read()
+	if err != nil {
+		return nil, err
+	}
+
+	return parser.NewParser(s.String(), string(content)).Parse()
+}
+
+// inline returns the statements of the script s, with its imports
+// inlined. Modules already bundled have no statements.
+func (b *bundler) inline(s script, main bool) ([]ast.Node, error) {
+	tree, err := b.parse(s)
+	if tree == nil || err != nil {
+		return nil, err
+	}
+
+	var nodes []ast.Node
+
+	for i, node := range tree.Root.Nodes {
+		// only the main script keeps its #! line
+		if c, ok := node.(*ast.CommentNode); ok && i == 0 && !main &&
+			strings.HasPrefix(c.String(), "#!") {
+			continue
+		}
+
+		imp, ok := node.(*ast.ImportNode)
+		if !ok {
+			b.findUnresolved(s, node)
+			nodes = append(nodes, node)
+			continue
+		}
+
+		module, err := b.resolve(s, imp)
+		if err != nil {
+			return nil, err
+		}
+
+		imported, err := b.inline(module, false)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(imported) == 0 {
+			continue
+		}
+
+		nodes = append(nodes, ast.NewCommentNode(imp.FileInfo, "# import "+imp.Path.Value()))
+		nodes = append(nodes, imported...)
+	}
+
+	return nodes, nil
+}
+
+// findUnresolved records the imports nested in the blocks of node.
+func (b *bundler) findUnresolved(s script, node ast.Node) {
+	ast.Inspect(node, func(node ast.Node) bool {
+		if imp, ok := node.(*ast.ImportNode); ok {
+			b.unresolved = append(b.unresolved, fmt.Sprintf(
+				"%s:%d:%d: import %s inside a block is resolved at runtime",
+				s, imp.Line(), imp.Column(), imp.Path.Value()))
+		}
+		return true
+	})
+}
+
+// resolve returns the script imported by imp in the script s,
+// searching the same locations, in the same order, as the import
+// statement, including the standard library embedded in nash.
+func (b *bundler) resolve(s script, imp *ast.ImportNode) (script, error) {
+	var tries []script
+
+	name := imp.Path.Value()
+	hasExt := filepath.Ext(name) != ""
+
+	add := func(fsys fs.FS, fpath string) {
+		tries = append(tries, script{fsys, fpath})
+
+		if !hasExt {
+			tries = append(tries, script{fsys, fpath + ".sh"})
+		}
+	}
+
+	if filepath.IsAbs(name) {
+		add(nil, name)
+	}
+
+	if s.fsys != nil {
+		localFile := path.Join(path.Dir(s.path), filepath.ToSlash(name))
+		if fs.ValidPath(localFile) {
+			add(s.fsys, localFile)
+		}
+	} else {
+		add(nil, filepath.Join(filepath.Dir(s.path), name))
+	}
+
+	add(nil, filepath.Join(b.nashpath, "lib", name))
+
+	tries = append(tries, script{path: filepath.Join(b.nashroot, "stdlib", name+".sh")})
+
+	if stdFile := filepath.ToSlash(name) + ".sh"; fs.ValidPath(stdFile) {
+		tries = append(tries, script{stdlib.FS, stdFile})
+	}
+
+	paths := make([]string, len(tries))
+
+	for i, try := range tries {
+		if try.isFile() {
+			return try, nil
+		}
+		paths[i] = try.String()
+	}
+
+	return script{}, fmt.Errorf(
+		"%s:%d:%d: failed to import path '%s'. The locations below have been tried:\n \"%s\"",
+		s, imp.Line(), imp.Column(), name, strings.Join(paths, `", "`))
+}
+
+func (s script) isFile() bool {
+	var (
+		info fs.FileInfo
+		err  error
+	)
+
+	if s.fsys == nil {
+		info, err = os.Stat(s.path)
+	} else {
+		info, err = fs.Stat(s.fsys, s.path)
+	}
+
+	return err == nil && !info.IsDir()
+}
+
+func (s script) read() ([]byte, error) {
+	if s.fsys == nil {
+		return ioutil.ReadFile(s.path)
+	}
+	return fs.ReadFile(s.fsys, s.path)
+}
+
+func (s script) String() string {
+	if s.fsys == nil {
+		return s.path
+	}
+	return "stdlib:" + s.path
+}
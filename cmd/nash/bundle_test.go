@@ -0,0 +1,83 @@
+package main_test
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	main "github.com/madlambda/nash/cmd/nash"
+	"github.com/madlambda/nash/internal/testing/fixture"
+)
+
+func TestBundle(t *testing.T) {
+	dir, cleanup := fixture.Tmpdir(t)
+	defer cleanup()
+
+	nashpath := filepath.Join(dir, "nashpath")
+	nashroot := filepath.Join(dir, "nashroot")
+
+	scripts := map[string]string{
+		"app/main.sh": "#!/usr/bin/env nash\n\nimport lib/greet\nimport fmt\n\n" +
+			"fn main() {\n\timport later\n\tgreet(\"world\")\n}\n\nmain()\n",
+		"app/lib/greet.sh": "#!/usr/bin/env nash\n\nimport fmt\n\n" +
+			"fn greet(name) {\n\tfmt_println(\"hello \"+$name)\n}\n",
+		"nashpath/lib/fmt.sh":     "import util\n\nfn fmt_println(s) {\n\techo $s\n}\n",
+		"nashroot/stdlib/util.sh": "var util_version = \"1\"\n",
+	}
+
+	for name, content := range scripts {
+		path := filepath.Join(dir, name)
+		fixture.MkdirAll(t, filepath.Dir(path))
+		err := ioutil.WriteFile(path, []byte(content), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	mainfile := filepath.Join(dir, "app", "main.sh")
+
+	tree, unresolved, err := main.Bundle(mainfile, nashpath, nashroot)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := "#!/usr/bin/env nash\n\n" +
+		"# import lib/greet\n" +
+		"# import fmt\n" +
+		"# import util\n" +
+		"var util_version = \"1\"\n\n" +
+		"fn fmt_println(s) {\n\techo $s\n}\n\n" +
+		"fn greet(name) {\n\tfmt_println(\"hello \"+$name)\n}\n\n" +
+		"fn main() {\n\timport later\n\n\tgreet(\"world\")\n}\n\n" +
+		"main()"
+
+	if got := tree.String(); got != want {
+		t.Fatalf("expected bundle:\n%s\n\ngot:\n%s", want, got)
+	}
+
+	if len(unresolved) != 1 || !strings.HasSuffix(unresolved[0],
+		"main.sh:7:1: import later inside a block is resolved at runtime") {
+		t.Fatalf("unexpected unresolved imports: %v", unresolved)
+	}
+}
+
+func TestBundleMissingImport(t *testing.T) {
+	dir, cleanup := fixture.Tmpdir(t)
+	defer cleanup()
+
+	mainfile := filepath.Join(dir, "main.sh")
+	err := ioutil.WriteFile(mainfile, []byte("import missing\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, _, err = main.Bundle(mainfile, dir, dir)
+	if err == nil {
+		t.Fatal("expected error bundling a missing import")
+	}
+
+	if !strings.Contains(err.Error(), "failed to import path 'missing'") {
+		t.Fatalf("unexpected error: %s", err)
+	}
+}
@@ -0,0 +1,415 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"html/template"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/madlambda/nash/ast"
+	"github.com/madlambda/nash/parser"
+)
+
+const docUsage = `usage: nash doc [flags] <module|file|function>
+
+Prints the documentation of the functions of a nash library, taken from
+the comments immediately preceding each function declaration. Functions
+whose name starts with "_" are considered private and are not listed.
+The comment at the top of the file, when not attached to a function,
+documents the library itself.
+
+The argument can be a script file, a module name (searched like the
+import statement does) or a function name, like io_println, in which
+case only its documentation is printed, as text.
+
+Indented comment lines are formatted as code examples.
+
+Flags:
+`
+
+type (
+	// fnDoc is the documentation of a function.
+	fnDoc struct {
+		Name string
+		Args []string
+		Doc  []docBlock
+	}
+
+	// docBlock is a paragraph of text or a code example.
+	docBlock struct {
+		Code bool
+		Text string
+	}
+
+	// moduleDoc is the documentation of a library.
+	moduleDoc struct {
+		Name string
+		Doc  []docBlock
+		Fns  []fnDoc
+	}
+)
+
+// runDoc implements the "nash doc" subcommand and returns the process
+// exit status.
+func runDoc(args []string) int {
+	flags := flag.NewFlagSet("doc", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprint(flags.Output(), docUsage)
+		flags.PrintDefaults()
+	}
+
+	html := flags.Bool("html", false, "output HTML instead of Markdown")
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return 2
+	}
+
+	err := Doc(os.Stdout, flags.Arg(0), *html)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		return 1
+	}
+	return 0
+}
+
+// Doc writes the documentation of name, that can be a module, a
+// script file or a function, into out.
+// Modules are documented in Markdown, or HTML if html is true, and
+// functions in plain text.
+func Doc(out io.Writer, name string, html bool) error {
+	fname, ok := findModule(name)
+	if ok {
+		mod, err := parseModuleDoc(fname)
+		if err != nil {
+			return err
+		}
+
+		if html {
+			return writeDocHTML(out, mod)
+		}
+		return writeDocMarkdown(out, mod)
+	}
+
+	// name is a function, prefixed by the name of its module
+	dir, fnName := filepath.Split(name)
+	for i, c := range fnName {
+		if c != '_' {
+			continue
+		}
+
+		fname, ok := findModule(dir + fnName[:i])
+		if !ok {
+			continue
+		}
+
+		mod, err := parseModuleDoc(fname)
+		if err != nil {
+			return err
+		}
+
+		for _, fn := range mod.Fns {
+			if fn.Name == fnName {
+				return writeDocText(out, fn)
+			}
+		}
+	}
+
+	return fmt.Errorf("no module or function named %q found", name)
+}
+
+// findModule returns the file of the module name, searching the same
+// locations used by import.
+func findModule(name string) (string, bool) {
+	var tries []string
+
+	if name == "" {
+		return "", false
+	}
+
+	tries = append(tries, name)
+	if filepath.Ext(name) == "" {
+		tries = append(tries, name+".sh")
+	}
+
+	if nashpath, err := NashPath(); err == nil {
+		tries = append(tries,
+			filepath.Join(nashpath, "lib", name),
+			filepath.Join(nashpath, "lib", name+".sh"))
+	}
+
+	if nashroot, err := NashRoot(); err == nil {
+		tries = append(tries, filepath.Join(nashroot, "stdlib", name+".sh"))
+	}
+
+	for _, path := range tries {
+		info, err := os.Stat(path)
+		if err == nil && !info.IsDir() {
+			return path, true
+		}
+	}
+
+	return "", false
+}
+
+// parseModuleDoc returns the documentation of the module, from the
+// comment at the top of the file, and of its public functions.
+func parseModuleDoc(fname string) (moduleDoc, error) {
+	content, err := ioutil.ReadFile(fname)
+	if err != nil {
+		return moduleDoc{}, err
+	}
+
+	tree, err := parser.NewParser(fname, string(content)).Parse()
+	if err != nil {
+		return moduleDoc{}, err
+	}
+
+	mod := moduleDoc{
+		Name: strings.TrimSuffix(filepath.Base(fname), ".sh"),
+	}
+
+	var (
+		comments []*ast.CommentNode
+
+		// leading is true until the first node that is not part
+		// of the comment at the top of the file
+		leading = true
+	)
+
+	// detach drops the comments if they are not immediately
+	// before line, keeping the first ones as the module doc.
+	detach := func(line int) {
+		if len(comments) == 0 ||
+			comments[len(comments)-1].Line() == line-1 {
+			return
+		}
+
+		if leading {
+			mod.Doc = docBlocks(commentLines(comments))
+			leading = false
+		}
+		comments = nil
+	}
+
+	for _, node := range tree.Root.Nodes {
+		switch n := node.(type) {
+		case *ast.CommentNode:
+			// only consecutive comment lines are part of the doc
+			detach(n.Line())
+			comments = append(comments, n)
+			continue
+		case *ast.FnDeclNode:
+			detach(n.Line())
+
+			if !strings.HasPrefix(n.Name(), "_") {
+				mod.Fns = append(mod.Fns, newFnDoc(n, comments))
+			}
+		}
+
+		leading = false
+		comments = nil
+	}
+
+	if leading {
+		mod.Doc = docBlocks(commentLines(comments))
+	}
+
+	return mod, nil
+}
+
+func newFnDoc(fn *ast.FnDeclNode, comments []*ast.CommentNode) fnDoc {
+	doc := fnDoc{
+		Name: fn.Name(),
+	}
+
+	for _, arg := range fn.Args() {
+		doc.Args = append(doc.Args, arg.String())
+	}
+
+	doc.Doc = docBlocks(commentLines(comments))
+	return doc
+}
+
+// commentLines returns the text of the comments, without the "#" and
+// the space following it.
+func commentLines(comments []*ast.CommentNode) []string {
+	var lines []string
+	for _, c := range comments {
+		line := strings.TrimPrefix(c.String(), "#")
+		if strings.HasPrefix(line, " ") {
+			line = line[1:]
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// docBlocks groups the comment lines in paragraphs of text and blocks
+// of code. Code lines are the ones indented by a tab or 4 spaces.
+func docBlocks(lines []string) []docBlock {
+	var (
+		blocks  []docBlock
+		current []string
+		code    bool
+	)
+
+	flush := func() {
+		text := strings.TrimRight(strings.Join(current, "\n"), "\n ")
+		if text != "" {
+			blocks = append(blocks, docBlock{Code: code, Text: text})
+		}
+		current = nil
+	}
+
+	for _, line := range lines {
+		isCode := strings.HasPrefix(line, "\t") || strings.HasPrefix(line, "    ")
+		isBlank := strings.TrimSpace(line) == ""
+
+		switch {
+		case isBlank && !code:
+			flush()
+			continue
+		case isBlank && code:
+			current = append(current, "")
+			continue
+		case isCode != code:
+			flush()
+			code = isCode
+		}
+
+		if code {
+			if strings.HasPrefix(line, "\t") {
+				line = line[1:]
+			} else {
+				line = line[4:]
+			}
+		}
+		current = append(current, line)
+	}
+
+	flush()
+	return blocks
+}
+
+func (fn fnDoc) Signature() string {
+	return fmt.Sprintf("fn %s(%s)", fn.Name, strings.Join(fn.Args, ", "))
+}
+
+// mdAnchor returns the anchor of a header, like mdtoc does.
+func mdAnchor(header string) string {
+	var anchor []rune
+
+	for _, r := range strings.ToLower(header) {
+		switch {
+		case r == ' ':
+			anchor = append(anchor, '-')
+		case r == '-' || (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9'):
+			anchor = append(anchor, r)
+		}
+	}
+	return string(anchor)
+}
+
+func writeDocMarkdown(out io.Writer, mod moduleDoc) error {
+	var b strings.Builder
+
+	b.WriteString("<!-- mdtocstart -->\n\n# Table of Contents\n\n")
+	fmt.Fprintf(&b, "- [%s](#%s)\n", mod.Name, mdAnchor(mod.Name))
+	for _, fn := range mod.Fns {
+		fmt.Fprintf(&b, "    - [%s](#%s)\n", fn.Name, mdAnchor(fn.Name))
+	}
+	b.WriteString("\n<!-- mdtocend -->\n\n")
+
+	fmt.Fprintf(&b, "# %s\n", mod.Name)
+	writeBlocksMarkdown(&b, mod.Doc)
+
+	for _, fn := range mod.Fns {
+		fmt.Fprintf(&b, "\n## %s\n\n", fn.Name)
+		fmt.Fprintf(&b, "```nash\n%s\n```\n", fn.Signature())
+		writeBlocksMarkdown(&b, fn.Doc)
+	}
+
+	_, err := io.WriteString(out, b.String())
+	return err
+}
+
+func writeBlocksMarkdown(b *strings.Builder, blocks []docBlock) {
+	for _, block := range blocks {
+		if block.Code {
+			fmt.Fprintf(b, "\n```nash\n%s\n```\n", block.Text)
+		} else {
+			fmt.Fprintf(b, "\n%s\n", block.Text)
+		}
+	}
+}
+
+var docHTML = template.Must(template.New("doc").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Name}}</title>
+</head>
+<body>
+<h1>{{.Name}}</h1>
+{{- template "blocks" .Doc}}
+<ul>
+{{- range .Fns}}
+<li><a href="#{{.Name}}">{{.Name}}</a></li>
+{{- end}}
+</ul>
+{{- range .Fns}}
+<h2 id="{{.Name}}">{{.Name}}</h2>
+<pre><code>{{.Signature}}</code></pre>
+{{- template "blocks" .Doc}}
+{{- end}}
+</body>
+</html>
+{{- define "blocks"}}
+{{- range .}}
+{{- if .Code}}
+<pre><code>{{.Text}}</code></pre>
+{{- else}}
+<p>{{.Text}}</p>
+{{- end}}
+{{- end}}
+{{- end}}
+`))
+
+func writeDocHTML(out io.Writer, mod moduleDoc) error {
+	return docHTML.Execute(out, mod)
+}
+
+// writeDocText writes the documentation of a single function in plain
+// text, to be read in the terminal.
+func writeDocText(out io.Writer, fn fnDoc) error {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "%s\n", fn.Signature())
+	for i, block := range fn.Doc {
+		if i > 0 {
+			b.WriteString("\n")
+		}
+
+		indent := "    "
+		if block.Code {
+			indent = "        "
+		}
+
+		for _, line := range strings.Split(block.Text, "\n") {
+			if line == "" {
+				b.WriteString("\n")
+				continue
+			}
+			fmt.Fprintf(&b, "%s%s\n", indent, line)
+		}
+	}
+
+	_, err := io.WriteString(out, b.String())
+	return err
+}
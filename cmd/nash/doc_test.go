@@ -0,0 +1,118 @@
+package main_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	main "github.com/madlambda/nash/cmd/nash"
+	"github.com/madlambda/nash/internal/testing/fixture"
+)
+
+const docLib = `# Greetings for everyone.
+
+# greet_hello says hello.
+#
+#	greet_hello("world")
+fn greet_hello(name) {
+	echo hello $name
+}
+
+# not a doc comment, there is a blank line
+
+fn greet_all(names...) {
+	for name in $names {
+		greet_hello($name)
+	}
+}
+
+# private functions are not documented
+fn _greet() {
+}
+`
+
+func TestDoc(t *testing.T) {
+	dir, cleanup := fixture.Tmpdir(t)
+	defer cleanup()
+
+	lib := filepath.Join(dir, "greet.sh")
+	err := ioutil.WriteFile(lib, []byte(docLib), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	err = main.Doc(&out, lib, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := "<!-- mdtocstart -->\n\n" +
+		"# Table of Contents\n\n" +
+		"- [greet](#greet)\n" +
+		"    - [greet_hello](#greethello)\n" +
+		"    - [greet_all](#greetall)\n\n" +
+		"<!-- mdtocend -->\n\n" +
+		"# greet\n\n" +
+		"Greetings for everyone.\n\n" +
+		"## greet_hello\n\n" +
+		"```nash\nfn greet_hello(name)\n```\n\n" +
+		"greet_hello says hello.\n\n" +
+		"```nash\ngreet_hello(\"world\")\n```\n\n" +
+		"## greet_all\n\n" +
+		"```nash\nfn greet_all(names...)\n```\n"
+
+	if out.String() != want {
+		t.Fatalf("markdown differs:\nwant:\n%s\ngot:\n%s", want, out.String())
+	}
+
+	out.Reset()
+	err = main.Doc(&out, filepath.Join(dir, "greet_hello"), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want = "fn greet_hello(name)\n" +
+		"    greet_hello says hello.\n\n" +
+		"        greet_hello(\"world\")\n"
+	if out.String() != want {
+		t.Fatalf("text differs:\nwant:\n%s\ngot:\n%s", want, out.String())
+	}
+
+	out.Reset()
+	err = main.Doc(&out, lib, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(out.String(), `<h2 id="greet_all">greet_all</h2>`) ||
+		!strings.Contains(out.String(), `<p>Greetings for everyone.</p>`) {
+		t.Fatalf("unexpected html:\n%s", out.String())
+	}
+
+	err = main.Doc(&out, filepath.Join(dir, "greet_absent"), false)
+	if err == nil {
+		t.Fatal("expected error documenting absent function")
+	}
+}
+
+func TestDocStdlibUpToDate(t *testing.T) {
+	for _, mod := range []string{"assert", "io", "map"} {
+		want, err := ioutil.ReadFile(filepath.Join("..", "..", "docs", "stdlib", mod+".md"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var out bytes.Buffer
+		err = main.Doc(&out, filepath.Join("..", "..", "stdlib", mod+".sh"), false)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if out.String() != string(want) {
+			t.Errorf("docs/stdlib/%s.md is outdated, run make stdlibdocs:\n%s", mod, out.String())
+		}
+	}
+}
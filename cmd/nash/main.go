@@ -1,17 +1,26 @@
-// Package main has two sides:
-// - User mode: shell
-// - tool mode: unix socket server for handling namespace operations
-// When started, the program choses their side based on the argv[0].
-// The name "nash" indicates a user shell and the name -nashd- indicates
-// the namespace server tool.
+// Package main is the nash shell.
+// The rfork blocks are run by executing the shell again, with the
+// name -nash-rfork- as argv[0], handled by the nash package itself.
+// "nash test" runs the test functions of *_test.sh files
+// and "nash doc" prints the documentation of nash libraries.
+// "nash bundle" writes a script and its imports as a single script and
+// "nash build" makes a standalone executable of them. The executables
+// built run their embedded script instead of being a shell.
+// "nash pkg" installs, lists, removes and updates versioned libraries.
 package main
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"os"
 
 	"github.com/madlambda/nash"
+	"github.com/madlambda/nash/parser"
 )
 
 var (
@@ -22,10 +31,14 @@ var (
 	debug       bool
 	file        string
 	command     string
-	addr        string
 	noInit      bool
 	interactive bool
 	install 	string
+	profile     string
+	coverprofile string
+	coverformat  string
+	testmode     bool
+	dumpAST      bool
 )
 
 func init() {
@@ -35,11 +48,11 @@ func init() {
 	flag.StringVar(&command, "c", "", "command to execute")
 	flag.StringVar(&install, "install", "", "path of the library that you want to install (can be a single file)")
 	flag.BoolVar(&interactive, "i", false, "Interactive mode (default if no args)")
-
-	if os.Args[0] == "-nashd-" || (len(os.Args) > 1 && os.Args[1] == "-daemon") {
-		flag.Bool("daemon", false, "force enable nashd mode")
-		flag.StringVar(&addr, "addr", "", "rcd unix file")
-	}
+	flag.StringVar(&profile, "profile", "", "write a profile of the script execution to file (and folded stacks to file.folded)")
+	flag.StringVar(&coverprofile, "coverprofile", "", "write (or merge into) a statement coverage profile of the executed files")
+	flag.StringVar(&coverformat, "coverformat", "lcov", "format of the coverage profile: lcov or go")
+	flag.BoolVar(&testmode, "testmode", false, "enable the builtin functions for tests (mock, mock_calls)")
+	flag.BoolVar(&dumpAST, "dump-ast", false, "print the syntax tree of the script (or -c command) as JSON and exit")
 }
 
 func main() {
@@ -47,12 +60,39 @@ func main() {
 	var shell *nash.Shell
 	var err error
 
+	if status, ok := runEmbedded(); ok {
+		os.Exit(status)
+	}
+
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "test":
+			os.Exit(runTests(os.Args[2:]))
+		case "doc":
+			os.Exit(runDoc(os.Args[2:]))
+		case "bundle":
+			os.Exit(runBundle(os.Args[2:]))
+		case "build":
+			os.Exit(runBuild(os.Args[2:]))
+		case "pkg":
+			os.Exit(runPkg(os.Args[2:]))
+		}
+	}
+
 	flag.Parse()
 
 	if version {
 		fmt.Printf("build tag: %s\n", VersionString)
 		return
 	}
+
+	if dumpAST {
+		if err := writeAST(os.Stdout, flag.Args(), command); err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+			os.Exit(1)
+		}
+		return
+	}
 	
 	if install != "" {
 		fmt.Printf("installing library located at [%s]\n", install)
@@ -81,9 +121,26 @@ func main() {
 
 	shell.SetDebug(debug)
 
-	if addr != "" {
-		startNashd(shell, addr)
-		return
+	if testmode {
+		shell.EnableTestMode()
+	}
+
+	if coverprofile != "" {
+		shell.EnableCoverage()
+		defer func() {
+			if err := writeCoverage(shell, coverprofile); err != nil {
+				fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+			}
+		}()
+	}
+
+	if profile != "" {
+		shell.EnableProfile()
+		defer func() {
+			if err := writeProfile(shell, profile); err != nil {
+				fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+			}
+		}()
 	}
 
 	if (file == "" && command == "") || interactive {
@@ -110,10 +167,106 @@ func main() {
 Error:
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+		if profile != "" && shell != nil {
+			if err := writeProfile(shell, profile); err != nil {
+				fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+			}
+		}
+		if coverprofile != "" && shell != nil {
+			if err := writeCoverage(shell, coverprofile); err != nil {
+				fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+			}
+		}
 		os.Exit(1)
 	}
 }
 
+// runEmbedded runs the script embedded by nash build, if the nash
+// executable has one, returning the exit status and true.
+func runEmbedded() (int, bool) {
+	exe, err := os.Executable()
+	if err != nil {
+		return 0, false
+	}
+
+	fsys, entry, err := OpenEmbedded(exe)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+		return 1, true
+	}
+
+	if fsys == nil {
+		return 0, false
+	}
+
+	shell, err := initShell()
+	if err == nil {
+		err = shell.ExecFS(fsys, entry, os.Args...)
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+		return 1, true
+	}
+	return 0, true
+}
+
+// writeAST writes the JSON encoded syntax tree of the script file given
+// in args or, if there is no file, of the command.
+func writeAST(out io.Writer, args []string, command string) error {
+	name, content := "<argument -c>", command
+
+	if len(args) > 0 {
+		data, err := ioutil.ReadFile(args[0])
+		if err != nil {
+			return err
+		}
+		name, content = args[0], string(data)
+	} else if command == "" {
+		return errors.New("-dump-ast requires a script file or -c")
+	}
+
+	tree, err := parser.NewParser(name, content).Parse()
+	if err != nil {
+		return err
+	}
+
+	data, err := tree.MarshalJSON()
+	if err != nil {
+		return err
+	}
+
+	// json.MarshalIndent would escape the HTML characters of strings
+	var buf bytes.Buffer
+	if err := json.Indent(&buf, data, "", "  "); err != nil {
+		return err
+	}
+
+	buf.WriteByte('\n')
+	_, err = buf.WriteTo(out)
+	return err
+}
+
+func writeProfile(shell *nash.Shell, fname string) error {
+	out, err := os.Create(fname)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	if err := shell.WriteProfile(out); err != nil {
+		return err
+	}
+
+	folded, err := os.Create(fname + ".folded")
+	if err != nil {
+		return err
+	}
+	defer folded.Close()
+
+	return shell.WriteProfileFolded(folded)
+}
+
 func initShell() (*nash.Shell, error) {
 		
 	nashpath, err := NashPath()
@@ -128,3 +281,27 @@ func initShell() (*nash.Shell, error) {
 	os.Mkdir(nashpath, 0755)
 	return nash.New(nashpath, nashroot)
 }
+
+// writeCoverage writes the coverage into fname, merging it with the
+// coverage already present in the file, so the coverage of several
+// script runs can be accumulated.
+func writeCoverage(shell *nash.Shell, fname string) error {
+	old, err := os.Open(fname)
+	if err == nil {
+		err = shell.MergeCoverage(old)
+		old.Close()
+		if err != nil {
+			return err
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	out, err := os.Create(fname)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	return shell.WriteCoverage(out, coverformat)
+}
@@ -0,0 +1,810 @@
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+const pkgUsage = `usage: nash pkg <command> [arguments]
+
+Manages the libraries installed in $NASHPATH/lib. Every library is
+installed in its own directory, $NASHPATH/lib/<name>, and imported as
+<name>/<file>.
+
+The installed libraries are recorded in $NASHPATH/nash.mod, with their
+version and source, and the checksums of their files in
+$NASHPATH/nash.sum. Installing again a version whose files changed is
+refused.
+
+Commands:
+
+	install [-name name] <source>[@version]
+		installs the library from a git repository, at the given
+		tag (the latest one by default), or from a tarball (.tar,
+		.tar.gz or .tgz). The version of tarballs is taken from
+		their name, like greet-v1.0.0.tar.gz, if not given.
+	list
+		lists the installed libraries.
+	remove <name>...
+		removes the libraries.
+	update [name...]
+		updates the libraries installed from git repositories to
+		their latest tag. All of them by default.
+`
+
+const (
+	modFile = "nash.mod"
+	sumFile = "nash.sum"
+)
+
+type (
+	// Package is a library installed by nash pkg.
+	Package struct {
+		Name    string
+		Version string
+		Source  string
+
+		// Status of the installed files: ok, modified or missing.
+		// Only set by PkgList.
+		Status string
+	}
+
+	// pkgManager manages the libraries of a nashpath.
+	pkgManager struct {
+		nashpath string
+		pkgs     []Package
+		sums     map[string]string // name@version to checksum
+	}
+)
+
+// runPkg implements the "nash pkg" subcommand and returns the process
+// exit status.
+func runPkg(args []string) int {
+	flags := flag.NewFlagSet("pkg", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprint(flags.Output(), pkgUsage)
+	}
+	flags.Parse(args)
+
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return 2
+	}
+
+	nashpath, err := NashPath()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		return 1
+	}
+
+	cmd, args := flags.Arg(0), flags.Args()[1:]
+
+	switch cmd {
+	case "install":
+		installFlags := flag.NewFlagSet("install", flag.ExitOnError)
+		installFlags.Usage = flags.Usage
+		name := installFlags.String("name", "", "name of the library (default is the name of the source)")
+
+		sources := parseInterspersed(installFlags, args)
+		if len(sources) != 1 {
+			flags.Usage()
+			return 2
+		}
+
+		var pkg Package
+		if pkg, err = PkgInstall(nashpath, sources[0], *name); err == nil {
+			fmt.Printf("installed %s %s\n", pkg.Name, pkg.Version)
+		}
+	case "list":
+		var pkgs []Package
+		if pkgs, err = PkgList(nashpath); err == nil {
+			err = writePkgs(os.Stdout, pkgs)
+		}
+	case "remove":
+		if len(args) == 0 {
+			flags.Usage()
+			return 2
+		}
+		err = PkgRemove(nashpath, args...)
+	case "update":
+		var updated []Package
+		if updated, err = PkgUpdate(nashpath, args...); err == nil {
+			for _, pkg := range updated {
+				fmt.Printf("updated %s to %s\n", pkg.Name, pkg.Version)
+			}
+		}
+	default:
+		flags.Usage()
+		return 2
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		return 1
+	}
+	return 0
+}
+
+func writePkgs(out io.Writer, pkgs []Package) error {
+	w := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+
+	for _, pkg := range pkgs {
+		status := ""
+		if pkg.Status != "ok" {
+			status = "(" + pkg.Status + ")"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", pkg.Name, pkg.Version, pkg.Source, status)
+	}
+
+	return w.Flush()
+}
+
+// PkgInstall installs the library source, a git repository or a
+// tarball, optionally followed by @version, in the nashpath. The name
+// defaults to the name of the repository or tarball.
+func PkgInstall(nashpath, source, name string) (Package, error) {
+	m, err := loadPkgManager(nashpath)
+	if err != nil {
+		return Package{}, err
+	}
+
+	source, version := splitVersion(source)
+
+	// sources are recorded with absolute paths, so they work from any
+	// working directory
+	if _, err := os.Stat(source); err == nil {
+		if source, err = filepath.Abs(source); err != nil {
+			return Package{}, err
+		}
+	}
+
+	if name == "" {
+		name = pkgName(source)
+	}
+
+	pkg, err := m.install(name, source, version)
+	if err != nil {
+		return Package{}, err
+	}
+
+	return pkg, m.save()
+}
+
+// PkgList returns the libraries installed in the nashpath, checking if
+// their files still match the checksums.
+func PkgList(nashpath string) ([]Package, error) {
+	m, err := loadPkgManager(nashpath)
+	if err != nil {
+		return nil, err
+	}
+
+	pkgs := make([]Package, len(m.pkgs))
+
+	for i, pkg := range m.pkgs {
+		pkg.Status = "ok"
+
+		sum, err := dirSum(m.dir(pkg.Name))
+		if os.IsNotExist(err) {
+			pkg.Status = "missing"
+		} else if err != nil {
+			return nil, err
+		} else if sum != m.sums[pkg.Name+"@"+pkg.Version] {
+			pkg.Status = "modified"
+		}
+
+		pkgs[i] = pkg
+	}
+
+	return pkgs, nil
+}
+
+// PkgRemove removes the libraries from the nashpath.
+func PkgRemove(nashpath string, names ...string) error {
+	m, err := loadPkgManager(nashpath)
+	if err != nil {
+		return err
+	}
+
+	for _, name := range names {
+		i := m.find(name)
+		if i < 0 {
+			return fmt.Errorf("library %s is not installed", name)
+		}
+
+		if err := os.RemoveAll(m.dir(name)); err != nil {
+			return err
+		}
+
+		m.pkgs = append(m.pkgs[:i], m.pkgs[i+1:]...)
+	}
+
+	return m.save()
+}
+
+// PkgUpdate updates the libraries, or all the libraries if no name is
+// given, installed from git repositories to their latest tag. It
+// returns the libraries updated.
+func PkgUpdate(nashpath string, names ...string) ([]Package, error) {
+	m, err := loadPkgManager(nashpath)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(names) == 0 {
+		for _, pkg := range m.pkgs {
+			names = append(names, pkg.Name)
+		}
+	}
+
+	var updated []Package
+
+	for _, name := range names {
+		i := m.find(name)
+		if i < 0 {
+			return nil, fmt.Errorf("library %s is not installed", name)
+		}
+
+		pkg := m.pkgs[i]
+
+		// tarballs have a single version
+		if isTarball(pkg.Source) {
+			continue
+		}
+
+		tags, err := gitTags(pkg.Source)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(tags) == 0 || compareVersions(tags[0], pkg.Version) <= 0 {
+			continue
+		}
+
+		pkg, err = m.install(pkg.Name, pkg.Source, tags[0])
+		if err != nil {
+			return nil, err
+		}
+
+		updated = append(updated, pkg)
+	}
+
+	return updated, m.save()
+}
+
+func loadPkgManager(nashpath string) (*pkgManager, error) {
+	m := &pkgManager{
+		nashpath: nashpath,
+		sums:     make(map[string]string),
+	}
+
+	err := readLines(filepath.Join(nashpath, modFile), func(fields []string) error {
+		if len(fields) != 4 || fields[0] != "require" {
+			return errors.New("expected: require <name> <version> <source>")
+		}
+
+		source, err := strconv.Unquote(fields[3])
+		if err != nil {
+			return fmt.Errorf("invalid source %s: %s", fields[3], err)
+		}
+
+		m.pkgs = append(m.pkgs, Package{Name: fields[1], Version: fields[2], Source: source})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = readLines(filepath.Join(nashpath, sumFile), func(fields []string) error {
+		if len(fields) != 3 {
+			return errors.New("expected: <name> <version> <checksum>")
+		}
+
+		m.sums[fields[0]+"@"+fields[1]] = fields[2]
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// readLines calls parse with the fields of each line of fname, that
+// are not empty or comments. A missing file has no lines.
+func readLines(fname string, parse func(fields []string) error) error {
+	file, err := os.Open(fname)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+
+	for lineno := 1; scanner.Scan(); lineno++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if err := parse(strings.Fields(line)); err != nil {
+			return fmt.Errorf("%s:%d: %s", fname, lineno, err)
+		}
+	}
+
+	return scanner.Err()
+}
+
+func (m *pkgManager) save() error {
+	var mod bytes.Buffer
+
+	mod.WriteString("# Libraries installed in $NASHPATH/lib, managed by nash pkg.\n\n")
+	for _, pkg := range m.pkgs {
+		fmt.Fprintf(&mod, "require %s %s %s\n", pkg.Name, pkg.Version, strconv.Quote(pkg.Source))
+	}
+
+	keys := make([]string, 0, len(m.sums))
+	for key := range m.sums {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var sum bytes.Buffer
+	for _, key := range keys {
+		i := strings.LastIndex(key, "@")
+		fmt.Fprintf(&sum, "%s %s %s\n", key[:i], key[i+1:], m.sums[key])
+	}
+
+	err := ioutil.WriteFile(filepath.Join(m.nashpath, modFile), mod.Bytes(), 0644)
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(filepath.Join(m.nashpath, sumFile), sum.Bytes(), 0644)
+}
+
+func (m *pkgManager) find(name string) int {
+	for i, pkg := range m.pkgs {
+		if pkg.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+func (m *pkgManager) dir(name string) string {
+	return filepath.Join(NashLibDir(m.nashpath), name)
+}
+
+// install installs the version of source as the library name, replacing
+// the version already installed.
+func (m *pkgManager) install(name, source, version string) (Package, error) {
+	if !validPkgName(name) {
+		return Package{}, fmt.Errorf("invalid library name %q", name)
+	}
+
+	i := m.find(name)
+	if i >= 0 && m.pkgs[i].Source != source {
+		return Package{}, fmt.Errorf("library %s is already installed from %s", name, m.pkgs[i].Source)
+	}
+
+	target := m.dir(name)
+	if _, err := os.Stat(target); i < 0 && err == nil {
+		return Package{}, fmt.Errorf("%s already exists and was not installed by nash pkg", target)
+	}
+
+	libdir := NashLibDir(m.nashpath)
+	if err := os.MkdirAll(libdir, 0755); err != nil {
+		return Package{}, err
+	}
+
+	// the files are fetched to a hidden dir of the lib dir, so they can
+	// be moved to the target dir
+	staging, err := ioutil.TempDir(libdir, ".nashpkg-")
+	if err != nil {
+		return Package{}, err
+	}
+	defer os.RemoveAll(staging)
+
+	if isTarball(source) {
+		if version == "" {
+			version = tarballVersion(source)
+		}
+		if version == "" {
+			return Package{}, fmt.Errorf("unknown version of %s, use %s@<version>", source, source)
+		}
+		err = extractTarball(source, staging)
+	} else {
+		version, err = gitExport(source, version, staging)
+	}
+
+	if err != nil {
+		return Package{}, err
+	}
+
+	sum, err := dirSum(staging)
+	if err != nil {
+		return Package{}, err
+	}
+
+	key := name + "@" + version
+	if old, ok := m.sums[key]; ok && old != sum {
+		return Package{}, fmt.Errorf(
+			"checksum mismatch for %s %s: the files of %s changed since it was installed (%s is now %s)",
+			name, version, source, old, sum)
+	}
+
+	if err := os.RemoveAll(target); err != nil {
+		return Package{}, err
+	}
+
+	if err := os.Rename(staging, target); err != nil {
+		return Package{}, err
+	}
+
+	if err := os.Chmod(target, 0755); err != nil {
+		return Package{}, err
+	}
+
+	pkg := Package{Name: name, Version: version, Source: source}
+	if i >= 0 {
+		m.pkgs[i] = pkg
+	} else {
+		m.pkgs = append(m.pkgs, pkg)
+	}
+
+	m.sums[key] = sum
+	return pkg, nil
+}
+
+// splitVersion splits source@version. The @ of URLs like
+// git@host:repo are not a version.
+func splitVersion(source string) (string, string) {
+	i := strings.LastIndex(source, "@")
+	if i < 0 || i < strings.LastIndexAny(source, "/:") {
+		return source, ""
+	}
+	return source[:i], source[i+1:]
+}
+
+// pkgName returns the default name of the library of source.
+func pkgName(source string) string {
+	name := path.Base(filepath.ToSlash(strings.TrimRight(source, "/")))
+
+	if i := strings.LastIndex(name, ":"); i >= 0 {
+		name = name[i+1:]
+	}
+
+	if isTarball(name) {
+		name = trimTarball(name)
+		if version := tarballVersion(name); version != "" {
+			name = strings.TrimSuffix(name, "-"+version)
+		}
+		return name
+	}
+
+	return strings.TrimSuffix(name, ".git")
+}
+
+func validPkgName(name string) bool {
+	if name == "" || strings.HasPrefix(name, ".") {
+		return false
+	}
+
+	for _, r := range name {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' ||
+			r >= '0' && r <= '9' || r == '_' || r == '-' || r == '.') {
+			return false
+		}
+	}
+	return true
+}
+
+func isTarball(source string) bool {
+	return trimTarball(source) != source
+}
+
+func trimTarball(name string) string {
+	for _, ext := range []string{".tar.gz", ".tgz", ".tar"} {
+		if strings.HasSuffix(name, ext) {
+			return strings.TrimSuffix(name, ext)
+		}
+	}
+	return name
+}
+
+// tarballVersion returns the version in names like greet-v1.0.0.tar.gz.
+func tarballVersion(source string) string {
+	name := trimTarball(path.Base(filepath.ToSlash(source)))
+
+	i := strings.LastIndex(name, "-")
+	if i < 0 {
+		return ""
+	}
+
+	version := name[i+1:]
+	if v := strings.TrimPrefix(version, "v"); v == "" || v[0] < '0' || v[0] > '9' {
+		return ""
+	}
+
+	return version
+}
+
+// extractTarball extracts the regular files of the tarball into dir.
+// If all files are inside a single directory, it is removed from their
+// paths.
+func extractTarball(tarball, dir string) error {
+	file, err := os.Open(tarball)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var r io.Reader = file
+
+	if !strings.HasSuffix(tarball, ".tar") {
+		gz, err := gzip.NewReader(file)
+		if err != nil {
+			return fmt.Errorf("%s: %s", tarball, err)
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	files := make(map[string][]byte)
+	tr := tar.NewReader(r)
+
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %s", tarball, err)
+		}
+
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		name := path.Clean(hdr.Name)
+		if path.IsAbs(name) || name == ".." || strings.HasPrefix(name, "../") {
+			return fmt.Errorf("%s: invalid file path %s", tarball, hdr.Name)
+		}
+
+		content, err := ioutil.ReadAll(tr)
+		if err != nil {
+			return fmt.Errorf("%s: %s", tarball, err)
+		}
+		files[name] = content
+	}
+
+	prefix := commonDir(files)
+
+	for name, content := range files {
+		fname := filepath.Join(dir, filepath.FromSlash(strings.TrimPrefix(name, prefix)))
+
+		if err := os.MkdirAll(filepath.Dir(fname), 0755); err != nil {
+			return err
+		}
+
+		if err := ioutil.WriteFile(fname, content, 0644); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// commonDir returns the directory, ending in /, containing all the
+// files, if there is one.
+func commonDir(files map[string][]byte) string {
+	prefix := ""
+
+	for name := range files {
+		i := strings.Index(name, "/")
+		if i < 0 {
+			return ""
+		}
+
+		if prefix == "" {
+			prefix = name[:i+1]
+		} else if prefix != name[:i+1] {
+			return ""
+		}
+	}
+
+	return prefix
+}
+
+// gitExport copies the files of the repository source at version into
+// dir, returning the version. The default version is the latest tag.
+func gitExport(source, version, dir string) (string, error) {
+	clone, err := ioutil.TempDir("", "nashpkg")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(clone)
+
+	if _, err := git("clone", "--quiet", "--no-checkout", source, clone); err != nil {
+		return "", err
+	}
+
+	if version == "" {
+		tags, err := git("-C", clone, "tag", "--list")
+		if err != nil {
+			return "", err
+		}
+
+		sorted := sortVersions(strings.Fields(tags))
+		if len(sorted) == 0 {
+			return "", fmt.Errorf("%s has no tags, use %s@<version>", source, source)
+		}
+		version = sorted[0]
+	}
+
+	commit, err := git("-C", clone, "rev-parse", "--verify", "--quiet", version+"^{commit}")
+	if err != nil {
+		return "", fmt.Errorf("%s has no version %s", source, version)
+	}
+
+	_, err = git("-C", clone, "checkout", "--quiet", "--detach", strings.TrimSpace(commit))
+	if err != nil {
+		return "", err
+	}
+
+	if err := os.RemoveAll(filepath.Join(clone, ".git")); err != nil {
+		return "", err
+	}
+
+	return version, copyDir(clone, dir)
+}
+
+// gitTags returns the tags of the repository source, latest first.
+func gitTags(source string) ([]string, error) {
+	out, err := git("ls-remote", "--tags", "--refs", source)
+	if err != nil {
+		return nil, err
+	}
+
+	var tags []string
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 2 {
+			tags = append(tags, strings.TrimPrefix(fields[1], "refs/tags/"))
+		}
+	}
+
+	return sortVersions(tags), nil
+}
+
+func git(args ...string) (string, error) {
+	var stderr bytes.Buffer
+
+	cmd := exec.Command("git", args...)
+	cmd.Stderr = &stderr
+
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("git %s: %s: %s", strings.Join(args, " "), err,
+			strings.TrimSpace(stderr.String()))
+	}
+
+	return string(out), nil
+}
+
+// copyDir copies the regular files of src into dst.
+func copyDir(src, dst string) error {
+	return filepath.Walk(src, func(fname string, info os.FileInfo, err error) error {
+		if err != nil || !info.Mode().IsRegular() {
+			return err
+		}
+
+		rel, err := filepath.Rel(src, fname)
+		if err != nil {
+			return err
+		}
+
+		return copyfile(filepath.Join(dst, filepath.Dir(rel)), fname)
+	})
+}
+
+// sortVersions sorts the versions, latest first.
+func sortVersions(versions []string) []string {
+	sort.SliceStable(versions, func(i, j int) bool {
+		return compareVersions(versions[i], versions[j]) > 0
+	})
+	return versions
+}
+
+// compareVersions compares versions like v1.10.2, numerically, returning
+// -1, 0 or 1 if a is older, equal or newer than b.
+func compareVersions(a, b string) int {
+	as := strings.Split(strings.TrimPrefix(a, "v"), ".")
+	bs := strings.Split(strings.TrimPrefix(b, "v"), ".")
+
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		an, aerr := strconv.Atoi(as[i])
+		bn, berr := strconv.Atoi(bs[i])
+
+		switch {
+		case aerr == nil && berr == nil && an != bn:
+			if an < bn {
+				return -1
+			}
+			return 1
+		case (aerr != nil || berr != nil) && as[i] != bs[i]:
+			if as[i] < bs[i] {
+				return -1
+			}
+			return 1
+		}
+	}
+
+	switch {
+	case len(as) < len(bs):
+		return -1
+	case len(as) > len(bs):
+		return 1
+	}
+	return 0
+}
+
+// dirSum returns the checksum of the files of dir: the SHA-256 of the
+// list of their paths and SHA-256 sums.
+func dirSum(dir string) (string, error) {
+	if _, err := os.Stat(dir); err != nil {
+		return "", err
+	}
+
+	var lines []string
+
+	err := filepath.Walk(dir, func(fname string, info os.FileInfo, err error) error {
+		if err != nil || !info.Mode().IsRegular() {
+			return err
+		}
+
+		rel, err := filepath.Rel(dir, fname)
+		if err != nil {
+			return err
+		}
+
+		content, err := ioutil.ReadFile(fname)
+		if err != nil {
+			return err
+		}
+
+		lines = append(lines, fmt.Sprintf("%x  %s\n", sha256.Sum256(content), filepath.ToSlash(rel)))
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	// Walk visits the files in lexical order
+	h := sha256.New()
+	for _, line := range lines {
+		io.WriteString(h, line)
+	}
+
+	return "h1:" + base64.StdEncoding.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,260 @@
+package main_test
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	main "github.com/madlambda/nash/cmd/nash"
+	"github.com/madlambda/nash/internal/testing/fixture"
+)
+
+func TestPkgGit(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not found")
+	}
+
+	dir, cleanup := fixture.Tmpdir(t)
+	defer cleanup()
+
+	nashpath := filepath.Join(dir, "nashpath")
+	fixture.MkdirAll(t, nashpath)
+
+	repo := filepath.Join(dir, "greet")
+	fixture.MkdirAll(t, repo)
+
+	gitRun(t, repo, "init", "--quiet")
+	commitVersion(t, repo, "v1.0.0", "hello v1")
+	commitVersion(t, repo, "v1.1.0", "hello v1.1")
+
+	pkg, err := main.PkgInstall(nashpath, repo+"@v1.0.0", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if pkg.Name != "greet" || pkg.Version != "v1.0.0" || pkg.Source != repo {
+		t.Fatalf("unexpected package installed: %+v", pkg)
+	}
+
+	checkPkgFile(t, nashpath, "greet/hello.sh", "hello v1")
+	checkPkgList(t, nashpath, "greet v1.0.0 ok")
+
+	commitVersion(t, repo, "v1.2.0", "hello v1.2")
+
+	updated, err := main.PkgUpdate(nashpath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(updated) != 1 || updated[0].Version != "v1.2.0" {
+		t.Fatalf("expected greet updated to v1.2.0 but got %+v", updated)
+	}
+
+	checkPkgFile(t, nashpath, "greet/hello.sh", "hello v1.2")
+	checkPkgList(t, nashpath, "greet v1.2.0 ok")
+
+	updated, err = main.PkgUpdate(nashpath, "greet")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(updated) != 0 {
+		t.Fatalf("expected no updates but got %+v", updated)
+	}
+
+	// moving a tag changes the files of an installed version
+	commitVersion(t, repo, "", "hello v1 again")
+	gitRun(t, repo, "tag", "-f", "v1.0.0")
+
+	_, err = main.PkgInstall(nashpath, repo+"@v1.0.0", "")
+	if err == nil || !strings.Contains(err.Error(), "checksum mismatch") {
+		t.Fatalf("expected checksum mismatch but got: %v", err)
+	}
+
+	checkPkgFile(t, nashpath, "greet/hello.sh", "hello v1.2")
+
+	if err := main.PkgRemove(nashpath, "greet"); err != nil {
+		t.Fatal(err)
+	}
+
+	checkPkgList(t, nashpath)
+
+	_, err = os.Stat(filepath.Join(nashpath, "lib", "greet"))
+	if !os.IsNotExist(err) {
+		t.Fatalf("expected greet removed but got: %v", err)
+	}
+}
+
+func TestPkgTarball(t *testing.T) {
+	dir, cleanup := fixture.Tmpdir(t)
+	defer cleanup()
+
+	nashpath := filepath.Join(dir, "nashpath")
+	fixture.MkdirAll(t, nashpath)
+
+	tarball := filepath.Join(dir, "util-v1.0.tar.gz")
+	writeTarball(t, tarball, map[string]string{
+		"util-v1.0/strings.sh":  "strings",
+		"util-v1.0/io/bytes.sh": "bytes",
+	})
+
+	pkg, err := main.PkgInstall(nashpath, tarball, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if pkg.Name != "util" || pkg.Version != "v1.0" {
+		t.Fatalf("unexpected package installed: %+v", pkg)
+	}
+
+	checkPkgFile(t, nashpath, "util/strings.sh", "strings")
+	checkPkgFile(t, nashpath, "util/io/bytes.sh", "bytes")
+
+	err = ioutil.WriteFile(filepath.Join(nashpath, "lib", "util", "strings.sh"), []byte("changed"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checkPkgList(t, nashpath, "util v1.0 modified")
+
+	// installing again restores the files
+	if _, err := main.PkgInstall(nashpath, tarball, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	checkPkgList(t, nashpath, "util v1.0 ok")
+
+	// the same name can not come from another source
+	other := filepath.Join(dir, "other", "util-v2.0.tgz")
+	fixture.MkdirAll(t, filepath.Dir(other))
+	writeTarball(t, other, map[string]string{"strings.sh": "strings"})
+
+	_, err = main.PkgInstall(nashpath, other, "")
+	if err == nil || !strings.Contains(err.Error(), "already installed") {
+		t.Fatalf("expected already installed error but got: %v", err)
+	}
+
+	// neither clobber libraries not installed by nash pkg
+	fixture.MkdirAll(t, filepath.Join(nashpath, "lib", "mine"))
+
+	_, err = main.PkgInstall(nashpath, other, "mine")
+	if err == nil || !strings.Contains(err.Error(), "not installed by nash pkg") {
+		t.Fatalf("expected error installing over mine but got: %v", err)
+	}
+}
+
+func TestPkgTarballPathTraversal(t *testing.T) {
+	dir, cleanup := fixture.Tmpdir(t)
+	defer cleanup()
+
+	nashpath := filepath.Join(dir, "nashpath")
+	fixture.MkdirAll(t, nashpath)
+
+	tarball := filepath.Join(dir, "evil-v1.tar")
+	writeTarball(t, tarball, map[string]string{"../../evil.sh": "evil"})
+
+	if _, err := main.PkgInstall(nashpath, tarball, ""); err == nil {
+		t.Fatal("expected error installing a tarball with files outside of it")
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "evil.sh")); !os.IsNotExist(err) {
+		t.Fatalf("expected no evil.sh but got: %v", err)
+	}
+}
+
+func checkPkgFile(t *testing.T, nashpath, name, want string) {
+	t.Helper()
+
+	content, err := ioutil.ReadFile(filepath.Join(nashpath, "lib", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(content) != want {
+		t.Fatalf("expected %s content %q but got %q", name, want, content)
+	}
+}
+
+func checkPkgList(t *testing.T, nashpath string, want ...string) {
+	t.Helper()
+
+	pkgs, err := main.PkgList(nashpath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, pkg := range pkgs {
+		got = append(got, pkg.Name+" "+pkg.Version+" "+pkg.Status)
+	}
+
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("expected libraries %v but got %v", want, got)
+	}
+}
+
+func commitVersion(t *testing.T, repo, tag, content string) {
+	t.Helper()
+
+	err := ioutil.WriteFile(filepath.Join(repo, "hello.sh"), []byte(content), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gitRun(t, repo, "add", "hello.sh")
+	gitRun(t, repo, "-c", "user.name=nash", "-c", "user.email=nash@example.com",
+		"commit", "--quiet", "-m", content)
+
+	if tag != "" {
+		gitRun(t, repo, "tag", tag)
+	}
+}
+
+func gitRun(t *testing.T, repo string, args ...string) {
+	t.Helper()
+
+	cmd := exec.Command("git", append([]string{"-C", repo}, args...)...)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("git %s: %s: %s", strings.Join(args, " "), err, out)
+	}
+}
+
+func writeTarball(t *testing.T, fname string, files map[string]string) {
+	t.Helper()
+
+	f, err := os.Create(fname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var tw *tar.Writer
+	if strings.HasSuffix(fname, ".tar") {
+		tw = tar.NewWriter(f)
+	} else {
+		gz := gzip.NewWriter(f)
+		defer gz.Close()
+		tw = tar.NewWriter(gz)
+	}
+	defer tw.Close()
+
+	for name, content := range files {
+		err := tw.WriteHeader(&tar.Header{
+			Name: name,
+			Mode: 0644,
+			Size: int64(len(content)),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := tw.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
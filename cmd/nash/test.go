@@ -0,0 +1,245 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/madlambda/nash/ast"
+	"github.com/madlambda/nash/parser"
+)
+
+const testUsage = `usage: nash test [flags] [dirs or files]
+
+Runs every "fn test_*" function declared in the *_test.sh files found
+in the given directories (recursively) or files. The default is the
+current directory.
+
+Each test function runs in its own nash process, so a test fails if it
+calls exit with a non zero status or aborts with an error.
+
+Tests can replace commands with nash functions using the mock(name, fn)
+builtin and check how they were invoked with mock_calls(name).
+
+Flags:
+`
+
+// runTests implements the "nash test" subcommand and returns the
+// process exit status.
+func runTests(args []string) int {
+	flags := flag.NewFlagSet("test", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprint(flags.Output(), testUsage)
+		flags.PrintDefaults()
+	}
+
+	run := flags.String("run", "", "run only the tests matching the regular expression")
+	verbose := flags.Bool("v", false, "verbose: print the name and output of every test")
+	cover := flags.String("coverprofile", "", "write (or merge into) a statement coverage profile")
+	flags.Parse(args)
+
+	filter, err := regexp.Compile(*run)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid -run regexp: %s\n", err)
+		return 2
+	}
+
+	paths := flags.Args()
+	if len(paths) == 0 {
+		paths = []string{"."}
+	}
+
+	files, err := findTestFiles(paths)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		return 2
+	}
+
+	self, err := os.Executable()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "unable to find the nash executable: %s\n", err)
+		return 2
+	}
+
+	extraArgs := []string{"-testmode"}
+	if *cover != "" {
+		extraArgs = append(extraArgs, "-coverprofile", *cover)
+	}
+
+	failed := false
+	for _, file := range files {
+		start := time.Now()
+
+		ntests, ok, err := runTestFile(self, file, filter, *verbose, extraArgs)
+		if err != nil {
+			fmt.Printf("FAIL\t%s\t%s\n", file, err)
+			failed = true
+			continue
+		}
+
+		if ntests == 0 {
+			fmt.Printf("?   \t%s\t[no tests to run]\n", file)
+			continue
+		}
+
+		elapsed := time.Since(start).Seconds()
+		if !ok {
+			fmt.Printf("FAIL\t%s\t%.3fs\n", file, elapsed)
+			failed = true
+			continue
+		}
+
+		fmt.Printf("ok  \t%s\t%.3fs\n", file, elapsed)
+	}
+
+	if failed {
+		return 1
+	}
+	return 0
+}
+
+// runTestFile runs the tests of file matching filter, returning the
+// number of tests executed and false if any of them fails.
+func runTestFile(
+	nashcmd string,
+	file string,
+	filter *regexp.Regexp,
+	verbose bool,
+	extraArgs []string,
+) (int, bool, error) {
+	tests, err := testFuncs(file)
+	if err != nil {
+		return 0, false, err
+	}
+
+	abspath, err := filepath.Abs(file)
+	if err != nil {
+		return 0, false, err
+	}
+
+	var ntests int
+
+	ok := true
+	for _, name := range tests {
+		if !filter.MatchString(name) {
+			continue
+		}
+
+		ntests++
+
+		if verbose {
+			fmt.Printf("=== RUN   %s\n", name)
+		}
+
+		code := fmt.Sprintf("import %q\n%s()\n", abspath, name)
+		args := append(append([]string{}, extraArgs...), "-c", code)
+
+		var output bytes.Buffer
+		cmd := exec.Command(nashcmd, args...)
+		cmd.Stdout = &output
+		cmd.Stderr = &output
+
+		start := time.Now()
+		err := cmd.Run()
+		elapsed := time.Since(start).Seconds()
+
+		if err != nil {
+			ok = false
+			fmt.Printf("--- FAIL: %s (%.3fs)\n", name, elapsed)
+			printTestOutput(output.String())
+			continue
+		}
+
+		if verbose {
+			fmt.Printf("--- PASS: %s (%.3fs)\n", name, elapsed)
+			printTestOutput(output.String())
+		}
+	}
+
+	return ntests, ok, nil
+}
+
+func printTestOutput(output string) {
+	output = strings.TrimRight(output, "\n")
+	if output == "" {
+		return
+	}
+
+	for _, line := range strings.Split(output, "\n") {
+		fmt.Printf("    %s\n", line)
+	}
+}
+
+// testFuncs returns the name of the test functions declared at the top
+// level of the file, in declaration order.
+func testFuncs(file string) ([]string, error) {
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	tree, err := parser.NewParser(file, string(content)).Parse()
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, node := range tree.Root.Nodes {
+		fndecl, ok := node.(*ast.FnDeclNode)
+		if ok && strings.HasPrefix(fndecl.Name(), "test_") {
+			names = append(names, fndecl.Name())
+		}
+	}
+	return names, nil
+}
+
+// findTestFiles returns the *_test.sh files given or found inside the
+// given directories.
+func findTestFiles(paths []string) ([]string, error) {
+	var files []string
+
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+
+		if !info.IsDir() {
+			files = append(files, path)
+			continue
+		}
+
+		err = filepath.Walk(path, func(p string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+
+			if info.IsDir() {
+				name := info.Name()
+				if p != path && (strings.HasPrefix(name, ".") || name == "vendor") {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+
+			if strings.HasSuffix(info.Name(), "_test.sh") {
+				files = append(files, p)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	sort.Strings(files)
+	return files, nil
+}
@@ -1,3 +1,18 @@
+// Command nashfmt formats nash scripts.
+//
+// The arguments can be files or directories, which are walked looking
+// for *.sh files. Without flags the formatted scripts are printed to
+// standard output. The -check flag makes nashfmt exit with status 1 if
+// any file is not formatted, being useful in CI together with -l or -d.
+//
+// The formatting style can be configured by a .nashfmt file, found by
+// walking up from the directory of each script:
+//
+//	indent = 4
+//	linewidth = 80
+//	alignvars = true
+//
+// See styleFile for the available keys.
 package main
 
 import (
@@ -6,28 +21,44 @@ import (
 	"io"
 	"io/ioutil"
 	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
 
+	"github.com/madlambda/nash/internal/diff"
 	"github.com/madlambda/nash/parser"
 )
 
 var (
 	overwrite bool
+	list      bool
+	showDiff  bool
+	check     bool
 	version   bool
 	// version is set at build time
 	VersionString = "No version provided"
 )
 
+type (
+	// result is the outcome of formatting a file.
+	result struct {
+		fname     string
+		content   string
+		formatted string
+		err       error
+	}
+)
+
 func init() {
 	flag.BoolVar(&overwrite, "w", false, "overwrite file")
+	flag.BoolVar(&list, "l", false, "list files whose formatting differs from nashfmt's")
+	flag.BoolVar(&showDiff, "d", false, "display diffs instead of rewriting files")
+	flag.BoolVar(&check, "check", false, "exit with non zero status if any file is not formatted")
 	flag.BoolVar(&version, "version", false, "Show version")
 }
 
 func main() {
-	var (
-		file io.ReadCloser
-		err  error
-	)
-
 	flag.Parse()
 
 	if version {
@@ -40,46 +71,177 @@ func main() {
 		return
 	}
 
-	fname := flag.Args()[0]
-
-	file, err = os.Open(fname)
-
+	files, err := findFiles(flag.Args())
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "error: %s\n", err.Error())
 		os.Exit(1)
 	}
 
-	content, err := ioutil.ReadAll(file)
+	status := 0
 
-	if err != nil {
-		file.Close()
-		fmt.Fprintf(os.Stderr, "error: %s\n", err.Error())
-		os.Exit(1)
+	for res := range formatFiles(files) {
+		if res.err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", res.err.Error())
+			status = 1
+			continue
+		}
+
+		if err := report(os.Stdout, res); err != nil {
+			fmt.Fprintf(os.Stderr, "error: %s\n", err.Error())
+			status = 1
+			continue
+		}
+
+		if check && res.formatted != res.content {
+			status = 1
+		}
+	}
+
+	os.Exit(status)
+}
+
+// findFiles returns the files named by args, walking directories
+// recursively for nash scripts. Hidden directories are skipped.
+func findFiles(args []string) ([]string, error) {
+	var files []string
+
+	for _, arg := range args {
+		info, err := os.Stat(arg)
+		if err != nil {
+			return nil, err
+		}
+
+		if !info.IsDir() {
+			files = append(files, arg)
+			continue
+		}
+
+		err = filepath.Walk(arg, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+
+			if info.IsDir() && path != arg && strings.HasPrefix(info.Name(), ".") {
+				return filepath.SkipDir
+			}
+
+			if !info.IsDir() && strings.HasSuffix(path, ".sh") {
+				files = append(files, path)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return files, nil
+}
+
+// formatFiles formats the files in parallel. The results are sent in
+// the same order of files.
+func formatFiles(files []string) <-chan result {
+	var (
+		results = make([]chan result, len(files))
+		jobs    = make(chan int)
+		out     = make(chan result)
+		wg      sync.WaitGroup
+	)
+
+	for i := range results {
+		results[i] = make(chan result, 1)
 	}
 
-	parser := parser.NewParser("nashfmt", string(content))
+	for w := 0; w < runtime.NumCPU(); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				results[i] <- formatFile(files[i])
+			}
+		}()
+	}
+
+	go func() {
+		for i := range files {
+			jobs <- i
+		}
+		close(jobs)
+		wg.Wait()
+	}()
 
-	ast, err := parser.Parse()
+	go func() {
+		for _, res := range results {
+			out <- <-res
+		}
+		close(out)
+	}()
 
+	return out
+}
+
+func formatFile(fname string) result {
+	content, err := ioutil.ReadFile(fname)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "%s\n", err.Error())
-		file.Close()
-		os.Exit(1)
+		return result{fname: fname, err: err}
 	}
 
-	file.Close()
+	tree, err := parser.NewParser(fname, string(content)).Parse()
+	if err != nil {
+		return result{fname: fname, err: err}
+	}
 
-	if !overwrite {
-		fmt.Printf("%s\n", ast.String())
-		return
+	style, err := findStyle(fname)
+	if err != nil {
+		return result{fname: fname, err: err}
 	}
 
-	if ast.String() != string(content) {
-		err = ioutil.WriteFile(fname, []byte(fmt.Sprintf("%s\n", ast.String())), 0666)
+	return result{
+		fname:     fname,
+		content:   string(content),
+		formatted: tree.Format(style) + "\n",
+	}
+}
+
+// report outputs the result accordingly to the flags, writing the file
+// if needed.
+func report(out io.Writer, res result) error {
+	changed := res.formatted != res.content
+
+	if !list && !showDiff && !overwrite {
+		if !check {
+			_, err := io.WriteString(out, res.formatted)
+			return err
+		}
+		return nil
+	}
+
+	if !changed {
+		return nil
+	}
+
+	if list {
+		fmt.Fprintln(out, res.fname)
+	}
 
+	if overwrite {
+		info, err := os.Stat(res.fname)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "error: %s\n", err.Error())
-			return
+			return err
+		}
+
+		err = ioutil.WriteFile(res.fname, []byte(res.formatted), info.Mode().Perm())
+		if err != nil {
+			return err
 		}
 	}
+
+	if showDiff {
+		_, err := io.WriteString(out, diff.Unified(
+			"a/"+filepath.ToSlash(res.fname), "b/"+filepath.ToSlash(res.fname),
+			res.content, res.formatted))
+		return err
+	}
+
+	return nil
 }
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/madlambda/nash/ast"
+	"github.com/madlambda/nash/internal/testing/fixture"
+)
+
+func TestFormatFiles(t *testing.T) {
+	dir, cleanup := fixture.Tmpdir(t)
+	defer cleanup()
+
+	scripts := map[string]string{
+		"a.sh":         "echo   hello\n",
+		"b/b.sh":       "echo hello\n",
+		"b/c/c.sh":     "fn a() {\necho a\n}\n",
+		"b/notes.txt":  "not a script",
+		".hidden/d.sh": "echo    hidden\n",
+	}
+
+	for name, content := range scripts {
+		path := filepath.Join(dir, name)
+		fixture.MkdirAll(t, filepath.Dir(path))
+		err := ioutil.WriteFile(path, []byte(content), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	files, err := findFiles([]string{dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []struct {
+		fname   string
+		changed bool
+	}{
+		{filepath.Join(dir, "a.sh"), true},
+		{filepath.Join(dir, "b", "b.sh"), false},
+		{filepath.Join(dir, "b", "c", "c.sh"), true},
+	}
+
+	if len(files) != len(want) {
+		t.Fatalf("expected %d files but got %v", len(want), files)
+	}
+
+	i := 0
+	for res := range formatFiles(files) {
+		if res.err != nil {
+			t.Fatal(res.err)
+		}
+
+		if res.fname != want[i].fname {
+			t.Fatalf("expected result of %s but got %s", want[i].fname, res.fname)
+		}
+
+		changed := res.content != res.formatted
+		if changed != want[i].changed {
+			t.Fatalf("%s: expected changed=%t but got %t",
+				res.fname, want[i].changed, changed)
+		}
+		i++
+	}
+
+	if i != len(want) {
+		t.Fatalf("expected %d results but got %d", len(want), i)
+	}
+
+	_, err = findFiles([]string{filepath.Join(dir, "missing")})
+	if !os.IsNotExist(err) {
+		t.Fatalf("expected not exist error, got %v", err)
+	}
+}
+
+func TestFindStyle(t *testing.T) {
+	dir, cleanup := fixture.Tmpdir(t)
+	defer cleanup()
+
+	fixture.MkdirAll(t, filepath.Join(dir, "a", "b"))
+	fixture.MkdirAll(t, filepath.Join(dir, "c"))
+
+	err := ioutil.WriteFile(filepath.Join(dir, "a", ".nashfmt"), []byte(`
+# project style
+indent = 4
+linewidth = 80
+alignvars = false
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ioutil.WriteFile(filepath.Join(dir, "c", ".nashfmt"), []byte("indent = four\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	style, err := findStyle(filepath.Join(dir, "a", "b", "script.sh"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := ast.Style{Indent: 4, LineWidth: 80, AlignVars: false}
+	if style != expected {
+		t.Fatalf("expected style %+v but got %+v", expected, style)
+	}
+
+	style, err = findStyle(filepath.Join(dir, "script.sh"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if style != ast.DefaultStyle {
+		t.Fatalf("expected default style but got %+v", style)
+	}
+
+	_, err = findStyle(filepath.Join(dir, "c", "script.sh"))
+	if err == nil {
+		t.Fatal("expected error for invalid indent")
+	}
+}
+
+func TestReportDiff(t *testing.T) {
+	showDiff = true
+	defer func() { showDiff = false }()
+
+	var out bytes.Buffer
+	err := report(&out, result{
+		fname:     "dir/a.sh",
+		content:   "echo   hello\n",
+		formatted: "echo hello\n",
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := "--- a/dir/a.sh\n+++ b/dir/a.sh\n"
+	if !strings.HasPrefix(out.String(), want) {
+		t.Fatalf("expected diff starting with %q but got:\n%s", want, out.String())
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/madlambda/nash/ast"
+)
+
+// styleFile is the name of the file configuring the formatting style.
+// It applies to the scripts of its directory and subdirectories.
+//
+// Each line has the form "key = value" and lines starting with '#'
+// are comments. The keys are:
+//
+//	indent     "tab" or the number of spaces used to indent blocks
+//	linewidth  maximum width of lines, 0 disables the wrapping
+//	alignvars  "true" or "false", align consecutive assignments
+const styleFile = ".nashfmt"
+
+var styles = struct {
+	sync.Mutex
+	dirs map[string]ast.Style
+}{
+	dirs: make(map[string]ast.Style),
+}
+
+// findStyle returns the style of the script fname, read from the
+// nearest .nashfmt file found walking up from its directory.
+func findStyle(fname string) (ast.Style, error) {
+	dir, err := filepath.Abs(filepath.Dir(fname))
+	if err != nil {
+		return ast.Style{}, err
+	}
+
+	styles.Lock()
+	defer styles.Unlock()
+
+	return dirStyle(dir)
+}
+
+func dirStyle(dir string) (ast.Style, error) {
+	if style, ok := styles.dirs[dir]; ok {
+		return style, nil
+	}
+
+	var (
+		style = ast.DefaultStyle
+		err   error
+	)
+
+	path := filepath.Join(dir, styleFile)
+
+	if _, serr := os.Stat(path); serr == nil {
+		style, err = readStyle(path)
+	} else if parent := filepath.Dir(dir); parent != dir {
+		style, err = dirStyle(parent)
+	}
+
+	if err != nil {
+		return ast.Style{}, err
+	}
+
+	styles.dirs[dir] = style
+	return style, nil
+}
+
+func readStyle(path string) (ast.Style, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return ast.Style{}, err
+	}
+
+	defer file.Close()
+
+	style := ast.DefaultStyle
+	scanner := bufio.NewScanner(file)
+
+	for lineno := 1; scanner.Scan(); lineno++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) != 2 {
+			return ast.Style{}, fmt.Errorf("%s:%d: expected key = value", path, lineno)
+		}
+
+		key := strings.TrimSpace(parts[0])
+		value := strings.TrimSpace(parts[1])
+
+		err = setStyle(&style, key, value)
+		if err != nil {
+			return ast.Style{}, fmt.Errorf("%s:%d: %s", path, lineno, err)
+		}
+	}
+
+	return style, scanner.Err()
+}
+
+func setStyle(style *ast.Style, key, value string) error {
+	switch key {
+	case "indent":
+		if value == "tab" {
+			style.Indent = 0
+			return nil
+		}
+
+		n, err := strconv.Atoi(value)
+		if err != nil || n <= 0 {
+			return fmt.Errorf("invalid indent %q, expected \"tab\" or a number of spaces", value)
+		}
+		style.Indent = n
+	case "linewidth":
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			return fmt.Errorf("invalid linewidth %q", value)
+		}
+		style.LineWidth = n
+	case "alignvars":
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("invalid alignvars %q", value)
+		}
+		style.AlignVars = b
+	default:
+		return fmt.Errorf("unknown key %q", key)
+	}
+
+	return nil
+}
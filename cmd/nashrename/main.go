@@ -0,0 +1,174 @@
+// Command nashrename renames a function or variable of nash scripts.
+//
+// Usage:
+//
+//	nashrename -from old -to new [-at file:line] [-w] file.sh|dir...
+//
+// The directories are walked looking for *.sh files. The global
+// declarations of the name old (functions and variables), or the one
+// at the position given by -at (that can also be a function argument,
+// a for loop variable or a variable local to a function), are renamed
+// together with the $old uses, the old() calls, the bindfn statements
+// and the uses in the files importing the script declaring it. Other
+// declarations of old, shadowing the renamed one, and their uses are
+// left untouched, as are the names not declared in the given files,
+// like environment variables and functions of the standard library.
+//
+// The renaming is refused if the new name is already in scope where
+// the old name is used, or if it would change the meaning of existing
+// uses of the new name.
+//
+// By default the changes are printed as a diff, the -w flag writes them
+// to the files.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/madlambda/nash/internal/diff"
+)
+
+var (
+	from      string
+	to        string
+	at        string
+	overwrite bool
+	version   bool
+	// version is set at build time
+	VersionString = "No version provided"
+)
+
+func init() {
+	flag.StringVar(&from, "from", "", "name of the function or variable to rename")
+	flag.StringVar(&to, "to", "", "new name")
+	flag.StringVar(&at, "at", "", "position (file:line) of the declaration to rename, instead of the global one")
+	flag.BoolVar(&overwrite, "w", false, "write the changes to the files instead of printing a diff")
+	flag.BoolVar(&version, "version", false, "Show version")
+}
+
+func main() {
+	flag.Parse()
+
+	if version {
+		fmt.Printf("build tag: %s\n", VersionString)
+		return
+	}
+
+	if from == "" || to == "" || len(flag.Args()) <= 0 {
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+
+	err := run(os.Stdout, from, to, flag.Args())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %s\n", err.Error())
+		os.Exit(1)
+	}
+}
+
+func run(out io.Writer, from, to string, args []string) error {
+	if !validName(from) {
+		return fmt.Errorf("invalid name %q", from)
+	}
+
+	if !validName(to) {
+		return fmt.Errorf("invalid name %q", to)
+	}
+
+	if from == to {
+		return errors.New("the names are the same")
+	}
+
+	files, err := findFiles(args)
+	if err != nil {
+		return err
+	}
+
+	prog, err := loadProgram(files)
+	if err != nil {
+		return err
+	}
+
+	changed, err := prog.rename(from, to, at)
+	if err != nil {
+		return err
+	}
+
+	scripts := make([]*script, 0, len(changed))
+	for s := range changed {
+		scripts = append(scripts, s)
+	}
+
+	sort.Slice(scripts, func(i, j int) bool {
+		return scripts[i].fname < scripts[j].fname
+	})
+
+	for _, s := range scripts {
+		if overwrite {
+			info, err := os.Stat(s.fname)
+			if err != nil {
+				return err
+			}
+
+			err = ioutil.WriteFile(s.fname, []byte(changed[s]), info.Mode().Perm())
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		_, err := io.WriteString(out, diff.Unified(
+			"a/"+filepath.ToSlash(s.fname), "b/"+filepath.ToSlash(s.fname), s.content, changed[s]))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// findFiles returns the files named by args, walking directories
+// recursively for nash scripts. Hidden directories are skipped.
+func findFiles(args []string) ([]string, error) {
+	var files []string
+
+	for _, arg := range args {
+		info, err := os.Stat(arg)
+		if err != nil {
+			return nil, err
+		}
+
+		if !info.IsDir() {
+			files = append(files, arg)
+			continue
+		}
+
+		err = filepath.Walk(arg, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+
+			if info.IsDir() && path != arg && strings.HasPrefix(info.Name(), ".") {
+				return filepath.SkipDir
+			}
+
+			if !info.IsDir() && strings.HasSuffix(path, ".sh") {
+				files = append(files, path)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return files, nil
+}
@@ -0,0 +1,332 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/madlambda/nash/internal/testing/fixture"
+)
+
+func writeScripts(t *testing.T, dir string, scripts map[string]string) {
+	for name, content := range scripts {
+		path := filepath.Join(dir, name)
+		fixture.MkdirAll(t, filepath.Dir(path))
+
+		err := ioutil.WriteFile(path, []byte(content), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestRename(t *testing.T) {
+	dir, cleanup := fixture.Tmpdir(t)
+	defer cleanup()
+
+	writeScripts(t, dir, map[string]string{
+		"lib/greet.sh": `fn greet(name) {
+	var msg = "hello "+$name
+	echo $msg
+}
+
+fn shout(name) {
+	greet($name)
+}
+`,
+		"main.sh": `import lib/greet
+
+var greet_count = "0"
+bindfn greet hi
+greet("world")
+var f = $greet
+$f("again")
+echo greet
+`,
+		"other.sh": `fn greet() {
+	echo "not imported"
+}
+`,
+	})
+
+	overwrite = true
+	defer func() { overwrite = false }()
+
+	err := run(ioutil.Discard, "greet", "welcome", []string{dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]string{
+		"lib/greet.sh": `fn welcome(name) {
+	var msg = "hello "+$name
+	echo $msg
+}
+
+fn shout(name) {
+	welcome($name)
+}
+`,
+		"main.sh": `import lib/greet
+
+var greet_count = "0"
+bindfn welcome hi
+welcome("world")
+var f = $welcome
+$f("again")
+echo greet
+`,
+		"other.sh": `fn welcome() {
+	echo "not imported"
+}
+`,
+	}
+
+	for name, want := range expected {
+		got, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if string(got) != want {
+			t.Errorf("%s: expected:\n%s\ngot:\n%s", name, want, got)
+		}
+	}
+}
+
+func TestRenameScopes(t *testing.T) {
+	const script = `var x = "global"
+
+fn f(x) {
+	for x in $x {
+		echo $x
+	}
+}
+
+fn g() {
+	echo $x $PATH
+}
+
+fn h() {
+	var x = "shadow"
+	echo $x
+}
+`
+
+	for _, tc := range []struct {
+		name     string
+		at       string
+		expected string
+	}{
+		{
+			name: "Global",
+			expected: `var y = "global"
+
+fn f(x) {
+	for x in $x {
+		echo $x
+	}
+}
+
+fn g() {
+	echo $y $PATH
+}
+
+fn h() {
+	var x = "shadow"
+	echo $x
+}
+`,
+		},
+		{
+			name: "Argument",
+			at:   "a.sh:3",
+			expected: `var x = "global"
+
+fn f(y) {
+	for y in $y {
+		echo $y
+	}
+}
+
+fn g() {
+	echo $x $PATH
+}
+
+fn h() {
+	var x = "shadow"
+	echo $x
+}
+`,
+		},
+		{
+			name: "Local",
+			at:   "a.sh:14",
+			expected: `var x = "global"
+
+fn f(x) {
+	for x in $x {
+		echo $x
+	}
+}
+
+fn g() {
+	echo $x $PATH
+}
+
+fn h() {
+	var y = "shadow"
+	echo $y
+}
+`,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			dir, cleanup := fixture.Tmpdir(t)
+			defer cleanup()
+
+			writeScripts(t, dir, map[string]string{"a.sh": script})
+
+			overwrite = true
+			defer func() { overwrite = false }()
+
+			at = ""
+			if tc.at != "" {
+				at = filepath.Join(dir, tc.at)
+			}
+			defer func() { at = "" }()
+
+			err := run(ioutil.Discard, "x", "y", []string{dir})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := ioutil.ReadFile(filepath.Join(dir, "a.sh"))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if string(got) != tc.expected {
+				t.Fatalf("expected:\n%s\ngot:\n%s", tc.expected, got)
+			}
+		})
+	}
+}
+
+func TestRenameDiff(t *testing.T) {
+	dir, cleanup := fixture.Tmpdir(t)
+	defer cleanup()
+
+	writeScripts(t, dir, map[string]string{
+		"a.sh": "var x = \"global\"\necho $x\n",
+	})
+
+	var out bytes.Buffer
+
+	err := run(&out, "x", "y", []string{dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fname := filepath.Join(dir, "a.sh")
+	expected := "--- a/" + filepath.ToSlash(fname) + "\n+++ b/" + filepath.ToSlash(fname) + "\n" +
+		`@@ -1,2 +1,2 @@
+-var x = "global"
+-echo $x
++var y = "global"
++echo $y
+`
+	if out.String() != expected {
+		t.Fatalf("expected diff:\n%s\ngot:\n%s", expected, out.String())
+	}
+}
+
+func TestRenameConflicts(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		script  string
+		from    string
+		to      string
+		at      string
+		wantErr string
+	}{
+		{
+			name:    "SameScope",
+			script:  "var a = \"1\"\nvar b = \"2\"\n",
+			from:    "a",
+			to:      "b",
+			wantErr: "b is already declared",
+		},
+		{
+			name:    "ShadowedByLocal",
+			script:  "fn a() {}\nfn f(b) {\n\ta()\n}\n",
+			from:    "a",
+			to:      "b",
+			wantErr: "b is already declared",
+		},
+		{
+			name:    "CapturesUse",
+			script:  "fn f() {\n\tvar a = \"2\"\n\techo $b\n}\n",
+			from:    "a",
+			to:      "b",
+			at:      "a.sh:2",
+			wantErr: "b would refer to the renamed a",
+		},
+		{
+			name:    "CapturesStdlib",
+			script:  "fn a() {}\nprint(\"x\")\n",
+			from:    "a",
+			to:      "print",
+			wantErr: "print would refer to the renamed a",
+		},
+		{
+			name:    "NotDeclared",
+			script:  "echo $a\n",
+			from:    "a",
+			to:      "b",
+			wantErr: "a is not declared",
+		},
+		{
+			name:    "OnlyLocal",
+			script:  "fn f() {\n\tvar a = \"1\"\n}\n",
+			from:    "a",
+			to:      "b",
+			wantErr: "choose the declaration with -at",
+		},
+		{
+			name:    "NotDeclaredAt",
+			script:  "var a = \"1\"\n",
+			from:    "a",
+			to:      "b",
+			at:      "a.sh:2",
+			wantErr: "a is not declared at",
+		},
+		{
+			name:    "Keyword",
+			script:  "var a = \"1\"\n",
+			from:    "a",
+			to:      "for",
+			wantErr: "invalid name",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			dir, cleanup := fixture.Tmpdir(t)
+			defer cleanup()
+
+			writeScripts(t, dir, map[string]string{"a.sh": tc.script})
+
+			at = ""
+			if tc.at != "" {
+				at = filepath.Join(dir, tc.at)
+			}
+			defer func() { at = "" }()
+
+			err := run(ioutil.Discard, tc.from, tc.to, []string{dir})
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Fatalf("expected error %q but got %v", tc.wantErr, err)
+			}
+		})
+	}
+}
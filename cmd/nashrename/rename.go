@@ -0,0 +1,251 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/madlambda/nash/token"
+)
+
+// validName reports whether name is a valid identifier for functions
+// and variables.
+func validName(name string) bool {
+	if name == "" || token.Lookup(name) != token.Ident {
+		return false
+	}
+
+	for i, r := range name {
+		if r == '_' || unicode.IsLetter(r) || (i > 0 && unicode.IsDigit(r)) {
+			continue
+		}
+		return false
+	}
+
+	return true
+}
+
+// declarations returns the declarations of from chosen to be renamed:
+// the one at the position at, given as file:line, or the global ones
+// if at is empty.
+func (prog *program) declarations(from, at string) (map[*occurrence]bool, error) {
+	var (
+		decls    = make(map[*occurrence]bool)
+		declared bool
+		fname    string
+		line     int
+		err      error
+	)
+
+	if at != "" {
+		fname, line, err = parsePosition(at)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, s := range prog.scripts {
+		for _, occ := range s.occurs {
+			if occ.name != from || !occ.decl {
+				continue
+			}
+
+			declared = true
+
+			switch {
+			case at == "" && occ.scope == s.global:
+			case at != "" && occ.line == line && sameFile(s.fname, fname):
+			default:
+				continue
+			}
+
+			decls[occ.target()] = true
+		}
+	}
+
+	switch {
+	case len(decls) > 0:
+		return decls, nil
+	case !declared:
+		return nil, fmt.Errorf("%s is not declared in the given files", from)
+	case at != "":
+		return nil, fmt.Errorf("%s is not declared at %s", from, at)
+	}
+
+	return nil, fmt.Errorf("%s is only declared inside functions, choose the declaration with -at file:line", from)
+}
+
+// parsePosition parses a position given as file:line.
+func parsePosition(pos string) (string, int, error) {
+	i := strings.LastIndexByte(pos, ':')
+	if i <= 0 {
+		return "", 0, fmt.Errorf("invalid position %q, expected file:line", pos)
+	}
+
+	line, err := strconv.Atoi(pos[i+1:])
+	if err != nil || line <= 0 {
+		return "", 0, fmt.Errorf("invalid position %q, expected file:line", pos)
+	}
+
+	return pos[:i], line, nil
+}
+
+// sameFile tells if the paths name the same file.
+func sameFile(a, b string) bool {
+	absA, errA := filepath.Abs(a)
+	absB, errB := filepath.Abs(b)
+
+	return errA == nil && errB == nil && absA == absB
+}
+
+// rename renames the declarations of from chosen by at, and the
+// references to them, to the name to. It returns the new content of
+// the changed scripts. Other declarations of from, like the ones
+// shadowing it inside functions, and the references to names not
+// declared in the program, like environment variables or functions of
+// the standard library, are left untouched.
+func (prog *program) rename(from, to, at string) (map[*script]string, error) {
+	decls, err := prog.declarations(from, at)
+	if err != nil {
+		return nil, err
+	}
+
+	var renamed []*occurrence
+
+	for _, s := range prog.scripts {
+		for _, occ := range s.occurs {
+			if occ.name == from && decls[occ.target()] {
+				renamed = append(renamed, occ)
+			}
+		}
+	}
+
+	for _, occ := range renamed {
+		if decl := occ.scope.lookup(to); decl != nil {
+			return nil, fmt.Errorf("%s: cannot rename %s to %s: %s is already declared at %s",
+				occ, from, to, to, decl)
+		}
+	}
+
+	// the references to the name to must not be captured by the
+	// renamed declarations.
+	for _, s := range prog.scripts {
+		for _, occ := range s.occurs {
+			if occ.name != to || occ.decl {
+				continue
+			}
+
+			for _, sc := range occ.scope.chain() {
+				if _, ok := sc.decls[to]; ok {
+					break
+				}
+
+				if decl, ok := sc.decls[from]; ok && decls[decl] {
+					return nil, fmt.Errorf("%s: cannot rename %s to %s: %s would refer to the renamed %s declared at %s",
+						occ, from, to, to, from, decl)
+				}
+			}
+		}
+	}
+
+	edits := make(map[*script][]*occurrence)
+	for _, occ := range renamed {
+		edits[occ.script] = append(edits[occ.script], occ)
+	}
+
+	changed := make(map[*script]string)
+	for s, occs := range edits {
+		content, err := s.replace(occs, from, to)
+		if err != nil {
+			return nil, err
+		}
+		changed[s] = content
+	}
+
+	return changed, nil
+}
+
+// replace replaces the occurrences of from in the script content.
+func (s *script) replace(occs []*occurrence, from, to string) (string, error) {
+	offsets := make(map[int]bool)
+
+	for _, occ := range occs {
+		off, err := s.offset(occ)
+		if err != nil {
+			return "", err
+		}
+		offsets[off] = true
+	}
+
+	sorted := make([]int, 0, len(offsets))
+	for off := range offsets {
+		sorted = append(sorted, off)
+	}
+	sort.Ints(sorted)
+
+	var (
+		buf  strings.Builder
+		last int
+	)
+
+	for _, off := range sorted {
+		buf.WriteString(s.content[last:off])
+		buf.WriteString(to)
+		last = off + len(from)
+	}
+
+	buf.WriteString(s.content[last:])
+	return buf.String(), nil
+}
+
+// offset returns the byte offset of the identifier of occ in the
+// script content, checking that the name is there.
+func (s *script) offset(occ *occurrence) (int, error) {
+	off := 0
+
+	// lines start at 1
+	for line := 1; line < occ.line; line++ {
+		i := strings.IndexByte(s.content[off:], '\n')
+		if i < 0 {
+			return 0, fmt.Errorf("%s: line out of range", occ)
+		}
+		off += i + 1
+	}
+
+	// columns count runes
+	for col := 0; col < occ.column && off < len(s.content); col++ {
+		_, size := utf8.DecodeRuneInString(s.content[off:])
+		off += size
+	}
+
+	if occ.keyword != "" {
+		if !strings.HasPrefix(s.content[off:], occ.keyword) {
+			return 0, fmt.Errorf("%s: expected %s", occ, occ.keyword)
+		}
+
+		off += len(occ.keyword)
+		for off < len(s.content) && (s.content[off] == ' ' || s.content[off] == '\t') {
+			off++
+		}
+	}
+
+	rest := s.content[off:]
+	if !strings.HasPrefix(rest, occ.name) {
+		return 0, fmt.Errorf("%s: expected %s", occ, occ.name)
+	}
+
+	if r, _ := utf8.DecodeRuneInString(rest[len(occ.name):]); r == '_' ||
+		unicode.IsLetter(r) || unicode.IsDigit(r) {
+		return 0, fmt.Errorf("%s: expected %s", occ, occ.name)
+	}
+
+	return off, nil
+}
+
+func (occ *occurrence) String() string {
+	return fmt.Sprintf("%s:%d:%d", occ.script.fname, occ.line, occ.column)
+}
@@ -0,0 +1,299 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/madlambda/nash/ast"
+	"github.com/madlambda/nash/parser"
+)
+
+type (
+	// script is a parsed nash file.
+	script struct {
+		fname   string
+		content string
+		tree    *ast.Tree
+
+		global  *scope
+		imports []*script
+		occurs  []*occurrence
+	}
+
+	// scope is a lexical scope. The scripts have a global scope and
+	// each function body has its own scope. The blocks of if and for
+	// statements share the scope of the enclosing function.
+	scope struct {
+		parent *scope
+		script *script
+		decls  map[string]*occurrence
+	}
+
+	// occurrence is an identifier in the source code, declaring or
+	// referencing a name.
+	occurrence struct {
+		name   string
+		decl   bool
+		scope  *scope
+		script *script
+
+		line, column int
+
+		// keyword preceding the identifier, for nodes positioned at
+		// the keyword instead of at the identifier.
+		keyword string
+	}
+
+	// program is a set of scripts and their imports.
+	program struct {
+		scripts []*script
+		byPath  map[string]*script
+	}
+)
+
+func newScope(parent *scope, s *script) *scope {
+	return &scope{
+		parent: parent,
+		script: s,
+		decls:  make(map[string]*occurrence),
+	}
+}
+
+// loadProgram parses the files and resolves the names of each one.
+func loadProgram(files []string) (*program, error) {
+	prog := &program{
+		byPath: make(map[string]*script),
+	}
+
+	for _, fname := range files {
+		content, err := ioutil.ReadFile(fname)
+		if err != nil {
+			return nil, err
+		}
+
+		tree, err := parser.NewParser(fname, string(content)).Parse()
+		if err != nil {
+			return nil, err
+		}
+
+		s := &script{
+			fname:   fname,
+			content: string(content),
+			tree:    tree,
+		}
+
+		s.global = newScope(nil, s)
+
+		abs, err := filepath.Abs(fname)
+		if err != nil {
+			return nil, err
+		}
+
+		prog.scripts = append(prog.scripts, s)
+		prog.byPath[abs] = s
+	}
+
+	for _, s := range prog.scripts {
+		s.resolve(s.tree.Root, s.global, prog)
+	}
+
+	return prog, nil
+}
+
+// resolve records the occurrences of the names found traversing node,
+// whose declarations are added to the scope sc.
+func (s *script) resolve(node ast.Node, sc *scope, prog *program) {
+	ast.Inspect(node, func(node ast.Node) bool {
+		switch n := node.(type) {
+		case *ast.ImportNode:
+			if imported := prog.lookupImport(s, n); imported != nil {
+				s.imports = append(s.imports, imported)
+			}
+		case *ast.FnDeclNode:
+			s.declare(sc, n.Name(), n.Line(), n.Column(), "")
+
+			body := newScope(sc, s)
+			for _, arg := range n.Args() {
+				s.declare(body, arg.Name, arg.Line(), arg.Column(), "")
+			}
+
+			if n.Tree() != nil && n.Tree().Root != nil {
+				s.resolve(n.Tree().Root, body, prog)
+			}
+			return false
+		case *ast.VarAssignDeclNode:
+			for _, name := range n.Assign.Names {
+				s.declare(sc, name.Ident, name.Line(), name.Column(), "")
+			}
+
+			for _, value := range n.Assign.Values {
+				s.resolve(value, sc, prog)
+			}
+			return false
+		case *ast.VarExecAssignDeclNode:
+			for _, name := range n.ExecAssign.Names {
+				s.declare(sc, name.Ident, name.Line(), name.Column(), "")
+			}
+
+			s.resolve(n.ExecAssign.Command(), sc, prog)
+			return false
+		case *ast.SetenvNode:
+			if n.Assignment() == nil {
+				s.reference(sc, n.Name, n.Line(), n.Column(), "setenv")
+				return false
+			}
+
+			// setenv with assignment declares the variable
+			var names []*ast.NameNode
+			switch assign := n.Assignment().(type) {
+			case *ast.AssignNode:
+				names = assign.Names
+			case *ast.ExecAssignNode:
+				names = assign.Names
+			}
+
+			for _, name := range names {
+				s.declare(sc, name.Ident, name.Line(), name.Column(), "")
+
+				if name.Index != nil {
+					s.resolve(name.Index, sc, prog)
+				}
+			}
+
+			switch assign := n.Assignment().(type) {
+			case *ast.AssignNode:
+				for _, value := range assign.Values {
+					s.resolve(value, sc, prog)
+				}
+			case *ast.ExecAssignNode:
+				s.resolve(assign.Command(), sc, prog)
+			}
+			return false
+		case *ast.ForNode:
+			if n.InExpr() != nil {
+				s.declare(sc, n.Identifier(), n.Line(), n.Column(), "for")
+			}
+		case *ast.NameNode:
+			s.reference(sc, n.Ident, n.Line(), n.Column(), "")
+		case *ast.VarExpr:
+			// the position is of the '$'
+			s.reference(sc, strings.TrimPrefix(n.Name, "$"), n.Line(), n.Column()+1, "")
+		case *ast.FnInvNode:
+			s.reference(sc, n.Name(), n.Line(), n.Column(), "")
+		case *ast.BindFnNode:
+			s.reference(sc, n.Name(), n.Line(), n.Column(), "bindfn")
+		}
+		return true
+	})
+}
+
+func (s *script) declare(sc *scope, name string, line, column int, keyword string) {
+	occ := s.occurrence(sc, name, line, column, keyword)
+	occ.decl = true
+
+	if _, ok := sc.decls[name]; !ok {
+		sc.decls[name] = occ
+	}
+}
+
+func (s *script) reference(sc *scope, name string, line, column int, keyword string) {
+	s.occurrence(sc, name, line, column, keyword)
+}
+
+func (s *script) occurrence(sc *scope, name string, line, column int, keyword string) *occurrence {
+	occ := &occurrence{
+		name:    name,
+		scope:   sc,
+		script:  s,
+		line:    line,
+		column:  column,
+		keyword: keyword,
+	}
+
+	s.occurs = append(s.occurs, occ)
+	return occ
+}
+
+// lookupImport returns the script imported by node, if it is part of
+// the program. Only imports relative to the importing script are
+// resolved, libraries of NASHPATH are not renamed.
+func (prog *program) lookupImport(s *script, node *ast.ImportNode) *script {
+	if node.Path == nil {
+		return nil
+	}
+
+	fname := node.Path.Value()
+	if !filepath.IsAbs(fname) {
+		fname = filepath.Join(filepath.Dir(s.fname), fname)
+	}
+
+	tries := []string{fname}
+	if filepath.Ext(fname) == "" {
+		tries = append(tries, fname+".sh")
+	}
+
+	for _, try := range tries {
+		abs, err := filepath.Abs(try)
+		if err != nil {
+			continue
+		}
+
+		if imported, ok := prog.byPath[abs]; ok {
+			return imported
+		}
+	}
+
+	return nil
+}
+
+// chain returns the scopes visible from sc, from the innermost to the
+// outermost. The global scopes of the imported scripts come last.
+func (sc *scope) chain() []*scope {
+	var scopes []*scope
+
+	for cur := sc; cur != nil; cur = cur.parent {
+		scopes = append(scopes, cur)
+	}
+
+	seen := map[*script]bool{sc.script: true}
+	queue := sc.script.imports
+
+	for len(queue) > 0 {
+		s := queue[0]
+		queue = queue[1:]
+
+		if seen[s] {
+			continue
+		}
+
+		seen[s] = true
+		scopes = append(scopes, s.global)
+		queue = append(queue, s.imports...)
+	}
+
+	return scopes
+}
+
+// target returns the declaration occ refers to, or nil if the name is
+// not declared in the program. Redeclarations in the same scope refer
+// to the first declaration.
+func (occ *occurrence) target() *occurrence {
+	if occ.decl {
+		return occ.scope.decls[occ.name]
+	}
+
+	return occ.scope.lookup(occ.name)
+}
+
+// lookup returns the declaration of name visible from sc, or nil if
+// the name is not declared in the program.
+func (sc *scope) lookup(name string) *occurrence {
+	for _, cur := range sc.chain() {
+		if decl, ok := cur.decls[name]; ok {
+			return decl
+		}
+	}
+
+	return nil
+}
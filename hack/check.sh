@@ -2,15 +2,11 @@
 
 set -e
 
+# statement coverage of the nash scripts executed by the tests
+export NASH_COVERPROFILE=$(pwd)/coverage.lcov
+rm -f $NASH_COVERPROFILE
+
 go test -race -coverprofile=coverage.txt ./...
 
 echo "running stdlib and stdbin tests"
-tests=$(find ./stdlib ./stdbin -name "*_test.sh")
-
-for t in ${tests[*]}
-do
-    echo
-    echo "running test: "$t
-    ./cmd/nash/nash $t
-    echo "success"
-done
+./cmd/nash/nash test -coverprofile $NASH_COVERPROFILE ./stdlib ./stdbin
@@ -63,9 +63,15 @@ for os in $supported_os {
 		var nash_dst = $bindir+"/nash"
 		var nashfmt_src = "./cmd/nashfmt/nashfmt"
 		var nashfmt_dst = $bindir+"/nashfmt"
+		var nashrename_src = "./cmd/nashrename/nashrename"
+		var nashrename_dst = $bindir+"/nashrename"
+		var bash2nash_src = "./cmd/bash2nash/bash2nash"
+		var bash2nash_dst = $bindir+"/bash2nash"
 		var execfiles = (
 			($nash_src $nash_dst)
 			($nashfmt_src $nashfmt_dst)
+			($nashrename_src $nashrename_dst)
+			($bash2nash_src $bash2nash_dst)
 		)
 
 		var execfiles <= prepare_execs($execfiles, $os)
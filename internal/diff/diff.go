@@ -0,0 +1,214 @@
+// Package diff computes line based differences between texts and
+// formats them in the unified format.
+package diff
+
+import (
+	"fmt"
+	"strings"
+)
+
+// context is the number of unchanged lines shown around each change.
+const context = 3
+
+type (
+	opKind int
+
+	// op is a line of the edit script transforming a into b.
+	op struct {
+		kind opKind
+		a, b int // line indexes in a and b
+	}
+)
+
+const (
+	opEqual opKind = iota
+	opDelete
+	opInsert
+)
+
+// Unified returns the unified diff transforming the text a, named
+// aname, into the text b, named bname. An empty string is returned if
+// both texts are equal.
+func Unified(aname, bname, a, b string) string {
+	if a == b {
+		return ""
+	}
+
+	alines, blines := splitLines(a), splitLines(b)
+	ops := editScript(alines, blines)
+
+	var out strings.Builder
+
+	fmt.Fprintf(&out, "--- %s\n+++ %s\n", aname, bname)
+
+	for start := 0; start < len(ops); {
+		// find the next change
+		for start < len(ops) && ops[start].kind == opEqual {
+			start++
+		}
+		if start == len(ops) {
+			break
+		}
+
+		first := start - context
+		if first < 0 {
+			first = 0
+		}
+
+		// extends the hunk while changes are close enough
+		end := start
+		for i := start; i < len(ops); i++ {
+			if ops[i].kind == opEqual {
+				if i-end > 2*context {
+					break
+				}
+				continue
+			}
+			end = i
+		}
+
+		last := end + context + 1
+		if last > len(ops) {
+			last = len(ops)
+		}
+
+		writeHunk(&out, ops[first:last], alines, blines)
+		start = last
+	}
+
+	return out.String()
+}
+
+func writeHunk(out *strings.Builder, ops []op, a, b []string) {
+	var astart, acount, bstart, bcount int
+
+	astart, bstart = ops[0].a, ops[0].b
+
+	for _, o := range ops {
+		switch o.kind {
+		case opEqual:
+			acount++
+			bcount++
+		case opDelete:
+			acount++
+		case opInsert:
+			bcount++
+		}
+	}
+
+	fmt.Fprintf(out, "@@ -%s +%s @@\n",
+		hunkRange(astart, acount), hunkRange(bstart, bcount))
+
+	for _, o := range ops {
+		switch o.kind {
+		case opEqual:
+			writeLine(out, ' ', a[o.a])
+		case opDelete:
+			writeLine(out, '-', a[o.a])
+		case opInsert:
+			writeLine(out, '+', b[o.b])
+		}
+	}
+}
+
+func writeLine(out *strings.Builder, prefix byte, line string) {
+	out.WriteByte(prefix)
+	out.WriteString(line)
+
+	if !strings.HasSuffix(line, "\n") {
+		out.WriteString("\n\\ No newline at end of file\n")
+	}
+}
+
+// hunkRange formats the range of a hunk, with 1 based line numbers.
+// Empty ranges refer to the line before the change, as diff does.
+func hunkRange(start, count int) string {
+	switch count {
+	case 0:
+		return fmt.Sprintf("%d,0", start)
+	case 1:
+		return fmt.Sprintf("%d", start+1)
+	}
+	return fmt.Sprintf("%d,%d", start+1, count)
+}
+
+// splitLines splits text in lines, keeping the line terminators.
+func splitLines(text string) []string {
+	var lines []string
+
+	for text != "" {
+		i := strings.IndexByte(text, '\n')
+		if i < 0 {
+			lines = append(lines, text)
+			break
+		}
+		lines = append(lines, text[:i+1])
+		text = text[i+1:]
+	}
+
+	return lines
+}
+
+// editScript returns the shortest sequence of operations transforming
+// a into b, using the longest common subsequence of lines.
+func editScript(a, b []string) []op {
+	// skip the common prefix and suffix, usually most of the text
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix &&
+		a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	ma, mb := a[prefix:len(a)-suffix], b[prefix:len(b)-suffix]
+
+	// lcs[i][j] is the length of the LCS of ma[i:] and mb[j:]
+	lcs := make([][]int, len(ma)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(mb)+1)
+	}
+
+	for i := len(ma) - 1; i >= 0; i-- {
+		for j := len(mb) - 1; j >= 0; j-- {
+			if ma[i] == mb[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	var ops []op
+
+	for i := 0; i < prefix; i++ {
+		ops = append(ops, op{opEqual, i, i})
+	}
+
+	i, j := 0, 0
+	for i < len(ma) || j < len(mb) {
+		switch {
+		case i < len(ma) && j < len(mb) && ma[i] == mb[j]:
+			ops = append(ops, op{opEqual, prefix + i, prefix + j})
+			i++
+			j++
+		case j == len(mb) || (i < len(ma) && lcs[i+1][j] >= lcs[i][j+1]):
+			ops = append(ops, op{opDelete, prefix + i, prefix + j})
+			i++
+		default:
+			ops = append(ops, op{opInsert, prefix + i, prefix + j})
+			j++
+		}
+	}
+
+	for k := 0; k < suffix; k++ {
+		ops = append(ops, op{opEqual, prefix + len(ma) + k, prefix + len(mb) + k})
+	}
+
+	return ops
+}
@@ -0,0 +1,68 @@
+package diff_test
+
+import (
+	"testing"
+
+	"github.com/madlambda/nash/internal/diff"
+)
+
+func TestUnified(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		a, b string
+		want string
+	}{
+		{
+			name: "Equal",
+			a:    "a\nb\n",
+			b:    "a\nb\n",
+			want: "",
+		},
+		{
+			name: "Change",
+			a:    "a\nb\nc\n",
+			b:    "a\nB\nc\n",
+			want: "--- a\n+++ b\n@@ -1,3 +1,3 @@\n a\n-b\n+B\n c\n",
+		},
+		{
+			name: "Insert",
+			a:    "a\n",
+			b:    "a\nb\n",
+			want: "--- a\n+++ b\n@@ -1 +1,2 @@\n a\n+b\n",
+		},
+		{
+			name: "FromEmpty",
+			a:    "",
+			b:    "a\n",
+			want: "--- a\n+++ b\n@@ -0,0 +1 @@\n+a\n",
+		},
+		{
+			name: "NoNewlineAtEnd",
+			a:    "a\nb",
+			b:    "a\nb\n",
+			want: "--- a\n+++ b\n@@ -1,2 +1,2 @@\n a\n-b\n\\ No newline at end of file\n+b\n",
+		},
+		{
+			name: "TwoHunks",
+			a:    "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\n12\n",
+			b:    "one\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\ntwelve\n",
+			want: "--- a\n+++ b\n" +
+				"@@ -1,4 +1,4 @@\n-1\n+one\n 2\n 3\n 4\n" +
+				"@@ -9,4 +9,4 @@\n 9\n 10\n 11\n-12\n+twelve\n",
+		},
+		{
+			name: "MergedHunks",
+			a:    "1\n2\n3\n4\n5\n6\n7\n",
+			b:    "one\n2\n3\n4\n5\n6\nseven\n",
+			want: "--- a\n+++ b\n" +
+				"@@ -1,7 +1,7 @@\n-1\n+one\n 2\n 3\n 4\n 5\n 6\n-7\n+seven\n",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got := diff.Unified("a", "b", tc.a, tc.b)
+			if got != tc.want {
+				t.Fatalf("diff mismatch:\nwant:\n%s\ngot:\n%s", tc.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,246 @@
+// Package rpc implements the protocol between nash and the process
+// started by rfork to run the rfork block.
+//
+// Every message is a JSON object prefixed by its size, as a 4 bytes
+// big endian integer. nash sends a Request with the tree of each
+// statement of the block and the rfork process answers each one with
+// a Reply, until a Request with Quit set. A Reply with Returned set
+// ends the block, returning its values.
+package rpc
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os/exec"
+
+	"github.com/madlambda/nash/ast"
+	"github.com/madlambda/nash/sh"
+)
+
+// MaxMessageSize is the maximum size of a message.
+const MaxMessageSize = 64 << 20
+
+type (
+	// Request asks the rfork process to execute a tree or to quit.
+	Request struct {
+		Tree *ast.Tree `json:"tree,omitempty"`
+		Quit bool      `json:"quit,omitempty"`
+	}
+
+	// Reply is the result of executing the tree of a Request. Status
+	// is zero and Error is empty on success. Line and Column are the
+	// position of the statement that failed. Returned tells if the
+	// statement was a return, with the values returned.
+	Reply struct {
+		Status   int     `json:"status"`
+		Error    string  `json:"error,omitempty"`
+		Line     int     `json:"line,omitempty"`
+		Column   int     `json:"column,omitempty"`
+		Returned bool    `json:"returned,omitempty"`
+		Values   []Value `json:"values,omitempty"`
+	}
+
+	// Value is a sh.Obj sent in a message. Functions can not be sent.
+	Value struct {
+		Type string  `json:"type"` // string or list
+		Str  string  `json:"str,omitempty"`
+		List []Value `json:"list,omitempty"`
+	}
+
+	// Client sends the requests to the rfork process.
+	Client struct {
+		conn io.ReadWriter
+	}
+
+	exitStatus interface {
+		ExitStatus() int
+	}
+)
+
+// WriteMessage writes v as a message to w.
+func WriteMessage(w io.Writer, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	if len(data) > MaxMessageSize {
+		return fmt.Errorf("message too big: %d bytes", len(data))
+	}
+
+	msg := make([]byte, 4, 4+len(data))
+	binary.BigEndian.PutUint32(msg, uint32(len(data)))
+	msg = append(msg, data...)
+
+	_, err = w.Write(msg)
+	return err
+}
+
+// ReadMessage reads a message from r and decodes it into v. It
+// returns io.EOF if r has no more messages.
+func ReadMessage(r io.Reader, v interface{}) error {
+	var size [4]byte
+
+	if _, err := io.ReadFull(r, size[:]); err != nil {
+		return err
+	}
+
+	n := binary.BigEndian.Uint32(size[:])
+	if n > MaxMessageSize {
+		return fmt.Errorf("message too big: %d bytes", n)
+	}
+
+	data := make([]byte, n)
+	if _, err := io.ReadFull(r, data); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+
+	return json.Unmarshal(data, v)
+}
+
+// NewClient returns a client using the connection conn.
+func NewClient(conn io.ReadWriter) *Client {
+	return &Client{conn: conn}
+}
+
+// Exec sends the tree to be executed and returns the reply.
+func (c *Client) Exec(tree *ast.Tree) (Reply, error) {
+	var reply Reply
+
+	if err := WriteMessage(c.conn, Request{Tree: tree}); err != nil {
+		return reply, err
+	}
+
+	err := ReadMessage(c.conn, &reply)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+
+	return reply, err
+}
+
+// Quit asks the rfork process to stop serving.
+func (c *Client) Quit() error {
+	return WriteMessage(c.conn, Request{Quit: true})
+}
+
+// Serve reads the requests of conn, executing their trees with exec,
+// until a Request with Quit set or the end of conn. The exec function
+// returns the values of the tree and if it returned.
+func Serve(conn io.ReadWriter, exec func(*ast.Tree) ([]sh.Obj, bool, error)) error {
+	for {
+		var req Request
+
+		err := ReadMessage(conn, &req)
+		if err == io.EOF {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if req.Quit {
+			return nil
+		}
+
+		if req.Tree == nil || req.Tree.Root == nil {
+			return fmt.Errorf("request with no tree")
+		}
+
+		values, returned, err := exec(req.Tree)
+
+		reply := NewReply(req.Tree, err)
+		if err == nil && returned {
+			reply.Returned = true
+			reply.Values, err = NewValues(values)
+			if err != nil {
+				reply = NewReply(req.Tree, err)
+			}
+		}
+
+		if err := WriteMessage(conn, reply); err != nil {
+			return err
+		}
+	}
+}
+
+// NewReply returns the reply of executing the tree, that returned err.
+func NewReply(tree *ast.Tree, err error) Reply {
+	if err == nil {
+		return Reply{}
+	}
+
+	reply := Reply{
+		Status: 1,
+		Error:  err.Error(),
+	}
+
+	switch e := err.(type) {
+	case *exec.ExitError:
+		reply.Status = e.ExitCode()
+	case exitStatus:
+		reply.Status = e.ExitStatus()
+	}
+
+	// killed by a signal
+	if reply.Status <= 0 {
+		reply.Status = 1
+	}
+
+	if tree != nil && tree.Root != nil && len(tree.Root.Nodes) > 0 {
+		node := tree.Root.Nodes[0]
+		reply.Line, reply.Column = node.Line(), node.Column()
+	}
+
+	return reply
+}
+
+// NewValues converts the objects to values.
+func NewValues(objs []sh.Obj) ([]Value, error) {
+	values := make([]Value, len(objs))
+
+	for i, obj := range objs {
+		switch obj.Type() {
+		case sh.StringType:
+			values[i] = Value{Type: "string", Str: obj.String()}
+		case sh.ListType:
+			list, err := NewValues(obj.(*sh.ListObj).List())
+			if err != nil {
+				return nil, err
+			}
+			values[i] = Value{Type: "list", List: list}
+		default:
+			return nil, fmt.Errorf("values of type %s can not be sent: %s", obj.Type(), obj)
+		}
+	}
+
+	return values, nil
+}
+
+// Objs converts the values to objects.
+func Objs(values []Value) ([]sh.Obj, error) {
+	objs := make([]sh.Obj, len(values))
+
+	for i, value := range values {
+		switch value.Type {
+		case "string":
+			objs[i] = sh.NewStrObj(value.Str)
+		case "list":
+			list, err := Objs(value.List)
+			if err != nil {
+				return nil, err
+			}
+			objs[i] = sh.NewListObj(list)
+		default:
+			return nil, fmt.Errorf("invalid value type %q", value.Type)
+		}
+	}
+
+	return objs, nil
+}
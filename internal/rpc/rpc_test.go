@@ -0,0 +1,153 @@
+package rpc_test
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/madlambda/nash/ast"
+	"github.com/madlambda/nash/internal/rpc"
+	"github.com/madlambda/nash/parser"
+	"github.com/madlambda/nash/sh"
+)
+
+type exitError struct {
+	status int
+}
+
+func (e exitError) Error() string   { return "exit" }
+func (e exitError) ExitStatus() int { return e.status }
+
+func TestMessageSplitReads(t *testing.T) {
+	// bigger than any single read of a socket
+	big := strings.Repeat("a", 256<<10)
+	tree := parse(t, `echo "`+big+`"`)
+
+	var buf bytes.Buffer
+	if err := rpc.WriteMessage(&buf, rpc.Request{Tree: tree}); err != nil {
+		t.Fatal(err)
+	}
+
+	var req rpc.Request
+	if err := rpc.ReadMessage(iotest.HalfReader(&buf), &req); err != nil {
+		t.Fatal(err)
+	}
+
+	if req.Tree == nil || !req.Tree.Root.IsEqual(tree.Root) {
+		t.Fatalf("tree differs after decoding: %v", req.Tree)
+	}
+}
+
+func TestMessageTruncated(t *testing.T) {
+	var buf bytes.Buffer
+	if err := rpc.WriteMessage(&buf, rpc.Request{Quit: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	truncated := bytes.NewReader(buf.Bytes()[:buf.Len()-1])
+
+	var req rpc.Request
+	if err := rpc.ReadMessage(truncated, &req); err == nil {
+		t.Fatal("expected error reading truncated message")
+	}
+}
+
+func TestServe(t *testing.T) {
+	client, server := net.Pipe()
+
+	var executed []string
+	done := make(chan error)
+
+	go func() {
+		done <- rpc.Serve(server, func(tree *ast.Tree) ([]sh.Obj, bool, error) {
+			stmt := tree.Root.Nodes[0].String()
+			executed = append(executed, stmt)
+
+			switch stmt {
+			case "false":
+				return nil, false, exitError{status: 3}
+			case "fail":
+				return nil, false, errors.New("failed")
+			case `return "a", ("b" "c")`:
+				return []sh.Obj{
+					sh.NewStrObj("a"),
+					sh.NewListObj([]sh.Obj{sh.NewStrObj("b"), sh.NewStrObj("c")}),
+				}, true, nil
+			}
+			return nil, false, nil
+		})
+	}()
+
+	c := rpc.NewClient(client)
+
+	for _, tc := range []struct {
+		stmt   string
+		status int
+		err    string
+	}{
+		{stmt: "true"},
+		{stmt: "false", status: 3, err: "exit"},
+		{stmt: "fail", status: 1, err: "failed"},
+	} {
+		reply, err := c.Exec(parse(t, "\n  "+tc.stmt))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if reply.Status != tc.status || reply.Error != tc.err {
+			t.Fatalf("%s: expected status %d and error %q but got %+v",
+				tc.stmt, tc.status, tc.err, reply)
+		}
+
+		if tc.status != 0 && (reply.Line != 2 || reply.Column != 2) {
+			t.Fatalf("%s: expected position 2:2 but got %d:%d",
+				tc.stmt, reply.Line, reply.Column)
+		}
+	}
+
+	reply, err := c.Exec(parse(t, `fn f() {
+	return "a", ("b" "c")
+}`).Root.Nodes[0].(*ast.FnDeclNode).Tree())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reply.Returned {
+		t.Fatalf("expected values returned but got %+v", reply)
+	}
+
+	values, err := rpc.Objs(reply.Values)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(values) != 2 || values[0].String() != "a" ||
+		values[1].Type() != sh.ListType || values[1].String() != "b c" {
+		t.Fatalf("unexpected values returned: %v", values)
+	}
+
+	if err := c.Quit(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Join(executed, " ") != `true false fail return "a", ("b" "c")` {
+		t.Fatalf("unexpected statements executed: %v", executed)
+	}
+}
+
+func parse(t *testing.T, code string) *ast.Tree {
+	t.Helper()
+
+	tree, err := parser.NewParser("rpc test", code).Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tree
+}
@@ -33,5 +33,16 @@ func Constructors() map[string]Constructor {
 		"chdir":  func() Fn { return newChdir() },
 		"append": func() Fn { return newAppend() },
 		"exit":   func() Fn { return newExit() },
+
+		"mount":      func() Fn { return newMount() },
+		"umount":     func() Fn { return newUmount() },
+		"bind":       func() Fn { return newBind() },
+		"pivot_root": func() Fn { return newPivotRoot() },
+		"chroot":     func() Fn { return newChroot() },
+
+		"ifup":    func() Fn { return newIfup() },
+		"veth":    func() Fn { return newVeth() },
+		"ipaddr":  func() Fn { return newIPAddr() },
+		"iproute": func() Fn { return newIPRoute() },
 	}
 }
@@ -0,0 +1,222 @@
+package builtin
+
+import (
+	"io"
+
+	"github.com/madlambda/nash/errors"
+	"github.com/madlambda/nash/sh"
+)
+
+type (
+	mountFn struct {
+		source, target, fstype string
+		options                []string
+	}
+
+	umountFn struct {
+		target  string
+		options []string
+	}
+
+	bindFn struct {
+		source, target, mode string
+	}
+
+	pivotRootFn struct {
+		newroot, putold string
+	}
+
+	chrootFn struct {
+		dir string
+	}
+)
+
+// privateMounts tells if the process runs in a mount namespace of its
+// own, created by rfork, where the mount builtins can be used without
+// changing the mounts of the rest of the system.
+var privateMounts bool
+
+// EnableMounts allows the mount builtins on this process. It must be
+// called only by processes running in a new mount namespace.
+func EnableMounts() { privateMounts = true }
+
+// MountsEnabled tells if EnableMounts was called.
+func MountsEnabled() bool { return privateMounts }
+
+func checkMounts(name string) error {
+	if !privateMounts {
+		return errors.NewError("%s: not inside a new mount namespace, use it inside a rfork block with the m flag", name)
+	}
+	return nil
+}
+
+// stringArgs returns the args as strings, checking there are at least
+// min and at most max (if not negative) of them.
+func stringArgs(name string, args []sh.Obj, min, max int) ([]string, error) {
+	if len(args) < min || (max >= 0 && len(args) > max) {
+		return nil, errors.NewError("%s: wrong number of arguments: %d", name, len(args))
+	}
+
+	strs := make([]string, len(args))
+
+	for i, arg := range args {
+		if arg.Type() != sh.StringType {
+			return nil, errors.NewError("%s expects string arguments, but a %s was provided", name, arg.Type())
+		}
+		strs[i] = arg.String()
+	}
+
+	return strs, nil
+}
+
+func newMount() *mountFn {
+	return &mountFn{}
+}
+
+func (m *mountFn) ArgNames() []sh.FnArg {
+	return []sh.FnArg{
+		sh.NewFnArg("source", false),
+		sh.NewFnArg("target", false),
+		sh.NewFnArg("fstype", false),
+		sh.NewFnArg("options...", true),
+	}
+}
+
+func (m *mountFn) Run(in io.Reader, out io.Writer, err io.Writer) ([]sh.Obj, error) {
+	if err := checkMounts("mount"); err != nil {
+		return nil, err
+	}
+	return nil, mount(m.source, m.target, m.fstype, m.options)
+}
+
+func (m *mountFn) SetArgs(args []sh.Obj) error {
+	strs, err := stringArgs("mount", args, 3, -1)
+	if err != nil {
+		return err
+	}
+
+	m.source, m.target, m.fstype, m.options = strs[0], strs[1], strs[2], strs[3:]
+	return nil
+}
+
+func newUmount() *umountFn {
+	return &umountFn{}
+}
+
+func (u *umountFn) ArgNames() []sh.FnArg {
+	return []sh.FnArg{
+		sh.NewFnArg("target", false),
+		sh.NewFnArg("options...", true),
+	}
+}
+
+func (u *umountFn) Run(in io.Reader, out io.Writer, err io.Writer) ([]sh.Obj, error) {
+	if err := checkMounts("umount"); err != nil {
+		return nil, err
+	}
+	return nil, umount(u.target, u.options)
+}
+
+func (u *umountFn) SetArgs(args []sh.Obj) error {
+	strs, err := stringArgs("umount", args, 1, -1)
+	if err != nil {
+		return err
+	}
+
+	u.target, u.options = strs[0], strs[1:]
+	return nil
+}
+
+func newBind() *bindFn {
+	return &bindFn{}
+}
+
+func (b *bindFn) ArgNames() []sh.FnArg {
+	return []sh.FnArg{
+		sh.NewFnArg("source", false),
+		sh.NewFnArg("target", false),
+		sh.NewFnArg("mode...", true),
+	}
+}
+
+func (b *bindFn) Run(in io.Reader, out io.Writer, err io.Writer) ([]sh.Obj, error) {
+	if err := checkMounts("bind"); err != nil {
+		return nil, err
+	}
+	return nil, bind(b.source, b.target, b.mode)
+}
+
+func (b *bindFn) SetArgs(args []sh.Obj) error {
+	strs, err := stringArgs("bind", args, 2, 3)
+	if err != nil {
+		return err
+	}
+
+	b.source, b.target, b.mode = strs[0], strs[1], "replace"
+
+	if len(strs) == 3 {
+		b.mode = strs[2]
+	}
+
+	switch b.mode {
+	case "replace", "before", "after":
+		return nil
+	}
+
+	return errors.NewError("bind: invalid mode %q, expected replace, before or after", b.mode)
+}
+
+func newPivotRoot() *pivotRootFn {
+	return &pivotRootFn{}
+}
+
+func (p *pivotRootFn) ArgNames() []sh.FnArg {
+	return []sh.FnArg{
+		sh.NewFnArg("newroot", false),
+		sh.NewFnArg("putold", false),
+	}
+}
+
+func (p *pivotRootFn) Run(in io.Reader, out io.Writer, err io.Writer) ([]sh.Obj, error) {
+	if err := checkMounts("pivot_root"); err != nil {
+		return nil, err
+	}
+	return nil, pivotRoot(p.newroot, p.putold)
+}
+
+func (p *pivotRootFn) SetArgs(args []sh.Obj) error {
+	strs, err := stringArgs("pivot_root", args, 2, 2)
+	if err != nil {
+		return err
+	}
+
+	p.newroot, p.putold = strs[0], strs[1]
+	return nil
+}
+
+func newChroot() *chrootFn {
+	return &chrootFn{}
+}
+
+func (c *chrootFn) ArgNames() []sh.FnArg {
+	return []sh.FnArg{
+		sh.NewFnArg("dir", false),
+	}
+}
+
+func (c *chrootFn) Run(in io.Reader, out io.Writer, err io.Writer) ([]sh.Obj, error) {
+	if err := checkMounts("chroot"); err != nil {
+		return nil, err
+	}
+	return nil, chroot(c.dir)
+}
+
+func (c *chrootFn) SetArgs(args []sh.Obj) error {
+	strs, err := stringArgs("chroot", args, 1, 1)
+	if err != nil {
+		return err
+	}
+
+	c.dir = strs[0]
+	return nil
+}
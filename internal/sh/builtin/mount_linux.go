@@ -0,0 +1,150 @@
+// +build linux
+
+package builtin
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// mountFlags are the mount options that are flags, the others are
+// passed to the filesystem.
+var mountFlags = map[string]uintptr{
+	"ro":          syscall.MS_RDONLY,
+	"nosuid":      syscall.MS_NOSUID,
+	"nodev":       syscall.MS_NODEV,
+	"noexec":      syscall.MS_NOEXEC,
+	"sync":        syscall.MS_SYNCHRONOUS,
+	"dirsync":     syscall.MS_DIRSYNC,
+	"remount":     syscall.MS_REMOUNT,
+	"bind":        syscall.MS_BIND,
+	"rbind":       syscall.MS_BIND | syscall.MS_REC,
+	"move":        syscall.MS_MOVE,
+	"rec":         syscall.MS_REC,
+	"noatime":     syscall.MS_NOATIME,
+	"nodiratime":  syscall.MS_NODIRATIME,
+	"relatime":    syscall.MS_RELATIME,
+	"strictatime": syscall.MS_STRICTATIME,
+	"private":     syscall.MS_PRIVATE,
+	"rprivate":    syscall.MS_PRIVATE | syscall.MS_REC,
+	"slave":       syscall.MS_SLAVE,
+	"rslave":      syscall.MS_SLAVE | syscall.MS_REC,
+	"shared":      syscall.MS_SHARED,
+	"rshared":     syscall.MS_SHARED | syscall.MS_REC,
+	"unbindable":  syscall.MS_UNBINDABLE,
+	"runbindable": syscall.MS_UNBINDABLE | syscall.MS_REC,
+}
+
+var umountFlags = map[string]int{
+	"force":    syscall.MNT_FORCE,
+	"detach":   syscall.MNT_DETACH,
+	"expire":   syscall.MNT_EXPIRE,
+	"nofollow": 0x8, // UMOUNT_NOFOLLOW
+}
+
+func mount(source, target, fstype string, options []string) error {
+	var (
+		flags uintptr
+		data  []string
+	)
+
+	for _, opt := range options {
+		for _, o := range strings.Split(opt, ",") {
+			if flag, ok := mountFlags[o]; ok {
+				flags |= flag
+			} else if o != "" {
+				data = append(data, o)
+			}
+		}
+	}
+
+	err := syscall.Mount(source, target, fstype, flags, strings.Join(data, ","))
+	if err != nil {
+		return mountError("mount", target, err)
+	}
+	return nil
+}
+
+func umount(target string, options []string) error {
+	var flags int
+
+	for _, opt := range options {
+		flag, ok := umountFlags[opt]
+		if !ok {
+			return fmt.Errorf("builtin: umount: invalid option %q", opt)
+		}
+		flags |= flag
+	}
+
+	if err := syscall.Unmount(target, flags); err != nil {
+		return mountError("umount", target, err)
+	}
+	return nil
+}
+
+// bind makes source visible at target. Replacing target is a bind
+// mount, while mounting before or after it is an overlay of both, with
+// the files of the first hiding the ones with the same name of the
+// other. Overlays are read only.
+func bind(source, target, mode string) error {
+	if mode == "replace" {
+		err := syscall.Mount(source, target, "", syscall.MS_BIND|syscall.MS_REC, "")
+		if err != nil {
+			return mountError("bind", target, err)
+		}
+		return nil
+	}
+
+	source, err := filepath.Abs(source)
+	if err != nil {
+		return err
+	}
+
+	target, err = filepath.Abs(target)
+	if err != nil {
+		return err
+	}
+
+	// the lower dirs are looked up from the first
+	dirs := []string{overlayPath(source), overlayPath(target)}
+	if mode == "after" {
+		dirs[0], dirs[1] = dirs[1], dirs[0]
+	}
+
+	err = syscall.Mount("overlay", target, "overlay", 0, "lowerdir="+strings.Join(dirs, ":"))
+	if err != nil {
+		return mountError("bind", target, err)
+	}
+	return nil
+}
+
+// overlayPath escapes the characters that separate the options and
+// the lower dirs of overlayfs.
+func overlayPath(path string) string {
+	return strings.NewReplacer(`\`, `\\`, ":", `\:`, ",", `\,`).Replace(path)
+}
+
+func pivotRoot(newroot, putold string) error {
+	if err := syscall.PivotRoot(newroot, putold); err != nil {
+		return mountError("pivot_root", newroot, err)
+	}
+	return os.Chdir("/")
+}
+
+func chroot(dir string) error {
+	if err := syscall.Chroot(dir); err != nil {
+		return mountError("chroot", dir, err)
+	}
+	return os.Chdir("/")
+}
+
+func mountError(name, path string, err error) error {
+	if err == syscall.EPERM {
+		return fmt.Errorf("builtin: %s: error[%s] path[%s]: unprivileged users need the mount namespace created with a user namespace (rfork um)",
+			name, err, path)
+	}
+	return fmt.Errorf("builtin: %s: error[%s] path[%s]", name, err, path)
+}
@@ -0,0 +1,91 @@
+// +build linux
+
+package builtin_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestMountInsideNamespace(t *testing.T) {
+	skipWithoutUserNS(t)
+
+	dir, teardown := setup(t)
+	defer teardown()
+
+	for _, name := range []string{"tmp", "new", "old"} {
+		if err := os.Mkdir(filepath.Join(dir, name), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	writeFile(t, filepath.Join(dir, "new", "shared"), "new")
+	writeFile(t, filepath.Join(dir, "new", "onlynew"), "onlynew")
+	writeFile(t, filepath.Join(dir, "old", "shared"), "old")
+
+	out := execSuccess(t, fmt.Sprintf(`
+		var dir = "%s"
+		setenv dir
+
+		var files <= rfork um {
+			mount("tmpfs", $dir+"/tmp", "tmpfs", "size=1m", "nosuid,nodev")
+			echo -n tmpfile > $dir+"/tmp/file"
+
+			bind($dir+"/new", $dir+"/old", "before")
+			var before <= cat $dir+"/old/shared"
+			var onlynew <= cat $dir+"/old/onlynew"
+			umount($dir+"/old")
+
+			bind($dir+"/new", $dir+"/old", "after")
+			var after <= cat $dir+"/old/shared"
+			umount($dir+"/old")
+
+			bind($dir+"/tmp", $dir+"/old")
+
+			# rfork blocks without m are still in a new mount namespace
+			rfork s {
+				umount($dir+"/old", "detach")
+			}
+
+			chroot($dir+"/tmp")
+			var root, err <= glob("/*")
+
+			return ($before $onlynew $after $root)
+		}
+		print("%%s", $files)
+	`, dir))
+
+	if out != "new onlynew old /file" {
+		t.Fatalf("unexpected output: %q", out)
+	}
+
+	// the mounts do not leak out of the namespace
+	if _, err := os.Stat(filepath.Join(dir, "tmp", "file")); !os.IsNotExist(err) {
+		t.Fatalf("expected no tmp/file outside of the namespace, got: %v", err)
+	}
+}
+
+func skipWithoutUserNS(t *testing.T) {
+	cmd := exec.Command("true")
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Cloneflags: syscall.CLONE_NEWUSER | syscall.CLONE_NEWNS,
+		UidMappings: []syscall.SysProcIDMap{
+			{ContainerID: 0, HostID: os.Getuid(), Size: 1},
+		},
+	}
+
+	if err := cmd.Run(); err != nil {
+		t.Skipf("user namespaces not available: %s", err)
+	}
+}
+
+func writeFile(t *testing.T, path, content string) {
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
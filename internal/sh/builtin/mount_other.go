@@ -0,0 +1,25 @@
+// +build !linux
+
+package builtin
+
+import "github.com/madlambda/nash/errors"
+
+func mount(source, target, fstype string, options []string) error {
+	return errors.NewError("mount only supported on Linux")
+}
+
+func umount(target string, options []string) error {
+	return errors.NewError("umount only supported on Linux")
+}
+
+func bind(source, target, mode string) error {
+	return errors.NewError("bind only supported on Linux")
+}
+
+func pivotRoot(newroot, putold string) error {
+	return errors.NewError("pivot_root only supported on Linux")
+}
+
+func chroot(dir string) error {
+	return errors.NewError("chroot only supported on Linux")
+}
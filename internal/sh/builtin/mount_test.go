@@ -0,0 +1,38 @@
+package builtin_test
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestMountOutsideNamespace(t *testing.T) {
+	for _, call := range []string{
+		`mount("tmpfs", "/tmp", "tmpfs")`,
+		`umount("/tmp")`,
+		`bind("/tmp", "/mnt")`,
+		`pivot_root("/tmp", "/tmp/old")`,
+		`chroot("/tmp")`,
+	} {
+		t.Run(call, func(t *testing.T) {
+			execFailure(t, call)
+		})
+	}
+}
+
+func TestMountWrongArgs(t *testing.T) {
+	for _, call := range []string{
+		`mount("tmpfs", "/tmp")`,
+		`umount()`,
+		`bind("/tmp")`,
+		`bind("/tmp", "/mnt", "under")`,
+		`pivot_root("/tmp")`,
+		`chroot("/tmp", "/mnt")`,
+		`chroot(("/tmp"))`,
+	} {
+		t.Run(call, func(t *testing.T) {
+			execFailure(t, fmt.Sprintf(`rfork um {
+	%s
+}`, call))
+		})
+	}
+}
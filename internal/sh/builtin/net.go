@@ -0,0 +1,179 @@
+package builtin
+
+import (
+	"io"
+
+	"github.com/madlambda/nash/errors"
+	"github.com/madlambda/nash/sh"
+)
+
+type (
+	ifupFn struct {
+		name string
+	}
+
+	vethFn struct {
+		name, peer, peeraddr string
+	}
+
+	ipaddrFn struct {
+		name, addr string
+	}
+
+	iprouteFn struct {
+		dst, gateway, name string
+	}
+)
+
+// privateNetwork tells if the process runs in a network namespace of
+// its own, created by rfork, where the network builtins can be used
+// without changing the network of the rest of the system.
+var privateNetwork bool
+
+// parentNetlink is a netlink socket on the network namespace of the
+// parent shell, or -1 if the process runs on the namespace of it.
+var parentNetlink = -1
+
+// EnableNetwork allows the network builtins on this process. It must
+// be called only by processes running in a new network namespace.
+func EnableNetwork() { privateNetwork = true }
+
+// NetworkEnabled tells if EnableNetwork was called.
+func NetworkEnabled() bool { return privateNetwork }
+
+// SetParentNetlink sets the file descriptor of a netlink route socket
+// opened by the parent shell on its network namespace, where veth
+// creates the peer interfaces.
+func SetParentNetlink(fd int) { parentNetlink = fd }
+
+func checkNetwork(name string) error {
+	if !privateNetwork {
+		return errors.NewError("%s: not inside a new network namespace, use it inside a rfork block with the n flag", name)
+	}
+	return nil
+}
+
+func newIfup() *ifupFn {
+	return &ifupFn{}
+}
+
+func (i *ifupFn) ArgNames() []sh.FnArg {
+	return []sh.FnArg{
+		sh.NewFnArg("name", false),
+	}
+}
+
+func (i *ifupFn) Run(in io.Reader, out io.Writer, err io.Writer) ([]sh.Obj, error) {
+	if err := checkNetwork("ifup"); err != nil {
+		return nil, err
+	}
+	return nil, ifup(i.name)
+}
+
+func (i *ifupFn) SetArgs(args []sh.Obj) error {
+	strs, err := stringArgs("ifup", args, 1, 1)
+	if err != nil {
+		return err
+	}
+
+	i.name = strs[0]
+	return nil
+}
+
+func newVeth() *vethFn {
+	return &vethFn{}
+}
+
+func (v *vethFn) ArgNames() []sh.FnArg {
+	return []sh.FnArg{
+		sh.NewFnArg("name", false),
+		sh.NewFnArg("peer", false),
+		sh.NewFnArg("peeraddr...", true),
+	}
+}
+
+func (v *vethFn) Run(in io.Reader, out io.Writer, err io.Writer) ([]sh.Obj, error) {
+	if err := checkNetwork("veth"); err != nil {
+		return nil, err
+	}
+	return nil, veth(v.name, v.peer, v.peeraddr)
+}
+
+func (v *vethFn) SetArgs(args []sh.Obj) error {
+	strs, err := stringArgs("veth", args, 2, 3)
+	if err != nil {
+		return err
+	}
+
+	v.name, v.peer, v.peeraddr = strs[0], strs[1], ""
+
+	if len(strs) == 3 {
+		v.peeraddr = strs[2]
+	}
+	return nil
+}
+
+func newIPAddr() *ipaddrFn {
+	return &ipaddrFn{}
+}
+
+func (i *ipaddrFn) ArgNames() []sh.FnArg {
+	return []sh.FnArg{
+		sh.NewFnArg("name", false),
+		sh.NewFnArg("addr", false),
+	}
+}
+
+func (i *ipaddrFn) Run(in io.Reader, out io.Writer, err io.Writer) ([]sh.Obj, error) {
+	if err := checkNetwork("ipaddr"); err != nil {
+		return nil, err
+	}
+	return nil, ipaddr(i.name, i.addr)
+}
+
+func (i *ipaddrFn) SetArgs(args []sh.Obj) error {
+	strs, err := stringArgs("ipaddr", args, 2, 2)
+	if err != nil {
+		return err
+	}
+
+	i.name, i.addr = strs[0], strs[1]
+	return nil
+}
+
+func newIPRoute() *iprouteFn {
+	return &iprouteFn{}
+}
+
+func (i *iprouteFn) ArgNames() []sh.FnArg {
+	return []sh.FnArg{
+		sh.NewFnArg("dst", false),
+		sh.NewFnArg("gateway", false),
+		sh.NewFnArg("name...", true),
+	}
+}
+
+func (i *iprouteFn) Run(in io.Reader, out io.Writer, err io.Writer) ([]sh.Obj, error) {
+	if err := checkNetwork("iproute"); err != nil {
+		return nil, err
+	}
+	return nil, iproute(i.dst, i.gateway, i.name)
+}
+
+func (i *iprouteFn) SetArgs(args []sh.Obj) error {
+	strs, err := stringArgs("iproute", args, 2, 3)
+	if err != nil {
+		return err
+	}
+
+	i.dst, i.gateway, i.name = strs[0], strs[1], ""
+
+	if len(strs) == 3 {
+		i.name = strs[2]
+	}
+
+	if i.gateway == "" && i.name == "" {
+		return errors.NewError("iproute: requires a gateway or an interface")
+	}
+	return nil
+}
@@ -0,0 +1,416 @@
+// +build linux
+
+package builtin
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// The attributes of the links missing in syscall.
+const (
+	iflaInfoKind = 1
+	iflaInfoData = 2
+	iflaNetNsFd  = 28
+	vethInfoPeer = 1
+)
+
+// nativeEndian is the byte order of the netlink messages.
+var nativeEndian binary.ByteOrder = func() binary.ByteOrder {
+	x := uint16(1)
+	if *(*byte)(unsafe.Pointer(&x)) == 1 {
+		return binary.LittleEndian
+	}
+	return binary.BigEndian
+}()
+
+// netlink is a rtnetlink socket, configuring the network namespace
+// where it was opened.
+type netlink struct {
+	fd  int
+	seq uint32
+}
+
+func dialNetlink() (*netlink, error) {
+	fd, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_RAW|syscall.SOCK_CLOEXEC, syscall.NETLINK_ROUTE)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open netlink socket: %s", err)
+	}
+	return &netlink{fd: fd}, nil
+}
+
+func (nl *netlink) Close() error {
+	return syscall.Close(nl.fd)
+}
+
+// request sends a message of type typ with the data and waits for its
+// acknowledgment, returning the data of the last reply before it.
+func (nl *netlink) request(typ, flags uint16, data []byte) ([]byte, error) {
+	nl.seq++
+
+	msg := make([]byte, syscall.NLMSG_HDRLEN+len(data))
+	nativeEndian.PutUint32(msg[0:], uint32(len(msg)))
+	nativeEndian.PutUint16(msg[4:], typ)
+	nativeEndian.PutUint16(msg[6:], flags|syscall.NLM_F_REQUEST|syscall.NLM_F_ACK)
+	nativeEndian.PutUint32(msg[8:], nl.seq)
+	copy(msg[syscall.NLMSG_HDRLEN:], data)
+
+	err := syscall.Sendto(nl.fd, msg, 0, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK})
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		reply []byte
+		buf   = make([]byte, 1<<16)
+	)
+
+	for {
+		n, _, err := syscall.Recvfrom(nl.fd, buf, 0)
+		if err != nil {
+			return nil, err
+		}
+
+		msgs, err := syscall.ParseNetlinkMessage(buf[:n])
+		if err != nil {
+			return nil, err
+		}
+
+		for _, m := range msgs {
+			if m.Header.Seq != nl.seq {
+				continue
+			}
+
+			if m.Header.Type != syscall.NLMSG_ERROR {
+				reply = append([]byte{}, m.Data...)
+				continue
+			}
+
+			if len(m.Data) < 4 {
+				return nil, fmt.Errorf("invalid netlink error message")
+			}
+
+			if errno := int32(nativeEndian.Uint32(m.Data)); errno != 0 {
+				return nil, syscall.Errno(-errno)
+			}
+			return reply, nil
+		}
+	}
+}
+
+// linkIndex returns the index of the interface name.
+func (nl *netlink) linkIndex(name string) (int32, error) {
+	data := ifInfomsg(0, 0, 0)
+	data = append(data, rtAttr(syscall.IFLA_IFNAME, cString(name))...)
+
+	reply, err := nl.request(syscall.RTM_GETLINK, 0, data)
+	if err != nil {
+		return 0, err
+	}
+
+	if len(reply) < syscall.SizeofIfInfomsg {
+		return 0, fmt.Errorf("invalid netlink link message")
+	}
+
+	return int32(nativeEndian.Uint32(reply[4:])), nil
+}
+
+func (nl *netlink) setLinkUp(index int32) error {
+	_, err := nl.request(syscall.RTM_NEWLINK, 0, ifInfomsg(index, syscall.IFF_UP, syscall.IFF_UP))
+	return err
+}
+
+func (nl *netlink) addAddr(index int32, ip net.IP, prefix int) error {
+	family, ip := ipFamily(ip)
+
+	data := ifAddrmsg(family, uint8(prefix), index)
+	if family == syscall.AF_INET {
+		data = append(data, rtAttr(syscall.IFA_LOCAL, ip)...)
+	}
+	data = append(data, rtAttr(syscall.IFA_ADDRESS, ip)...)
+
+	_, err := nl.request(syscall.RTM_NEWADDR, syscall.NLM_F_CREATE|syscall.NLM_F_EXCL, data)
+	return err
+}
+
+// addVeth creates the veth pair name and peer, with peer on the network
+// namespace nsfd, or on the same of name if nsfd is negative.
+func (nl *netlink) addVeth(name, peer string, nsfd int) error {
+	peerInfo := ifInfomsg(0, 0, 0)
+	peerInfo = append(peerInfo, rtAttr(syscall.IFLA_IFNAME, cString(peer))...)
+	if nsfd >= 0 {
+		peerInfo = append(peerInfo, rtAttr(iflaNetNsFd, uint32Bytes(uint32(nsfd)))...)
+	}
+
+	linkInfo := rtAttr(iflaInfoKind, []byte("veth"))
+	linkInfo = append(linkInfo, rtAttr(iflaInfoData, rtAttr(vethInfoPeer, peerInfo))...)
+
+	data := ifInfomsg(0, 0, 0)
+	data = append(data, rtAttr(syscall.IFLA_IFNAME, cString(name))...)
+	data = append(data, rtAttr(syscall.IFLA_LINKINFO, linkInfo)...)
+
+	_, err := nl.request(syscall.RTM_NEWLINK, syscall.NLM_F_CREATE|syscall.NLM_F_EXCL, data)
+	return err
+}
+
+// addRoute adds a route to dst, or the default one if dst is nil,
+// through the gateway and/or the interface index, if not zero.
+func (nl *netlink) addRoute(dst *net.IPNet, gateway net.IP, index int32) error {
+	var (
+		family uint8
+		dstIP  []byte
+		dstLen int
+	)
+
+	if dst != nil {
+		family, dstIP = ipFamily(dst.IP)
+		dstLen, _ = dst.Mask.Size()
+	}
+
+	scope := uint8(syscall.RT_SCOPE_UNIVERSE)
+	if gateway == nil {
+		scope = syscall.RT_SCOPE_LINK
+	} else {
+		var gwFamily uint8
+
+		gwFamily, gateway = ipFamily(gateway)
+		if dst != nil && gwFamily != family {
+			return fmt.Errorf("gateway %s of another address family", gateway)
+		}
+		family = gwFamily
+	}
+
+	if family == 0 {
+		family = syscall.AF_INET
+	}
+
+	data := rtMsg(family, uint8(dstLen), scope)
+	if dstLen > 0 {
+		data = append(data, rtAttr(syscall.RTA_DST, dstIP)...)
+	}
+	if gateway != nil {
+		data = append(data, rtAttr(syscall.RTA_GATEWAY, gateway)...)
+	}
+	if index != 0 {
+		data = append(data, rtAttr(syscall.RTA_OIF, uint32Bytes(uint32(index)))...)
+	}
+
+	_, err := nl.request(syscall.RTM_NEWROUTE, syscall.NLM_F_CREATE|syscall.NLM_F_EXCL, data)
+	return err
+}
+
+func ifInfomsg(index int32, flags, change uint32) []byte {
+	b := make([]byte, syscall.SizeofIfInfomsg)
+	b[0] = syscall.AF_UNSPEC
+	nativeEndian.PutUint32(b[4:], uint32(index))
+	nativeEndian.PutUint32(b[8:], flags)
+	nativeEndian.PutUint32(b[12:], change)
+	return b
+}
+
+func ifAddrmsg(family, prefix uint8, index int32) []byte {
+	b := make([]byte, syscall.SizeofIfAddrmsg)
+	b[0] = family
+	b[1] = prefix
+	b[3] = syscall.RT_SCOPE_UNIVERSE
+	nativeEndian.PutUint32(b[4:], uint32(index))
+	return b
+}
+
+func rtMsg(family, dstLen, scope uint8) []byte {
+	b := make([]byte, syscall.SizeofRtMsg)
+	b[0] = family
+	b[1] = dstLen
+	b[4] = syscall.RT_TABLE_MAIN
+	b[5] = syscall.RTPROT_BOOT
+	b[6] = scope
+	b[7] = syscall.RTN_UNICAST
+	return b
+}
+
+// rtAttr returns the attribute typ with the value, padded to 4 bytes.
+func rtAttr(typ uint16, value []byte) []byte {
+	length := syscall.SizeofRtAttr + len(value)
+
+	b := make([]byte, (length+syscall.RTA_ALIGNTO-1) & ^(syscall.RTA_ALIGNTO-1))
+	nativeEndian.PutUint16(b[0:], uint16(length))
+	nativeEndian.PutUint16(b[2:], typ)
+	copy(b[syscall.SizeofRtAttr:], value)
+	return b
+}
+
+func cString(s string) []byte {
+	return append([]byte(s), 0)
+}
+
+func uint32Bytes(n uint32) []byte {
+	b := make([]byte, 4)
+	nativeEndian.PutUint32(b, n)
+	return b
+}
+
+func ipFamily(ip net.IP) (uint8, net.IP) {
+	if ip4 := ip.To4(); ip4 != nil {
+		return syscall.AF_INET, ip4
+	}
+	return syscall.AF_INET6, ip
+}
+
+func ifup(name string) error {
+	nl, err := dialNetlink()
+	if err != nil {
+		return err
+	}
+	defer nl.Close()
+
+	index, err := nl.linkIndex(name)
+	if err == nil {
+		err = nl.setLinkUp(index)
+	}
+	if err != nil {
+		return netError("ifup", name, err)
+	}
+	return nil
+}
+
+// veth creates a veth pair, with name on the network namespace of the
+// process and peer on the one of the parent shell, if there is one. The
+// peer is brought up, with the address peeraddr if not empty.
+func veth(name, peer, peeraddr string) error {
+	var (
+		ip     net.IP
+		prefix int
+	)
+
+	if peeraddr != "" {
+		addr, ipnet, err := net.ParseCIDR(peeraddr)
+		if err != nil {
+			return fmt.Errorf("builtin: veth: invalid address %q, expected ip/prefix", peeraddr)
+		}
+		ip = addr
+		prefix, _ = ipnet.Mask.Size()
+	}
+
+	var (
+		nl  *netlink
+		err error
+	)
+
+	if parentNetlink >= 0 {
+		// the pair is created from the parent namespace, moving name
+		// to ours, so the peer can be configured there
+		ns, err := os.Open("/proc/self/ns/net")
+		if err != nil {
+			return err
+		}
+		defer ns.Close()
+
+		nl = &netlink{fd: parentNetlink}
+		err = nl.addVeth(peer, name, int(ns.Fd()))
+		if err != nil {
+			return netError("veth", peer, err)
+		}
+	} else {
+		nl, err = dialNetlink()
+		if err != nil {
+			return err
+		}
+		defer nl.Close()
+
+		if err := nl.addVeth(name, peer, -1); err != nil {
+			return netError("veth", name, err)
+		}
+	}
+
+	index, err := nl.linkIndex(peer)
+	if err == nil && ip != nil {
+		err = nl.addAddr(index, ip, prefix)
+	}
+	if err == nil {
+		err = nl.setLinkUp(index)
+	}
+	if err != nil {
+		return netError("veth", peer, err)
+	}
+	return nil
+}
+
+func ipaddr(name, addr string) error {
+	ip, ipnet, err := net.ParseCIDR(addr)
+	if err != nil {
+		return fmt.Errorf("builtin: ipaddr: invalid address %q, expected ip/prefix", addr)
+	}
+
+	prefix, _ := ipnet.Mask.Size()
+
+	nl, err := dialNetlink()
+	if err != nil {
+		return err
+	}
+	defer nl.Close()
+
+	index, err := nl.linkIndex(name)
+	if err == nil {
+		err = nl.addAddr(index, ip, prefix)
+	}
+	if err != nil {
+		return netError("ipaddr", name, err)
+	}
+	return nil
+}
+
+// iproute adds a route to dst, a network or "default", through the
+// gateway and/or the interface name.
+func iproute(dst, gateway, name string) error {
+	var (
+		dstNet *net.IPNet
+		gw     net.IP
+	)
+
+	if dst != "default" {
+		_, ipnet, err := net.ParseCIDR(dst)
+		if err != nil {
+			return fmt.Errorf("builtin: iproute: invalid destination %q, expected default or network/prefix", dst)
+		}
+		dstNet = ipnet
+	}
+
+	if gateway != "" {
+		gw = net.ParseIP(gateway)
+		if gw == nil {
+			return fmt.Errorf("builtin: iproute: invalid gateway %q", gateway)
+		}
+	}
+
+	nl, err := dialNetlink()
+	if err != nil {
+		return err
+	}
+	defer nl.Close()
+
+	var index int32
+
+	if name != "" {
+		index, err = nl.linkIndex(name)
+		if err != nil {
+			return netError("iproute", name, err)
+		}
+	}
+
+	if err := nl.addRoute(dstNet, gw, index); err != nil {
+		return fmt.Errorf("builtin: iproute: error[%s] dst[%s]", err, dst)
+	}
+	return nil
+}
+
+func netError(name, iface string, err error) error {
+	if err == syscall.EPERM && name == "veth" && parentNetlink >= 0 {
+		return fmt.Errorf("builtin: %s: error[%s] interface[%s]: only root can create interfaces on the parent namespace",
+			name, err, iface)
+	}
+	return fmt.Errorf("builtin: %s: error[%s] interface[%s]", name, err, iface)
+}
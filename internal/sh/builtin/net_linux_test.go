@@ -0,0 +1,148 @@
+// +build linux
+
+package builtin_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNetworkInsideNamespace(t *testing.T) {
+	skipWithoutUserNS(t)
+
+	if _, err := exec.LookPath("ip"); err != nil {
+		t.Skip("ip not found")
+	}
+
+	out := execSuccess(t, `
+		var out <= rfork un {
+			ifup("lo")
+			var lo <= cat /sys/class/net/lo/operstate
+
+			# without a new network namespace, both ends are created here
+			rfork s {
+				veth("nash0", "nash1", "10.1.0.1/30")
+			}
+
+			ipaddr("nash0", "10.1.0.2/30")
+			ifup("nash0")
+			iproute("10.2.0.0/16", "10.1.0.1")
+			iproute("default", "", "nash0")
+
+			var addr <= ip -o -4 addr show nash0 | awk "{print $4}"
+			var routes <= ip route | awk "{print $1}"
+			return ($lo $addr $routes)
+		}
+		print("%s", $out)
+	`)
+
+	for _, expected := range []string{"unknown", "10.1.0.2/30", "default", "10.1.0.0/30", "10.2.0.0/16"} {
+		if !strings.Contains(out, expected) {
+			t.Fatalf("expected %q on the output, got: %q", expected, out)
+		}
+	}
+
+	execFailure(t, `rfork un {
+	ipaddr("nash0", "10.1.0.2/30")
+}`)
+	execFailure(t, `rfork un {
+	ipaddr("lo", "10.1.0.2")
+}`)
+	execFailure(t, `rfork un {
+	iproute("10.2.0.0/16", "10.1.0.1")
+}`)
+}
+
+func TestVethParentNamespace(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("only root can create interfaces on the parent namespace")
+	}
+
+	dir, teardown := setup(t)
+	defer teardown()
+
+	const peer = "nash-test0"
+
+	checked := make(chan error, 1)
+
+	// the peer exists only while the network namespace of the block
+	go func() {
+		err := waitFile(filepath.Join(dir, "ready"))
+		if err == nil {
+			err = checkInterface(peer, "10.200.0.1/24")
+		}
+		checked <- err
+		ioutil.WriteFile(filepath.Join(dir, "done"), nil, 0644)
+	}()
+
+	execSuccess(t, fmt.Sprintf(`
+		var dir = "%s"
+		setenv dir
+
+		rfork n ("veth") {
+			veth("eth0", "%s", "10.200.0.1/24")
+			ipaddr("eth0", "10.200.0.2/24")
+			ifup("eth0")
+			iproute("default", "10.200.0.1")
+
+			echo -n > $dir+"/ready"
+			sh -c "while [ ! -e $dir/done ]; do sleep 0.1; done"
+		}
+	`, dir, peer))
+
+	if err := <-checked; err != nil {
+		t.Fatal(err)
+	}
+
+	// without the veth option both interfaces are on the namespace
+	// of the block
+	execSuccess(t, fmt.Sprintf(`rfork n {
+	veth("eth0", "%s")
+	ifup("%s")
+}`, peer, peer))
+
+	if _, err := net.InterfaceByName(peer); err == nil {
+		t.Fatalf("expected no %s on the parent namespace", peer)
+	}
+}
+
+func waitFile(path string) error {
+	for i := 0; i < 100; i++ {
+		if _, err := os.Stat(path); err == nil {
+			return nil
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return fmt.Errorf("timeout waiting for %s", path)
+}
+
+func checkInterface(name, addr string) error {
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return err
+	}
+
+	if iface.Flags&net.FlagUp == 0 {
+		return fmt.Errorf("interface %s is down", name)
+	}
+
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return err
+	}
+
+	for _, a := range addrs {
+		if a.String() == addr {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("expected address %s on %s, got %v", addr, name, addrs)
+}
@@ -0,0 +1,21 @@
+// +build !linux
+
+package builtin
+
+import "github.com/madlambda/nash/errors"
+
+func ifup(name string) error {
+	return errors.NewError("ifup only supported on Linux")
+}
+
+func veth(name, peer, peeraddr string) error {
+	return errors.NewError("veth only supported on Linux")
+}
+
+func ipaddr(name, addr string) error {
+	return errors.NewError("ipaddr only supported on Linux")
+}
+
+func iproute(dst, gateway, name string) error {
+	return errors.NewError("iproute only supported on Linux")
+}
@@ -0,0 +1,38 @@
+package builtin_test
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestNetworkOutsideNamespace(t *testing.T) {
+	for _, call := range []string{
+		`ifup("lo")`,
+		`veth("eth0", "veth0")`,
+		`ipaddr("lo", "127.0.0.2/8")`,
+		`iproute("default", "10.0.0.1")`,
+	} {
+		t.Run(call, func(t *testing.T) {
+			execFailure(t, call)
+		})
+	}
+}
+
+func TestNetworkWrongArgs(t *testing.T) {
+	for _, call := range []string{
+		`ifup()`,
+		`ifup("lo", "eth0")`,
+		`veth("eth0")`,
+		`veth("eth0", "veth0", "10.0.0.1/24", "10.0.0.2/24")`,
+		`ipaddr("lo")`,
+		`ipaddr(("lo"), "127.0.0.2/8")`,
+		`iproute("default")`,
+		`iproute("default", "")`,
+	} {
+		t.Run(call, func(t *testing.T) {
+			execFailure(t, fmt.Sprintf(`rfork un {
+	%s
+}`, call))
+		})
+	}
+}
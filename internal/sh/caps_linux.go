@@ -0,0 +1,155 @@
+// +build linux
+
+package sh
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+// capNames are the names of the capabilities, without the cap_ prefix,
+// indexed by their numbers.
+var capNames = []string{
+	"chown", "dac_override", "dac_read_search", "fowner", "fsetid",
+	"kill", "setgid", "setuid", "setpcap", "linux_immutable",
+	"net_bind_service", "net_broadcast", "net_admin", "net_raw",
+	"ipc_lock", "ipc_owner", "sys_module", "sys_rawio", "sys_chroot",
+	"sys_ptrace", "sys_pacct", "sys_admin", "sys_boot", "sys_nice",
+	"sys_resource", "sys_time", "sys_tty_config", "mknod", "lease",
+	"audit_write", "audit_control", "setfcap", "mac_override",
+	"mac_admin", "syslog", "wake_alarm", "block_suspend", "audit_read",
+	"perfmon", "bpf", "checkpoint_restore",
+}
+
+const (
+	prCapAmbient         = 47
+	prCapAmbientClearAll = 4
+	prSetNoNewPrivs      = 38
+
+	linuxCapabilityVersion3 = 0x20080522
+)
+
+type (
+	capHeader struct {
+		version uint32
+		pid     int32
+	}
+
+	capData struct {
+		effective   uint32
+		permitted   uint32
+		inheritable uint32
+	}
+)
+
+// parseCaps returns the capabilities of the comma separated list of
+// names, with or without the cap_ prefix, or all of them.
+func parseCaps(value string) ([]int, error) {
+	if value == "all" {
+		caps := make([]int, lastCap()+1)
+		for i := range caps {
+			caps[i] = i
+		}
+		return caps, nil
+	}
+
+	var caps []int
+
+	for _, name := range strings.Split(value, ",") {
+		name = strings.TrimPrefix(strings.ToLower(name), "cap_")
+
+		found := false
+
+		for i, capName := range capNames {
+			if name == capName {
+				caps = append(caps, i)
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return nil, fmt.Errorf("unknown capability %q", name)
+		}
+	}
+
+	return caps, nil
+}
+
+// lastCap returns the number of the last capability of the kernel.
+func lastCap() int {
+	content, err := ioutil.ReadFile("/proc/sys/kernel/cap_last_cap")
+	if err == nil {
+		if n, err := strconv.Atoi(strings.TrimSpace(string(content))); err == nil {
+			return n
+		}
+	}
+	return len(capNames) - 1
+}
+
+// dropCaps removes the capabilities from the bounding set, that limits
+// the capabilities of the programs executed, and from the sets of the
+// calling thread.
+func dropCaps(caps []int) error {
+	for _, c := range caps {
+		_, _, errno := syscall.RawSyscall(syscall.SYS_PRCTL, syscall.PR_CAPBSET_DROP, uintptr(c), 0)
+		// the capabilities unknown by the kernel are not there
+		if errno != 0 && errno != syscall.EINVAL {
+			return fmt.Errorf("unable to drop capability %s: %s", capName(c), errno)
+		}
+	}
+
+	_, _, errno := syscall.RawSyscall6(syscall.SYS_PRCTL, prCapAmbient, prCapAmbientClearAll, 0, 0, 0, 0)
+	if errno != 0 && errno != syscall.EINVAL {
+		return fmt.Errorf("unable to clear the ambient capabilities: %s", errno)
+	}
+
+	header := capHeader{version: linuxCapabilityVersion3}
+	var data [2]capData
+
+	_, _, errno = syscall.RawSyscall(syscall.SYS_CAPGET,
+		uintptr(unsafe.Pointer(&header)), uintptr(unsafe.Pointer(&data[0])), 0)
+	if errno != 0 {
+		return fmt.Errorf("unable to get the capabilities: %s", errno)
+	}
+
+	for _, c := range caps {
+		if c >= 64 {
+			continue
+		}
+
+		mask := ^(uint32(1) << uint(c%32))
+		data[c/32].effective &= mask
+		data[c/32].permitted &= mask
+		data[c/32].inheritable &= mask
+	}
+
+	_, _, errno = syscall.RawSyscall(syscall.SYS_CAPSET,
+		uintptr(unsafe.Pointer(&header)), uintptr(unsafe.Pointer(&data[0])), 0)
+	if errno != 0 {
+		return fmt.Errorf("unable to set the capabilities: %s", errno)
+	}
+
+	return nil
+}
+
+func capName(c int) string {
+	if c < len(capNames) {
+		return "cap_" + capNames[c]
+	}
+	return strconv.Itoa(c)
+}
+
+// setNoNewPrivs stops the calling thread and the programs it executes
+// from gaining privileges, like with setuid programs.
+func setNoNewPrivs() error {
+	_, _, errno := syscall.RawSyscall6(syscall.SYS_PRCTL, prSetNoNewPrivs, 1, 0, 0, 0, 0)
+	if errno != 0 {
+		return fmt.Errorf("unable to set no_new_privs: %s", errno)
+	}
+	return nil
+}
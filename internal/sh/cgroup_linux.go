@@ -0,0 +1,358 @@
+// +build linux
+
+package sh
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+type (
+	// cgroupLimit is the value of an interface file of a cgroup v2,
+	// like memory.max.
+	cgroupLimit struct {
+		name, value string
+	}
+
+	// cgroup is a cgroup v2 created for the processes of a rfork block.
+	cgroup struct {
+		path string
+
+		// leaf is the cgroup where the calling process moved itself
+		// to enable the controllers of its cgroup, moving back by
+		// remove. See enableControllers.
+		leaf    string
+		enabled []string
+	}
+)
+
+// cgroupLimits are the interface files that can be set by the rfork
+// options.
+var cgroupLimits = map[string]bool{
+	"memory.max":      true,
+	"memory.high":     true,
+	"memory.swap.max": true,
+	"cpu.max":         true,
+	"cpu.weight":      true,
+	"pids.max":        true,
+	"io.max":          true,
+	"io.weight":       true,
+}
+
+// rforkOOMStatus is the exit status of the rfork blocks that failed
+// after the OOM killer killed some of its processes, as the shells do
+// for processes killed by SIGKILL.
+const rforkOOMStatus = 137
+
+var cgroupSeq uint32
+
+// newCgroup creates a cgroup below the one of the calling process,
+// with the limits set.
+func newCgroup(limits []cgroupLimit) (*cgroup, error) {
+	parent, err := currentCgroup()
+	if err != nil {
+		return nil, err
+	}
+
+	var controllers []string
+
+	for _, limit := range limits {
+		controller := limit.name[:strings.Index(limit.name, ".")]
+		if !hasString(controllers, controller) {
+			controllers = append(controllers, controller)
+		}
+	}
+
+	cg := &cgroup{
+		path: filepath.Join(parent, fmt.Sprintf("nash-rfork-%d-%d",
+			os.Getpid(), atomic.AddUint32(&cgroupSeq, 1))),
+	}
+
+	if err := cg.enableControllers(parent, controllers); err != nil {
+		cg.restore()
+		return nil, err
+	}
+
+	if err := os.Mkdir(cg.path, 0755); err != nil {
+		cg.restore()
+		return nil, fmt.Errorf("unable to create cgroup: %s", err)
+	}
+
+	for _, limit := range limits {
+		err := ioutil.WriteFile(filepath.Join(cg.path, limit.name), []byte(limit.value), 0)
+		if err != nil {
+			cg.remove()
+			return nil, fmt.Errorf("unable to set %s to %q: %s", limit.name, limit.value, unwrapPathError(err))
+		}
+	}
+
+	return cg, nil
+}
+
+// addCgroupProc moves the process pid to the cgroup path.
+func addCgroupProc(path string, pid int) error {
+	err := ioutil.WriteFile(filepath.Join(path, "cgroup.procs"), []byte(strconv.Itoa(pid)), 0)
+	if err != nil {
+		return fmt.Errorf("unable to add process %d to cgroup: %s", pid, unwrapPathError(err))
+	}
+	return nil
+}
+
+// open returns the cgroup directory, to create processes directly in
+// the cgroup with SysProcAttr.CgroupFD.
+func (cg *cgroup) open() (*os.File, error) {
+	fd, err := syscall.Open(cg.path, syscall.O_RDONLY|syscall.O_DIRECTORY|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open cgroup: %s", err)
+	}
+	return os.NewFile(uintptr(fd), cg.path), nil
+}
+
+// oomKills returns the number of processes of the cgroup killed by the
+// OOM killer.
+func (cg *cgroup) oomKills() int {
+	events, err := ioutil.ReadFile(filepath.Join(cg.path, "memory.events"))
+	if err != nil {
+		return 0
+	}
+
+	for _, line := range strings.Split(string(events), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 2 && fields[0] == "oom_kill" {
+			n, _ := strconv.Atoi(fields[1])
+			return n
+		}
+	}
+
+	return 0
+}
+
+// remove kills the processes left in the cgroup, like daemons started
+// by the rfork block, and removes it. The cgroup of the calling process
+// is restored even if the removal fails.
+func (cg *cgroup) remove() error {
+	return joinErrors(cg.removeDir(), cg.restore())
+}
+
+func (cg *cgroup) removeDir() error {
+	err := os.Remove(cg.path)
+	if err == nil || os.IsNotExist(err) {
+		return nil
+	}
+
+	// cgroup.kill exists since Linux 5.14
+	ioutil.WriteFile(filepath.Join(cg.path, "cgroup.kill"), []byte("1"), 0)
+
+	for i := 0; i < 100; i++ {
+		time.Sleep(10 * time.Millisecond)
+
+		if err = os.Remove(cg.path); err == nil {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("unable to remove cgroup: %s", err)
+}
+
+// restore undoes the changes made by enableControllers to the cgroup
+// of the calling process: disables the controllers enabled and moves
+// the calling process back from its leaf cgroup, removing it. All the
+// changes are undone, even if some fail.
+func (cg *cgroup) restore() error {
+	var errs []error
+
+	parent := filepath.Dir(cg.path)
+
+	for _, controller := range cg.enabled {
+		err := writeSubtreeControl(parent, "-"+controller)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("unable to disable the cgroup controller %s on %s: %s", controller, parent, err))
+		}
+	}
+
+	if cg.leaf != "" {
+		if err := addCgroupProc(parent, os.Getpid()); err != nil {
+			errs = append(errs, err)
+		} else if err := os.Remove(cg.leaf); err != nil {
+			errs = append(errs, fmt.Errorf("unable to remove cgroup: %s", err))
+		}
+	}
+
+	cg.leaf, cg.enabled = "", nil
+	return joinErrors(errs...)
+}
+
+// joinErrors returns an error with the messages of the non nil errors.
+func joinErrors(errs ...error) error {
+	var msgs []string
+
+	for _, err := range errs {
+		if err != nil {
+			msgs = append(msgs, err.Error())
+		}
+	}
+
+	if len(msgs) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%s", strings.Join(msgs, "; "))
+}
+
+// currentCgroup returns the path of the cgroup v2 of the calling
+// process.
+func currentCgroup() (string, error) {
+	mountpoint, root, err := cgroup2Mount()
+	if err != nil {
+		return "", err
+	}
+
+	content, err := ioutil.ReadFile("/proc/self/cgroup")
+	if err != nil {
+		return "", err
+	}
+
+	for _, line := range strings.Split(string(content), "\n") {
+		if !strings.HasPrefix(line, "0::") {
+			continue
+		}
+
+		rel, err := filepath.Rel(root, line[len("0::"):])
+		if err != nil || strings.HasPrefix(rel, "..") {
+			return "", fmt.Errorf("cgroup %s is not visible on %s", line[len("0::"):], mountpoint)
+		}
+
+		return filepath.Join(mountpoint, rel), nil
+	}
+
+	return "", fmt.Errorf("process has no cgroup v2")
+}
+
+// cgroup2Mount returns the mount point of the cgroup v2 filesystem and
+// the path of the cgroup mounted there.
+func cgroup2Mount() (string, string, error) {
+	file, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return "", "", err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+
+	for scanner.Scan() {
+		// id parent major:minor root mountpoint options... - fstype source options
+		fields := strings.Fields(scanner.Text())
+
+		for i := 6; i < len(fields)-1; i++ {
+			if fields[i] == "-" {
+				if fields[i+1] == "cgroup2" {
+					return unescapeMountPath(fields[4]), unescapeMountPath(fields[3]), nil
+				}
+				break
+			}
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return "", "", err
+	}
+
+	return "", "", fmt.Errorf("cgroup v2 filesystem not mounted")
+}
+
+// unescapeMountPath decodes the octal escapes of the spaces, tabs,
+// newlines and backslashes of the paths of /proc/self/mountinfo.
+func unescapeMountPath(path string) string {
+	return strings.NewReplacer(`\040`, " ", `\011`, "\t", `\012`, "\n", `\134`, `\`).Replace(path)
+}
+
+// enableControllers enables the controllers for the children of the
+// cgroup. The cgroups with processes, except the root one, can not
+// enable controllers, so if needed the calling process moves itself to
+// a new child cgroup until restore is called.
+func (cg *cgroup) enableControllers(path string, controllers []string) error {
+	available, err := ioutil.ReadFile(filepath.Join(path, "cgroup.controllers"))
+	if err != nil {
+		return err
+	}
+
+	enabled, err := ioutil.ReadFile(filepath.Join(path, "cgroup.subtree_control"))
+	if err != nil {
+		return err
+	}
+
+	for _, controller := range controllers {
+		if !hasString(strings.Fields(string(available)), controller) {
+			return fmt.Errorf("cgroup controller %s not available on %s", controller, path)
+		}
+
+		if hasString(strings.Fields(string(enabled)), controller) {
+			continue
+		}
+
+		err := writeSubtreeControl(path, "+"+controller)
+		if err == syscall.EBUSY && cg.leaf == "" {
+			if err = cg.leave(path); err == nil {
+				err = writeSubtreeControl(path, "+"+controller)
+			}
+		}
+
+		if err == syscall.EBUSY {
+			return fmt.Errorf("unable to enable the cgroup controller %s on %s: other processes are in the cgroup, "+
+				"run nash in a cgroup of its own (e.g. systemd-run --user --scope -p Delegate=yes)", controller, path)
+		}
+
+		if err != nil {
+			return fmt.Errorf("unable to enable the cgroup controller %s on %s: %s", controller, path, err)
+		}
+
+		// recorded at once, so restore disables it even if enabling
+		// the next controllers fails
+		cg.enabled = append(cg.enabled, controller)
+	}
+
+	return nil
+}
+
+func writeSubtreeControl(path, change string) error {
+	err := ioutil.WriteFile(filepath.Join(path, "cgroup.subtree_control"), []byte(change), 0)
+	return unwrapPathError(err)
+}
+
+// leave moves the calling process from the cgroup path to a new child
+// cgroup. Other processes still make the controllers of the cgroup
+// busy.
+func (cg *cgroup) leave(path string) error {
+	leaf := filepath.Join(path, fmt.Sprintf("nash-%d", os.Getpid()))
+
+	if err := os.Mkdir(leaf, 0755); err != nil && !os.IsExist(err) {
+		return err
+	}
+
+	cg.leaf = leaf
+
+	return addCgroupProc(leaf, os.Getpid())
+}
+
+func unwrapPathError(err error) error {
+	if e, ok := err.(*os.PathError); ok {
+		return e.Err
+	}
+	return err
+}
+
+func hasString(strs []string, s string) bool {
+	for _, str := range strs {
+		if str == s {
+			return true
+		}
+	}
+	return false
+}
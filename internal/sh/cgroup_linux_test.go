@@ -0,0 +1,220 @@
+// +build linux
+
+package sh
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+func TestCgroup(t *testing.T) {
+	cg, err := newCgroup(nil)
+	if err != nil {
+		t.Skipf("unable to create cgroup: %s", err)
+	}
+
+	dir, err := cg.open()
+	if err != nil {
+		cg.remove()
+		t.Fatal(err)
+	}
+
+	cmd := exec.Command("sleep", "60")
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		UseCgroupFD: true,
+		CgroupFD:    int(dir.Fd()),
+	}
+
+	err = cmd.Start()
+	dir.Close()
+
+	if err != nil {
+		cg.remove()
+		t.Fatal(err)
+	}
+
+	content, err := ioutil.ReadFile("/proc/" + strconv.Itoa(cmd.Process.Pid) + "/cgroup")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(string(content), "/"+filepath.Base(cg.path)+"\n") {
+		t.Errorf("expected process in cgroup %s but got: %s", cg.path, content)
+	}
+
+	// the processes left are killed
+	if err := cg.remove(); err != nil {
+		cmd.Process.Kill()
+		t.Fatal(err)
+	}
+
+	if err := cmd.Wait(); err == nil || !strings.Contains(err.Error(), "killed") {
+		t.Errorf("expected process killed but got: %v", err)
+	}
+
+	if _, err := os.Stat(cg.path); !os.IsNotExist(err) {
+		t.Errorf("expected cgroup removed but got: %v", err)
+	}
+}
+
+func TestCgroupLeave(t *testing.T) {
+	parent, err := currentCgroup()
+	if err != nil {
+		t.Skip(err)
+	}
+
+	// the test process runs in a cgroup of its own, that can not
+	// enable controllers while the process is there
+	path := filepath.Join(parent, "nash-test-"+strconv.Itoa(os.Getpid()))
+	if err := os.Mkdir(path, 0755); err != nil {
+		t.Skipf("unable to create cgroup: %s", err)
+	}
+	defer os.Remove(path)
+
+	available, err := ioutil.ReadFile(filepath.Join(path, "cgroup.controllers"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	controllers := strings.Fields(string(available))
+	if len(controllers) == 0 {
+		t.Skip("no cgroup controller available")
+	}
+
+	if err := addCgroupProc(path, os.Getpid()); err != nil {
+		t.Skip(err)
+	}
+	defer addCgroupProc(parent, os.Getpid())
+
+	cg := &cgroup{path: filepath.Join(path, "nash-rfork-test")}
+	if err := cg.enableControllers(path, controllers[:1]); err != nil {
+		cg.restore()
+		t.Fatal(err)
+	}
+
+	if current, err := currentCgroup(); err != nil || current != cg.leaf {
+		t.Errorf("expected process in cgroup %s but got %s: %v", cg.leaf, current, err)
+	}
+
+	if err := cg.restore(); err != nil {
+		t.Fatal(err)
+	}
+
+	if current, err := currentCgroup(); err != nil || current != path {
+		t.Errorf("expected process back in cgroup %s but got %s: %v", path, current, err)
+	}
+
+	enabled, err := ioutil.ReadFile(filepath.Join(path, "cgroup.subtree_control"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(strings.Fields(string(enabled))) != 0 {
+		t.Errorf("expected controllers disabled but got %q", enabled)
+	}
+
+	entries, err := ioutil.ReadDir(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() {
+			t.Errorf("expected leaf cgroup removed but found %s", entry.Name())
+		}
+	}
+}
+
+func TestCgroupRestorePartial(t *testing.T) {
+	parent, err := currentCgroup()
+	if err != nil {
+		t.Skip(err)
+	}
+
+	// without processes in the cgroup the controllers are enabled
+	// without leaving it
+	path := filepath.Join(parent, "nash-test-"+strconv.Itoa(os.Getpid()))
+	if err := os.Mkdir(path, 0755); err != nil {
+		t.Skipf("unable to create cgroup: %s", err)
+	}
+	defer os.Remove(path)
+
+	available, err := ioutil.ReadFile(filepath.Join(path, "cgroup.controllers"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	controllers := strings.Fields(string(available))
+	if len(controllers) == 0 {
+		t.Skip("no cgroup controller available")
+	}
+
+	cg := &cgroup{path: filepath.Join(path, "nash-rfork-test")}
+
+	err = cg.enableControllers(path, []string{controllers[0], "nash-test"})
+	if err == nil || !strings.Contains(err.Error(), "nash-test not available") {
+		t.Errorf("expected controller not available error but got: %v", err)
+	}
+
+	if err := cg.restore(); err != nil {
+		t.Fatal(err)
+	}
+
+	enabled, err := ioutil.ReadFile(filepath.Join(path, "cgroup.subtree_control"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(strings.Fields(string(enabled))) != 0 {
+		t.Errorf("expected controllers disabled but got %q", enabled)
+	}
+}
+
+func TestRforkLimitOptions(t *testing.T) {
+	options, err := parseRforkOptions([]string{
+		"memory.max=64M",
+		"cpu.max=50000 100000",
+		"pids.max=100",
+		"io.max=8:0 rbps=1048576",
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []cgroupLimit{
+		{"memory.max", "64M"},
+		{"cpu.max", "50000 100000"},
+		{"pids.max", "100"},
+		{"io.max", "8:0 rbps=1048576"},
+	}
+
+	if len(options.limits) != len(expected) {
+		t.Fatalf("expected limits %v but got %v", expected, options.limits)
+	}
+
+	for i, limit := range expected {
+		if options.limits[i] != limit {
+			t.Errorf("expected limit %v but got %v", limit, options.limits[i])
+		}
+	}
+
+	for _, opt := range []string{"memory.max", "memory.max=", "memory.stat=1"} {
+		if _, err := parseRforkOptions([]string{opt}); err == nil {
+			t.Errorf("expected error parsing %s", opt)
+		}
+	}
+}
+
+func TestUnescapeMountPath(t *testing.T) {
+	got := unescapeMountPath(`/mnt/a\040b\134c`)
+	if got != `/mnt/a b\c` {
+		t.Errorf("unexpected path: %s", got)
+	}
+}
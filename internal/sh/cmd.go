@@ -70,8 +70,19 @@ func (c *Cmd) SetStdout(out io.Writer) { c.Cmd.Stdout = out }
 func (c *Cmd) SetStderr(err io.Writer) { c.Cmd.Stderr = err }
 
 func (c *Cmd) SetArgs(nodeArgs []sh.Obj) error {
-	args := make([]string, 1, len(nodeArgs)+1)
-	args[0] = c.Path
+	args, err := cmdArgs(nodeArgs)
+	if err != nil {
+		return err
+	}
+
+	c.Cmd.Args = append([]string{c.Path}, args...)
+	return nil
+}
+
+// cmdArgs converts the evaluated arguments of a command into strings,
+// expanding lists.
+func cmdArgs(nodeArgs []sh.Obj) ([]string, error) {
+	args := make([]string, 0, len(nodeArgs))
 
 	for _, obj := range nodeArgs {
 		if obj.Type() == sh.StringType {
@@ -83,21 +94,20 @@ func (c *Cmd) SetArgs(nodeArgs []sh.Obj) error {
 
 			for _, l := range values {
 				if l.Type() != sh.StringType {
-					return errors.NewError("Command arguments requires string or list of strings. But received '%v'", l.String())
+					return nil, errors.NewError("Command arguments requires string or list of strings. But received '%v'", l.String())
 				}
 
 				lstr := l.(*sh.StrObj)
 				args = append(args, lstr.Str())
 			}
 		} else if obj.Type() == sh.FnType {
-			return errors.NewError("Function cannot be passed as argument to commands.")
+			return nil, errors.NewError("Function cannot be passed as argument to commands.")
 		} else {
-			return errors.NewError("Invalid command argument '%v'", obj)
+			return nil, errors.NewError("Invalid command argument '%v'", obj)
 		}
 	}
 
-	c.Cmd.Args = args
-	return nil
+	return args, nil
 }
 
 func (c *Cmd) Args() []ast.Expr { return c.argExprs }
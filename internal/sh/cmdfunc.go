@@ -0,0 +1,167 @@
+package sh
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/madlambda/nash/errors"
+	"github.com/madlambda/nash/sh"
+)
+
+type (
+	// CmdFunc is a command implemented in Go. The args do not include
+	// the command name and env is the environment the command would
+	// have if it were a process. The returned value is the exit
+	// status of the command.
+	CmdFunc func(args []string, env []string, stdin io.Reader, stdout, stderr io.Writer) int
+
+	// CmdResolver resolves a command name to its implementation.
+	// Returning nil makes the shell fall back to the default
+	// resolution, looking for an executable on PATH.
+	CmdResolver func(name string) CmdFunc
+
+	// goCmd is a sh.Runner that runs a CmdFunc as a command.
+	goCmd struct {
+		name string
+		fn   CmdFunc
+		args []string
+		env  []string
+
+		stdin          io.Reader
+		stdout, stderr io.Writer
+
+		closeAfterWait io.Closer
+		done           chan int
+	}
+
+	// errExitStatus is the error of a CmdFunc that exited with
+	// non zero status.
+	errExitStatus struct {
+		*errors.NashError
+		status int
+	}
+)
+
+func newErrExitStatus(name string, status int) error {
+	return &errExitStatus{
+		NashError: errors.NewError("%s: exit status %d", name, status),
+		status:    status,
+	}
+}
+
+func (e *errExitStatus) ExitStatus() int { return e.status }
+
+func newGoCmd(name string, fn CmdFunc) *goCmd {
+	return &goCmd{
+		name: name,
+		fn:   fn,
+	}
+}
+
+func (c *goCmd) SetArgs(nodeArgs []sh.Obj) error {
+	args, err := cmdArgs(nodeArgs)
+	if err != nil {
+		return err
+	}
+
+	c.args = args
+	return nil
+}
+
+func (c *goCmd) SetEnviron(env []string) { c.env = env }
+func (c *goCmd) SetStdin(in io.Reader)   { c.stdin = in }
+func (c *goCmd) SetStdout(out io.Writer) { c.stdout = out }
+func (c *goCmd) SetStderr(err io.Writer) { c.stderr = err }
+func (c *goCmd) Stdin() io.Reader        { return c.stdin }
+func (c *goCmd) Stdout() io.Writer       { return c.stdout }
+func (c *goCmd) Stderr() io.Writer       { return c.stderr }
+func (c *goCmd) Results() []sh.Obj       { return nil }
+func (c *goCmd) String() string          { return fmt.Sprintf("<go command %q>", c.name) }
+
+func (c *goCmd) StdoutPipe() (io.ReadCloser, error) {
+	if c.stdout != nil {
+		return nil, errors.NewError("Stdout already set")
+	}
+
+	pr, pw := io.Pipe()
+	c.stdout = pw
+	c.closeAfterWait = pw
+	return pr, nil
+}
+
+func (c *goCmd) Start() error {
+	stdin, stdout, stderr := c.stdin, c.stdout, c.stderr
+	if stdin == nil {
+		stdin = eofReader{}
+	}
+	if stdout == nil {
+		stdout = ioutil.Discard
+	}
+	if stderr == nil {
+		stderr = ioutil.Discard
+	}
+
+	// the pipe of the previous command of a pipeline is closed by its
+	// Wait, so the command reads from a copy, as a process would
+	var closeStdin io.Closer
+	if f, ok := stdin.(*os.File); ok {
+		dup, err := dupFile(f)
+		if err != nil {
+			return err
+		}
+		if dup != f {
+			stdin, closeStdin = dup, dup
+		}
+	}
+
+	c.done = make(chan int, 1)
+
+	go func() {
+		status := c.fn(c.args, c.env, stdin, stdout, stderr)
+		if closeStdin != nil {
+			closeStdin.Close()
+		}
+		if c.closeAfterWait != nil {
+			// signals EOF to the next command of the pipe
+			c.closeAfterWait.Close()
+		}
+		c.done <- status
+	}()
+
+	return nil
+}
+
+func (c *goCmd) Wait() error {
+	status := <-c.done
+	if status != 0 {
+		return newErrExitStatus(c.name, status)
+	}
+	return nil
+}
+
+type eofReader struct{}
+
+func (eofReader) Read([]byte) (int, error) { return 0, io.EOF }
+
+// SetCmdResolver sets a resolver that is consulted, after the function
+// bindings, for every command executed by the shell.
+func (shell *Shell) SetCmdResolver(resolver CmdResolver) {
+	shell.cmdResolver = resolver
+}
+
+// resolveCmd returns the Go implementation of the command, if any.
+func (shell *Shell) resolveCmd(name string) CmdFunc {
+	if shell.mocks != nil {
+		if fn := shell.mocks.resolve(name); fn != nil {
+			return fn
+		}
+	}
+
+	if shell.cmdResolver != nil {
+		return shell.cmdResolver(name)
+	}
+
+	return nil
+}
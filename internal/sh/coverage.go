@@ -0,0 +1,256 @@
+package sh
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/madlambda/nash/ast"
+	"github.com/madlambda/nash/errors"
+)
+
+type (
+	// Coverage records which statements of the executed script files
+	// were run, keyed by file and line.
+	// Only files executed with ExecFile (including imported ones) are
+	// tracked. A single Coverage is shared by a shell and all of its
+	// sub-shells.
+	Coverage struct {
+		mu    sync.Mutex
+		files map[string]map[int]int // absolute filename -> line -> hits
+		paths map[string]string      // filename as executed -> absolute filename
+	}
+)
+
+// NewCoverage creates an empty coverage.
+func NewCoverage() *Coverage {
+	return &Coverage{
+		files: make(map[string]map[int]int),
+		paths: make(map[string]string),
+	}
+}
+
+// Register adds every statement of the tree as a statement of the file,
+// so lines never executed are reported with zero hits.
+func (c *Coverage) Register(filename string, tr *ast.Tree) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// the path is resolved now because the script can change the
+	// working directory later.
+	abs := absPath(filename)
+	c.paths[filename] = abs
+
+	registerStmts(c.fileLines(abs), tr)
+}
+
+func registerStmts(lines map[int]int, tr *ast.Tree) {
+	if tr == nil || tr.Root == nil {
+		return
+	}
+
+	ast.Inspect(tr.Root, func(node ast.Node) bool {
+		block, ok := node.(*ast.BlockNode)
+		if !ok {
+			return true
+		}
+
+		for _, stmt := range block.Nodes {
+			if stmt.Type() == ast.NodeComment {
+				continue
+			}
+
+			if _, ok := lines[stmt.Line()]; !ok {
+				lines[stmt.Line()] = 0
+			}
+		}
+		return true
+	})
+}
+
+// hit records the execution of the statement node of the given file.
+func (c *Coverage) hit(filename string, node ast.Node) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	abs, ok := c.paths[filename]
+	if !ok {
+		return
+	}
+
+	c.files[abs][node.Line()]++
+}
+
+// Files returns the absolute name of every tracked file, sorted.
+func (c *Coverage) Files() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	files := make([]string, 0, len(c.files))
+	for fname := range c.files {
+		files = append(files, fname)
+	}
+	sort.Strings(files)
+	return files
+}
+
+// Lines returns the hits of each statement line of the file given by
+// its absolute name.
+func (c *Coverage) Lines(filename string) map[int]int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	lines := make(map[int]int, len(c.files[filename]))
+	for line, hits := range c.files[filename] {
+		lines[line] = hits
+	}
+	return lines
+}
+
+// WriteLCOV writes the coverage in the LCOV tracefile format.
+func (c *Coverage) WriteLCOV(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+
+	fmt.Fprintf(bw, "TN:\n")
+	for _, fname := range c.Files() {
+		lines := c.Lines(fname)
+
+		fmt.Fprintf(bw, "SF:%s\n", fname)
+
+		var hit int
+		for _, line := range sortedLines(lines) {
+			if lines[line] > 0 {
+				hit++
+			}
+			fmt.Fprintf(bw, "DA:%d,%d\n", line, lines[line])
+		}
+
+		fmt.Fprintf(bw, "LF:%d\n", len(lines))
+		fmt.Fprintf(bw, "LH:%d\n", hit)
+		fmt.Fprintf(bw, "end_of_record\n")
+	}
+
+	return bw.Flush()
+}
+
+// WriteCoverProfile writes the coverage in the Go cover profile format,
+// using count mode. Each statement line is reported as a block spanning
+// the whole line.
+func (c *Coverage) WriteCoverProfile(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+
+	fmt.Fprintf(bw, "mode: count\n")
+	for _, fname := range c.Files() {
+		lines := c.Lines(fname)
+
+		for _, line := range sortedLines(lines) {
+			fmt.Fprintf(bw, "%s:%d.1,%d.1 1 %d\n", fname, line, line+1,
+				lines[line])
+		}
+	}
+
+	return bw.Flush()
+}
+
+// Merge adds the hits of a previously written coverage, in LCOV or Go
+// cover profile format, to c.
+func (c *Coverage) Merge(r io.Reader) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var (
+		lines  map[int]int
+		lineno int
+	)
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		lineno++
+		text := strings.TrimSpace(scanner.Text())
+
+		switch {
+		case text == "" || text == "TN:" || text == "end_of_record" ||
+			strings.HasPrefix(text, "mode:") ||
+			strings.HasPrefix(text, "LF:") ||
+			strings.HasPrefix(text, "LH:"):
+			continue
+		case strings.HasPrefix(text, "SF:"):
+			lines = c.fileLines(text[3:])
+		case strings.HasPrefix(text, "DA:"):
+			if lines == nil {
+				return errors.NewError("coverage:%d: DA record outside of a file", lineno)
+			}
+
+			parts := strings.Split(text[3:], ",")
+			if len(parts) < 2 {
+				return errors.NewError("coverage:%d: invalid DA record: %s", lineno, text)
+			}
+
+			line, err1 := strconv.Atoi(parts[0])
+			hits, err2 := strconv.Atoi(parts[1])
+			if err1 != nil || err2 != nil {
+				return errors.NewError("coverage:%d: invalid DA record: %s", lineno, text)
+			}
+
+			lines[line] += hits
+		default:
+			// Go cover profile: file:line.col,line.col numstmt count
+			fields := strings.Fields(text)
+			colon := strings.LastIndex(text, ":")
+			if len(fields) != 3 || colon == -1 {
+				return errors.NewError("coverage:%d: invalid record: %s", lineno, text)
+			}
+
+			pos := strings.SplitN(text[colon+1:], ".", 2)
+			line, err1 := strconv.Atoi(pos[0])
+			hits, err2 := strconv.Atoi(fields[2])
+			if err1 != nil || err2 != nil {
+				return errors.NewError("coverage:%d: invalid record: %s", lineno, text)
+			}
+
+			c.fileLines(text[:colon])[line] += hits
+		}
+	}
+
+	return scanner.Err()
+}
+
+func (c *Coverage) fileLines(filename string) map[int]int {
+	lines, ok := c.files[filename]
+	if !ok {
+		lines = make(map[int]int)
+		c.files[filename] = lines
+	}
+	return lines
+}
+
+func sortedLines(lines map[int]int) []int {
+	sorted := make([]int, 0, len(lines))
+	for line := range lines {
+		sorted = append(sorted, line)
+	}
+	sort.Ints(sorted)
+	return sorted
+}
+
+func absPath(fname string) string {
+	abs, err := filepath.Abs(fname)
+	if err != nil {
+		return fname
+	}
+	return abs
+}
+
+// SetCoverage enables the recording of statement coverage of every file
+// executed by the shell. A nil coverage disables it.
+func (shell *Shell) SetCoverage(c *Coverage) {
+	shell.coverage = c
+}
+
+// Coverage returns the coverage in use or nil if it is disabled.
+func (shell *Shell) Coverage() *Coverage { return shell.coverage }
@@ -0,0 +1,112 @@
+package sh_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/madlambda/nash/internal/sh"
+)
+
+func TestCoverage(t *testing.T) {
+	f, teardown := setup(t)
+	defer teardown()
+
+	cover := sh.NewCoverage()
+	f.shell.SetCoverage(cover)
+
+	lib := filepath.Join(f.envDirs.Lib, "lib.sh")
+	err := ioutil.WriteFile(lib, []byte(`fn check(a) {
+	if $a == "yes" {
+		echo yes
+	} else {
+		echo no
+	}
+}
+
+fn unused() {
+	echo unused
+}
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	script := filepath.Join(f.envDirs.Path, "main.sh")
+	err = ioutil.WriteFile(script, []byte(`import lib
+
+# comments are not statements
+check("yes")
+check("yes")
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = f.shell.ExecFile(script)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assertLines := func(fname string, expected map[int]int) {
+		t.Helper()
+
+		got := cover.Lines(fname)
+		if len(got) != len(expected) {
+			t.Fatalf("%s: expected lines %v but got %v", fname, expected, got)
+		}
+
+		for line, hits := range expected {
+			if got[line] != hits {
+				t.Errorf("%s:%d: expected %d hits but got %d",
+					fname, line, hits, got[line])
+			}
+		}
+	}
+
+	assertLines(script, map[int]int{1: 1, 4: 1, 5: 1})
+	assertLines(lib, map[int]int{
+		1: 1, 2: 2, 3: 2, 5: 0,
+		9: 1, 10: 0,
+	})
+
+	var lcov bytes.Buffer
+	err = cover.WriteLCOV(&lcov)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(lcov.String(), "SF:"+lib+"\nDA:1,1\nDA:2,2\nDA:3,2\nDA:5,0\n") {
+		t.Errorf("unexpected lcov output:\n%s", lcov.String())
+	}
+
+	merged := sh.NewCoverage()
+	for i := 0; i < 2; i++ {
+		err = merged.Merge(bytes.NewReader(lcov.Bytes()))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var profile bytes.Buffer
+	err = merged.WriteCoverProfile(&profile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(profile.String(), lib+":2.1,3.1 1 4\n") {
+		t.Errorf("unexpected cover profile:\n%s", profile.String())
+	}
+
+	mergedAgain := sh.NewCoverage()
+	err = mergedAgain.Merge(bytes.NewReader(profile.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if hits := mergedAgain.Lines(lib)[3]; hits != 4 {
+		t.Errorf("expected 4 hits after merging cover profile but got %d", hits)
+	}
+}
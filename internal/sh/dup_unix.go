@@ -0,0 +1,19 @@
+// +build !windows,!plan9
+
+package sh
+
+import (
+	"os"
+	"syscall"
+)
+
+// dupFile returns a new file with a duplicate of the descriptor of f.
+func dupFile(f *os.File) (*os.File, error) {
+	fd, err := syscall.Dup(int(f.Fd()))
+	if err != nil {
+		return nil, err
+	}
+
+	syscall.CloseOnExec(fd)
+	return os.NewFile(uintptr(fd), f.Name()), nil
+}
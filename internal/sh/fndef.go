@@ -12,6 +12,7 @@ import (
 type (
 	fnDef struct {
 		name     string
+		filename string // file where the function was declared
 		Parent   *Shell
 		Body     *ast.Tree
 		argNames []sh.FnArg
@@ -34,12 +35,13 @@ type (
 // newFnDef creates a new function definition
 func newFnDef(name string, parent *Shell, args []*ast.FnArgNode, body *ast.Tree) (*fnDef, error) {
 	fn := fnDef{
-		name:   name,
-		Parent: parent,
-		Body:   body,
-		stdin:  parent.stdin,
-		stdout: parent.stdout,
-		stderr: parent.stderr,
+		name:     name,
+		filename: parent.filename,
+		Parent:   parent,
+		Body:     body,
+		stdin:    parent.stdin,
+		stdout:   parent.stdout,
+		stderr:   parent.stderr,
 	}
 
 	for i := 0; i < len(args); i++ {
@@ -93,6 +95,7 @@ func newUserFnDef(name string, parent *Shell, args []*ast.FnArgNode, body *ast.T
 
 func (ufnDef *userFnDef) Build() sh.Fn {
 	userfn := NewUserFn(ufnDef.Name(), ufnDef.ArgNames(), ufnDef.Body, ufnDef.Parent)
+	userfn.subshell.filename = ufnDef.filename
 	userfn.SetStdin(ufnDef.stdin)
 	userfn.SetStdout(ufnDef.stdout)
 	userfn.SetStderr(ufnDef.stderr)
@@ -0,0 +1,172 @@
+// +build linux
+
+package sh
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// The files with the subordinate ids the users can map on their user
+// namespaces.
+var (
+	subuidPath = "/etc/subuid"
+	subgidPath = "/etc/subgid"
+)
+
+// parseIDMap parses a mapping of the form container:host:size.
+func parseIDMap(value string) (syscall.SysProcIDMap, error) {
+	var ids [3]int
+
+	fields := strings.Split(value, ":")
+	if len(fields) != len(ids) {
+		return syscall.SysProcIDMap{}, fmt.Errorf("expected container:host:size")
+	}
+
+	for i, field := range fields {
+		id, err := strconv.Atoi(field)
+		if err != nil || id < 0 {
+			return syscall.SysProcIDMap{}, fmt.Errorf("invalid id %q", field)
+		}
+		ids[i] = id
+	}
+
+	if ids[2] == 0 {
+		return syscall.SysProcIDMap{}, fmt.Errorf("size must be greater than zero")
+	}
+
+	return syscall.SysProcIDMap{
+		ContainerID: ids[0],
+		HostID:      ids[1],
+		Size:        ids[2],
+	}, nil
+}
+
+// readSubIDs returns the ranges of subordinate ids of the user, given
+// by its name or id, on the file path, in the format of subuid(5).
+// Only the HostID and Size of the ranges are set.
+func readSubIDs(path, name string, id int) ([]syscall.SysProcIDMap, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var ranges []syscall.SysProcIDMap
+
+	for i, line := range strings.Split(string(content), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Split(line, ":")
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("%s:%d: invalid line: %s", path, i+1, line)
+		}
+
+		if fields[0] != name && fields[0] != strconv.Itoa(id) {
+			continue
+		}
+
+		start, err := strconv.Atoi(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: invalid start: %s", path, i+1, fields[1])
+		}
+
+		count, err := strconv.Atoi(fields[2])
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: invalid count: %s", path, i+1, fields[2])
+		}
+
+		ranges = append(ranges, syscall.SysProcIDMap{HostID: start, Size: count})
+	}
+
+	if len(ranges) == 0 {
+		return nil, fmt.Errorf("no subordinate ids for %s on %s", name, path)
+	}
+
+	return ranges, nil
+}
+
+// appendIDMappings returns the mappings with the ranges mapped to the
+// container ids after the last one already mapped.
+func appendIDMappings(mappings, ranges []syscall.SysProcIDMap) []syscall.SysProcIDMap {
+	var next int
+
+	for _, m := range mappings {
+		if end := m.ContainerID + m.Size; end > next {
+			next = end
+		}
+	}
+
+	result := append([]syscall.SysProcIDMap{}, mappings...)
+
+	for _, r := range ranges {
+		r.ContainerID = next
+		result = append(result, r)
+		next += r.Size
+	}
+
+	return result
+}
+
+// writeIDMappings writes the uid and gid mappings of the process pid
+// with the setuid programs newuidmap and newgidmap, that check the ids
+// against /etc/subuid and /etc/subgid.
+func writeIDMappings(pid int, uids, gids []syscall.SysProcIDMap, denySetgroups bool) error {
+	if err := runIDMap("newuidmap", pid, uids); err != nil {
+		return err
+	}
+
+	if denySetgroups {
+		path := fmt.Sprintf("/proc/%d/setgroups", pid)
+		if err := ioutil.WriteFile(path, []byte("deny"), 0); err != nil {
+			return fmt.Errorf("unable to deny setgroups: %s", err)
+		}
+	}
+
+	return runIDMap("newgidmap", pid, gids)
+}
+
+func runIDMap(prog string, pid int, mappings []syscall.SysProcIDMap) error {
+	args := []string{strconv.Itoa(pid)}
+
+	for _, m := range mappings {
+		args = append(args, strconv.Itoa(m.ContainerID),
+			strconv.Itoa(m.HostID), strconv.Itoa(m.Size))
+	}
+
+	out, err := exec.Command(prog, args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s: %s: %s", prog, err, strings.TrimSpace(string(out)))
+	}
+
+	return nil
+}
+
+// waitIDMappings waits for the parent shell to write the mappings of
+// the rfork process, then executes it again with the args, without
+// rforkIDMap, followed by opts. It only returns on errors, or nil if
+// the parent shell gave up mapping the ids.
+func waitIDMappings(args, opts []string) error {
+	var b [1]byte
+
+	n, err := syscall.Read(rforkFd, b[:])
+	if err != nil {
+		return err
+	}
+
+	if n == 0 {
+		return nil
+	}
+
+	argv := append([]string{rforkArg0}, args[:2]...)
+	argv = append(argv, opts...)
+
+	return syscall.Exec("/proc/self/exe", argv, os.Environ())
+}
@@ -0,0 +1,103 @@
+// +build linux
+
+package sh
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+func TestRforkIDMapOptions(t *testing.T) {
+	options, err := parseRforkOptions([]string{
+		"uidmap=0:1000:1",
+		"uidmap=1:100000:65536",
+		"gidmap=0:1000:1",
+		"setgroups=allow",
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []syscall.SysProcIDMap{
+		{ContainerID: 0, HostID: 1000, Size: 1},
+		{ContainerID: 1, HostID: 100000, Size: 65536},
+	}
+
+	if !reflect.DeepEqual(options.uidMappings, expected) {
+		t.Errorf("expected uid mappings %v but got %v", expected, options.uidMappings)
+	}
+
+	if !reflect.DeepEqual(options.gidMappings, expected[:1]) {
+		t.Errorf("expected gid mappings %v but got %v", expected[:1], options.gidMappings)
+	}
+
+	if options.setgroups != "allow" {
+		t.Errorf("expected setgroups allow but got %q", options.setgroups)
+	}
+
+	if err := options.check(syscall.CLONE_NEWNS); err == nil {
+		t.Error("expected error with mappings but no user namespace")
+	}
+
+	for _, opt := range []string{
+		"uidmap=0:1000",
+		"uidmap=0:1000:0",
+		"gidmap=0:-1:1",
+		"gidmap=a:b:c",
+		"setgroups=yes",
+		"subids=yes",
+	} {
+		if _, err := parseRforkOptions([]string{opt}); err == nil {
+			t.Errorf("expected error parsing %s", opt)
+		}
+	}
+}
+
+func TestReadSubIDs(t *testing.T) {
+	f, err := ioutil.TempFile("", "nash-subuid")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+
+	_, err = f.WriteString(strings.Join([]string{
+		"# comment",
+		"other:100000:65536",
+		"user:165536:65536",
+		"",
+		"1000:300000:1000",
+	}, "\n"))
+	f.Close()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ranges, err := readSubIDs(f.Name(), "user", 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mappings := appendIDMappings([]syscall.SysProcIDMap{
+		{ContainerID: 0, HostID: 1000, Size: 1},
+	}, ranges)
+
+	expected := []syscall.SysProcIDMap{
+		{ContainerID: 0, HostID: 1000, Size: 1},
+		{ContainerID: 1, HostID: 165536, Size: 65536},
+		{ContainerID: 65537, HostID: 300000, Size: 1000},
+	}
+
+	if !reflect.DeepEqual(mappings, expected) {
+		t.Errorf("expected mappings %v but got %v", expected, mappings)
+	}
+
+	if _, err := readSubIDs(f.Name(), "nobody", 65534); err == nil {
+		t.Error("expected error for user without subordinate ids")
+	}
+}
@@ -0,0 +1,177 @@
+// +build ignore
+
+// mkseccomp generates the tables of system call numbers used by the
+// seccomp filters of rfork, from the Linux headers installed on the
+// system, preprocessed by cpp.
+//
+// Usage:
+//
+//	go run mkseccomp.go amd64|arm64
+//
+// The table is written to zseccomp_linux_<arch>.go.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"go/format"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type arch struct {
+	header string
+	flags  []string
+
+	// audit is the AUDIT_ARCH_* value of the architecture
+	audit string
+}
+
+var arches = map[string]arch{
+	"amd64": {
+		header: "asm/unistd_64.h",
+		audit:  "0xc000003e",
+	},
+	"arm64": {
+		// arm64 uses the generic table, with the system calls
+		// enabled by arch/arm64/include/uapi/asm/unistd.h
+		header: "asm-generic/unistd.h",
+		flags: []string{
+			"-D__BITS_PER_LONG=64",
+			"-D__ARCH_WANT_RENAMEAT",
+			"-D__ARCH_WANT_NEW_STAT",
+			"-D__ARCH_WANT_SET_GET_RLIMIT",
+			"-D__ARCH_WANT_TIME32_SYSCALLS",
+			"-D__ARCH_WANT_SYS_CLONE3",
+			"-D__ARCH_WANT_MEMFD_SECRET",
+		},
+		audit: "0xc00000b7",
+	},
+}
+
+type syscallNumber struct {
+	name string
+	nr   uint64
+}
+
+func main() {
+	if len(os.Args) != 2 {
+		fmt.Fprintf(os.Stderr, "usage: go run mkseccomp.go amd64|arm64\n")
+		os.Exit(2)
+	}
+
+	goarch := os.Args[1]
+
+	err := generate(goarch)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "mkseccomp: %s\n", err)
+		os.Exit(1)
+	}
+}
+
+func generate(goarch string) error {
+	a, ok := arches[goarch]
+	if !ok {
+		return fmt.Errorf("unsupported architecture %q", goarch)
+	}
+
+	defines, err := cppDefines(a.header, a.flags)
+	if err != nil {
+		return err
+	}
+
+	version, err := cppDefines("linux/version.h", nil)
+	if err != nil {
+		return err
+	}
+
+	var syscalls []syscallNumber
+
+	for name := range defines {
+		if !strings.HasPrefix(name, "__NR_") ||
+			name == "__NR_syscalls" || name == "__NR_arch_specific_syscall" {
+			continue
+		}
+
+		nr, err := resolve(defines, name)
+		if err != nil {
+			return err
+		}
+
+		syscalls = append(syscalls, syscallNumber{strings.TrimPrefix(name, "__NR_"), nr})
+	}
+
+	sort.Slice(syscalls, func(i, j int) bool {
+		return syscalls[i].nr < syscalls[j].nr
+	})
+
+	var buf bytes.Buffer
+
+	fmt.Fprintf(&buf, "// Code generated by mkseccomp.go from the headers of Linux %s.%s. DO NOT EDIT.\n\n",
+		version["LINUX_VERSION_MAJOR"], version["LINUX_VERSION_PATCHLEVEL"])
+	fmt.Fprintf(&buf, "// +build linux,%s\n\n", goarch)
+	fmt.Fprintf(&buf, "package sh\n\n")
+	fmt.Fprintf(&buf, "// seccompArch is the AUDIT_ARCH_* value of the architecture.\n")
+	fmt.Fprintf(&buf, "const seccompArch = %s\n\n", a.audit)
+	fmt.Fprintf(&buf, "// syscallNumbers maps the names of the system calls to their numbers.\n")
+	fmt.Fprintf(&buf, "var syscallNumbers = map[string]uint32{\n")
+	for _, s := range syscalls {
+		fmt.Fprintf(&buf, "\t%q: %d,\n", s.name, s.nr)
+	}
+	fmt.Fprintf(&buf, "}\n")
+
+	src, err := format.Source(buf.Bytes())
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile("zseccomp_linux_"+goarch+".go", src, 0644)
+}
+
+// cppDefines returns the macros defined by the header.
+func cppDefines(header string, flags []string) (map[string]string, error) {
+	cmd := exec.Command("cpp", append([]string{"-dM"}, flags...)...)
+	cmd.Stdin = strings.NewReader("#include <" + header + ">\n")
+	cmd.Stderr = os.Stderr
+
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("preprocessing %s: %s", header, err)
+	}
+
+	defines := make(map[string]string)
+
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		fields := strings.SplitN(scanner.Text(), " ", 3)
+		if len(fields) == 3 && fields[0] == "#define" {
+			defines[fields[1]] = strings.TrimSpace(fields[2])
+		}
+	}
+
+	return defines, scanner.Err()
+}
+
+// resolve returns the number of the macro name, defined as a number
+// or as another macro.
+func resolve(defines map[string]string, name string) (uint64, error) {
+	for i := 0; i < 10; i++ {
+		value, ok := defines[name]
+		if !ok {
+			return 0, fmt.Errorf("%s is not defined", name)
+		}
+
+		if nr, err := strconv.ParseUint(value, 0, 32); err == nil {
+			return nr, nil
+		}
+
+		name = value
+	}
+
+	return 0, fmt.Errorf("%s: too many indirections", name)
+}
@@ -0,0 +1,231 @@
+package sh
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"sync"
+
+	"github.com/madlambda/nash/errors"
+	"github.com/madlambda/nash/internal/sh/builtin"
+	"github.com/madlambda/nash/sh"
+)
+
+type (
+	// CmdMocks replaces commands by mocks and records every
+	// invocation of them. Mocks take precedence over the command
+	// resolver and over the commands found on PATH.
+	CmdMocks struct {
+		mu    sync.Mutex
+		mocks map[string]CmdFunc
+		calls map[string][][]string
+	}
+
+	mockFn struct {
+		mocks *CmdMocks
+		name  string
+		fn    sh.FnDef
+	}
+
+	mockCallsFn struct {
+		mocks *CmdMocks
+		name  string
+	}
+)
+
+// NewCmdMocks creates an empty set of mocks.
+func NewCmdMocks() *CmdMocks {
+	return &CmdMocks{
+		mocks: make(map[string]CmdFunc),
+		calls: make(map[string][][]string),
+	}
+}
+
+// Mock replaces the command name by fn.
+func (m *CmdMocks) Mock(name string, fn CmdFunc) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.mocks[name] = fn
+	m.calls[name] = nil
+}
+
+// Calls returns the arguments of every invocation of the mocked
+// command name, in order.
+func (m *CmdMocks) Calls(name string) [][]string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	calls := make([][]string, len(m.calls[name]))
+	copy(calls, m.calls[name])
+	return calls
+}
+
+func (m *CmdMocks) resolve(name string) CmdFunc {
+	m.mu.Lock()
+	fn, ok := m.mocks[name]
+	m.mu.Unlock()
+
+	if !ok {
+		return nil
+	}
+
+	return func(args []string, env []string, stdin io.Reader, stdout, stderr io.Writer) int {
+		m.mu.Lock()
+		m.calls[name] = append(m.calls[name], append([]string{}, args...))
+		m.mu.Unlock()
+
+		return fn(args, env, stdin, stdout, stderr)
+	}
+}
+
+// SetCmdMocks sets the mocks used to replace commands.
+func (shell *Shell) SetCmdMocks(mocks *CmdMocks) {
+	shell.mocks = mocks
+}
+
+// CmdMocks returns the mocks in use, or nil if there is none.
+func (shell *Shell) CmdMocks() *CmdMocks { return shell.mocks }
+
+// EnableTestMode adds the builtin functions used to write tests:
+//
+//	mock(name, fn)     replaces the command name by the function fn,
+//	                   that receives the command arguments and can
+//	                   return the exit status of the command.
+//	mock_calls(name)   returns a list with the arguments of each
+//	                   invocation of the mocked command.
+func (shell *Shell) EnableTestMode() {
+	if shell.mocks == nil {
+		shell.mocks = NewCmdMocks()
+	}
+
+	mocks := shell.mocks
+	constructors := map[string]builtin.Constructor{
+		"mock":       func() builtin.Fn { return &mockFn{mocks: mocks} },
+		"mock_calls": func() builtin.Fn { return &mockCallsFn{mocks: mocks} },
+	}
+
+	for name, constructor := range constructors {
+		fnDef := newBuiltinFnDef(name, shell, constructor)
+		shell.Newvar(name, sh.NewFnObj(fnDef))
+	}
+}
+
+// fnCmd returns a command implemented by the nash function fnDef.
+// The function receives the command arguments as strings and, if it
+// returns a value, it is used as the exit status.
+func fnCmd(fnDef sh.FnDef) CmdFunc {
+	return func(args []string, env []string, stdin io.Reader, stdout, stderr io.Writer) int {
+		objs := make([]sh.Obj, len(args))
+		for i, arg := range args {
+			objs[i] = sh.NewStrObj(arg)
+		}
+
+		fn := fnDef.Build()
+		err := fn.SetArgs(objs)
+		if err != nil {
+			fmt.Fprintf(stderr, "%s\n", err)
+			return ENotStarted
+		}
+
+		fn.SetStdin(stdin)
+		fn.SetStdout(stdout)
+		fn.SetStderr(stderr)
+
+		// the setters do not change the streams of the subshell
+		// running the body of user functions
+		if userfn, ok := fn.(*UserFn); ok {
+			userfn.subshell.SetStdin(stdin)
+			userfn.subshell.SetStdout(stdout)
+			userfn.subshell.SetStderr(stderr)
+		}
+
+		err = fn.Start()
+		if err == nil {
+			err = fn.Wait()
+		}
+		if err != nil {
+			fmt.Fprintf(stderr, "%s\n", err)
+			return 1
+		}
+
+		results := fn.Results()
+		if len(results) == 0 {
+			return 0
+		}
+
+		status, err := strconv.Atoi(results[0].String())
+		if err != nil {
+			fmt.Fprintf(stderr, "mock %s: invalid exit status %q\n",
+				fnDef.Name(), results[0].String())
+			return 1
+		}
+		return status
+	}
+}
+
+func (m *mockFn) ArgNames() []sh.FnArg {
+	return []sh.FnArg{
+		sh.NewFnArg("name", false),
+		sh.NewFnArg("fn", false),
+	}
+}
+
+func (m *mockFn) SetArgs(args []sh.Obj) error {
+	if len(args) != 2 {
+		return errors.NewError("mock expects 2 arguments")
+	}
+
+	if args[0].Type() != sh.StringType {
+		return errors.NewError("mock expects a command name string, but a %s was provided",
+			args[0].Type())
+	}
+
+	if args[1].Type() != sh.FnType {
+		return errors.NewError("mock expects a function, but a %s was provided",
+			args[1].Type())
+	}
+
+	m.name = args[0].(*sh.StrObj).Str()
+	m.fn = args[1].(*sh.FnObj).Fn()
+	return nil
+}
+
+func (m *mockFn) Run(in io.Reader, out io.Writer, err io.Writer) ([]sh.Obj, error) {
+	m.mocks.Mock(m.name, fnCmd(m.fn))
+	return nil, nil
+}
+
+func (m *mockCallsFn) ArgNames() []sh.FnArg {
+	return []sh.FnArg{
+		sh.NewFnArg("name", false),
+	}
+}
+
+func (m *mockCallsFn) SetArgs(args []sh.Obj) error {
+	if len(args) != 1 {
+		return errors.NewError("mock_calls expects 1 argument")
+	}
+
+	if args[0].Type() != sh.StringType {
+		return errors.NewError("mock_calls expects a command name string, but a %s was provided",
+			args[0].Type())
+	}
+
+	m.name = args[0].(*sh.StrObj).Str()
+	return nil
+}
+
+func (m *mockCallsFn) Run(in io.Reader, out io.Writer, err io.Writer) ([]sh.Obj, error) {
+	var calls []sh.Obj
+
+	for _, args := range m.mocks.Calls(m.name) {
+		objs := make([]sh.Obj, len(args))
+		for i, arg := range args {
+			objs[i] = sh.NewStrObj(arg)
+		}
+		calls = append(calls, sh.NewListObj(objs))
+	}
+
+	return []sh.Obj{sh.NewListObj(calls)}, nil
+}
@@ -0,0 +1,131 @@
+package sh_test
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/madlambda/nash/internal/sh"
+)
+
+func TestCmdResolver(t *testing.T) {
+	f, teardown := setup(t)
+	defer teardown()
+
+	f.shell.SetCmdResolver(func(name string) sh.CmdFunc {
+		switch name {
+		case "greet":
+			return func(args []string, env []string, stdin io.Reader, stdout, stderr io.Writer) int {
+				fmt.Fprintf(stdout, "hello %s\n", strings.Join(args, " "))
+				return 0
+			}
+		case "upper":
+			return func(args []string, env []string, stdin io.Reader, stdout, stderr io.Writer) int {
+				data, _ := ioutil.ReadAll(stdin)
+				fmt.Fprint(stdout, strings.ToUpper(string(data)))
+				return 0
+			}
+		case "broken":
+			return func(args []string, env []string, stdin io.Reader, stdout, stderr io.Writer) int {
+				return 42
+			}
+		}
+		return nil
+	})
+
+	err := f.shell.Exec("resolver", `
+greet world
+greet nash | upper
+var _, status <= broken
+echo $status
+echo from path
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "hello world\nHELLO NASH\n42\nfrom path\n"
+	if f.shellOut.String() != expected {
+		t.Fatalf("expected %q but got %q", expected, f.shellOut.String())
+	}
+}
+
+func TestCmdMocks(t *testing.T) {
+	f, teardown := setup(t)
+	defer teardown()
+
+	f.shell.EnableTestMode()
+
+	err := f.shell.Exec("mocks", `
+fn fake_git(args...) {
+	echo git $args
+	if $args[0] == "push" {
+		return "1"
+	}
+}
+
+mock("git", $fake_git)
+
+git status -s
+var _, status <= git push origin master
+echo $status
+
+var calls <= mock_calls("git")
+for call in $calls {
+	echo $call
+}
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "git status -s\n1\nstatus -s\npush origin master\n"
+	if f.shellOut.String() != expected {
+		t.Fatalf("expected %q but got %q", expected, f.shellOut.String())
+	}
+
+	calls := f.shell.CmdMocks().Calls("git")
+	expectedCalls := [][]string{
+		{"status", "-s"},
+		{"push", "origin", "master"},
+	}
+	if !reflect.DeepEqual(calls, expectedCalls) {
+		t.Fatalf("expected calls %v but got %v", expectedCalls, calls)
+	}
+}
+
+func TestCmdMocksPipe(t *testing.T) {
+	f, teardown := setup(t)
+	defer teardown()
+
+	f.shell.EnableTestMode()
+
+	err := f.shell.Exec("mocks", `
+fn fake_git(args...) {
+	echo git $args
+}
+
+fn fake_upper() {
+	tr a-z A-Z
+}
+
+mock("git", $fake_git)
+mock("upper", $fake_upper)
+
+git log | tr a-z A-Z
+echo nash | upper
+var out <= git status
+echo $out
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "GIT LOG\nNASH\ngit status\n"
+	if f.shellOut.String() != expected {
+		t.Fatalf("expected %q but got %q", expected, f.shellOut.String())
+	}
+}
@@ -0,0 +1,250 @@
+package sh
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/madlambda/nash/ast"
+)
+
+type (
+	// Profiler records the wall time spent executing each statement
+	// and each function call. The time spent waiting for child
+	// processes is accounted separately from the time spent inside
+	// the interpreter.
+	// A single Profiler is shared by a shell and all of its sub-shells.
+	Profiler struct {
+		mu      sync.Mutex
+		entries map[string]*ProfileEntry
+		stacks  map[string]time.Duration
+	}
+
+	// ProfileEntry is the profile of a statement or function.
+	ProfileEntry struct {
+		Name  string // location and description of the statement, or the function name
+		Calls int
+
+		Cum  time.Duration // total time, including nested statements
+		Self time.Duration // time spent in the interpreter itself
+		Exec time.Duration // time spent waiting for child processes
+	}
+
+	profFrame struct {
+		name  string
+		stack string
+		start time.Time
+		child time.Duration
+		exec  time.Duration
+	}
+)
+
+// NewProfiler creates an empty profiler.
+func NewProfiler() *Profiler {
+	return &Profiler{
+		entries: make(map[string]*ProfileEntry),
+		stacks:  make(map[string]time.Duration),
+	}
+}
+
+// Entries returns the profile entries sorted by cumulative time.
+func (p *Profiler) Entries() []ProfileEntry {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	entries := make([]ProfileEntry, 0, len(p.entries))
+	for _, e := range p.entries {
+		entries = append(entries, *e)
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Cum == entries[j].Cum {
+			return entries[i].Name < entries[j].Name
+		}
+		return entries[i].Cum > entries[j].Cum
+	})
+	return entries
+}
+
+// WriteReport writes a human readable report of the profile, sorted by
+// cumulative time.
+func (p *Profiler) WriteReport(w io.Writer) error {
+	_, err := fmt.Fprintf(w, "%12s %12s %12s %8s  %s\n",
+		"cum(ms)", "self(ms)", "exec(ms)", "calls", "statement")
+	if err != nil {
+		return err
+	}
+
+	ms := func(d time.Duration) float64 {
+		return float64(d) / float64(time.Millisecond)
+	}
+
+	for _, e := range p.Entries() {
+		_, err := fmt.Fprintf(w, "%12.3f %12.3f %12.3f %8d  %s\n",
+			ms(e.Cum), ms(e.Self), ms(e.Exec), e.Calls, e.Name)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteFolded writes the profile in the folded stacks format, one
+// stack per line followed by the time spent in microseconds.
+// The output can be used as input to flamegraph.pl or speedscope.
+// Time spent in child processes is reported as an "[exec]" frame.
+func (p *Profiler) WriteFolded(w io.Writer) error {
+	p.mu.Lock()
+	stacks := make([]string, 0, len(p.stacks))
+	for stack := range p.stacks {
+		stacks = append(stacks, stack)
+	}
+	sort.Strings(stacks)
+
+	lines := make([]string, 0, len(stacks))
+	for _, stack := range stacks {
+		lines = append(lines, fmt.Sprintf("%s %d", stack,
+			p.stacks[stack]/time.Microsecond))
+	}
+	p.mu.Unlock()
+
+	for _, line := range lines {
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *Profiler) record(f *profFrame, parent *profFrame) {
+	elapsed := time.Since(f.start)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	self := elapsed - f.child - f.exec
+	if self < 0 {
+		self = 0
+	}
+
+	e, ok := p.entries[f.name]
+	if !ok {
+		e = &ProfileEntry{Name: f.name}
+		p.entries[f.name] = e
+	}
+
+	e.Calls++
+	e.Cum += elapsed
+	e.Self += self
+	e.Exec += f.exec
+
+	p.stacks[f.stack] += self
+	if f.exec > 0 {
+		p.stacks[f.stack+";[exec]"] += f.exec
+	}
+
+	if parent != nil {
+		parent.child += elapsed
+	}
+}
+
+func (p *Profiler) addExec(f *profFrame, d time.Duration) {
+	p.mu.Lock()
+	f.exec += d
+	p.mu.Unlock()
+}
+
+// SetProfiler enables profiling of everything executed by the shell.
+// A nil profiler disables profiling.
+func (shell *Shell) SetProfiler(p *Profiler) {
+	shell.profiler = p
+}
+
+// Profiler returns the profiler in use or nil if profiling is disabled.
+func (shell *Shell) Profiler() *Profiler { return shell.profiler }
+
+// profEnter pushes a new frame on the shell call stack.
+func (shell *Shell) profEnter(name string) *profFrame {
+	stack := name
+	if len(shell.frames) > 0 {
+		stack = shell.frames[len(shell.frames)-1].stack + ";" + name
+	}
+
+	f := &profFrame{
+		name:  name,
+		stack: stack,
+		start: time.Now(),
+	}
+
+	shell.frames = append(shell.frames, f)
+	return f
+}
+
+// profLeave pops the frame f and records its timing.
+func (shell *Shell) profLeave(f *profFrame) {
+	var parent *profFrame
+
+	shell.frames = shell.frames[:len(shell.frames)-1]
+	if len(shell.frames) > 0 {
+		parent = shell.frames[len(shell.frames)-1]
+	}
+
+	shell.profiler.record(f, parent)
+}
+
+// profExec accounts d as time spent waiting for child processes.
+func (shell *Shell) profExec(d time.Duration) {
+	if shell.profiler == nil || len(shell.frames) == 0 {
+		return
+	}
+
+	shell.profiler.addExec(shell.frames[len(shell.frames)-1], d)
+}
+
+// callFrames returns a copy of the current call stack to be inherited
+// by the sub-shell of a function invocation.
+func (shell *Shell) callFrames() []*profFrame {
+	frames := make([]*profFrame, len(shell.frames))
+	copy(frames, shell.frames)
+	return frames
+}
+
+var profNodeDesc = map[ast.NodeType]string{
+	ast.NodeImport:            "import",
+	ast.NodeSetenv:            "setenv",
+	ast.NodeVarAssignDecl:     "var",
+	ast.NodeVarExecAssignDecl: "var",
+	ast.NodeAssign:            "assign",
+	ast.NodeExecAssign:        "assign",
+	ast.NodeRfork:             "rfork",
+	ast.NodeIf:                "if",
+	ast.NodeFor:               "for",
+	ast.NodeBindFn:            "bindfn",
+	ast.NodeReturn:            "return",
+}
+
+func profNodeName(filename string, node ast.Node) string {
+	var desc string
+
+	switch n := node.(type) {
+	case *ast.CommandNode:
+		desc = "cmd " + n.Name()
+	case *ast.PipeNode:
+		var names []string
+		for _, c := range n.Commands() {
+			names = append(names, c.Name())
+		}
+		desc = "pipe " + strings.Join(names, " | ")
+	case *ast.FnInvNode:
+		desc = "call " + n.Name()
+	case *ast.FnDeclNode:
+		desc = "fn decl " + n.Name()
+	default:
+		desc = profNodeDesc[node.Type()]
+	}
+
+	return fmt.Sprintf("%s:%d %s", filename, node.Line(), desc)
+}
@@ -0,0 +1,66 @@
+package sh_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/madlambda/nash/internal/sh"
+)
+
+func TestProfile(t *testing.T) {
+	f, teardown := setup(t)
+	defer teardown()
+
+	prof := sh.NewProfiler()
+	f.shell.SetProfiler(prof)
+
+	script := filepath.Join(f.envDirs.Path, "prof.sh")
+	err := ioutil.WriteFile(script, []byte(`fn greet(name) {
+	echo hello $name
+}
+
+for n in (a b c) {
+	greet($n)
+}
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = f.shell.ExecFile(script)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	calls := map[string]int{}
+	for _, e := range prof.Entries() {
+		calls[e.Name] = e.Calls
+	}
+
+	for name, want := range map[string]int{
+		script + ":5 for":        1,
+		script + ":6 call greet": 3,
+		"fn greet":               3,
+		script + ":2 cmd echo":   3,
+	} {
+		if calls[name] != want {
+			t.Errorf("%s: expected %d calls but got %d (profile: %v)",
+				name, want, calls[name], calls)
+		}
+	}
+
+	var folded bytes.Buffer
+	err = prof.WriteFolded(&folded)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stack := script + ":5 for;" + script + ":6 call greet;fn greet;" +
+		script + ":2 cmd echo;[exec]"
+	if !strings.Contains(folded.String(), stack+" ") {
+		t.Errorf("folded stack %q not found in:\n%s", stack, folded.String())
+	}
+}
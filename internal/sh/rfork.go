@@ -6,8 +6,9 @@ package sh
 import (
 	"github.com/madlambda/nash/ast"
 	"github.com/madlambda/nash/errors"
+	"github.com/madlambda/nash/sh"
 )
 
-func (sh *Shell) executeRfork(rfork *ast.RforkNode) error {
-	return errors.NewError("rfork only supported on Linux and Plan9")
+func (shell *Shell) executeRfork(rfork *ast.RforkNode) ([]sh.Obj, error) {
+	return nil, errors.NewError("rfork only supported on Linux and Plan9")
 }
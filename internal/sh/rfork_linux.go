@@ -6,16 +6,26 @@ package sh
 import (
 	"fmt"
 	"io"
-	"net"
+	"io/ioutil"
 	"os"
 	"os/exec"
+	"os/user"
+	"runtime"
 	"strconv"
+	"strings"
 	"syscall"
-	"time"
 
 	"github.com/madlambda/nash/ast"
+	"github.com/madlambda/nash/errors"
+	"github.com/madlambda/nash/internal/rpc"
+	"github.com/madlambda/nash/internal/sh/builtin"
+	"github.com/madlambda/nash/sh"
+	"github.com/madlambda/nash/token"
 )
 
+// cloneNewTime is the flag of the time namespace, missing in syscall.
+const cloneNewTime = 0x80
+
 func getProcAttrs(flags uintptr) *syscall.SysProcAttr {
 	uid := os.Getuid()
 	gid := os.Getgid()
@@ -24,6 +34,13 @@ func getProcAttrs(flags uintptr) *syscall.SysProcAttr {
 		Cloneflags: flags,
 	}
 
+	// clone takes the time namespace flag as part of the exit signal,
+	// so the child unshares it, entering it when it executes.
+	if flags&cloneNewTime != 0 {
+		sysproc.Cloneflags &^= cloneNewTime
+		sysproc.Unshareflags = cloneNewTime
+	}
+
 	if (flags & syscall.CLONE_NEWUSER) == syscall.CLONE_NEWUSER {
 		sysproc.UidMappings = []syscall.SysProcIDMap{
 			{
@@ -45,63 +62,610 @@ func getProcAttrs(flags uintptr) *syscall.SysProcAttr {
 	return sysproc
 }
 
-func dialRc(sockpath string) (net.Conn, error) {
-	retries := 0
+// rforkArg0 is the name, os.Args[0], of the processes that run the
+// rfork blocks.
+const rforkArg0 = "-nash-rfork-"
+
+// rforkFd is the file descriptor, inherited by the rfork process, of
+// its end of the socket pair connecting it to the parent shell.
+const rforkFd = 3
 
-retryRforkDial:
-	client, err := net.Dial("unix", sockpath)
+// The rfork blocks are run by executing the program again, so any
+// program embedding nash can rfork. The process exits after serving
+// the block, before the main function of the program runs.
+func init() {
+	if os.Args[0] == rforkArg0 {
+		os.Exit(serveRfork(os.Args[1:]))
+	}
+}
+
+// rforkMounts is the argument telling the rfork process it runs in a
+// new mount namespace, its own or of an enclosing rfork block.
+const rforkMounts = "mounts"
+
+// rforkNetwork is the argument telling the rfork process it runs in a
+// new network namespace, its own or of an enclosing rfork block.
+const rforkNetwork = "network"
+
+// rforkParentNetlink is the argument telling the rfork process it
+// inherited, as rforkNetlinkFd, a netlink socket on the network
+// namespace of the parent shell, passed only with the veth option.
+const rforkParentNetlink = "parentnetlink"
+
+// rforkNetlinkFd is the file descriptor of the netlink socket passed
+// with rforkParentNetlink.
+const rforkNetlinkFd = 4
+
+// rforkRootfs is the prefix of the argument telling the rfork process
+// the directory where it mounts its root filesystem, that the parent
+// shell removes after the block ends.
+const rforkRootfs = "rootfs:"
+
+// rforkIDMap is the argument telling the rfork process to wait for the
+// parent shell to write its uid and gid mappings, with newuidmap and
+// newgidmap, and then execute itself again to get the capabilities of
+// root in its user namespace.
+const rforkIDMap = "idmap"
+
+// rforkOptions are the options of a rfork block, given as a list of
+// "name=value" strings after the flags.
+type rforkOptions struct {
+	// propagation is the mount propagation type set recursively on
+	// the mounts of the new mount namespace, or zero to keep the one
+	// copied from the parent namespace.
+	propagation uintptr
+
+	// uidMappings and gidMappings replace the default mappings of the
+	// new user namespace, of the current uid and gid to root.
+	uidMappings, gidMappings []syscall.SysProcIDMap
+
+	// subids adds the subordinate ids of the user, from /etc/subuid
+	// and /etc/subgid, to the mappings.
+	subids bool
+
+	// setgroups allows or denies (the default) the setgroups system
+	// call in the new user namespace.
+	setgroups string
+
+	// limits are set on a new cgroup v2 for the processes of the
+	// block.
+	limits []cgroupLimit
+
+	// noNewPrivs, dropCaps and seccomp restrict the rfork process,
+	// and so the programs it executes, before it runs the block.
+	noNewPrivs bool
+	dropCaps   []int
+	seccomp    []syscall.SockFilter
+
+	// veth passes to the block a netlink socket on the network
+	// namespace of the parent shell, where the veth builtin creates
+	// the peer interfaces.
+	veth bool
+
+	// rootfs is the tar file or OCI image layout unpacked on the new
+	// root filesystem of the block, where binds are made visible.
+	rootfs string
+	binds  []rforkBind
+
+	// exe is the executable of the rfork process, open before it
+	// changed its root filesystem.
+	exe *os.File
+}
+
+var rforkPropagations = map[string]uintptr{
+	"private":    syscall.MS_PRIVATE,
+	"slave":      syscall.MS_SLAVE,
+	"shared":     syscall.MS_SHARED,
+	"unbindable": syscall.MS_UNBINDABLE,
+}
+
+func parseRforkOptions(opts []string) (*rforkOptions, error) {
+	var options rforkOptions
+
+	for _, opt := range opts {
+		name, value := opt, ""
+		if i := strings.Index(opt, "="); i >= 0 {
+			name, value = opt[:i], opt[i+1:]
+		}
+
+		switch name {
+		case "propagation":
+			propagation, ok := rforkPropagations[value]
+			if !ok {
+				return nil, fmt.Errorf("Invalid rfork propagation %q, expected private, slave, shared or unbindable", value)
+			}
+			options.propagation = propagation
+		case "uidmap", "gidmap":
+			idmap, err := parseIDMap(value)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid rfork %s %q: %s", name, value, err)
+			}
+
+			if name == "uidmap" {
+				options.uidMappings = append(options.uidMappings, idmap)
+			} else {
+				options.gidMappings = append(options.gidMappings, idmap)
+			}
+		case "subids":
+			if value != "" {
+				return nil, fmt.Errorf("Rfork option subids has no value: %s", opt)
+			}
+			options.subids = true
+		case "setgroups":
+			if value != "allow" && value != "deny" {
+				return nil, fmt.Errorf("Invalid rfork setgroups %q, expected allow or deny", value)
+			}
+			options.setgroups = value
+		case "no_new_privs":
+			if value != "" {
+				return nil, fmt.Errorf("Rfork option no_new_privs has no value: %s", opt)
+			}
+			options.noNewPrivs = true
+		case "dropcaps":
+			caps, err := parseCaps(value)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid rfork dropcaps %q: %s", value, err)
+			}
+			options.dropCaps = append(options.dropCaps, caps...)
+		case "seccomp":
+			if value == "" {
+				return nil, fmt.Errorf("Rfork option seccomp requires default or the path of a profile")
+			}
+
+			filter, err := loadSeccomp(value)
+			if err != nil {
+				return nil, err
+			}
+			options.seccomp = filter
+		case "veth":
+			if value != "" {
+				return nil, fmt.Errorf("Rfork option veth has no value: %s", opt)
+			}
+			options.veth = true
+		case "rootfs":
+			if value == "" {
+				return nil, fmt.Errorf("Rfork option rootfs requires a tar file or an OCI image layout")
+			}
+			options.rootfs = value
+		case "bind":
+			b, err := parseRforkBind(value)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid rfork bind %q: %s", value, err)
+			}
+			options.binds = append(options.binds, b)
+		default:
+			if !cgroupLimits[name] {
+				return nil, fmt.Errorf("Wrong rfork option: %s", opt)
+			}
+
+			if value == "" {
+				return nil, fmt.Errorf("Rfork option %s requires a value", name)
+			}
+
+			options.limits = append(options.limits, cgroupLimit{name: name, value: value})
+		}
+	}
+
+	return &options, nil
+}
+
+// check returns an error if an option needs a namespace not in flags.
+func (options *rforkOptions) check(flags uintptr) error {
+	if options.propagation != 0 && flags&syscall.CLONE_NEWNS == 0 {
+		return fmt.Errorf("Rfork propagation option requires the m flag")
+	}
+
+	if options.customIDs() && flags&syscall.CLONE_NEWUSER == 0 {
+		return fmt.Errorf("Rfork uidmap, gidmap, subids and setgroups options require the u flag")
+	}
+
+	if options.veth && flags&syscall.CLONE_NEWNET == 0 {
+		return fmt.Errorf("Rfork veth option requires the n flag")
+	}
+
+	// the capabilities of a new user namespace do not allow changing
+	// the network namespace of the parent shell
+	if options.veth && flags&syscall.CLONE_NEWUSER != 0 {
+		return fmt.Errorf("Rfork veth option can not be used with the u flag")
+	}
 
+	if options.rootfs != "" && flags&syscall.CLONE_NEWNS == 0 {
+		return fmt.Errorf("Rfork rootfs option requires the m flag")
+	}
+
+	if options.binds != nil && options.rootfs == "" {
+		return fmt.Errorf("Rfork bind option requires the rootfs option")
+	}
+
+	// pivot_root requires the mounts not shared
+	if options.rootfs != "" && options.propagation == syscall.MS_SHARED {
+		return fmt.Errorf("Rfork rootfs option can not be used with propagation=shared")
+	}
+
+	return nil
+}
+
+// customIDs tells if the options change the default mappings of the
+// user namespace.
+func (options *rforkOptions) customIDs() bool {
+	return options.uidMappings != nil || options.gidMappings != nil ||
+		options.subids || options.setgroups != ""
+}
+
+// idMappings returns the uid and gid mappings of the user namespace.
+func (options *rforkOptions) idMappings() ([]syscall.SysProcIDMap, []syscall.SysProcIDMap, error) {
+	uids, gids := options.uidMappings, options.gidMappings
+
+	if uids == nil {
+		uids = []syscall.SysProcIDMap{{ContainerID: 0, HostID: os.Getuid(), Size: 1}}
+	}
+
+	if gids == nil {
+		gids = []syscall.SysProcIDMap{{ContainerID: 0, HostID: os.Getgid(), Size: 1}}
+	}
+
+	if !options.subids {
+		return uids, gids, nil
+	}
+
+	name := strconv.Itoa(os.Getuid())
+	if u, err := user.Current(); err == nil {
+		name = u.Username
+	}
+
+	subuids, err := readSubIDs(subuidPath, name, os.Getuid())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	subgids, err := readSubIDs(subgidPath, name, os.Getuid())
 	if err != nil {
-		if retries < 3 {
-			retries++
-			time.Sleep(time.Duration(retries) * time.Second)
-			goto retryRforkDial
+		return nil, nil, err
+	}
+
+	return appendIDMappings(uids, subuids), appendIDMappings(gids, subgids), nil
+}
+
+// setup prepares the rfork process before it runs the block, with its
+// root filesystem mounted on rootDir.
+func (options *rforkOptions) setup(rootDir string) error {
+	propagation := options.propagation
+
+	// the root filesystem does not show up outside of the namespace
+	if propagation == 0 && options.rootfs != "" {
+		propagation = syscall.MS_PRIVATE
+	}
+
+	if propagation != 0 {
+		err := syscall.Mount("", "/", "", syscall.MS_REC|propagation, "")
+		if err != nil {
+			return fmt.Errorf("unable to change the mount propagation: %s", err)
+		}
+	}
+
+	if options.rootfs != "" {
+		// there is no /proc on the new root filesystem to execute the
+		// process again with the restrictions
+		if options.restricted() {
+			exe, err := os.Open("/proc/self/exe")
+			if err != nil {
+				return err
+			}
+			options.exe = exe
+		}
+
+		if err := setupRootfs(rootDir, options.rootfs, options.binds); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// restricted tells if the options restrict the rfork process.
+func (options *rforkOptions) restricted() bool {
+	return options.noNewPrivs || options.dropCaps != nil || options.seccomp != nil
+}
+
+// restrict applies the restrictions of the options to the calling
+// thread and executes the rfork process again, with the args, so all
+// of its threads are restricted. It only returns on errors.
+func (options *rforkOptions) restrict(args []string) error {
+	runtime.LockOSThread()
+
+	if options.noNewPrivs {
+		if err := setNoNewPrivs(); err != nil {
+			return err
+		}
+	}
+
+	// the filter can be installed without no_new_privs only with
+	// CAP_SYS_ADMIN, so before dropping it
+	if options.seccomp != nil {
+		if err := installSeccomp(options.seccomp); err != nil {
+			return err
 		}
 	}
 
-	return client, err
+	if options.dropCaps != nil {
+		if err := dropCaps(options.dropCaps); err != nil {
+			return err
+		}
+	}
+
+	// there is no /proc on the new root filesystem
+	if options.exe != nil {
+		return execFile(options.exe, args, os.Environ())
+	}
+
+	return syscall.Exec("/proc/self/exe", args, os.Environ())
 }
 
-// executeRfork executes the calling program again but passing
-// a new name for the process on os.Args[0] and passing an unix
-// socket file to communicate to.
-func (sh *Shell) executeRfork(rfork *ast.RforkNode) error {
+// serveRfork executes the statements sent by the parent shell, that
+// passes its nashpath and nashroot as args, followed by rforkMounts,
+// rforkNetwork and rforkParentNetlink if needed and the options of the
+// block, and returns the process exit status. The rforkRootfs argument
+// precedes the options too, if the block has a root filesystem.
+func serveRfork(args []string) int {
+	if len(args) < 2 {
+		fmt.Fprintf(os.Stderr, "%s: expected nashpath and nashroot as arguments\n", rforkArg0)
+		return 2
+	}
+
+	opts := args[2:]
+	if len(opts) > 0 && opts[0] == rforkIDMap {
+		if err := waitIDMappings(args, opts[1:]); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", rforkArg0, err)
+		}
+		return 1
+	}
+
 	var (
-		tr               *ast.Tree
-		i                int
-		nashClient       net.Conn
-		copyOut, copyErr bool
+		rootDir       string
+		parentNetlink bool
 	)
 
-	if sh.stdout != os.Stdout {
+markers:
+	for len(opts) > 0 {
+		switch opt := opts[0]; {
+		case strings.HasPrefix(opt, rforkRootfs):
+			rootDir = opt[len(rforkRootfs):]
+		case opt == rforkMounts:
+			builtin.EnableMounts()
+		case opt == rforkNetwork:
+			builtin.EnableNetwork()
+		case opt == rforkParentNetlink:
+			builtin.SetParentNetlink(rforkNetlinkFd)
+			parentNetlink = true
+		default:
+			break markers
+		}
+
+		opts = opts[1:]
+	}
+
+	markers := args[2 : len(args)-len(opts)]
+
+	options, err := parseRforkOptions(opts)
+	if err == nil {
+		err = options.setup(rootDir)
+	}
+
+	// the options are already applied, so they are not passed again
+	if err == nil && options.restricted() {
+		argv := append([]string{rforkArg0}, args[:2]...)
+		err = options.restrict(append(argv, markers...))
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", rforkArg0, err)
+		return 1
+	}
+
+	// the socket is kept across the restrict execution above, but
+	// not passed to the commands of the block
+	if parentNetlink {
+		syscall.CloseOnExec(rforkNetlinkFd)
+	}
+
+	nashpath, nashroot := args[0], args[1]
+
+	// the directories of the parent shell are not on the new root
+	if rootDir != "" {
+		nashpath, nashroot = "", ""
+	}
+
+	shell, err := NewShell(nashpath, nashroot)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", rforkArg0, err)
+		return 1
+	}
+
+	conn := os.NewFile(rforkFd, "rfork")
+	defer conn.Close()
+
+	// the block can return values, like a function
+	shell.SetIsFn(true)
+
+	err = rpc.Serve(conn, func(tree *ast.Tree) ([]sh.Obj, bool, error) {
+		// errors refer to the file of the parent shell
+		shell.filename = tree.Name
+
+		values, err := shell.executeTree(tree, false)
+		if _, ok := err.(*errStopWalking); ok {
+			return values, true, nil
+		}
+		return nil, false, err
+	})
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", rforkArg0, err)
+		return 1
+	}
+	return 0
+}
+
+// executeRfork executes the calling program again, or the one set by
+// SetNashdPath, with new namespaces, passing the name rforkArg0 on
+// os.Args[0] and one end of a socket pair to communicate to. It returns
+// the values returned by the rfork block.
+func (shell *Shell) executeRfork(rfork *ast.RforkNode) (_ []sh.Obj, err error) {
+	var copyOut, copyErr bool
+
+	if shell.stdout != os.Stdout {
 		copyOut = true
 	}
 
-	if sh.stderr != os.Stderr {
+	if shell.stderr != os.Stderr {
 		copyErr = true
 	}
 
-	if sh.nashdPath == "" {
-		return fmt.Errorf("Nashd not set")
+	path := shell.nashdPath
+	if path == "" {
+		path = "/proc/self/exe"
 	}
 
-	unixfile := "/tmp/nash." + randRunes(4) + ".sock"
-
-	cmd := exec.Cmd{
-		Path: sh.nashdPath,
-		Args: append([]string{"-nashd-"}, "-noinit", "-addr", unixfile),
-		Env:  buildenv(sh.Environ()),
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM|syscall.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create rfork socket pair: %s", err)
 	}
 
+	conn := os.NewFile(uintptr(fds[0]), "rfork")
+	child := os.NewFile(uintptr(fds[1]), "rfork")
+
+	defer conn.Close()
+	defer child.Close()
+
 	arg := rfork.Arg()
 
 	forkFlags, err := getflags(arg.Value())
 
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	opts, err := shell.evalRforkOptions(rfork)
+	if err != nil {
+		return nil, err
+	}
+
+	options, err := parseRforkOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := options.check(forkFlags); err != nil {
+		return nil, err
+	}
+
+	sysproc := getProcAttrs(forkFlags)
+
+	var (
+		uids, gids []syscall.SysProcIDMap
+		newidmap   bool
+	)
+
+	if options.customIDs() {
+		uids, gids, err = options.idMappings()
+		if err != nil {
+			return nil, err
+		}
+
+		// only root can write mappings other than its own ids
+		// and allow setgroups, the others need the setuid helpers
+		newidmap = os.Geteuid() != 0
+
+		if newidmap {
+			sysproc.UidMappings, sysproc.GidMappings = nil, nil
+		} else {
+			sysproc.UidMappings, sysproc.GidMappings = uids, gids
+			sysproc.GidMappingsEnableSetgroups = options.setgroups == "allow"
+		}
+	}
+
+	args := []string{rforkArg0, shell.nashpath, shell.nashroot}
+	if newidmap {
+		args = append(args, rforkIDMap)
+	}
+	if builtin.MountsEnabled() || forkFlags&syscall.CLONE_NEWNS != 0 {
+		args = append(args, rforkMounts)
+	}
+	if builtin.NetworkEnabled() || forkFlags&syscall.CLONE_NEWNET != 0 {
+		args = append(args, rforkNetwork)
+	}
+
+	extraFiles := []*os.File{child}
+
+	// the rfork process can only create interfaces on the network
+	// namespace of the parent shell through a socket opened on it
+	if options.veth {
+		fd, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_RAW|syscall.SOCK_CLOEXEC, syscall.NETLINK_ROUTE)
+		if err != nil {
+			return nil, fmt.Errorf("unable to open netlink socket: %s", err)
+		}
+
+		netlink := os.NewFile(uintptr(fd), "netlink")
+		defer netlink.Close()
+
+		args = append(args, rforkParentNetlink)
+		extraFiles = append(extraFiles, netlink)
+	}
+
+	// the root filesystem is mounted only on the namespace of the
+	// rfork process, leaving its mount point empty here
+	if options.rootfs != "" {
+		rootDir, err := ioutil.TempDir("", "nash-rootfs-")
+		if err != nil {
+			return nil, err
+		}
+		defer os.Remove(rootDir)
+
+		args = append(args, rforkRootfs+rootDir)
+	}
+
+	args = append(args, opts...)
+
+	var cg *cgroup
+
+	if len(options.limits) > 0 {
+		cg, err = newCgroup(options.limits)
+		if err != nil {
+			return nil, err
+		}
+
+		defer func() {
+			rerr := cg.remove()
+			if rerr == nil {
+				return
+			}
+
+			if err == nil {
+				err = rerr
+			} else {
+				fmt.Fprintf(shell.stderr, "%s\n", rerr)
+			}
+		}()
+
+		// the rfork process is created in the cgroup, to not run
+		// any of the block outside of it
+		dir, err := cg.open()
+		if err != nil {
+			return nil, err
+		}
+		defer dir.Close()
+
+		sysproc.UseCgroupFD = true
+		sysproc.CgroupFD = int(dir.Fd())
+	}
+
+	cmd := exec.Cmd{
+		Path:       path,
+		Args:       args,
+		Env:        buildenv(shell.Environ()),
+		ExtraFiles: extraFiles,
 	}
 
-	cmd.SysProcAttr = getProcAttrs(forkFlags)
+	cmd.SysProcAttr = sysproc
 
 	stdoutDone := make(chan bool)
 	stderrDone := make(chan bool)
@@ -114,10 +678,10 @@ func (sh *Shell) executeRfork(rfork *ast.RforkNode) error {
 		stdout, err = cmd.StdoutPipe()
 
 		if err != nil {
-			return err
+			return nil, err
 		}
 	} else {
-		cmd.Stdout = sh.stdout
+		cmd.Stdout = shell.stdout
 		close(stdoutDone)
 	}
 
@@ -125,26 +689,26 @@ func (sh *Shell) executeRfork(rfork *ast.RforkNode) error {
 		stderr, err = cmd.StderrPipe()
 
 		if err != nil {
-			return err
+			return nil, err
 		}
 	} else {
-		cmd.Stderr = sh.stderr
+		cmd.Stderr = shell.stderr
 		close(stderrDone)
 	}
 
-	cmd.Stdin = sh.stdin
+	cmd.Stdin = shell.stdin
 
 	err = cmd.Start()
 
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	if copyOut {
 		go func() {
 			defer close(stdoutDone)
 
-			io.Copy(sh.stdout, stdout)
+			io.Copy(shell.stdout, stdout)
 		}()
 	}
 
@@ -152,73 +716,134 @@ func (sh *Shell) executeRfork(rfork *ast.RforkNode) error {
 		go func() {
 			defer close(stderrDone)
 
-			io.Copy(sh.stderr, stderr)
+			io.Copy(shell.stderr, stderr)
 		}()
 	}
 
-	nashClient, err = dialRc(unixfile)
+	// only the child uses its end
+	child.Close()
 
-	defer nashClient.Close()
+	var values []sh.Obj
 
-	tr = rfork.Tree()
+	if newidmap {
+		err = writeIDMappings(cmd.Process.Pid, uids, gids, options.setgroups != "allow")
+		if err == nil {
+			// wakes up the rfork process
+			_, err = conn.Write([]byte{0})
+		}
+	}
 
-	if tr == nil || tr.Root == nil {
-		return fmt.Errorf("Rfork with no sub block")
+	if err == nil {
+		values, err = shell.rforkExec(rpc.NewClient(conn), rfork)
 	}
 
-	for i = 0; i < len(tr.Root.Nodes); i++ {
-		var (
-			n, status int
-		)
+	// we're done with rfork daemon
+	conn.Close()
+
+	<-stdoutDone
+	<-stderrDone
 
-		node := tr.Root.Nodes[i]
-		data := []byte(node.String() + "\n")
+	err2 := cmd.Wait()
 
-		n, err = nashClient.Write(data)
+	if err == nil {
+		err = err2
+	}
 
-		if err != nil || n != len(data) {
-			return fmt.Errorf("RPC call failed: Err: %v, bytes written: %d", err, n)
+	if err != nil && cg != nil {
+		if n := cg.oomKills(); n > 0 {
+			return nil, &errExitStatus{
+				NashError: errors.NewError("%s (the OOM killer killed %d processes of the rfork block)", err, n),
+				status:    rforkOOMStatus,
+			}
 		}
+	}
+
+	if err != nil {
+		return nil, err
+	}
 
-		// read response
+	return values, nil
+}
 
-		var response [1024]byte
-		n, err = nashClient.Read(response[:])
+// evalRforkOptions evaluates the options of the rfork block, a string
+// or a list of strings.
+func (shell *Shell) evalRforkOptions(rfork *ast.RforkNode) ([]string, error) {
+	expr := rfork.Options()
+	if expr == nil {
+		return nil, nil
+	}
 
-		if err != nil {
-			break
-		}
+	obj, err := shell.evalExpr(expr)
+	if err != nil {
+		return nil, err
+	}
 
-		status, err = strconv.Atoi(string(response[0:n]))
+	if obj.Type() == sh.StringType {
+		return []string{obj.String()}, nil
+	}
 
-		if err != nil {
-			err = fmt.Errorf("Invalid status: %s", string(response[0:n]))
-			break
-		}
+	if obj.Type() != sh.ListType {
+		return nil, errors.NewEvalError(shell.filename, expr,
+			"Rfork options must be a string or a list, but a %s was provided", obj.Type())
+	}
+
+	var opts []string
 
-		if status != 0 {
-			err = fmt.Errorf("nash: Exited with status %d", status)
-			break
+	for _, opt := range obj.(*sh.ListObj).List() {
+		if opt.Type() != sh.StringType {
+			return nil, errors.NewEvalError(shell.filename, expr,
+				"Rfork options must be strings, but a %s was provided", opt.Type())
 		}
+		opts = append(opts, opt.String())
 	}
 
-	// we're done with rfork daemon
-	nashClient.Write([]byte("quit"))
-
-	<-stdoutDone
-	<-stderrDone
+	return opts, nil
+}
 
-	err2 := cmd.Wait()
+// rforkExec sends each statement of the rfork block to the rfork
+// process, stopping at the first one that fails or returns.
+func (shell *Shell) rforkExec(client *rpc.Client, rfork *ast.RforkNode) ([]sh.Obj, error) {
+	tr := rfork.Tree()
 
-	if err != nil {
-		return err
+	if tr == nil || tr.Root == nil {
+		client.Quit()
+		return nil, fmt.Errorf("Rfork with no sub block")
 	}
 
-	if err2 != nil {
-		return err2
+	for _, node := range tr.Root.Nodes {
+		stmt := ast.NewTree(shell.filename)
+		stmt.Root = ast.NewBlockNode(token.NewFileInfo(node.Line(), node.Column()))
+		stmt.Root.Push(node)
+
+		reply, err := client.Exec(stmt)
+		if err != nil {
+			return nil, fmt.Errorf("RPC call failed: %s", err)
+		}
+
+		if reply.Status != 0 {
+			msg := reply.Error
+
+			// evaluation errors already have their position
+			if !strings.HasPrefix(msg, shell.filename+":") {
+				msg = fmt.Sprintf("%s:%d:%d: %s", shell.filename, reply.Line, reply.Column, msg)
+			}
+
+			return nil, &errExitStatus{
+				NashError: errors.NewError("%s", msg),
+				status:    reply.Status,
+			}
+		}
+
+		if reply.Returned {
+			values, err := rpc.Objs(reply.Values)
+			if err != nil {
+				return nil, err
+			}
+			return values, client.Quit()
+		}
 	}
 
-	return nil
+	return nil, client.Quit()
 }
 
 func getflags(flags string) (uintptr, error) {
@@ -235,6 +860,14 @@ func getflags(flags string) (uintptr, error) {
 				syscall.CLONE_NEWNS |
 				syscall.CLONE_NEWUTS |
 				syscall.CLONE_NEWIPC)
+
+			if namespaceSupported("cgroup") {
+				lflags |= syscall.CLONE_NEWCGROUP
+			}
+
+			if namespaceSupported("time") {
+				lflags |= cloneNewTime
+			}
 		case 'u':
 			lflags |= syscall.CLONE_NEWUSER
 		case 'p':
@@ -247,6 +880,10 @@ func getflags(flags string) (uintptr, error) {
 			lflags |= syscall.CLONE_NEWUTS
 		case 'i':
 			lflags |= syscall.CLONE_NEWIPC
+		case 'g':
+			lflags |= syscall.CLONE_NEWCGROUP
+		case 't':
+			lflags |= cloneNewTime
 		default:
 			return 0, fmt.Errorf("Wrong rfork flag: %c", flags[i])
 		}
@@ -258,3 +895,10 @@ func getflags(flags string) (uintptr, error) {
 
 	return lflags, nil
 }
+
+// namespaceSupported tells if the kernel has the namespace type name,
+// as named on /proc/self/ns.
+func namespaceSupported(name string) bool {
+	_, err := os.Stat("/proc/self/ns/" + name)
+	return err == nil
+}
@@ -22,7 +22,7 @@ func getletters() string {
 }
 
 func getvalid() string {
-	return "cumnpsi"
+	return "cumnpsigt"
 }
 
 func testTblFlagsOK(flagstr string, expected uintptr, t *testing.T) {
@@ -69,10 +69,23 @@ func TestRforkFlags(t *testing.T) {
 	testTblFlagsOK("i", syscall.CLONE_NEWIPC, t)
 	testTblFlagsOK("s", syscall.CLONE_NEWUTS, t)
 	testTblFlagsOK("p", syscall.CLONE_NEWPID, t)
-	testTblFlagsOK("c", syscall.CLONE_NEWUSER|
-		syscall.CLONE_NEWNS|syscall.CLONE_NEWNET|
-		syscall.CLONE_NEWIPC|syscall.CLONE_NEWUTS|
-		syscall.CLONE_NEWUSER|syscall.CLONE_NEWPID, t)
+	testTblFlagsOK("g", syscall.CLONE_NEWCGROUP, t)
+	testTblFlagsOK("t", cloneNewTime, t)
+
+	container := uintptr(syscall.CLONE_NEWUSER |
+		syscall.CLONE_NEWNS | syscall.CLONE_NEWNET |
+		syscall.CLONE_NEWIPC | syscall.CLONE_NEWUTS |
+		syscall.CLONE_NEWUSER | syscall.CLONE_NEWPID)
+
+	if namespaceSupported("cgroup") {
+		container |= syscall.CLONE_NEWCGROUP
+	}
+
+	if namespaceSupported("time") {
+		container |= cloneNewTime
+	}
+
+	testTblFlagsOK("c", container, t)
 	testTblFlagsOK("um", syscall.CLONE_NEWUSER|syscall.CLONE_NEWNS, t)
 	testTblFlagsOK("umn", syscall.CLONE_NEWUSER|
 		syscall.CLONE_NEWNS|
@@ -93,3 +106,49 @@ func TestRforkFlags(t *testing.T) {
 		syscall.CLONE_NEWPID|
 		syscall.CLONE_NEWUTS, t)
 }
+
+func TestRforkTimeProcAttrs(t *testing.T) {
+	attrs := getProcAttrs(syscall.CLONE_NEWNS | cloneNewTime)
+
+	if attrs.Cloneflags != syscall.CLONE_NEWNS {
+		t.Errorf("Clone flags differ: expected %08x but %08x", syscall.CLONE_NEWNS, attrs.Cloneflags)
+	}
+
+	if attrs.Unshareflags != cloneNewTime {
+		t.Errorf("Unshare flags differ: expected %08x but %08x", cloneNewTime, attrs.Unshareflags)
+	}
+}
+
+func TestRforkVethOption(t *testing.T) {
+	options, err := parseRforkOptions([]string{"veth"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !options.veth {
+		t.Fatal("expected the veth option")
+	}
+
+	if err := options.check(syscall.CLONE_NEWNET); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		opts  []string
+		flags uintptr
+		err   string
+	}{
+		{[]string{"veth=eth0"}, syscall.CLONE_NEWNET, "veth has no value"},
+		{[]string{"veth"}, syscall.CLONE_NEWNS, "veth option requires the n flag"},
+		{[]string{"veth"}, syscall.CLONE_NEWUSER | syscall.CLONE_NEWNET, "can not be used with the u flag"},
+	} {
+		options, err := parseRforkOptions(test.opts)
+		if err == nil {
+			err = options.check(test.flags)
+		}
+
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%v: expected error %q but got: %v", test.opts, test.err, err)
+		}
+	}
+}
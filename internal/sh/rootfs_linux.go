@@ -0,0 +1,606 @@
+// +build linux
+
+package sh
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+type (
+	// rforkBind is a directory or file of the host made visible on
+	// the root filesystem of a rfork block.
+	rforkBind struct {
+		source, target string
+	}
+
+	ociDescriptor struct {
+		MediaType string       `json:"mediaType"`
+		Digest    string       `json:"digest"`
+		Platform  *ociPlatform `json:"platform"`
+	}
+
+	ociPlatform struct {
+		Architecture string `json:"architecture"`
+		OS           string `json:"os"`
+	}
+
+	// ociIndex is an image index, or a manifest when it has layers.
+	ociIndex struct {
+		MediaType string          `json:"mediaType"`
+		Manifests []ociDescriptor `json:"manifests"`
+		Layers    []ociDescriptor `json:"layers"`
+	}
+
+	// ociBlob reads a blob of an OCI image layout, checking its digest.
+	ociBlob struct {
+		file   *os.File
+		hash   hash.Hash
+		digest string
+	}
+)
+
+// rforkOldRoot is where the old root is put by pivot_root, before it is
+// unmounted.
+const rforkOldRoot = "/.nash-oldroot"
+
+// parseRforkBind parses a bind of the form source:target, or a path
+// made visible at the same place.
+func parseRforkBind(value string) (rforkBind, error) {
+	source, target := value, value
+	if i := strings.Index(value, ":"); i >= 0 {
+		source, target = value[:i], value[i+1:]
+	}
+
+	if source == "" || target == "" {
+		return rforkBind{}, fmt.Errorf("expected source:target")
+	}
+
+	return rforkBind{source: source, target: target}, nil
+}
+
+// setupRootfs makes a tmpfs mounted on dir the root directory, with the
+// files unpacked from rootfs and the binds, so the root filesystem is
+// gone when the mount namespace ends. On a new pid namespace, /proc is
+// mounted too.
+func setupRootfs(dir, rootfs string, binds []rforkBind) error {
+	err := syscall.Mount("tmpfs", dir, "tmpfs", 0, "mode=0755")
+	if err != nil {
+		return fmt.Errorf("unable to mount the root filesystem: %s", err)
+	}
+
+	if err := unpackRootfs(rootfs, dir); err != nil {
+		return fmt.Errorf("unable to unpack %s: %s", rootfs, err)
+	}
+
+	for _, b := range binds {
+		if err := bindRootfs(dir, b); err != nil {
+			return err
+		}
+	}
+
+	// inside user namespaces, proc can be mounted only where other
+	// proc mounts are visible, so not after the old root goes away
+	if os.Getpid() == 1 {
+		if err := mountProc(dir); err != nil {
+			return err
+		}
+	}
+
+	oldroot := filepath.Join(dir, rforkOldRoot)
+
+	err = os.Mkdir(oldroot, 0700)
+	if err == nil {
+		err = syscall.PivotRoot(dir, oldroot)
+	}
+	if err == nil {
+		err = os.Chdir("/")
+	}
+	if err == nil {
+		err = syscall.Unmount(rforkOldRoot, syscall.MNT_DETACH)
+	}
+	if err == nil {
+		err = os.Remove(rforkOldRoot)
+	}
+
+	if err != nil {
+		return fmt.Errorf("unable to change the root filesystem: %s", err)
+	}
+
+	return nil
+}
+
+func mountProc(dir string) error {
+	path, err := rootfsPath(dir, "/proc")
+	if err == nil {
+		err = os.Mkdir(path, 0555)
+		if os.IsExist(err) {
+			err = nil
+		}
+	}
+	if err == nil {
+		err = syscall.Mount("proc", path, "proc", syscall.MS_NOSUID|syscall.MS_NODEV|syscall.MS_NOEXEC, "")
+	}
+
+	if err != nil {
+		return fmt.Errorf("unable to mount /proc: %s", err)
+	}
+	return nil
+}
+
+// bindRootfs bind mounts the source of the host on the target of the
+// root filesystem on dir, creating it if needed.
+func bindRootfs(dir string, b rforkBind) error {
+	info, err := os.Stat(b.source)
+	if err != nil {
+		return fmt.Errorf("unable to bind %s: %s", b.source, err)
+	}
+
+	target, err := rootfsPath(dir, b.target)
+	if err != nil {
+		return fmt.Errorf("unable to bind %s on %s: %s", b.source, b.target, err)
+	}
+
+	if linfo, err := os.Lstat(target); err == nil && linfo.Mode()&os.ModeSymlink != 0 {
+		return fmt.Errorf("unable to bind %s on %s: target is a symbolic link", b.source, b.target)
+	} else if os.IsNotExist(err) {
+		if info.IsDir() {
+			err = os.Mkdir(target, 0755)
+		} else {
+			err = ioutil.WriteFile(target, nil, 0644)
+		}
+		if err != nil {
+			return fmt.Errorf("unable to bind %s on %s: %s", b.source, b.target, err)
+		}
+	}
+
+	err = syscall.Mount(b.source, target, "", syscall.MS_BIND|syscall.MS_REC, "")
+	if err != nil {
+		return fmt.Errorf("unable to bind %s on %s: %s", b.source, b.target, err)
+	}
+
+	return nil
+}
+
+// unpackRootfs unpacks on dir the root filesystem of path, a tar file,
+// optionally compressed with gzip, or an OCI image layout directory,
+// whose layers are applied in order.
+func unpackRootfs(path, dir string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+
+	if !info.IsDir() {
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		return unpackLayer(file, dir, false)
+	}
+
+	layers, err := ociLayers(path)
+	if err != nil {
+		return err
+	}
+
+	for _, layer := range layers {
+		blob, err := openOCIBlob(path, layer.Digest)
+		if err != nil {
+			return err
+		}
+
+		err = unpackLayer(blob, dir, true)
+		if err == nil {
+			err = blob.verify()
+		}
+
+		blob.file.Close()
+
+		if err != nil {
+			return fmt.Errorf("layer %s: %s", layer.Digest, err)
+		}
+	}
+
+	return nil
+}
+
+// unpackLayer extracts the tar archive, decompressing it if needed, on
+// dir, applying the whiteouts of the OCI image layers if told so.
+func unpackLayer(r io.Reader, dir string, whiteouts bool) error {
+	br := bufio.NewReader(r)
+
+	magic, _ := br.Peek(4)
+
+	switch {
+	case bytes.HasPrefix(magic, []byte{0x1f, 0x8b}):
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+
+		return extractTar(gz, dir, whiteouts)
+	case bytes.Equal(magic, []byte{0x28, 0xb5, 0x2f, 0xfd}):
+		return fmt.Errorf("zstd compression not supported")
+	}
+
+	return extractTar(br, dir, whiteouts)
+}
+
+func extractTar(r io.Reader, dir string, whiteouts bool) error {
+	tr := tar.NewReader(r)
+
+	// the opaque whiteouts only remove the files of the lower layers
+	extracted := make(map[string]bool)
+
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		name := filepath.Clean("/" + hdr.Name)
+		if name == "/" {
+			continue
+		}
+
+		base := filepath.Base(name)
+
+		if whiteouts && strings.HasPrefix(base, ".wh.") {
+			err = whiteout(dir, filepath.Dir(name), base[len(".wh."):], extracted)
+		} else {
+			extracted[name] = true
+			err = extractEntry(tr, hdr, dir, name)
+		}
+
+		if err != nil {
+			return fmt.Errorf("%s: %s", hdr.Name, err)
+		}
+	}
+}
+
+// whiteout removes the file name from the directory parent, or all
+// the files not extracted by the layer for the opaque whiteouts.
+func whiteout(dir, parent, name string, extracted map[string]bool) error {
+	if name == "" || name == "." || name == ".." || strings.Contains(name, "/") {
+		return fmt.Errorf("invalid whiteout %s", ".wh."+name)
+	}
+
+	// the parent directories are checked as on the extraction, so
+	// the removal does not follow symbolic links
+	target, err := rootfsPath(dir, filepath.Join(parent, name))
+	if err != nil {
+		return err
+	}
+
+	target = filepath.Clean(target)
+	if !strings.HasPrefix(target, filepath.Clean(dir)+string(filepath.Separator)) {
+		return fmt.Errorf("invalid whiteout path %s", filepath.Join(parent, ".wh."+name))
+	}
+
+	if name != ".wh..opq" {
+		return os.RemoveAll(target)
+	}
+
+	path := filepath.Dir(target)
+
+	files, err := ioutil.ReadDir(path)
+	if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		if extracted[filepath.Join(parent, file.Name())] {
+			continue
+		}
+
+		if err := os.RemoveAll(filepath.Join(path, file.Name())); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func extractEntry(tr *tar.Reader, hdr *tar.Header, dir, name string) error {
+	path, err := rootfsPath(dir, name)
+	if err != nil {
+		return err
+	}
+
+	info, err := os.Lstat(path)
+	if err == nil && !(info.IsDir() && hdr.Typeflag == tar.TypeDir) {
+		if err := os.RemoveAll(path); err != nil {
+			return err
+		}
+	}
+
+	mode := hdr.FileInfo().Mode()
+
+	switch hdr.Typeflag {
+	case tar.TypeDir:
+		if err := os.Mkdir(path, 0755); err != nil && !os.IsExist(err) {
+			return err
+		}
+	case tar.TypeReg:
+		file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if err != nil {
+			return err
+		}
+
+		_, err = io.Copy(file, tr)
+		if err2 := file.Close(); err == nil {
+			err = err2
+		}
+		if err != nil {
+			return err
+		}
+	case tar.TypeSymlink:
+		if err := os.Symlink(hdr.Linkname, path); err != nil {
+			return err
+		}
+	case tar.TypeLink:
+		target, err := rootfsPath(dir, filepath.Clean("/"+hdr.Linkname))
+		if err != nil {
+			return err
+		}
+
+		if err := os.Link(target, path); err != nil {
+			return err
+		}
+	case tar.TypeChar, tar.TypeBlock, tar.TypeFifo:
+		typ := map[byte]uint32{
+			tar.TypeChar:  syscall.S_IFCHR,
+			tar.TypeBlock: syscall.S_IFBLK,
+			tar.TypeFifo:  syscall.S_IFIFO,
+		}[hdr.Typeflag]
+
+		dev := mkdev(uint32(hdr.Devmajor), uint32(hdr.Devminor))
+
+		err := syscall.Mknod(path, typ|uint32(hdr.Mode&07777), int(dev))
+		// devices can not be created in user namespaces
+		if err == syscall.EPERM && hdr.Typeflag != tar.TypeFifo {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	default:
+		return nil
+	}
+
+	// the ids not mapped on the user namespace can not be used
+	err = os.Lchown(path, hdr.Uid, hdr.Gid)
+	if err = unwrapPathError(err); err != nil && err != syscall.EINVAL && err != syscall.EPERM {
+		return err
+	}
+
+	if hdr.Typeflag == tar.TypeSymlink || hdr.Typeflag == tar.TypeLink {
+		return nil
+	}
+
+	// after chown, that clears the setuid and setgid bits
+	if err := os.Chmod(path, mode); err != nil {
+		return err
+	}
+
+	return os.Chtimes(path, time.Now(), hdr.ModTime)
+}
+
+// rootfsPath returns the path of name on the root filesystem on dir,
+// creating its missing directories. The directories can not be
+// symbolic links, that could point outside of dir.
+func rootfsPath(dir, name string) (string, error) {
+	name = filepath.Clean("/" + name)
+	if name == "/" {
+		return dir, nil
+	}
+
+	path := dir
+	parts := strings.Split(name[1:], "/")
+
+	for _, part := range parts[:len(parts)-1] {
+		path = filepath.Join(path, part)
+
+		info, err := os.Lstat(path)
+		if os.IsNotExist(err) {
+			err = os.Mkdir(path, 0755)
+			if err != nil {
+				return "", err
+			}
+			continue
+		}
+
+		if err != nil {
+			return "", err
+		}
+
+		if !info.IsDir() {
+			return "", fmt.Errorf("%s is not a directory", strings.TrimPrefix(path, dir))
+		}
+	}
+
+	return filepath.Join(path, parts[len(parts)-1]), nil
+}
+
+func mkdev(major, minor uint32) uint64 {
+	return uint64(major&0xfff)<<8 | uint64(major&^0xfff)<<32 |
+		uint64(minor&0xff) | uint64(minor&^0xff)<<12
+}
+
+// ociLayers returns the layers of the image of the OCI image layout on
+// dir. If it has many images, the one of the platform is used.
+func ociLayers(dir string) ([]ociDescriptor, error) {
+	if _, err := os.Stat(filepath.Join(dir, "oci-layout")); err != nil {
+		return nil, fmt.Errorf("%s is not an OCI image layout", dir)
+	}
+
+	content, err := ioutil.ReadFile(filepath.Join(dir, "index.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	var index ociIndex
+
+	for {
+		if err := json.Unmarshal(content, &index); err != nil {
+			return nil, fmt.Errorf("invalid OCI index: %s", err)
+		}
+
+		if index.Manifests == nil {
+			return index.Layers, nil
+		}
+
+		manifest, err := ociManifest(index.Manifests)
+		if err != nil {
+			return nil, err
+		}
+
+		content, err = readOCIBlob(dir, manifest.Digest)
+		if err != nil {
+			return nil, err
+		}
+
+		index = ociIndex{}
+	}
+}
+
+// ociManifest returns the manifest of the platform, or the first one.
+func ociManifest(manifests []ociDescriptor) (ociDescriptor, error) {
+	if len(manifests) == 0 {
+		return ociDescriptor{}, fmt.Errorf("OCI index with no manifests")
+	}
+
+	for _, m := range manifests {
+		if m.Platform != nil && m.Platform.OS == runtime.GOOS &&
+			m.Platform.Architecture == runtime.GOARCH {
+			return m, nil
+		}
+	}
+
+	return manifests[0], nil
+}
+
+func readOCIBlob(dir, digest string) ([]byte, error) {
+	blob, err := openOCIBlob(dir, digest)
+	if err != nil {
+		return nil, err
+	}
+	defer blob.file.Close()
+
+	content, err := ioutil.ReadAll(blob)
+	if err == nil {
+		err = blob.verify()
+	}
+
+	return content, err
+}
+
+func openOCIBlob(dir, digest string) (*ociBlob, error) {
+	i := strings.Index(digest, ":")
+	if i < 0 {
+		return nil, fmt.Errorf("invalid digest %q", digest)
+	}
+
+	alg, encoded := digest[:i], digest[i+1:]
+
+	var h hash.Hash
+
+	switch alg {
+	case "sha256":
+		h = sha256.New()
+	case "sha512":
+		h = sha512.New()
+	default:
+		return nil, fmt.Errorf("unsupported digest algorithm %q", alg)
+	}
+
+	if _, err := hex.DecodeString(encoded); err != nil || len(encoded) != 2*h.Size() {
+		return nil, fmt.Errorf("invalid digest %q", digest)
+	}
+
+	file, err := os.Open(filepath.Join(dir, "blobs", alg, encoded))
+	if err != nil {
+		return nil, err
+	}
+
+	return &ociBlob{file: file, hash: h, digest: digest}, nil
+}
+
+func (b *ociBlob) Read(p []byte) (int, error) {
+	n, err := b.file.Read(p)
+	b.hash.Write(p[:n])
+	return n, err
+}
+
+// verify reads the rest of the blob and checks its digest.
+func (b *ociBlob) verify() error {
+	if _, err := io.Copy(ioutil.Discard, b); err != nil {
+		return err
+	}
+
+	alg := b.digest[:strings.Index(b.digest, ":")]
+
+	if digest := alg + ":" + hex.EncodeToString(b.hash.Sum(nil)); digest != b.digest {
+		return fmt.Errorf("blob digest mismatch: got %s", digest)
+	}
+
+	return nil
+}
+
+const atEmptyPath = 0x1000
+
+// execFile executes the program open as file, for the processes
+// without /proc to execute themselves again.
+func execFile(file *os.File, args, env []string) error {
+	nr, ok := syscallNumbers["execveat"]
+	if !ok {
+		return fmt.Errorf("executing a file descriptor not supported on %s", runtime.GOARCH)
+	}
+
+	argv, err := syscall.SlicePtrFromStrings(args)
+	if err != nil {
+		return err
+	}
+
+	envv, err := syscall.SlicePtrFromStrings(env)
+	if err != nil {
+		return err
+	}
+
+	var empty byte
+
+	_, _, errno := syscall.Syscall6(uintptr(nr), file.Fd(), uintptr(unsafe.Pointer(&empty)),
+		uintptr(unsafe.Pointer(&argv[0])), uintptr(unsafe.Pointer(&envv[0])), atEmptyPath, 0)
+
+	// the dynamic loader is looked up on the new root filesystem
+	if errno == syscall.ENOENT {
+		return fmt.Errorf("unable to execute the rfork process again on the new root filesystem, " +
+			"the restrictions need a statically linked program (built with CGO_ENABLED=0)")
+	}
+
+	return errno
+}
@@ -0,0 +1,313 @@
+// +build linux
+
+package sh
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+type tarEntry struct {
+	name, content, link string
+	typ                 byte
+}
+
+func TestUnpackRootfs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "nash-rootfs-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	archive := filepath.Join(dir, "rootfs.tar.gz")
+	writeTestFile(t, archive, string(gzipData(t, tarData(t, []tarEntry{
+		{name: "etc/", typ: tar.TypeDir},
+		{name: "etc/hostname", content: "container\n"},
+		{name: "bin", link: "usr/bin", typ: tar.TypeSymlink},
+		{name: "usr/bin/tool", content: "tool"},
+		{name: "usr/bin/tool2", link: "usr/bin/tool", typ: tar.TypeLink},
+		{name: "../../escaped", content: "inside"},
+	}))))
+
+	root := filepath.Join(dir, "root")
+	if err := os.Mkdir(root, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := unpackRootfs(archive, root); err != nil {
+		t.Fatal(err)
+	}
+
+	for path, expected := range map[string]string{
+		"etc/hostname":  "container\n",
+		"bin/tool":      "tool",
+		"usr/bin/tool2": "tool",
+		"escaped":       "inside",
+	} {
+		content, err := ioutil.ReadFile(filepath.Join(root, path))
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+
+		if string(content) != expected {
+			t.Errorf("expected %q on %s but got %q", expected, path, content)
+		}
+	}
+
+	// the files are not written through symbolic links
+	for _, entries := range [][]tarEntry{
+		{{name: "etc", link: dir, typ: tar.TypeSymlink}, {name: "etc/passwd", content: "root"}},
+		{{name: "etc", link: dir, typ: tar.TypeSymlink}, {name: "etc/dir/", typ: tar.TypeDir}},
+	} {
+		root, err := ioutil.TempDir(dir, "root")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = unpackLayer(bytes.NewReader(tarData(t, entries)), root, false)
+		if err == nil || !strings.Contains(err.Error(), "/etc is not a directory") {
+			t.Errorf("expected error writing through a symbolic link but got: %v", err)
+		}
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "passwd")); !os.IsNotExist(err) {
+		t.Errorf("expected no file outside of the root filesystem but got: %v", err)
+	}
+}
+
+func TestUnpackOCILayout(t *testing.T) {
+	dir, err := ioutil.TempDir("", "nash-rootfs-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	layout := filepath.Join(dir, "layout")
+	if err := os.MkdirAll(filepath.Join(layout, "blobs", "sha256"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	writeTestFile(t, filepath.Join(layout, "oci-layout"), `{"imageLayoutVersion": "1.0.0"}`)
+
+	base := writeBlob(t, layout, gzipData(t, tarData(t, []tarEntry{
+		{name: "etc/hostname", content: "base"},
+		{name: "etc/removed", content: "removed"},
+		{name: "opaque/old", content: "old"},
+	})))
+
+	top := writeBlob(t, layout, tarData(t, []tarEntry{
+		{name: "etc/hostname", content: "top"},
+		{name: "etc/.wh.removed"},
+		{name: "opaque/new", content: "new"},
+		{name: "opaque/.wh..wh..opq"},
+	}))
+
+	manifest := writeBlob(t, layout, jsonData(t, ociIndex{
+		Layers: []ociDescriptor{{Digest: base}, {Digest: top}},
+	}))
+
+	writeTestFile(t, filepath.Join(layout, "index.json"), string(jsonData(t, ociIndex{
+		Manifests: []ociDescriptor{
+			{Digest: manifest, Platform: &ociPlatform{OS: "plan9", Architecture: "386"}},
+		},
+	})))
+
+	root := filepath.Join(dir, "root")
+	if err := os.Mkdir(root, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := unpackRootfs(layout, root); err != nil {
+		t.Fatal(err)
+	}
+
+	var files []string
+
+	err = filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			content, err := ioutil.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			files = append(files, strings.TrimPrefix(path, root)+"="+string(content))
+		}
+		return err
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"/etc/hostname=top", "/opaque/new=new"}
+	if !reflect.DeepEqual(files, expected) {
+		t.Fatalf("expected files %v but got %v", expected, files)
+	}
+
+	// the blobs must match their digests
+	writeTestFile(t, filepath.Join(layout, "blobs", "sha256", strings.TrimPrefix(top, "sha256:")),
+		string(tarData(t, []tarEntry{{name: "etc/hostname", content: "changed"}})))
+
+	err = unpackRootfs(layout, root)
+	if err == nil || !strings.Contains(err.Error(), "digest mismatch") {
+		t.Fatalf("expected digest mismatch error but got: %v", err)
+	}
+}
+
+func TestUnpackWhiteoutEscape(t *testing.T) {
+	dir, err := ioutil.TempDir("", "nash-rootfs-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	outside := filepath.Join(dir, "outside")
+	writeTestFile(t, outside, "outside")
+
+	for _, name := range []string{".wh...", "etc/.wh...", ".wh..", "etc/.wh."} {
+		root, err := ioutil.TempDir(dir, "root")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = unpackLayer(bytes.NewReader(tarData(t, []tarEntry{
+			{name: "etc/hostname", content: "layer"},
+			{name: name},
+		})), root, true)
+
+		if err == nil || !strings.Contains(err.Error(), "invalid whiteout") {
+			t.Errorf("%s: expected invalid whiteout error but got: %v", name, err)
+		}
+
+		if _, err := os.Stat(root); err != nil {
+			t.Errorf("%s: expected the root filesystem to exist but got: %v", name, err)
+		}
+	}
+
+	if _, err := os.Stat(outside); err != nil {
+		t.Fatalf("expected the file outside of the root filesystem to exist but got: %v", err)
+	}
+}
+
+func TestRforkRootfsOptions(t *testing.T) {
+	options, err := parseRforkOptions([]string{
+		"rootfs=/images/alpine.tar.gz",
+		"bind=/src:/work",
+		"bind=/dev",
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []rforkBind{{source: "/src", target: "/work"}, {source: "/dev", target: "/dev"}}
+	if !reflect.DeepEqual(options.binds, expected) {
+		t.Errorf("expected binds %v but got %v", expected, options.binds)
+	}
+
+	for _, test := range []struct {
+		opts  []string
+		flags uintptr
+		err   string
+	}{
+		{[]string{"rootfs="}, syscall.CLONE_NEWNS, "requires a tar file or an OCI image layout"},
+		{[]string{"rootfs=/rootfs.tar", "bind=:/work"}, syscall.CLONE_NEWNS, `Invalid rfork bind ":/work"`},
+		{[]string{"rootfs=/rootfs.tar"}, syscall.CLONE_NEWUSER, "rootfs option requires the m flag"},
+		{[]string{"bind=/src"}, syscall.CLONE_NEWNS, "bind option requires the rootfs option"},
+		{[]string{"rootfs=/rootfs.tar", "propagation=shared"}, syscall.CLONE_NEWNS, "propagation=shared"},
+	} {
+		options, err := parseRforkOptions(test.opts)
+		if err == nil {
+			err = options.check(test.flags)
+		}
+
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%v: expected error %q but got: %v", test.opts, test.err, err)
+		}
+	}
+}
+
+func tarData(t *testing.T, entries []tarEntry) []byte {
+	var buf bytes.Buffer
+
+	tw := tar.NewWriter(&buf)
+
+	for _, e := range entries {
+		hdr := &tar.Header{
+			Name:     e.name,
+			Linkname: e.link,
+			Typeflag: e.typ,
+			Mode:     0644,
+			Size:     int64(len(e.content)),
+		}
+
+		if e.typ == 0 {
+			hdr.Typeflag = tar.TypeReg
+		}
+
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := tw.Write([]byte(e.content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+func gzipData(t *testing.T, data []byte) []byte {
+	var buf bytes.Buffer
+
+	gz := gzip.NewWriter(&buf)
+
+	if _, err := gz.Write(data); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+func jsonData(t *testing.T, v interface{}) []byte {
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// writeBlob writes the blob on the OCI image layout and returns its
+// digest.
+func writeBlob(t *testing.T, layout string, data []byte) string {
+	sum := sha256.Sum256(data)
+	encoded := hex.EncodeToString(sum[:])
+
+	writeTestFile(t, filepath.Join(layout, "blobs", "sha256", encoded), string(data))
+	return "sha256:" + encoded
+}
+
+func writeTestFile(t *testing.T, path, content string) {
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,242 @@
+// +build linux
+
+package sh
+
+//go:generate go run mkseccomp.go amd64
+//go:generate go run mkseccomp.go arm64
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"runtime"
+	"syscall"
+	"unsafe"
+)
+
+type (
+	// seccompProfile is a seccomp profile in the JSON format of the
+	// OCI runtime spec, without the argument filters:
+	//
+	//	{
+	//		"defaultAction": "SCMP_ACT_ALLOW",
+	//		"syscalls": [
+	//			{"names": ["mount", "umount2"], "action": "SCMP_ACT_ERRNO"}
+	//		]
+	//	}
+	//
+	// The system calls unknown on the architecture are ignored.
+	seccompProfile struct {
+		DefaultAction   string           `json:"defaultAction"`
+		DefaultErrnoRet *uint32          `json:"defaultErrnoRet"`
+		Syscalls        []seccompSyscall `json:"syscalls"`
+
+		// cloneDeny are the flags that make clone fail with EPERM,
+		// the only argument filter, used by the default profile.
+		cloneDeny uint32
+	}
+
+	seccompSyscall struct {
+		Names    []string          `json:"names"`
+		Action   string            `json:"action"`
+		ErrnoRet *uint32           `json:"errnoRet"`
+		Args     []json.RawMessage `json:"args"`
+	}
+)
+
+// The return values of the seccomp filters.
+const (
+	seccompRetKillProcess = 0x80000000
+	seccompRetKillThread  = 0x00000000
+	seccompRetTrap        = 0x00030000
+	seccompRetErrno       = 0x00050000
+	seccompRetLog         = 0x7ffc0000
+	seccompRetAllow       = 0x7fff0000
+)
+
+var seccompActions = map[string]uint32{
+	"SCMP_ACT_KILL":         seccompRetKillThread,
+	"SCMP_ACT_KILL_THREAD":  seccompRetKillThread,
+	"SCMP_ACT_KILL_PROCESS": seccompRetKillProcess,
+	"SCMP_ACT_TRAP":         seccompRetTrap,
+	"SCMP_ACT_ERRNO":        seccompRetErrno,
+	"SCMP_ACT_LOG":          seccompRetLog,
+	"SCMP_ACT_ALLOW":        seccompRetAllow,
+}
+
+// seccompDefaultDeny are the system calls that fail with EPERM on the
+// default profile. They change the system, the namespaces or the
+// mounts, or inspect other processes. The io_uring ones would run
+// the other system calls without the filter.
+var seccompDefaultDeny = []string{
+	"acct", "add_key", "bpf", "clock_adjtime", "clock_settime",
+	"create_module", "delete_module", "finit_module", "fsconfig",
+	"fsmount", "fsopen", "fspick", "get_kernel_syms", "init_module",
+	"io_uring_enter", "io_uring_register", "io_uring_setup",
+	"ioperm", "iopl", "kcmp", "kexec_file_load", "kexec_load", "keyctl",
+	"lookup_dcookie", "mount", "mount_setattr", "move_mount",
+	"name_to_handle_at", "nfsservctl", "open_by_handle_at", "open_tree",
+	"perf_event_open", "pivot_root", "process_vm_readv",
+	"process_vm_writev", "ptrace", "query_module", "quotactl",
+	"reboot", "request_key", "setns", "settimeofday", "stime", "swapoff",
+	"swapon", "syslog", "sysfs", "_sysctl", "umount", "umount2",
+	"unshare", "uselib", "userfaultfd", "ustat", "vm86", "vm86old",
+}
+
+// seccompCloneNamespaces are the flags of clone creating namespaces,
+// denied by the default profile. The flags of clone3 are in memory,
+// out of reach of the filter, so clone3 fails with ENOSYS, making the
+// programs fall back to clone.
+const seccompCloneNamespaces = syscall.CLONE_NEWNS | syscall.CLONE_NEWCGROUP |
+	syscall.CLONE_NEWUTS | syscall.CLONE_NEWIPC | syscall.CLONE_NEWUSER |
+	syscall.CLONE_NEWPID | syscall.CLONE_NEWNET
+
+// loadSeccomp returns the filter of the profile name, "default" or the
+// path of a JSON profile.
+func loadSeccomp(name string) ([]syscall.SockFilter, error) {
+	if seccompArch == 0 {
+		return nil, fmt.Errorf("seccomp not supported on %s", runtime.GOARCH)
+	}
+
+	if name == "default" {
+		enosys := uint32(syscall.ENOSYS)
+
+		return seccompFilter(&seccompProfile{
+			DefaultAction: "SCMP_ACT_ALLOW",
+			Syscalls: []seccompSyscall{
+				{Names: seccompDefaultDeny, Action: "SCMP_ACT_ERRNO"},
+				{Names: []string{"clone3"}, Action: "SCMP_ACT_ERRNO", ErrnoRet: &enosys},
+			},
+			cloneDeny: seccompCloneNamespaces,
+		})
+	}
+
+	content, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+
+	var profile seccompProfile
+
+	if err := json.Unmarshal(content, &profile); err != nil {
+		return nil, fmt.Errorf("invalid seccomp profile %s: %s", name, err)
+	}
+
+	filter, err := seccompFilter(&profile)
+	if err != nil {
+		return nil, fmt.Errorf("invalid seccomp profile %s: %s", name, err)
+	}
+
+	return filter, nil
+}
+
+// seccompFilter compiles the profile to a BPF program. It kills the
+// processes calling system calls of other architectures.
+func seccompFilter(profile *seccompProfile) ([]syscall.SockFilter, error) {
+	defaultRet, err := seccompRet(profile.DefaultAction, profile.DefaultErrnoRet)
+	if err != nil {
+		return nil, err
+	}
+
+	filter := []syscall.SockFilter{
+		bpfStmt(syscall.BPF_LD|syscall.BPF_W|syscall.BPF_ABS, 4), // arch
+		bpfJump(syscall.BPF_JMP|syscall.BPF_JEQ|syscall.BPF_K, seccompArch, 1, 0),
+		bpfStmt(syscall.BPF_RET|syscall.BPF_K, seccompRetKillProcess),
+		bpfStmt(syscall.BPF_LD|syscall.BPF_W|syscall.BPF_ABS, 0), // nr
+	}
+
+	// the x32 system calls of amd64 have the same numbers plus this bit
+	if seccompArch == 0xc000003e {
+		filter = append(filter,
+			bpfJump(syscall.BPF_JMP|syscall.BPF_JGE|syscall.BPF_K, 0x40000000, 0, 1),
+			bpfStmt(syscall.BPF_RET|syscall.BPF_K, seccompRetKillProcess))
+	}
+
+	for _, sc := range profile.Syscalls {
+		if len(sc.Args) > 0 {
+			return nil, fmt.Errorf("argument filters are not supported: %v", sc.Names)
+		}
+
+		ret, err := seccompRet(sc.Action, sc.ErrnoRet)
+		if err != nil {
+			return nil, err
+		}
+
+		if ret == defaultRet {
+			continue
+		}
+
+		for _, name := range sc.Names {
+			nr, ok := syscallNumbers[name]
+			if !ok {
+				continue
+			}
+
+			filter = append(filter,
+				bpfJump(syscall.BPF_JMP|syscall.BPF_JEQ|syscall.BPF_K, nr, 0, 1),
+				bpfStmt(syscall.BPF_RET|syscall.BPF_K, ret))
+		}
+	}
+
+	// the flags are the first argument of clone on amd64 and arm64,
+	// whose lower half is at offset 16 of the seccomp data
+	if nr, ok := syscallNumbers["clone"]; ok && profile.cloneDeny != 0 {
+		filter = append(filter,
+			bpfJump(syscall.BPF_JMP|syscall.BPF_JEQ|syscall.BPF_K, nr, 0, 4),
+			bpfStmt(syscall.BPF_LD|syscall.BPF_W|syscall.BPF_ABS, 16),
+			bpfJump(syscall.BPF_JMP|syscall.BPF_JSET|syscall.BPF_K, profile.cloneDeny, 0, 1),
+			bpfStmt(syscall.BPF_RET|syscall.BPF_K, seccompRetErrno|uint32(syscall.EPERM)),
+			bpfStmt(syscall.BPF_LD|syscall.BPF_W|syscall.BPF_ABS, 0))
+	}
+
+	filter = append(filter, bpfStmt(syscall.BPF_RET|syscall.BPF_K, defaultRet))
+
+	// BPF_MAXINSNS
+	if len(filter) > 4096 {
+		return nil, fmt.Errorf("too many system calls")
+	}
+
+	return filter, nil
+}
+
+func seccompRet(action string, errno *uint32) (uint32, error) {
+	ret, ok := seccompActions[action]
+	if !ok {
+		return 0, fmt.Errorf("invalid action %q", action)
+	}
+
+	if ret == seccompRetErrno {
+		if errno == nil {
+			return ret | uint32(syscall.EPERM), nil
+		}
+		return ret | (*errno & 0xffff), nil
+	}
+
+	return ret, nil
+}
+
+func bpfStmt(code uint16, k uint32) syscall.SockFilter {
+	return syscall.SockFilter{Code: code, K: k}
+}
+
+func bpfJump(code uint16, k uint32, jt, jf uint8) syscall.SockFilter {
+	return syscall.SockFilter{Code: code, Jt: jt, Jf: jf, K: k}
+}
+
+// installSeccomp installs the filter on the calling thread.
+func installSeccomp(filter []syscall.SockFilter) error {
+	prog := syscall.SockFprog{
+		Len:    uint16(len(filter)),
+		Filter: &filter[0],
+	}
+
+	_, _, errno := syscall.RawSyscall(syscall.SYS_PRCTL, syscall.PR_SET_SECCOMP,
+		seccompModeFilter, uintptr(unsafe.Pointer(&prog)))
+	if errno != 0 {
+		return fmt.Errorf("unable to install the seccomp filter: %s", errno)
+	}
+
+	return nil
+}
+
+const seccompModeFilter = 2
@@ -0,0 +1,118 @@
+// +build linux
+
+package sh
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"syscall"
+	"testing"
+)
+
+func TestSeccompFilter(t *testing.T) {
+	if seccompArch == 0 {
+		t.Skip("seccomp not supported")
+	}
+
+	errno := uint32(syscall.EACCES)
+
+	filter, err := seccompFilter(&seccompProfile{
+		DefaultAction: "SCMP_ACT_ALLOW",
+		Syscalls: []seccompSyscall{
+			{Names: []string{"mkdirat", "unknown"}, Action: "SCMP_ACT_ERRNO", ErrnoRet: &errno},
+			{Names: []string{"read"}, Action: "SCMP_ACT_ALLOW"},
+		},
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the unknown and the default action syscalls have no rules
+	rule := []syscall.SockFilter{
+		bpfJump(syscall.BPF_JMP|syscall.BPF_JEQ|syscall.BPF_K, syscallNumbers["mkdirat"], 0, 1),
+		bpfStmt(syscall.BPF_RET|syscall.BPF_K, seccompRetErrno|uint32(syscall.EACCES)),
+		bpfStmt(syscall.BPF_RET|syscall.BPF_K, seccompRetAllow),
+	}
+
+	if len(filter) < len(rule) || !reflect.DeepEqual(filter[len(filter)-len(rule):], rule) {
+		t.Errorf("unexpected filter: %v", filter)
+	}
+
+	for _, profile := range []seccompProfile{
+		{DefaultAction: "SCMP_ACT_NONE"},
+		{DefaultAction: "SCMP_ACT_ALLOW", Syscalls: []seccompSyscall{{Names: []string{"read"}}}},
+		{DefaultAction: "SCMP_ACT_ALLOW", Syscalls: []seccompSyscall{
+			{Names: []string{"read"}, Action: "SCMP_ACT_ERRNO", Args: []json.RawMessage{[]byte("{}")}},
+		}},
+	} {
+		if _, err := seccompFilter(&profile); err == nil {
+			t.Errorf("expected error compiling profile %+v", profile)
+		}
+	}
+}
+
+func TestLoadSeccomp(t *testing.T) {
+	if seccompArch == 0 {
+		t.Skip("seccomp not supported")
+	}
+
+	if _, err := loadSeccomp("default"); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := ioutil.TempFile("", "nash-seccomp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+
+	_, err = f.WriteString(`{"defaultAction": "SCMP_ACT_ERRNO", "syscalls": [
+		{"names": ["read", "write"], "action": "SCMP_ACT_ALLOW"}
+	]}`)
+	f.Close()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	filter, err := loadSeccomp(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	last := filter[len(filter)-1]
+	if last != bpfStmt(syscall.BPF_RET|syscall.BPF_K, seccompRetErrno|uint32(syscall.EPERM)) {
+		t.Errorf("expected the default action to return EPERM but got: %v", last)
+	}
+
+	if _, err := loadSeccomp(f.Name() + ".missing"); err == nil {
+		t.Error("expected error loading missing profile")
+	}
+}
+
+func TestParseCaps(t *testing.T) {
+	caps, err := parseCaps("net_raw,CAP_SYS_ADMIN")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(caps, []int{13, 21}) {
+		t.Errorf("unexpected capabilities: %v", caps)
+	}
+
+	caps, err = parseCaps("all")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(caps) != lastCap()+1 {
+		t.Errorf("expected %d capabilities but got %d", lastCap()+1, len(caps))
+	}
+
+	if _, err := parseCaps("cap_nothing"); err == nil {
+		t.Error("expected error parsing unknown capability")
+	}
+}
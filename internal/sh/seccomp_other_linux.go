@@ -0,0 +1,8 @@
+// +build linux,!amd64,!arm64
+
+package sh
+
+// seccomp is supported only on the architectures with a syscall table
+const seccompArch = 0
+
+var syscallNumbers map[string]uint32
@@ -4,22 +4,26 @@ import (
 	"bytes"
 	"fmt"
 	"io"
+	"io/fs"
 	"io/ioutil"
 	"net"
 	"os"
 	"os/signal"
+	"path"
 	"path/filepath"
 	"runtime"
 	"strconv"
 	"strings"
 	"sync"
 	"syscall"
+	"time"
 
 	"github.com/madlambda/nash/ast"
 	"github.com/madlambda/nash/errors"
 	"github.com/madlambda/nash/internal/sh/builtin"
 	"github.com/madlambda/nash/parser"
 	"github.com/madlambda/nash/sh"
+	"github.com/madlambda/nash/stdlib"
 	"github.com/madlambda/nash/token"
 )
 
@@ -46,6 +50,7 @@ type (
 		nashdPath   string
 		isFn        bool
 		filename    string // current file being executed or imported
+		filefs      fs.FS  // filesystem of filename, nil if it is on disk
 
 		sigs        chan os.Signal
 		interrupted bool
@@ -66,6 +71,14 @@ type (
 
 		nashpath string
 		nashroot string
+		fsys     fs.FS // scripts searched by import after the disk
+
+		profiler *Profiler
+		frames   []*profFrame // profiler call stack
+		coverage *Coverage
+
+		cmdResolver CmdResolver
+		mocks       *CmdMocks
 
 		*sync.Mutex
 	}
@@ -81,6 +94,19 @@ type (
 	errStopWalking struct {
 		*errors.NashError
 	}
+
+	// nashrootError is the error of an invalid nashroot.
+	nashrootError struct {
+		error
+	}
+
+	// importPath is a location searched by import: a file of fsys or,
+	// if fsys is nil, of the disk.
+	importPath struct {
+		fsys fs.FS
+		name string // name of fsys on error messages
+		path string
+	}
 )
 
 const (
@@ -133,7 +159,6 @@ func newShell(nashpath string, nashroot string, abort bool) (*Shell, error) {
 		abortOnErr:  abort,
 		isFn:        false,
 		logf:        NewLog(logNS, false),
-		nashdPath:   nashdAutoDiscover(),
 		stdout:      os.Stdout,
 		stderr:      os.Stderr,
 		stdin:       os.Stdin,
@@ -153,12 +178,24 @@ func newShell(nashpath string, nashroot string, abort bool) (*Shell, error) {
 	}
 
 	shell.setupSignals()
+
+	// the rfork blocks on a new root filesystem have no nashpath and
+	// nashroot
+	if nashpath == "" && nashroot == "" {
+		return shell, nil
+	}
+
 	err = validateDirs(nashpath, nashroot)
 	if err != nil {
 		if shell.abortOnErr {
 			return nil, err
 		}
 
+		// the stdlib is embedded, so nashroot is not required
+		if _, ok := err.(nashrootError); ok {
+			return shell, nil
+		}
+
 		printerr := func(msg string) {
 			shell.Stderr().Write([]byte(msg + "\n"))
 		}
@@ -179,7 +216,7 @@ func NewSubShell(name string, parent *Shell) *Shell {
 		isFn:      true,
 		parent:    parent,
 		logf:      NewLog(logNS, false),
-		nashdPath: nashdAutoDiscover(),
+		nashdPath: parent.nashdPath,
 		stdout:    parent.Stdout(),
 		stderr:    parent.Stderr(),
 		stdin:     parent.Stdin(),
@@ -188,6 +225,13 @@ func NewSubShell(name string, parent *Shell) *Shell {
 		binds:     make(Fns),
 		Mutex:     parent.Mutex,
 		filename:  parent.filename,
+		filefs:    parent.filefs,
+		fsys:      parent.fsys,
+		profiler:  parent.profiler,
+		coverage:  parent.coverage,
+
+		cmdResolver: parent.cmdResolver,
+		mocks:       parent.mocks,
 	}
 }
 
@@ -421,7 +465,8 @@ func (shell *Shell) Setvar(name string, value sh.Obj) bool {
 func (shell *Shell) IsFn() bool     { return shell.isFn }
 func (shell *Shell) SetIsFn(b bool) { shell.isFn = b }
 
-// SetNashdPath sets an alternativa path to nashd
+// SetNashdPath sets the program executed to run the rfork blocks,
+// instead of the running one. It must be a program using nash.
 func (shell *Shell) SetNashdPath(path string) {
 	shell.nashdPath = path
 }
@@ -580,21 +625,54 @@ func (shell *Shell) Exec(path, content string) error {
 
 // Execute the nash file at given path
 func (shell *Shell) ExecFile(path string) error {
-	bkCurFile := shell.filename
-
 	content, err := ioutil.ReadFile(path)
 
 	if err != nil {
 		return err
 	}
 
-	shell.filename = path
+	return shell.execFile(nil, path, content)
+}
+
+// ExecFileFS executes the nash file at the given path of fsys. The
+// scripts imported by it are searched first in fsys.
+func (shell *Shell) ExecFileFS(fsys fs.FS, path string) error {
+	content, err := fs.ReadFile(fsys, path)
+	if err != nil {
+		return err
+	}
+
+	return shell.execFile(fsys, path, content)
+}
+
+// SetFS sets a filesystem where the scripts imported are searched, as
+// a library directory, when not found on disk.
+func (shell *Shell) SetFS(fsys fs.FS) {
+	shell.fsys = fsys
+}
+
+func (shell *Shell) execFile(fsys fs.FS, path string, content []byte) error {
+	bkCurFile, bkCurFS := shell.filename, shell.filefs
+
+	shell.filename, shell.filefs = path, fsys
 
 	defer func() {
-		shell.filename = bkCurFile
+		shell.filename, shell.filefs = bkCurFile, bkCurFS
 	}()
 
-	return shell.Exec(path, string(content))
+	if shell.coverage == nil {
+		return shell.Exec(path, string(content))
+	}
+
+	tr, err := parser.NewParser(path, string(content)).Parse()
+	if err != nil {
+		return err
+	}
+
+	shell.coverage.Register(path, tr)
+
+	_, err = shell.ExecuteTree(tr)
+	return err
 }
 
 func (shell *Shell) newvar(name *ast.NameNode, value sh.Obj) error {
@@ -824,6 +902,15 @@ func (shell *Shell) executeNode(node ast.Node) ([]sh.Obj, error) {
 
 	shell.logf("Executing node: %v\n", node)
 
+	if shell.coverage != nil && node.Type() != ast.NodeComment {
+		shell.coverage.hit(shell.filename, node)
+	}
+
+	if shell.profiler != nil && node.Type() != ast.NodeComment {
+		frame := shell.profEnter(profNodeName(shell.filename, node))
+		defer shell.profLeave(frame)
+	}
+
 	switch node.Type() {
 	case ast.NodeImport:
 		err = shell.executeImport(node.(*ast.ImportNode))
@@ -844,7 +931,8 @@ func (shell *Shell) executeNode(node ast.Node) ([]sh.Obj, error) {
 	case ast.NodePipe:
 		_, err = shell.executePipe(node.(*ast.PipeNode))
 	case ast.NodeRfork:
-		err = shell.executeRfork(node.(*ast.RforkNode))
+		// the values returned by the block are ignored
+		_, err = shell.executeRfork(node.(*ast.RforkNode))
 	case ast.NodeIf:
 		objs, err = shell.executeIf(node.(*ast.IfNode))
 	case ast.NodeFnDecl:
@@ -949,11 +1037,6 @@ func (shell *Shell) getNashRootFromGOPATH(preverr error) (string, error) {
 	return filepath.Join(gopath, filepath.FromSlash("/src/github.com/madlambda/nash")), nil
 }
 
-func isValidNashRoot(nashroot string) bool {
-	_, err := os.Stat(filepath.Join(nashroot, "stdlib"))
-	return err == nil
-}
-
 func (shell *Shell) executeImport(node *ast.ImportNode) error {
 	obj, err := shell.evalExpr(node.Path)
 	if err != nil {
@@ -972,57 +1055,106 @@ func (shell *Shell) executeImport(node *ast.ImportNode) error {
 
 	shell.logf("Importing '%s'", fname)
 
-	var (
-		tries  []string
-		hasExt bool
-	)
+	tries := shell.importPaths(fname)
 
-	hasExt = filepath.Ext(fname) != ""
-	if filepath.IsAbs(fname) {
-		tries = append(tries, fname)
+	shell.logf("Trying %q\n", tries)
 
-		if !hasExt {
-			tries = append(tries, fname+".sh")
+	for _, try := range tries {
+		if try.fsys != nil {
+			d, err := fs.Stat(try.fsys, try.path)
+			if err == nil && !d.IsDir() {
+				return shell.ExecFileFS(try.fsys, try.path)
+			}
+			continue
+		}
+
+		d, err := os.Stat(try.path)
+
+		if err != nil {
+			continue
 		}
+
+		if m := d.Mode(); !m.IsDir() {
+			return shell.ExecFile(try.path)
+		}
+	}
+
+	paths := make([]string, len(tries))
+	for i, try := range tries {
+		paths[i] = try.String()
 	}
 
-	if shell.filename != "" {
-		localFile := filepath.Join(filepath.Dir(shell.filename), fname)
-		tries = append(tries, localFile)
+	errmsg := fmt.Sprintf(
+		"Failed to import path '%s'. The locations below have been tried:\n \"%s\"",
+		fname,
+		strings.Join(paths, `", "`),
+	)
+
+	return errors.NewEvalError(shell.filename, node, errmsg)
+}
+
+// importPaths returns the locations searched, in order, when importing
+// fname. After the disk, the scripts are searched in the filesystem set
+// by SetFS and in the embedded standard library. The imports of scripts
+// of a filesystem are searched first relative to them, in the same
+// filesystem.
+func (shell *Shell) importPaths(fname string) []importPath {
+	var (
+		tries  []importPath
+		hasExt bool
+	)
+
+	add := func(fsys fs.FS, name, fpath string) {
+		tries = append(tries, importPath{fsys: fsys, name: name, path: fpath})
 
 		if !hasExt {
-			tries = append(tries, localFile+".sh")
+			tries = append(tries, importPath{fsys: fsys, name: name, path: fpath + ".sh"})
 		}
 	}
 
-	tries = append(tries, filepath.Join(shell.nashpath, "lib", fname))
-	if !hasExt {
-		tries = append(tries, filepath.Join(shell.nashpath, "lib", fname+".sh"))
+	hasExt = filepath.Ext(fname) != ""
+	if filepath.IsAbs(fname) {
+		add(nil, "", fname)
 	}
 
-	tries = append(tries, filepath.Join(shell.nashroot, "stdlib", fname+".sh"))
+	if shell.filefs != nil {
+		localFile := path.Join(path.Dir(shell.filename), filepath.ToSlash(fname))
+		if fs.ValidPath(localFile) {
+			add(shell.filefs, fsName(shell.filefs), localFile)
+		}
+	} else if shell.filename != "" {
+		add(nil, "", filepath.Join(filepath.Dir(shell.filename), fname))
+	}
 
-	shell.logf("Trying %q\n", tries)
+	add(nil, "", filepath.Join(shell.nashpath, "lib", fname))
 
-	for _, path := range tries {
-		d, err := os.Stat(path)
+	tries = append(tries, importPath{path: filepath.Join(shell.nashroot, "stdlib", fname+".sh")})
 
-		if err != nil {
-			continue
+	if shell.fsys != nil {
+		if libFile := path.Join("lib", filepath.ToSlash(fname)); fs.ValidPath(libFile) {
+			add(shell.fsys, "embedded", libFile)
 		}
+	}
 
-		if m := d.Mode(); !m.IsDir() {
-			return shell.ExecFile(path)
-		}
+	if stdFile := filepath.ToSlash(fname) + ".sh"; fs.ValidPath(stdFile) {
+		tries = append(tries, importPath{fsys: stdlib.FS, name: "stdlib", path: stdFile})
 	}
 
-	errmsg := fmt.Sprintf(
-		"Failed to import path '%s'. The locations below have been tried:\n \"%s\"",
-		fname,
-		strings.Join(tries, `", "`),
-	)
+	return tries
+}
 
-	return errors.NewEvalError(shell.filename, node, errmsg)
+func fsName(fsys fs.FS) string {
+	if fsys == fs.FS(stdlib.FS) {
+		return "stdlib"
+	}
+	return "embedded"
+}
+
+func (p importPath) String() string {
+	if p.fsys == nil {
+		return p.path
+	}
+	return p.name + ":" + p.path
 }
 
 // executePipe executes a pipe of ast.Command's. Each command can be
@@ -1153,6 +1285,11 @@ func (shell *Shell) executePipe(pipe *ast.PipeNode) (sh.Obj, error) {
 		goto pipeError
 	}
 
+	if shell.profiler != nil {
+		start := time.Now()
+		defer func() { shell.profExec(time.Since(start)) }()
+	}
+
 	for i := 0; i < len(cmds); i++ {
 		cmd := cmds[i]
 
@@ -1424,6 +1561,14 @@ func (shell *Shell) getCommand(c *ast.CommandNode) (sh.Runner, bool, error) {
 		return runner, ignoreError, err
 	}
 
+	if fn := shell.resolveCmd(cmdName); fn != nil {
+		cmd := newGoCmd(cmdName, fn)
+		cmd.SetStdin(shell.stdin)
+		cmd.SetStdout(shell.stdout)
+		cmd.SetStderr(shell.stderr)
+		return cmd, ignoreError, nil
+	}
+
 	cmd, err = NewCmd(cmdName)
 
 	if err != nil {
@@ -1490,6 +1635,11 @@ func (shell *Shell) executeCommand(c *ast.CommandNode) (sh.Obj, error) {
 		goto cmdError
 	}
 
+	if _, ok := cmd.(*Cmd); ok && shell.profiler != nil {
+		start := time.Now()
+		defer func() { shell.profExec(time.Since(start)) }()
+	}
+
 	err = cmd.Start()
 	if err != nil {
 		goto cmdError
@@ -2022,6 +2172,21 @@ func (shell *Shell) executeExecAssignFn(assign *ast.ExecAssignNode) ([]sh.Obj, e
 	return fnValues, nil
 }
 
+func (shell *Shell) executeExecAssignRfork(assign *ast.ExecAssignNode) ([]sh.Obj, error) {
+	values, err := shell.executeRfork(assign.Command().(*ast.RforkNode))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(values) != len(assign.Names) {
+		return nil, errors.NewEvalError(shell.filename,
+			assign, "rfork returns %d objects, but statement expects %d",
+			len(values), len(assign.Names))
+	}
+
+	return values, nil
+}
+
 func (shell *Shell) executeExecAssign(v *ast.ExecAssignNode) (err error) {
 	exec := v.Command()
 	switch exec.Type() {
@@ -2032,6 +2197,13 @@ func (shell *Shell) executeExecAssign(v *ast.ExecAssignNode) (err error) {
 			return err
 		}
 		err = shell.setvars(v.Names, values)
+	case ast.NodeRfork:
+		var values []sh.Obj
+		values, err = shell.executeExecAssignRfork(v)
+		if err != nil {
+			return err
+		}
+		err = shell.setvars(v.Names, values)
 	case ast.NodeCommand, ast.NodePipe:
 		var stdout, stderr, status sh.Obj
 		stdout, stderr, status, err = shell.executeExecAssignCmd(v)
@@ -2042,7 +2214,7 @@ func (shell *Shell) executeExecAssign(v *ast.ExecAssignNode) (err error) {
 		err = shell.setcmdvars(v.Names, stdout, stderr, status)
 	default:
 		err = errors.NewEvalError(shell.filename,
-			exec, "Invalid node type (%v). Expected function call, command or pipe",
+			exec, "Invalid node type (%v). Expected function call, command, pipe or rfork",
 			exec)
 	}
 
@@ -2090,6 +2262,13 @@ func (shell *Shell) executeVarExecAssign(v *ast.VarExecAssignDeclNode) (err erro
 			return err
 		}
 		shell.newvars(assign.Names, values)
+	case ast.NodeRfork:
+		var values []sh.Obj
+		values, err = shell.executeExecAssignRfork(assign)
+		if err != nil {
+			return err
+		}
+		shell.newvars(assign.Names, values)
 	case ast.NodeCommand, ast.NodePipe:
 		var stdout, stderr, status sh.Obj
 		stdout, stderr, status, err = shell.executeExecAssignCmd(assign)
@@ -2100,7 +2279,7 @@ func (shell *Shell) executeVarExecAssign(v *ast.VarExecAssignDeclNode) (err erro
 		shell.newcmdvars(assign.Names, stdout, stderr, status)
 	default:
 		err = errors.NewEvalError(shell.filename,
-			exec, "Invalid node type (%v). Expected function call, command or pipe",
+			exec, "Invalid node type (%v). Expected function call, command, pipe or rfork",
 			exec)
 	}
 
@@ -2256,6 +2435,16 @@ func (shell *Shell) executeFnInv(n *ast.FnInvNode) ([]sh.Obj, error) {
 			n, err.Error())
 	}
 
+	if shell.profiler != nil {
+		frame := shell.profEnter("fn " + fnDef.Name())
+		defer shell.profLeave(frame)
+
+		if userfn, ok := fn.(*UserFn); ok {
+			userfn.subshell.profiler = shell.profiler
+			userfn.subshell.frames = shell.callFrames()
+		}
+	}
+
 	fn.SetStdin(shell.stdin)
 	fn.SetStdout(shell.stdout)
 	fn.SetStderr(shell.stderr)
@@ -2481,7 +2670,7 @@ func validateDirs(nashpath string, nashroot string) error {
 	}
 	err = validateDir(nashroot)
 	if err != nil {
-		return fmt.Errorf("invalid nashroot, stdlib/stdbin won't be available: error: %s", err)
+		return nashrootError{fmt.Errorf("invalid nashroot, stdlib/stdbin won't be available: error: %s", err)}
 	}
 	return nil
 }
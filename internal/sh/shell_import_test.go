@@ -5,6 +5,7 @@ import (
 	"path/filepath"
 	"strings"
 	"testing"
+	"testing/fstest"
 
 	"github.com/madlambda/nash/internal/sh"
 	"github.com/madlambda/nash/internal/sh/internal/fixture"
@@ -103,6 +104,74 @@ func TestImportsLibFromWorkingDirBeforeLibAndStdlib(t *testing.T) {
 	`, "localcode\n")
 }
 
+func TestImportsLibFromEmbeddedStdlib(t *testing.T) {
+
+	nashdirs := fixture.SetupNashDirs(t)
+	defer nashdirs.Cleanup()
+
+	newTestShell(t, nashdirs.Path, nashdirs.Root).ExecCheckingOutput(t, `
+		import io
+		io_println("embedded %s", "stdlib")
+	`, "embedded stdlib\n")
+}
+
+func TestImportsLibFromFS(t *testing.T) {
+
+	nashdirs := fixture.SetupNashDirs(t)
+	defer nashdirs.Cleanup()
+
+	writeFile(t, filepath.Join(nashdirs.Lib, "lib.sh"), `
+		fn test() {
+			echo "libcode"
+		}
+	`)
+
+	fsys := fstest.MapFS{
+		"main.sh": {Data: []byte(`
+			import tools/local
+			import lib
+			import embedded
+			local()
+			test()
+			embedded()
+		`)},
+		"tools/local.sh": {Data: []byte(`
+			import sibling
+			fn local() {
+				sibling()
+			}
+		`)},
+		"tools/sibling.sh": {Data: []byte(`
+			fn sibling() {
+				echo "localcode"
+			}
+		`)},
+		"lib/lib.sh": {Data: []byte(`
+			fn test() {
+				echo "embeddedlibcode"
+			}
+		`)},
+		"lib/embedded.sh": {Data: []byte(`
+			fn embedded() {
+				echo "embeddedcode"
+			}
+		`)},
+	}
+
+	shell := newTestShell(t, nashdirs.Path, nashdirs.Root)
+	shell.shell.SetFS(fsys)
+
+	err := shell.shell.ExecFileFS(fsys, "main.sh")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := "localcode\nlibcode\nembeddedcode\n"
+	if got := shell.stdout.String(); got != want {
+		t.Fatalf("expected output: [%s] got: [%s]", want, got)
+	}
+}
+
 func TestStdErrOnInvalidSearchPaths(t *testing.T) {
 	type testCase struct {
 		name     string
@@ -3,12 +3,15 @@
 package sh_test
 
 import (
+	"archive/tar"
 	"bytes"
 	"fmt"
 	"io/ioutil"
 	"os"
 	"os/exec"
+	"runtime"
 	"strings"
+	"syscall"
 	"testing"
 )
 
@@ -147,3 +150,515 @@ func TestExecuteRforkUserNSNested(t *testing.T) {
 		return
 	}
 }
+
+func TestExecuteRforkStatus(t *testing.T) {
+	if !enableUserNS {
+		t.Skip("User namespace not enabled")
+		return
+	}
+
+	f, teardown := setup(t)
+	defer teardown()
+
+	err := f.shell.Exec("rfork status", `rfork u {
+	echo before
+	sh -c "exit 3"
+	echo after
+}`)
+
+	if err == nil {
+		t.Fatal("expected error")
+	}
+
+	if !strings.HasSuffix(err.Error(), ":3:1: exit status 3") {
+		t.Fatalf("expected the position of the failed statement but got: %s", err)
+	}
+
+	exiterr, ok := err.(interface{ ExitStatus() int })
+	if !ok || exiterr.ExitStatus() != 3 {
+		t.Fatalf("expected exit status 3 but got: %#v", err)
+	}
+
+	if got := f.shellOut.String(); got != "before\n" {
+		t.Fatalf("expected [before] but got: [%s]", got)
+	}
+}
+
+func TestExecuteRforkReturn(t *testing.T) {
+	if !enableUserNS {
+		t.Skip("User namespace not enabled")
+		return
+	}
+
+	f, teardown := setup(t)
+	defer teardown()
+
+	err := f.shell.Exec("rfork return", `
+        fn probe() {
+            var uid, names <= rfork u {
+                var uid <= id -u
+                if $uid == "0" {
+                    return $uid, ("a" "b")
+                }
+                echo notreached
+            }
+
+            # the return ends only the rfork block
+            rfork u {
+                return "ignored"
+            }
+
+            return $uid, $names
+        }
+
+        var uid, names <= probe()
+        echo $uid $names
+        `)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := f.shellOut.String(); got != "0 a b\n" {
+		t.Fatalf("expected [0 a b] but got: [%s]", got)
+	}
+
+	err = f.shell.Exec("rfork return", `var v <= rfork u {
+	echo nothing
+}`)
+
+	if err == nil || !strings.Contains(err.Error(), "rfork returns 0 objects, but statement expects 1") {
+		t.Fatalf("expected error assigning no values but got: %v", err)
+	}
+}
+
+func TestExecuteRforkCgroupTimeNS(t *testing.T) {
+	if !enableUserNS {
+		t.Skip("User namespace not enabled")
+		return
+	}
+
+	for _, ns := range []string{"cgroup", "time"} {
+		if _, err := os.Stat("/proc/self/ns/" + ns); err != nil {
+			t.Skipf("%s namespace not supported: %s", ns, err)
+		}
+	}
+
+	f, teardown := setup(t)
+	defer teardown()
+
+	err := f.shell.Exec("rfork cgroup time", `
+        var ns <= rfork ugt {
+            var cgroup <= readlink /proc/self/ns/cgroup
+            var time <= readlink /proc/self/ns/time
+            return ($cgroup $time)
+        }
+        echo -n $ns
+        `)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := strings.Split(f.shellOut.String(), " ")
+	if len(got) != 2 {
+		t.Fatalf("expected the cgroup and time namespaces but got: [%s]", f.shellOut)
+	}
+
+	for i, ns := range []string{"cgroup", "time"} {
+		parent, err := os.Readlink("/proc/self/ns/" + ns)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got[i] == parent {
+			t.Errorf("expected a new %s namespace but got the parent one: %s", ns, parent)
+		}
+	}
+}
+
+func TestExecuteRforkPropagation(t *testing.T) {
+	if !enableUserNS {
+		t.Skip("User namespace not enabled")
+		return
+	}
+
+	dir, err := ioutil.TempDir("", "nash-rfork")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	f, teardown := setup(t)
+	defer teardown()
+
+	// the mounts on the shared dir propagate to the namespace of the
+	// outer block, unless the propagation of the inner one is private
+	err = f.shell.Exec("rfork propagation", fmt.Sprintf(`
+        var dir = "%s"
+        setenv dir
+
+        var files <= rfork um {
+            mount("tmpfs", $dir, "tmpfs")
+            mount("", $dir, "", "shared")
+            mkdir $dir+"/shared" $dir+"/private"
+
+            rfork m {
+                mount("tmpfs", $dir+"/shared", "tmpfs")
+                echo -n leaked > $dir+"/shared/file"
+            }
+
+            rfork m ("propagation=private") {
+                mount("tmpfs", $dir+"/private", "tmpfs")
+                echo -n leaked > $dir+"/private/file"
+            }
+
+            var files, err <= glob($dir+"/*/file")
+            return $files
+        }
+        echo -n $files
+        `, dir))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := f.shellOut.String(), dir+"/shared/file"; got != want {
+		t.Fatalf("expected [%s] but got: [%s]", want, got)
+	}
+
+	err = f.shell.Exec("rfork propagation", `rfork u ("propagation=private") {
+	true
+}`)
+
+	if err == nil || !strings.Contains(err.Error(), "propagation option requires the m flag") {
+		t.Fatalf("expected error without the m flag but got: %v", err)
+	}
+
+	err = f.shell.Exec("rfork propagation", `rfork um ("propagation=none") {
+	true
+}`)
+
+	if err == nil || !strings.Contains(err.Error(), `Invalid rfork propagation "none"`) {
+		t.Fatalf("expected invalid propagation error but got: %v", err)
+	}
+}
+
+func TestExecuteRforkIDMappings(t *testing.T) {
+	if !enableUserNS {
+		t.Skip("User namespace not enabled")
+		return
+	}
+
+	// other users need newuidmap and newgidmap with subordinate ids
+	if os.Geteuid() != 0 {
+		t.Skip("Mapping ranges of ids requires root")
+	}
+
+	dir, err := ioutil.TempDir("", "nash-rfork")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	f, teardown := setup(t)
+	defer teardown()
+
+	err = f.shell.Exec("rfork idmap", fmt.Sprintf(`
+        var dir = "%s"
+        setenv dir
+
+        var setgroups <= rfork u ("uidmap=0:0:1" "uidmap=1:100000:65536" "gidmap=0:0:1" "gidmap=1:200000:65536" "setgroups=allow") {
+            touch $dir+"/file"
+            chown 1000:2000 $dir+"/file"
+
+            var setgroups <= cat /proc/self/setgroups
+            return $setgroups
+        }
+
+        var deny <= rfork u {
+            var setgroups <= cat /proc/self/setgroups
+            return $setgroups
+        }
+
+        echo -n $setgroups $deny
+        `, dir))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := f.shellOut.String(); got != "allow deny" {
+		t.Fatalf("expected [allow deny] but got: [%s]", got)
+	}
+
+	info, err := os.Stat(dir + "/file")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stat := info.Sys().(*syscall.Stat_t)
+	if stat.Uid != 100999 || stat.Gid != 201999 {
+		t.Fatalf("expected owner 100999:201999 but got %d:%d", stat.Uid, stat.Gid)
+	}
+}
+
+func TestExecuteRforkLimits(t *testing.T) {
+	if !enableUserNS {
+		t.Skip("User namespace not enabled")
+		return
+	}
+
+	skipWithoutCgroupControllers(t, "memory", "pids")
+
+	f, teardown := setup(t)
+	defer teardown()
+
+	err := f.shell.Exec("rfork limits", `
+        var max <= rfork u ("pids.max=20" "memory.max=64M") {
+            var cgroup <= grep "^0::" /proc/self/cgroup
+            var path <= split($cgroup, ":")
+            var pids <= cat "/sys/fs/cgroup"+$path[2]+"/pids.max"
+            return $pids
+        }
+        echo -n $max
+        `)
+
+	if err != nil && strings.Contains(err.Error(), "other processes are in the cgroup") {
+		t.Skip(err)
+	}
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := f.shellOut.String(); got != "20" {
+		t.Fatalf("expected pids.max 20 but got: [%s]", got)
+	}
+
+	// tail keeps the input in memory until a newline
+	err = f.shell.Exec("rfork oom", `rfork u ("memory.max=16M" "memory.swap.max=0") {
+	head -c 128M /dev/zero | tail -n 1
+}`)
+
+	exiterr, ok := err.(interface{ ExitStatus() int })
+	if !ok || exiterr.ExitStatus() != 137 || !strings.Contains(err.Error(), "OOM killer") {
+		t.Fatalf("expected OOM error with status 137 but got: %v", err)
+	}
+}
+
+func skipWithoutCgroupControllers(t *testing.T, controllers ...string) {
+	content, err := ioutil.ReadFile("/proc/self/cgroup")
+	if err != nil {
+		t.Skip(err)
+	}
+
+	for _, line := range strings.Split(string(content), "\n") {
+		if !strings.HasPrefix(line, "0::") {
+			continue
+		}
+
+		available, err := ioutil.ReadFile("/sys/fs/cgroup" + line[len("0::"):] + "/cgroup.controllers")
+		if err != nil {
+			t.Skip(err)
+		}
+
+		for _, controller := range controllers {
+			if !strings.Contains(" "+string(available)+" ", " "+controller+" ") {
+				t.Skipf("cgroup controller %s not available", controller)
+			}
+		}
+		return
+	}
+
+	t.Skip("no cgroup v2")
+}
+
+func TestExecuteRforkRestrictions(t *testing.T) {
+	if !enableUserNS {
+		t.Skip("User namespace not enabled")
+		return
+	}
+
+	if runtime.GOARCH != "amd64" && runtime.GOARCH != "arm64" {
+		t.Skip("seccomp not supported")
+	}
+
+	dir, err := ioutil.TempDir("", "nash-rfork")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	profile := dir + "/profile.json"
+	err = ioutil.WriteFile(profile, []byte(`{
+		"defaultAction": "SCMP_ACT_ALLOW",
+		"syscalls": [{"names": ["mkdir", "mkdirat"], "action": "SCMP_ACT_ERRNO"}]
+	}`), 0644)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, teardown := setup(t)
+	defer teardown()
+
+	err = f.shell.Exec("rfork restrictions", fmt.Sprintf(`
+        var dir = "%s"
+        setenv dir
+
+        rfork u ("seccomp=default" "no_new_privs" "dropcaps=all") {
+            grep -E "^(NoNewPrivs|Seccomp|CapBnd):" /proc/self/status
+        }
+
+        var out <= rfork u ("seccomp="+$dir+"/profile.json") {
+            var out, status <= mkdir $dir+"/denied" >[2=1]
+            return $status
+        }
+        echo $out
+        `, dir))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "CapBnd:\t0000000000000000\nNoNewPrivs:\t1\nSeccomp:\t2\n1\n"
+	if got := f.shellOut.String(); got != expected {
+		t.Fatalf("expected [%s] but got: [%s]", expected, got)
+	}
+
+	if _, err := os.Stat(dir + "/denied"); !os.IsNotExist(err) {
+		t.Fatalf("expected mkdir denied by the seccomp profile but got: %v", err)
+	}
+}
+
+func TestExecuteRforkSeccompNamespaces(t *testing.T) {
+	if !enableUserNS {
+		t.Skip("User namespace not enabled")
+		return
+	}
+
+	if runtime.GOARCH != "amd64" && runtime.GOARCH != "arm64" {
+		t.Skip("seccomp not supported")
+	}
+
+	f, teardown := setup(t)
+	defer teardown()
+
+	// the nested rfork creates its namespace with clone
+	err := f.shell.Exec("rfork seccomp clone", `
+        rfork u ("seccomp=default") {
+            echo outer
+            rfork u {
+                echo inner
+            }
+        }
+        `)
+
+	if err == nil || !strings.Contains(err.Error(), "operation not permitted") {
+		t.Fatalf("expected clone with CLONE_NEWUSER denied but got: %v", err)
+	}
+
+	if got := f.shellOut.String(); got != "outer\n" {
+		t.Fatalf("expected [outer\n] but got: [%s]", got)
+	}
+}
+
+func TestExecuteRforkRootfs(t *testing.T) {
+	if !enableUserNS {
+		t.Skip("User namespace not enabled")
+		return
+	}
+
+	dir, err := ioutil.TempDir("", "nash-rfork")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var buf bytes.Buffer
+
+	tw := tar.NewWriter(&buf)
+	for _, name := range []string{"etc/hostname", "usr/bin/tool"} {
+		err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Typeflag: tar.TypeReg})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	writeFile(t, dir+"/rootfs.tar", buf.String())
+
+	for _, path := range []string{"/src/project", "/tmp"} {
+		if err := os.MkdirAll(dir+path, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	f, teardown := setup(t)
+	defer teardown()
+
+	// the parent shell creates the mount point of the root filesystem
+	// on the temporary directory
+	tmpdir := os.Getenv("TMPDIR")
+	os.Setenv("TMPDIR", dir+"/tmp")
+	defer os.Setenv("TMPDIR", tmpdir)
+
+	err = f.shell.Exec("rfork rootfs", fmt.Sprintf(`
+        var dir = "%s"
+        setenv dir
+
+        var files <= rfork um ("rootfs="+$dir+"/rootfs.tar" "bind="+$dir+"/src:/work") {
+            var files, err <= glob("/*/*")
+            return $files
+        }
+        echo -n $files
+        `, dir))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := f.shellOut.String(), "/etc/hostname /usr/bin /work/project"; got != want {
+		t.Fatalf("expected [%s] but got: [%s]", want, got)
+	}
+
+	f.shellOut.Reset()
+
+	// proc is mounted on new pid namespaces
+	err = f.shell.Exec("rfork rootfs", fmt.Sprintf(`
+        var files <= rfork upm ("rootfs=%s/rootfs.tar") {
+            var files, err <= glob("/proc/1/status")
+            return $files
+        }
+        echo -n $files
+        `, dir))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := f.shellOut.String(), "/proc/1/status"; got != want {
+		t.Fatalf("expected [%s] but got: [%s]", want, got)
+	}
+
+	// the mount point is removed after the block ends
+	files, err := ioutil.ReadDir(dir + "/tmp")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(files) != 0 {
+		t.Fatalf("expected no files on %s but got %d", dir+"/tmp", len(files))
+	}
+
+	err = f.shell.Exec("rfork rootfs", `rfork u ("rootfs=/rootfs.tar") {
+	true
+}`)
+
+	if err == nil || !strings.Contains(err.Error(), "rootfs option requires the m flag") {
+		t.Fatalf("expected error without the m flag but got: %v", err)
+	}
+}
@@ -3,30 +3,14 @@ package sh
 import (
 	"fmt"
 	"io"
-	"math/rand"
 	"os"
 	"os/exec"
 	"strconv"
 	"syscall"
-	"time"
 
 	"github.com/madlambda/nash/sh"
 )
 
-var letterRunes = []rune("abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ")
-
-func init() {
-	rand.Seed(time.Now().UnixNano())
-}
-
-func randRunes(n int) string {
-	b := make([]rune, n)
-	for i := range b {
-		b[i] = letterRunes[rand.Intn(len(letterRunes))]
-	}
-	return string(b)
-}
-
 func buildenv(e Env) []string {
 	env := make([]string, 0, len(e))
 
@@ -73,6 +57,8 @@ func getErrStatus(err error, def string) string {
 		if statusObj, ok := exiterr.Sys().(syscall.WaitStatus); ok {
 			status = strconv.Itoa(statusObj.ExitStatus())
 		}
+	} else if exiterr, ok := err.(*errExitStatus); ok {
+		status = strconv.Itoa(exiterr.ExitStatus())
 	}
 
 	return status
@@ -6,6 +6,7 @@ import (
 	"bytes"
 	"fmt"
 	"io"
+	"io/fs"
 
 	"github.com/madlambda/nash/ast"
 	shell "github.com/madlambda/nash/internal/sh"
@@ -17,6 +18,15 @@ type (
 	Shell struct {
 		interp *shell.Shell
 	}
+
+	// CmdFunc is a command implemented in Go. It receives the command
+	// arguments (without the command name), the environment and the
+	// standard file descriptors and returns the exit status.
+	CmdFunc = shell.CmdFunc
+
+	// CmdResolver resolves a command name to a Go implementation.
+	// It must return nil for commands that should be looked up on PATH.
+	CmdResolver = shell.CmdResolver
 )
 
 func newShell(nashpath string, nashroot string, abort bool) (*Shell, error) {
@@ -91,11 +101,115 @@ func (nash *Shell) Prompt() string {
 	return "<no prompt> "
 }
 
-// SetNashdPath sets an alternativa path to nashd
+// SetNashdPath sets the program executed to run the rfork blocks,
+// instead of the running one. It must be a program using nash.
 func (nash *Shell) SetNashdPath(path string) {
 	nash.interp.SetNashdPath(path)
 }
 
+// EnableProfile enables the profiling of every statement and function
+// call executed by the shell from now on.
+func (nash *Shell) EnableProfile() {
+	if nash.interp.Profiler() == nil {
+		nash.interp.SetProfiler(shell.NewProfiler())
+	}
+}
+
+// WriteProfile writes a report of the time spent on each statement and
+// function call, sorted by cumulative time.
+// The profile must be enabled with EnableProfile.
+func (nash *Shell) WriteProfile(out io.Writer) error {
+	prof := nash.interp.Profiler()
+	if prof == nil {
+		return fmt.Errorf("profiling is not enabled")
+	}
+	return prof.WriteReport(out)
+}
+
+// WriteProfileFolded writes the profile in the folded stacks format
+// used by flamegraph tools.
+// The profile must be enabled with EnableProfile.
+func (nash *Shell) WriteProfileFolded(out io.Writer) error {
+	prof := nash.interp.Profiler()
+	if prof == nil {
+		return fmt.Errorf("profiling is not enabled")
+	}
+	return prof.WriteFolded(out)
+}
+
+// EnableCoverage enables the recording of which statements of the
+// executed script files were run.
+func (nash *Shell) EnableCoverage() {
+	if nash.interp.Coverage() == nil {
+		nash.interp.SetCoverage(shell.NewCoverage())
+	}
+}
+
+// MergeCoverage adds the hits of a coverage previously written by
+// WriteCoverage, in any of the supported formats, to the coverage of
+// the shell.
+// The coverage must be enabled with EnableCoverage.
+func (nash *Shell) MergeCoverage(in io.Reader) error {
+	cover := nash.interp.Coverage()
+	if cover == nil {
+		return fmt.Errorf("coverage is not enabled")
+	}
+	return cover.Merge(in)
+}
+
+// WriteCoverage writes the statement coverage of the executed files.
+// The format can be "lcov" (LCOV tracefile) or "go" (Go cover profile).
+// The coverage must be enabled with EnableCoverage.
+func (nash *Shell) WriteCoverage(out io.Writer, format string) error {
+	cover := nash.interp.Coverage()
+	if cover == nil {
+		return fmt.Errorf("coverage is not enabled")
+	}
+
+	switch format {
+	case "lcov":
+		return cover.WriteLCOV(out)
+	case "go":
+		return cover.WriteCoverProfile(out)
+	}
+
+	return fmt.Errorf("unknown coverage format: %s", format)
+}
+
+// SetCmdResolver sets the resolver of the commands executed by the
+// shell. Functions bound to commands (bindfn) take precedence over it.
+func (nash *Shell) SetCmdResolver(resolver CmdResolver) {
+	nash.interp.SetCmdResolver(resolver)
+}
+
+// MockCmd replaces the command name by fn and starts recording its
+// invocations. Mocks take precedence over the command resolver.
+func (nash *Shell) MockCmd(name string, fn CmdFunc) {
+	mocks := nash.interp.CmdMocks()
+	if mocks == nil {
+		mocks = shell.NewCmdMocks()
+		nash.interp.SetCmdMocks(mocks)
+	}
+	mocks.Mock(name, fn)
+}
+
+// MockCalls returns the arguments of every invocation of the mocked
+// command name.
+func (nash *Shell) MockCalls(name string) [][]string {
+	mocks := nash.interp.CmdMocks()
+	if mocks == nil {
+		return nil
+	}
+	return mocks.Calls(name)
+}
+
+// EnableTestMode adds the builtin functions for tests to the shell:
+// mock(name, fn) replaces a command by a nash function and
+// mock_calls(name) returns the arguments of each invocation of it.
+func (nash *Shell) EnableTestMode() {
+	nash.interp.EnableTestMode()
+}
+
 // Exec executes the code specified by string content.
 // By default, nash uses os.Stdin, os.Stdout and os.Stderr as input, output
 // and error file descriptors. You can change it with SetStdin, SetStdout and Stderr,
@@ -135,15 +249,41 @@ func (nash *Shell) ExecuteString(path, content string) error {
 // ExecFile executes the script content of the file specified by path
 // and passes as arguments to the script the given args slice.
 func (nash *Shell) ExecFile(path string, args ...string) error {
-	if len(args) > 0 {
-		err := nash.ExecuteString("setting args", `var ARGS = `+args2Nash(args))
-		if err != nil {
-			return fmt.Errorf("Failed to set nash arguments: %s", err.Error())
-		}
+	if err := nash.setArgs(args); err != nil {
+		return err
 	}
 	return nash.interp.ExecFile(path)
 }
 
+// ExecFS executes the script of the fsys filesystem specified by path,
+// like ExecFile. The scripts imported are searched in fsys: relative
+// to the importing script and, if not found on disk, in the lib
+// directory of fsys.
+func (nash *Shell) ExecFS(fsys fs.FS, path string, args ...string) error {
+	if err := nash.setArgs(args); err != nil {
+		return err
+	}
+
+	nash.interp.SetFS(fsys)
+	return nash.interp.ExecFileFS(fsys, path)
+}
+
+func (nash *Shell) setArgs(args []string) error {
+	if len(args) == 0 {
+		return nil
+	}
+
+	// setting the arguments is not part of the script profile
+	prof := nash.interp.Profiler()
+	nash.interp.SetProfiler(nil)
+	err := nash.ExecuteString("setting args", `var ARGS = `+args2Nash(args))
+	nash.interp.SetProfiler(prof)
+	if err != nil {
+		return fmt.Errorf("Failed to set nash arguments: %s", err.Error())
+	}
+	return nil
+}
+
 // ExecuteFile executes the given file.
 // Deprecated: Use ExecFile instead.
 func (nash *Shell) ExecuteFile(path string) error {
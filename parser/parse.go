@@ -21,6 +21,22 @@ type (
 		tok        *scanner.Token // token saved for lookahead
 		openblocks int
 
+		// last and prev are the last tokens consumed, used to set
+		// the end of the nodes.
+		last, prev *scanner.Token
+
+		// comments not attached to nodes yet. The trailing one is
+		// at the end of the line of the last token.
+		leading  []*ast.CommentNode
+		trailing *ast.CommentNode
+
+		// stmtLevel is set when looking for the next statement of
+		// a block, where comment lines are statements.
+		stmtLevel bool
+		// multiDepth is the depth of multi-line commands being
+		// parsed, where the arguments can span lines.
+		multiDepth int
+
 		insidePipe bool
 
 		keywordParsers map[token.Token]parserFn
@@ -87,19 +103,53 @@ func (p *Parser) Parse() (tr *ast.Tree, err error) {
 	return tr, nil
 }
 
+// scan returns the next item from the Lexer. Comments found inside
+// statements are saved to be attached to the nodes.
+func (p *Parser) scan() scanner.Token {
+	for {
+		tok := <-p.l.Tokens
+
+		if tok.Type() == token.Illegal {
+			panic(errors.NewError(tok.Value()))
+		}
+
+		if tok.Type() != token.Comment {
+			return tok
+		}
+
+		comment := ast.NewCommentNode(tok.FileInfo, tok.Value())
+
+		if p.last != nil && p.last.EndLine() == tok.Line() {
+			if p.trailing != nil {
+				p.leading = append(p.leading, p.trailing)
+			}
+
+			p.trailing = comment
+			continue
+		}
+
+		if p.stmtLevel {
+			return tok
+		}
+
+		p.leading = append(p.leading, comment)
+	}
+}
+
 // next returns the next item from lookahead buffer if not empty or
 // from the Lexer
 func (p *Parser) next() scanner.Token {
+	var tok scanner.Token
+
 	if p.tok != nil {
-		t := p.tok
+		tok = *p.tok
 		p.tok = nil
-		return *t
+	} else {
+		tok = p.scan()
 	}
 
-	tok := <-p.l.Tokens
-
-	if tok.Type() == token.Illegal {
-		panic(errors.NewError(tok.Value()))
+	if tok.Type() != token.Semicolon && tok.Type() != token.EOF {
+		p.prev, p.last = p.last, &tok
 	}
 
 	return tok
@@ -113,30 +163,113 @@ func (p *Parser) backup(it scanner.Token) error {
 
 	p.tok = &it
 
+	if p.last != nil && *p.last == it {
+		p.last = p.prev
+	}
+
 	return nil
 }
 
 // ignores the next item
 func (p *Parser) ignore() {
-	if p.tok != nil {
-		p.tok = nil
-	} else {
-		<-p.l.Tokens
-	}
+	p.next()
 }
 
 // peek gets but do not discards the next item (lookahead)
 func (p *Parser) peek() scanner.Token {
-	i := p.next()
+	if p.tok != nil {
+		return *p.tok
+	}
+
+	i := p.scan()
 	p.tok = &i
 	return i
 }
 
+// setEnd sets the end of the node to the end of the last consumed
+// token.
+func (p *Parser) setEnd(n ast.Node) {
+	if p.last == nil {
+		return
+	}
+
+	n.(interface {
+		SetEnd(line, column int)
+	}).SetEnd(p.last.EndLine(), p.last.EndColumn())
+}
+
+// attachComments attaches the pending comments to the node n, just
+// parsed as part of a construct spanning multiple lines: the comment
+// lines before it and the comment at the end of its last line.
+func (p *Parser) attachComments(n ast.Node) {
+	p.attachLeading(n)
+
+	if p.trailing != nil && p.trailing.Line() == n.EndLine() {
+		n.Comments().Trailing = p.trailing
+		p.trailing = nil
+	}
+}
+
+// attachLeading attaches the pending comments preceding the node n.
+func (p *Parser) attachLeading(n ast.Node) {
+	comments := n.Comments()
+
+	if p.trailing != nil && p.trailing.Line() < n.Line() {
+		// comment after the opening of the construct
+		comments.Leading = append(comments.Leading, p.trailing)
+		p.trailing = nil
+	}
+
+	var rest []*ast.CommentNode
+
+	for _, c := range p.leading {
+		if c.Line() < n.Line() {
+			comments.Leading = append(comments.Leading, c)
+		} else {
+			rest = append(rest, c)
+		}
+	}
+
+	p.leading = rest
+}
+
+// flushComments adds the pending comments to the block. The trailing
+// comment is attached to the last statement, or to the block itself if
+// it is at the line opening it. Comments that could not be attached to
+// any node become statements.
+func (p *Parser) flushComments(block *ast.BlockNode) {
+	if c := p.trailing; c != nil {
+		p.trailing = nil
+
+		nodes := block.Nodes
+
+		switch {
+		case len(nodes) > 0 && nodes[len(nodes)-1].EndLine() == c.Line() &&
+			nodes[len(nodes)-1].Comments().Trailing == nil:
+			nodes[len(nodes)-1].Comments().Trailing = c
+		case len(nodes) == 0 && block.Line() == c.Line():
+			block.Comments().Trailing = c
+		default:
+			p.leading = append([]*ast.CommentNode{c}, p.leading...)
+		}
+	}
+
+	for _, c := range p.leading {
+		block.Push(c)
+	}
+
+	p.leading = nil
+}
+
 func (p *Parser) parseBlock(lineStart, columnStart int) (*ast.BlockNode, error) {
 	ln := ast.NewBlockNode(token.NewFileInfo(lineStart, columnStart))
 
 	for {
+		p.stmtLevel = true
 		it := p.peek()
+		p.stmtLevel = false
+
+		p.flushComments(ln)
 
 		switch it.Type() {
 		case token.EOF:
@@ -155,6 +288,7 @@ func (p *Parser) parseBlock(lineStart, columnStart int) (*ast.BlockNode, error)
 			}
 
 			p.openblocks--
+			p.setEnd(ln)
 			return ln, nil
 		default:
 			n, err := p.parseStatement()
@@ -163,6 +297,10 @@ func (p *Parser) parseBlock(lineStart, columnStart int) (*ast.BlockNode, error)
 				return nil, err
 			}
 
+			if n.Type() != ast.NodeComment {
+				p.setEnd(n)
+			}
+
 			ln.Push(n)
 		}
 	}
@@ -172,17 +310,19 @@ finish:
 		return nil, errors.NewUnfinishedBlockError(p.name, p.peek())
 	}
 
+	p.setEnd(ln)
 	return ln, nil
 }
 
 func (p *Parser) parseStatement() (ast.Node, error) {
 	it := p.next()
-	next := p.peek()
 
 	if fn, ok := p.keywordParsers[it.Type()]; ok {
 		return fn(it)
 	}
 
+	next := p.peek()
+
 	// statement starting with ident:
 	// - fn call
 	// - variable assignment
@@ -289,6 +429,7 @@ func (p *Parser) parseVariable(tok *scanner.Token, allowVararg bool) (ast.Expr,
 		if isVariadic {
 			p.ignore()
 		}
+		p.setEnd(indexedVar)
 		return indexedVar, nil
 	}
 
@@ -300,7 +441,9 @@ func (p *Parser) parseVariable(tok *scanner.Token, allowVararg bool) (ast.Expr,
 		p.ignore()
 	}
 
-	return ast.NewVarVariadicExpr(varTok.FileInfo, varTok.Value(), isVariadic), nil
+	variable := ast.NewVarVariadicExpr(varTok.FileInfo, varTok.Value(), isVariadic)
+	p.setEnd(variable)
+	return variable, nil
 }
 
 func (p *Parser) parsePipe(first *ast.CommandNode) (ast.Node, error) {
@@ -312,6 +455,15 @@ func (p *Parser) parsePipe(first *ast.CommandNode) (ast.Node, error) {
 	n.AddCmd(first)
 
 	for it = p.peek(); it.Type() == token.Ident || it.Type() == token.Arg; it = p.peek() {
+		if p.multiDepth > 0 {
+			// comment after the pipe symbol
+			last := n.Commands()[len(n.Commands())-1]
+			if p.trailing != nil && p.trailing.Line() == last.EndLine() {
+				last.Comments().Trailing = p.trailing
+				p.trailing = nil
+			}
+		}
+
 		p.next()
 		cmd, err := p.parseCommand(it)
 
@@ -319,6 +471,10 @@ func (p *Parser) parsePipe(first *ast.CommandNode) (ast.Node, error) {
 			return nil, err
 		}
 
+		if p.multiDepth > 0 {
+			p.attachComments(cmd)
+		}
+
 		n.AddCmd(cmd.(*ast.CommandNode))
 
 		if !p.insidePipe {
@@ -339,6 +495,8 @@ func (p *Parser) parsePipe(first *ast.CommandNode) (ast.Node, error) {
 		p.ignore()
 	}
 
+	p.setEnd(n)
+
 	it = p.peek()
 
 	if it.Type() == token.RBrace {
@@ -356,11 +514,15 @@ func (p *Parser) parsePipe(first *ast.CommandNode) (ast.Node, error) {
 
 func (p *Parser) parseCommand(it scanner.Token) (ast.Node, error) {
 	isMulti := false
+	start := it
 
 	if it.Type() == token.LParen {
 		// multiline command
 		isMulti = true
 
+		p.multiDepth++
+		defer func() { p.multiDepth-- }()
+
 		it = p.next()
 	}
 
@@ -374,6 +536,14 @@ func (p *Parser) parseCommand(it scanner.Token) (ast.Node, error) {
 
 	n := ast.NewCommandNode(it.FileInfo, it.Value(), isMulti)
 
+	if p.multiDepth > 0 {
+		p.attachLeading(n)
+	}
+
+	if isMulti {
+		n.SetLParen(start.FileInfo)
+	}
+
 cmdLoop:
 	for {
 		it = p.peek()
@@ -385,6 +555,7 @@ cmdLoop:
 					p.insidePipe = false
 				}
 
+				p.setEnd(n)
 				return n, nil
 			}
 
@@ -401,6 +572,10 @@ cmdLoop:
 				return nil, err
 			}
 
+			if p.multiDepth > 0 {
+				p.attachComments(arg)
+			}
+
 			n.AddArg(arg)
 		case typ == token.Plus:
 			return nil, newParserError(it, p.name,
@@ -413,8 +588,14 @@ cmdLoop:
 				return nil, err
 			}
 
+			if p.multiDepth > 0 {
+				p.attachComments(redir)
+			}
+
 			n.AddRedirect(redir)
 		case typ == token.Pipe:
+			p.setEnd(n)
+
 			if p.insidePipe {
 				p.next()
 				// TODO(i4k): test against pipes and multiline cmds
@@ -448,6 +629,8 @@ cmdLoop:
 		it = p.peek()
 	}
 
+	p.setEnd(n)
+
 	if p.insidePipe {
 		p.insidePipe = false
 		return n, nil
@@ -538,6 +721,7 @@ func (p *Parser) parseRedirection(it scanner.Token) (*ast.RedirectNode, error) {
 
 	if !isValidArgument(it) {
 		if rval != ast.RedirMapNoValue || lval != ast.RedirMapNoValue {
+			p.setEnd(redir)
 			return redir, nil
 		}
 
@@ -555,6 +739,7 @@ func (p *Parser) parseRedirection(it scanner.Token) (*ast.RedirectNode, error) {
 	}
 
 	redir.SetLocation(arg)
+	p.setEnd(redir)
 
 	return redir, nil
 }
@@ -715,7 +900,9 @@ hasConcat:
 		goto hasConcat
 	}
 
-	return ast.NewConcatExpr(token.NewFileInfo(firstArg.Line(), firstArg.Column()), parts), nil
+	concat := ast.NewConcatExpr(token.NewFileInfo(firstArg.Line(), firstArg.Column()), parts)
+	p.setEnd(concat)
+	return concat, nil
 }
 
 func (p *Parser) parseAssignment(ident scanner.Token) (ast.Node, error) {
@@ -738,6 +925,10 @@ func (p *Parser) parseAssignment(ident scanner.Token) (ast.Node, error) {
 		err   error
 	)
 
+	names := []*ast.NameNode{
+		ast.NewNameNode(ident.FileInfo, ident.Value(), nil),
+	}
+
 	if it.Type() == token.LBrack {
 		index, err = p.parseIndexing()
 
@@ -745,11 +936,10 @@ func (p *Parser) parseAssignment(ident scanner.Token) (ast.Node, error) {
 			return nil, err
 		}
 
-		it = p.next()
-	}
+		names[0].Index = index
+		p.setEnd(names[0])
 
-	names := []*ast.NameNode{
-		ast.NewNameNode(ident.FileInfo, ident.Value(), index),
+		it = p.next()
 	}
 
 	if it.Type() != token.Comma {
@@ -757,22 +947,22 @@ func (p *Parser) parseAssignment(ident scanner.Token) (ast.Node, error) {
 	}
 
 	for it = p.next(); it.Type() == token.Ident; it = p.next() {
-		var index ast.Expr
-
-		name := it
+		name := ast.NewNameNode(it.FileInfo, it.Value(), nil)
 		it = p.next()
 
 		if it.Type() == token.LBrack {
-			index, err = p.parseIndexing()
+			name.Index, err = p.parseIndexing()
 
 			if err != nil {
 				return nil, err
 			}
 
+			p.setEnd(name)
+
 			it = p.next()
 		}
 
-		names = append(names, ast.NewNameNode(name.FileInfo, name.Value(), index))
+		names = append(names, name)
 
 		if it.Type() != token.Comma {
 			break
@@ -808,10 +998,18 @@ func (p *Parser) parseList(tok *scanner.Token) (ast.Node, error) {
 		return nil, newParserError(lit, p.name, "Unexpected token %v. Expecting (", lit)
 	}
 
-	var values []ast.Expr
+	var (
+		values  []ast.Expr
+		opening *ast.CommentNode
+	)
 
 	it := p.peek()
 
+	if p.trailing != nil && p.trailing.Line() == lit.Line() {
+		// comment after the opening parenthesis
+		opening, p.trailing = p.trailing, nil
+	}
+
 	for isValidArgument(it) || it.Type() == token.LParen {
 		if it.Type() == token.LParen {
 			arg, err = p.parseList(nil)
@@ -828,6 +1026,8 @@ func (p *Parser) parseList(tok *scanner.Token) (ast.Node, error) {
 			return nil, err
 		}
 
+		p.attachComments(arg)
+
 		it = p.peek()
 
 		values = append(values, arg)
@@ -848,7 +1048,11 @@ func (p *Parser) parseList(tok *scanner.Token) (ast.Node, error) {
 		isVariadic = true
 		p.ignore()
 	}
-	return ast.NewListVariadicExpr(lit.FileInfo, values, isVariadic), nil
+
+	list := ast.NewListVariadicExpr(lit.FileInfo, values, isVariadic)
+	list.Comments().Opening = opening
+	p.setEnd(list)
+	return list, nil
 }
 
 func (p *Parser) parseAssignValues(names []*ast.NameNode) (ast.Node, error) {
@@ -897,11 +1101,14 @@ func (p *Parser) parseAssignValues(names []*ast.NameNode) (ast.Node, error) {
 			len(names), len(values))
 	}
 
+	assign := ast.NewAssignNode(names[0].FileInfo, names, values)
+	p.setEnd(assign)
+
 	if p.peek().Type() == token.Semicolon {
 		p.ignore()
 	}
 
-	return ast.NewAssignNode(names[0].FileInfo, names, values), nil
+	return assign, nil
 }
 
 func (p *Parser) parseAssignCmdOut(identifiers []*ast.NameNode) (ast.Node, error) {
@@ -912,12 +1119,15 @@ func (p *Parser) parseAssignCmdOut(identifiers []*ast.NameNode) (ast.Node, error
 
 	it := p.next()
 
-	if it.Type() != token.Ident && it.Type() != token.Arg && it.Type() != token.Variable && it.Type() != token.LParen {
+	if it.Type() != token.Ident && it.Type() != token.Arg && it.Type() != token.Variable &&
+		it.Type() != token.LParen && it.Type() != token.Rfork {
 		return nil, newParserError(it, p.name,
 			"Invalid token %v. Expected command or function invocation", it)
 	}
 
-	if it.Type() == token.LParen {
+	if it.Type() == token.Rfork {
+		exec, err = p.parseRfork(it)
+	} else if it.Type() == token.LParen {
 		// command invocation
 		exec, err = p.parseCommand(it)
 	} else {
@@ -943,7 +1153,13 @@ func (p *Parser) parseAssignCmdOut(identifiers []*ast.NameNode) (ast.Node, error
 		panic("internal error parsing assignment")
 	}
 
-	return ast.NewExecAssignNode(identifiers[0].FileInfo, identifiers, exec)
+	assign, err := ast.NewExecAssignNode(identifiers[0].FileInfo, identifiers, exec)
+	if err != nil {
+		return nil, err
+	}
+
+	p.setEnd(assign)
+	return assign, nil
 }
 
 func (p *Parser) parseRfork(it scanner.Token) (ast.Node, error) {
@@ -960,6 +1176,26 @@ func (p *Parser) parseRfork(it scanner.Token) (ast.Node, error) {
 
 	it = p.peek()
 
+	if it.Type() == token.LParen || it.Type() == token.Variable {
+		var (
+			opts ast.Node
+			err  error
+		)
+
+		if it.Type() == token.LParen {
+			opts, err = p.parseList(nil)
+		} else {
+			opts, err = p.parseVariable(nil, false)
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		n.SetOptions(opts.(ast.Expr))
+		it = p.peek()
+	}
+
 	if it.Type() == token.LBrace {
 		blockPos := it.FileInfo
 
@@ -1067,6 +1303,7 @@ func (p *Parser) parseIf(it scanner.Token) (ast.Node, error) {
 		n.SetElseTree(elseTree)
 	}
 
+	p.setEnd(n)
 	return n, nil
 }
 
@@ -1081,23 +1318,25 @@ func (p *Parser) parseFnArgs() ([]*ast.FnArgNode, error) {
 
 	for {
 		it := p.next()
-		if it.Type() == token.Ident {
-			argName := it.Value()
-			isVariadic := false
-			if p.peek().Type() == token.Dotdotdot {
-				isVariadic = true
-				p.ignore()
-			}
-			args = append(args, ast.NewFnArgNode(it.FileInfo,
-				argName, isVariadic))
-		} else {
+		if it.Type() != token.Ident {
 			return nil, newParserError(it, p.name, "Unexpected token %v. Expected identifier or ')'", it)
 		}
 
+		argName := it.Value()
+		isVariadic := false
+		if p.peek().Type() == token.Dotdotdot {
+			isVariadic = true
+			p.ignore()
+		}
+		arg := ast.NewFnArgNode(it.FileInfo, argName, isVariadic)
+		p.setEnd(arg)
+		args = append(args, arg)
+
 		it = p.peek()
 		if it.Type() == token.Comma {
 			p.ignore()
 			it = p.peek()
+			p.attachComments(arg)
 
 			if it.Type() == token.RParen {
 				break
@@ -1105,6 +1344,8 @@ func (p *Parser) parseFnArgs() ([]*ast.FnArgNode, error) {
 			continue
 		}
 
+		p.attachComments(arg)
+
 		if it.Type() != token.RParen {
 			return nil, newParserError(it, p.name, "Unexpected '%v'. Expected ')'", it)
 		}
@@ -1257,6 +1498,8 @@ func (p *Parser) parseFnInv(ident scanner.Token, allowSemicolon bool) (ast.Node,
 		goto parseError
 	}
 
+	p.setEnd(n)
+
 	// semicolon is optional here
 	if allowSemicolon && p.peek().Type() == token.Semicolon {
 		p.next()
@@ -1293,6 +1536,7 @@ func (p *Parser) parseElse() (*ast.BlockNode, bool, error) {
 
 		block := ast.NewBlockNode(it.FileInfo)
 		block.Push(ifNode)
+		p.setEnd(block)
 
 		return block, true, nil
 	}
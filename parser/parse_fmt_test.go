@@ -1,6 +1,15 @@
 package parser
 
-import "testing"
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/madlambda/nash/ast"
+	"github.com/madlambda/nash/scanner"
+	"github.com/madlambda/nash/token"
+)
 
 type fmtTestTable struct {
 	input, expected string
@@ -201,6 +210,29 @@ fn test() {
 	testFmtTable(testTable, t)
 }
 
+func TestFmtListAndArgComments(t *testing.T) {
+	testTable := []fmtTestTable{
+		{
+			"var a = ( # open\n\t\"x\"\n\t\"y\"\n)",
+			"var a = ( # open\n\t\"x\"\n\t\"y\"\n)",
+		},
+		{
+			"a = ( # open\n)",
+			"a = ( # open\n)",
+		},
+		{
+			"fn f(a, # arg a\n\tb) {\n\techo $a $b\n}",
+			"fn f(a, # arg a\n\tb) {\n\techo $a $b\n}",
+		},
+		{
+			"fn f( # first\n\ta,\n\t# about b\n\tb # b\n) {\n\techo $a $b\n}",
+			"fn f( # first\n\ta,\n\t# about b\n\tb # b\n) {\n\techo $a $b\n}",
+		},
+	}
+
+	testFmtTable(testTable, t)
+}
+
 func TestFmtSamples(t *testing.T) {
 	testTable := []fmtTestTable{
 		{
@@ -342,3 +374,206 @@ func TestFmtPipes(t *testing.T) {
 
 	testFmtTable(testTable, t)
 }
+
+func TestFmtStyle(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		style    ast.Style
+		input    string
+		expected string
+	}{
+		{
+			name:  "SpacesIndent",
+			style: ast.Style{Indent: 4, AlignVars: true},
+			input: "fn a() {\nfor i in $l {\necho $i\n}\n}",
+			expected: `fn a() {
+    for i in $l {
+        echo $i
+    }
+}`,
+		},
+		{
+			name:  "AlignVars",
+			style: ast.Style{AlignVars: true},
+			input: "var a = \"1\"\nvar abc = \"2\"\nvar out <= ls\nvar output <= ls",
+			expected: `var a   = "1"
+var abc = "2"
+
+var out    <= ls
+var output <= ls`,
+		},
+		{
+			name:     "NoAlignVars",
+			style:    ast.Style{},
+			input:    "var a = \"1\"\nvar abc = \"2\"",
+			expected: "var a = \"1\"\nvar abc = \"2\"",
+		},
+		{
+			name:  "WrapCommand",
+			style: ast.Style{LineWidth: 30, AlignVars: true},
+			input: `docker run --rm -v /tmp:/tmp -it ubuntu`,
+			expected: `(
+	docker run --rm
+		-v /tmp:/tmp
+		-it ubuntu
+)`,
+		},
+		{
+			name:  "WrapCommandWithSpaces",
+			style: ast.Style{Indent: 2, LineWidth: 30},
+			input: `var out <= docker run --rm -v /tmp:/tmp -it ubuntu`,
+			expected: `var out <= (
+  docker run --rm
+         -v /tmp:/tmp
+         -it ubuntu
+)`,
+		},
+		{
+			name:  "WrapNestedCommand",
+			style: ast.Style{LineWidth: 30},
+			input: "if $a == \"1\" {\necho hello world from nash\n}",
+			expected: `if $a == "1" {
+	(
+		echo hello world from nash
+	)
+}`,
+		},
+		{
+			name:  "WrapPipe",
+			style: ast.Style{LineWidth: 30},
+			input: `cat /etc/passwd | grep root | wc -l`,
+			expected: `(
+	cat /etc/passwd |
+	grep root |
+	wc -l
+)`,
+		},
+		{
+			name:     "ShortLines",
+			style:    ast.Style{LineWidth: 30},
+			input:    `echo hello`,
+			expected: `echo hello`,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			tree, err := NewParser(test.name, test.input).Parse()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := tree.Format(test.style)
+			if got != test.expected {
+				t.Fatalf("Fmt differ:\n%s\n!=\n%s", got, test.expected)
+			}
+		})
+	}
+}
+
+func TestFmtIdempotent(t *testing.T) {
+	var files []string
+
+	for _, pattern := range []string{
+		"../testfiles/*.sh",
+		"../examples/*.sh",
+		"../stdlib/*.sh",
+		"../stdbin/*/*.sh",
+	} {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, matches...)
+	}
+
+	styles := []ast.Style{
+		ast.DefaultStyle,
+		{Indent: 4, LineWidth: 40},
+		{LineWidth: 30, AlignVars: true},
+		{Indent: 2, LineWidth: 20, AlignVars: true},
+	}
+
+	for _, fname := range files {
+		content, err := ioutil.ReadFile(fname)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, style := range styles {
+			tree, err := NewParser(fname, string(content)).Parse()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			once := tree.Format(style)
+
+			tree, err = NewParser(fname, once).Parse()
+			if err != nil {
+				t.Fatalf("%s: formatted code with style %+v does not parse: %s\n%s",
+					fname, style, err, once)
+			}
+
+			twice := tree.Format(style)
+			if once != twice {
+				t.Fatalf("%s: formatting with style %+v is not idempotent:\n%s\n!=\n%s",
+					fname, style, once, twice)
+			}
+		}
+	}
+}
+
+// TestFmtRoundTrip checks that formatting keeps every comment of the
+// corpus scripts, in the same order. The testfiles/comments.sh script
+// is already formatted and must be reproduced exactly.
+func TestFmtRoundTrip(t *testing.T) {
+	var files []string
+
+	for _, pattern := range []string{
+		"../testfiles/*.sh",
+		"../examples/*.sh",
+	} {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, matches...)
+	}
+
+	for _, fname := range files {
+		content, err := ioutil.ReadFile(fname)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		tree, err := NewParser(fname, string(content)).Parse()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		formatted := tree.String() + "\n"
+
+		want := comments(fname, string(content))
+		got := comments(fname, formatted)
+
+		if strings.Join(want, "\n") != strings.Join(got, "\n") {
+			t.Errorf("%s: comments differ after formatting:\n%s\n!=\n%s",
+				fname, strings.Join(want, "\n"), strings.Join(got, "\n"))
+		}
+
+		if filepath.Base(fname) == "comments.sh" && formatted != string(content) {
+			t.Errorf("%s: round trip differs:\n%s\n!=\n%s",
+				fname, string(content), formatted)
+		}
+	}
+}
+
+func comments(fname, content string) []string {
+	var values []string
+
+	for tok := range scanner.Lex(fname, content).Tokens {
+		if tok.Type() == token.Comment {
+			values = append(values, tok.Value())
+		}
+	}
+
+	return values
+}
@@ -823,6 +823,88 @@ func TestParseRforkWithBlock(t *testing.T) {
 
 }
 
+func TestParseRforkAssign(t *testing.T) {
+	expected := ast.NewTree("rfork assign")
+	ln := ast.NewBlockNode(token.NewFileInfo(1, 0))
+	rfork := ast.NewRforkNode(token.NewFileInfo(1, 8))
+	rfork.SetFlags(ast.NewStringExpr(token.NewFileInfo(1, 14), "n", false))
+
+	ret := ast.NewReturnNode(token.NewFileInfo(2, 1))
+	ret.Returns = []ast.Expr{ast.NewStringExpr(token.NewFileInfo(2, 9), "up", true)}
+
+	bln := ast.NewBlockNode(token.NewFileInfo(1, 16))
+	bln.Push(ret)
+	subtree := ast.NewTree("rfork")
+	subtree.Root = bln
+
+	rfork.SetTree(subtree)
+
+	assign, err := ast.NewExecAssignNode(token.NewFileInfo(1, 0),
+		[]*ast.NameNode{ast.NewNameNode(token.NewFileInfo(1, 0), "link", nil)},
+		rfork)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ln.Push(assign)
+	expected.Root = ln
+
+	parserTest("rfork assign", `link <= rfork n {
+	return "up"
+}
+`, expected, t, true)
+}
+
+func TestParseRforkOptions(t *testing.T) {
+	expected := ast.NewTree("rfork options")
+	ln := ast.NewBlockNode(token.NewFileInfo(1, 0))
+	rfork := ast.NewRforkNode(token.NewFileInfo(1, 0))
+	rfork.SetFlags(ast.NewStringExpr(token.NewFileInfo(1, 6), "um", false))
+	rfork.SetOptions(ast.NewListExpr(token.NewFileInfo(1, 9), []ast.Expr{
+		ast.NewStringExpr(token.NewFileInfo(1, 11), "propagation=private", true),
+	}))
+
+	cmd := ast.NewCommandNode(token.NewFileInfo(2, 1), "ls", false)
+
+	bln := ast.NewBlockNode(token.NewFileInfo(1, 33))
+	bln.Push(cmd)
+	subtree := ast.NewTree("rfork")
+	subtree.Root = bln
+
+	rfork.SetTree(subtree)
+
+	ln.Push(rfork)
+	expected.Root = ln
+
+	parserTest("rfork options", `rfork um ("propagation=private") {
+	ls
+}
+`, expected, t, true)
+
+	expected = ast.NewTree("rfork options variable")
+	ln = ast.NewBlockNode(token.NewFileInfo(1, 0))
+	rfork = ast.NewRforkNode(token.NewFileInfo(1, 0))
+	rfork.SetFlags(ast.NewStringExpr(token.NewFileInfo(1, 6), "um", false))
+	rfork.SetOptions(ast.NewVarExpr(token.NewFileInfo(1, 9), "$opts"))
+
+	cmd = ast.NewCommandNode(token.NewFileInfo(2, 1), "ls", false)
+
+	bln = ast.NewBlockNode(token.NewFileInfo(1, 15))
+	bln.Push(cmd)
+	subtree = ast.NewTree("rfork")
+	subtree.Root = bln
+
+	rfork.SetTree(subtree)
+
+	ln.Push(rfork)
+	expected.Root = ln
+
+	parserTest("rfork options variable", `rfork um $opts {
+	ls
+}
+`, expected, t, true)
+}
+
 func TestUnpairedRforkBlocks(t *testing.T) {
 	parser := NewParser("unpaired", "rfork u {")
 
@@ -1730,3 +1812,33 @@ func TestFunctionPipes(t *testing.T) {
 		return
 	}
 }
+
+func TestParseEndPositions(t *testing.T) {
+	for _, tc := range []struct {
+		code               string
+		endLine, endColumn int
+	}{
+		{`echo hello`, 1, 10},
+		{`echo "hello world"`, 1, 18},
+		{`var a = "1"`, 1, 11},
+		{`var a = ("a" "b")`, 1, 17},
+		{`var out <= ls -la`, 1, 17},
+		{"cat /etc/passwd | grep root", 1, 27},
+		{"(\n\tls -la\n\t\t/tmp\n)", 4, 1},
+		{"fn a() {\n\techo a\n}", 3, 1},
+		{"if $a == \"1\" {\n\techo a\n} else {\n\techo b\n}", 5, 1},
+		{"for a in $list {\n\techo $a\n}", 3, 1},
+		{`a("b", "c")`, 1, 11},
+	} {
+		tree, err := NewParser("end positions", tc.code).Parse()
+		if err != nil {
+			t.Fatalf("%q: %s", tc.code, err)
+		}
+
+		node := tree.Root.Nodes[0]
+		if node.EndLine() != tc.endLine || node.EndColumn() != tc.endColumn {
+			t.Errorf("%q: expected end at %d:%d but got %d:%d", tc.code,
+				tc.endLine, tc.endColumn, node.EndLine(), node.EndColumn())
+		}
+	}
+}
@@ -77,9 +77,18 @@ func (l *Lexer) run() {
 	close(l.Tokens) // No more tokens will be delivered
 }
 
+// emitVal emits a token of value val, that starts at line and column
+// and ends at the current position.
 func (l *Lexer) emitVal(t token.Token, val string, line, column int) {
+	l.emitRange(t, val, line, column, l.line, l.column)
+}
+
+func (l *Lexer) emitRange(t token.Token, val string, line, column, endLine, endColumn int) {
+	info := token.NewFileInfo(line, column)
+	info.SetEnd(endLine, endColumn)
+
 	l.Tokens <- Token{
-		FileInfo: token.NewFileInfo(line, column),
+		FileInfo: info,
 
 		typ: t,
 		val: val,
@@ -91,8 +100,11 @@ func (l *Lexer) emitVal(t token.Token, val string, line, column int) {
 }
 
 func (l *Lexer) emit(t token.Token) {
+	info := token.NewFileInfo(l.lineStart, l.columnStart)
+	info.SetEnd(l.line, l.column)
+
 	l.Tokens <- Token{
-		FileInfo: token.NewFileInfo(l.lineStart, l.columnStart),
+		FileInfo: info,
 
 		typ: t,
 		val: l.input[l.start:l.pos],
@@ -116,6 +128,7 @@ func (l *Lexer) next() rune {
 
 	if l.pos >= len(l.input) {
 		l.width = 0
+		l.prevColumn = l.column
 		return eof
 	}
 
@@ -400,7 +413,7 @@ func lexStart(l *Lexer) stateFn {
 				next = l.peek()
 				if next == '.' {
 					l.next()
-					l.emitVal(token.Ident, ident, identLine, identCol)
+					l.emitRange(token.Ident, ident, identLine, identCol, dotLine, dotColumn)
 					l.emitVal(token.Dotdotdot, "...", dotLine, dotColumn)
 					return lexStart
 				}
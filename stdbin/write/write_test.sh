@@ -9,25 +9,27 @@ fn clean() {
     _, _ <= rm -f $nonExistentFile
 }
 
-clean()
+fn test_write_creates_and_appends() {
+    clean()
 
-var out, err, status <= write $nonExistentFile "hello"
-assert("", $out, "standard out isnt empty")
-assert("", $err, "standard err isnt empty")
-assert("0", $status, "status is not success")
+    var out, err, status <= write $nonExistentFile "hello"
+    assert("", $out, "standard out isnt empty")
+    assert("", $err, "standard err isnt empty")
+    assert("0", $status, "status is not success")
 
-var content, status <= cat $nonExistentFile
-assert("0", $status, "status is not success")
-assert("hello", $content, "file content is wrong")
+    var content, status <= cat $nonExistentFile
+    assert("0", $status, "status is not success")
+    assert("hello", $content, "file content is wrong")
 
-# test append
-out, err, status <= write $nonExistentFile "1"
-assert("", $out, "standard out isnt empty")
-assert("", $err, "standard err isnt empty")
-assert("0", $status, "status is not success")
+    # test append
+    out, err, status <= write $nonExistentFile "1"
+    assert("", $out, "standard out isnt empty")
+    assert("", $err, "standard err isnt empty")
+    assert("0", $status, "status is not success")
 
-content, status <= cat $nonExistentFile
-assert("0", $status, "status is not success")
-assert("hello1", $content, "file content is wrong")
+    content, status <= cat $nonExistentFile
+    assert("0", $status, "status is not success")
+    assert("hello1", $content, "file content is wrong")
 
-clean()
+    clean()
+}
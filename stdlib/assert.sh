@@ -0,0 +1,73 @@
+# Assertions for tests run with `nash test`. When an assertion fails
+# it prints a message on stderr and exits with status 1, failing the
+# test.
+
+# assert_fail fails the test. The message is formatted like the
+# format builtin and printed on stderr.
+#
+#	import assert
+#
+#	assert_fail("unexpected value: %s", "hi")
+#	#Stderr:"unexpected value: hi\n"
+fn assert_fail(msg, args...) {
+	var errmsg <= format($msg, $args...)
+
+	echo $errmsg >[1=2]
+
+	exit("1")
+}
+
+# assert_equal fails the test if want and got are different.
+#
+#	import assert
+#
+#	assert_equal("hi", "hi")
+#	assert_equal("hi", "bye")
+#	#Stderr:"assert_equal: want [hi] got [bye]\n"
+fn assert_equal(want, got) {
+	if $want != $got {
+		assert_fail("assert_equal: want [%s] got [%s]", $want, $got)
+	}
+}
+
+# assert_contains fails the test if str does not contain substr.
+#
+#	import assert
+#
+#	assert_contains("hello world", "lo wo")
+#	assert_contains("hello", "bye")
+#	#Stderr:"assert_contains: [hello] does not contain [bye]\n"
+fn assert_contains(str, substr) {
+	if $substr == "" {
+		return
+	}
+
+	var parts <= split($str, $substr)
+	var n     <= len($parts)
+
+	if $n == "1" {
+		assert_fail("assert_contains: [%s] does not contain [%s]", $str, $substr)
+	}
+}
+
+# assert_fails fails the test if the command cmd, called with the
+# given args, succeeds.
+#
+#	import assert
+#
+#	assert_fails("ls", "/path/that/does/not/exist")
+#	assert_fails("true")
+#	#Stderr:"assert_fails: expected [true] to fail\n"
+fn assert_fails(cmd, args...) {
+	var _, status <= env $cmd $args...
+
+	if $status == "0" {
+		var cmdline = $cmd
+
+		for arg in $args {
+			cmdline = $cmdline+" "+$arg
+		}
+
+		assert_fail("assert_fails: expected [%s] to fail", $cmdline)
+	}
+}
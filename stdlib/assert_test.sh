@@ -0,0 +1,35 @@
+import assert
+
+fn run_assert(code) {
+	var _, status <= ./cmd/nash/nash -c "import assert\n"+$code >[2=]
+
+	return $status
+}
+
+fn test_equal() {
+	assert_equal("nash", "nash")
+	assert_equal("", "")
+
+	var status <= run_assert("assert_equal(\"a\", \"b\")")
+
+	assert_equal("1", $status)
+}
+
+fn test_contains() {
+	assert_contains("hello world", "lo wo")
+	assert_contains("hello world", "hello world")
+	assert_contains("hello", "")
+
+	var status <= run_assert("assert_contains(\"hello\", \"world\")")
+
+	assert_equal("1", $status)
+}
+
+fn test_fails() {
+	assert_fails("false")
+	assert_fails("ls", "./here-be-no-dragons")
+
+	var status <= run_assert("assert_fails(\"true\")")
+
+	assert_equal("1", $status)
+}
@@ -1,4 +1,8 @@
-
+# io_println has the same behavior of print but adds a newline
+# at the end.
+#
+#	io_println("hello %s", "world")
+#	# Output: hello world
 fn io_println(msg, args...) {
         print($msg + "\n", $args...)
 }
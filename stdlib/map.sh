@@ -1,17 +1,34 @@
+# map_new creates an empty map. A map is a list of (key value)
+# pairs and can be iterated with a for loop or with map_iter.
+#
+#	var m <= map_new()
+#	m <= map_add($m, "key", "value")
 fn map_new() {
         return ()
 }
 
+# map_get returns the value of key, or an empty string if the key
+# is not on the map.
 fn map_get(map, key) {
         return map_get_default($map, $key, "")
 }
 
+# map_iter calls func with the key and the value of each entry
+# of the map.
+#
+#	fn print_entry(key, val) {
+#		echo $key $val
+#	}
+#
+#	map_iter($m, $print_entry)
 fn map_iter(map, func) {
         for entry in $map {
                 $func($entry[0], $entry[1])
         }
 }
 
+# map_get_default returns the value of key, or default if the key
+# is not on the map.
 fn map_get_default(map, key, default) {
         for entry in $map {
                 if $entry[0] == $key {
@@ -22,6 +39,8 @@ fn map_get_default(map, key, default) {
         return $default
 }
 
+# map_add returns a map with key set to val, overriding the old
+# value if the key was already on the map.
 fn map_add(map, key, val) {
         for entry in $map {
                 if $entry[0] == $key {
@@ -35,6 +54,7 @@ fn map_add(map, key, val) {
         return $map
 }
 
+# map_del returns a map without the key.
 fn map_del(map, key) {
 	var newmap = ()
 
@@ -74,10 +74,3 @@ fn test_removing_absent_key() {
         map <= map_del($map, "key")
         expect($map, "key", "")
 }
-
-test_adding_keys()
-test_absent_key_will_have_empty_string_value()
-test_absent_key_with_custom_default_value()
-test_iterates_map()
-test_removing_key()
-test_removing_absent_key()
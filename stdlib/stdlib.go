@@ -0,0 +1,10 @@
+// Package stdlib embeds the nash standard library, so it can be
+// imported even if $NASHROOT/stdlib is not installed.
+package stdlib
+
+import "embed"
+
+// FS has the scripts of the standard library.
+//
+//go:embed *.sh
+var FS embed.FS
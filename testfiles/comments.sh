@@ -0,0 +1,52 @@
+#!/usr/bin/env nash
+
+# Comments in every position the formatter must preserve.
+echo hello # trailing
+
+var greeting = "hello" # trailing assignment
+var names    = (
+	# the first name
+	alice # trailing element
+	bob
+)
+
+var colors   = ( # the colors
+	red
+	( # nested
+		green
+	) # after nested
+)
+
+fn paint(color, # the color
+	# where to paint
+	target) {
+	echo $color $target
+}
+
+fn greet(name) { # after the brace
+	# inside the body
+	echo $greeting $name # inside trailing
+	# last in the block
+} # after the function
+
+if $greeting == "hello" { # if trailing
+	greet("alice")
+} else { # else trailing
+	greet("bob")
+}
+
+(
+	ls -la # flags
+		/tmp
+)
+
+(
+	# before the pipe
+	cat /etc/passwd | # first command
+	grep root
+)
+
+greet("carol") # invocation
+
+var out <= echo $greeting # command output
+# final comment
@@ -13,6 +13,9 @@ import (
 )
 
 // Exec runs the script code and returns the result of it.
+// If the NASH_COVERPROFILE environment variable is set, the statement
+// coverage of the script (and of everything it imports) is merged into
+// the file it names.
 func Exec(
 	t *testing.T,
 	nashpath string,
@@ -33,6 +36,9 @@ func Exec(
 	assert.NoError(t, err, "writing script code to tmp file")
 
 	scriptargs = append([]string{scriptfile.Name()}, scriptargs...)
+	if coverprofile := os.Getenv("NASH_COVERPROFILE"); coverprofile != "" {
+		scriptargs = append([]string{"-coverprofile", coverprofile}, scriptargs...)
+	}
 	cmd := exec.Command(nashpath, scriptargs...)
 
 	stdout := bytes.Buffer{}
@@ -0,0 +1,66 @@
+package tests
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/madlambda/nash/tests/internal/assert"
+)
+
+func TestTestCmd(t *testing.T) {
+	dir, err := ioutil.TempDir("", "nash-test-cmd")
+	assert.NoError(t, err, "creating tmp dir")
+	defer os.RemoveAll(dir)
+
+	err = ioutil.WriteFile(filepath.Join(dir, "sample_test.sh"), []byte(`
+import assert
+
+fn test_pass() {
+	assert_equal("a", "a")
+}
+
+fn test_fail() {
+	assert_contains("hello", "bye")
+}
+
+fn test_exit_status() {
+	exit("2")
+}
+
+fn helper() {
+	exit("1")
+}
+`), 0644)
+	assert.NoError(t, err, "writing test file")
+
+	runTest := func(args ...string) (string, error) {
+		cmd := exec.Command(Nashcmd, append([]string{"test"}, args...)...)
+		cmd.Env = append(os.Environ(), "NASHROOT="+Projectpath)
+		out, err := cmd.CombinedOutput()
+		return string(out), err
+	}
+
+	out, err := runTest("-run", "pass", dir)
+	assert.NoError(t, err, "running passing tests: "+out)
+	assert.ContainsString(t, out, "ok  \t"+filepath.Join(dir, "sample_test.sh"))
+
+	out, err = runTest("-v", "-run", "pass", dir)
+	assert.NoError(t, err, "running passing tests: "+out)
+	assert.ContainsString(t, out, "--- PASS: test_pass")
+
+	out, err = runTest(dir)
+	if err == nil {
+		t.Fatalf("expected failure, got success: %s", out)
+	}
+	assert.ContainsString(t, out, "--- FAIL: test_fail")
+	assert.ContainsString(t, out, "assert_contains: [hello] does not contain [bye]")
+	assert.ContainsString(t, out, "--- FAIL: test_exit_status")
+	assert.ContainsString(t, out, "FAIL\t"+filepath.Join(dir, "sample_test.sh"))
+
+	out, err = runTest("-run", "helper", dir)
+	assert.NoError(t, err, "running no tests: "+out)
+	assert.ContainsString(t, out, "[no tests to run]")
+}
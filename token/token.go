@@ -5,8 +5,12 @@ import "strconv"
 type (
 	Token int
 
+	// FileInfo is the position of a token or node in the file. Lines
+	// start at 1 and columns at 0. The end is the position right after
+	// the last character.
 	FileInfo struct {
-		line, column int
+		line, column       int
+		endLine, endColumn int
 	}
 )
 
@@ -145,9 +149,16 @@ func IsKeyword(t Token) bool {
 	return false
 }
 
-func NewFileInfo(l, c int) FileInfo { return FileInfo{l, c} }
-func (info FileInfo) Line() int     { return info.line }
-func (info FileInfo) Column() int   { return info.column }
+func NewFileInfo(l, c int) FileInfo  { return FileInfo{line: l, column: c} }
+func (info FileInfo) Line() int      { return info.line }
+func (info FileInfo) Column() int    { return info.column }
+func (info FileInfo) EndLine() int   { return info.endLine }
+func (info FileInfo) EndColumn() int { return info.endColumn }
+
+// SetEnd sets the end position.
+func (info *FileInfo) SetEnd(line, column int) {
+	info.endLine, info.endColumn = line, column
+}
 
 func (tok Token) String() string {
 	s := ""